@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/eigerco/strawberry/internal/archive"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/genesis"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/internal/state"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+// runExport writes a range of stored blocks to an archive file.
+// strawberry export -datadir ./data -from <hash> -to <hash> out.jamblocks
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbFlags := registerStoreFlags(fs)
+	from := fs.String("from", "", "Hash of the first block to export")
+	to := fs.String("to", "", "Hash of the last block to export")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dbFlags.dataDir == "" {
+		return errors.New("data directory is required")
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected a single output file")
+	}
+	fromHash, err := parseHash(*from)
+	if err != nil {
+		return fmt.Errorf("invalid from hash: %w", err)
+	}
+	toHash, err := parseHash(*to)
+	if err != nil {
+		return fmt.Errorf("invalid to hash: %w", err)
+	}
+
+	kvStore, err := dbFlags.open()
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer kvStore.Close()
+	chain, err := openChain(kvStore)
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+
+	file, err := os.Create(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	defer file.Close()
+
+	count, err := archive.Export(chain, fromHash, toHash, file)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("sync archive: %w", err)
+	}
+	fmt.Printf("exported %d blocks to %s\n", count, fs.Arg(0))
+	return nil
+}
+
+// runImport replays the blocks of an archive file and stores them.
+// The posterior state root is printed for every block. With a chain spec the
+// blocks are applied to its genesis state, and the archive must belong to
+// its chain.
+// strawberry import -datadir ./data [-chain-spec spec.json] in.jamblocks
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbFlags := registerStoreFlags(fs)
+	chainSpecPath := fs.String("chain-spec", "", "Chain spec file providing the initial state")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected a single input file")
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer file.Close()
+
+	// Verify the checksum up front so that a corrupted archive is not partially imported
+	header, err := archive.Verify(file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind archive: %w", err)
+	}
+
+	initialState := &state.State{}
+	if *chainSpecPath != "" {
+		spec, err := genesis.Load(*chainSpecPath)
+		if err != nil {
+			return err
+		}
+		genesisHash, err := spec.Hash()
+		if err != nil {
+			return fmt.Errorf("hash genesis header: %w", err)
+		}
+		if genesisHash != header.ChainID {
+			return fmt.Errorf("%w: archive %x, chain spec %x", archive.ErrChainMismatch, header.ChainID, genesisHash)
+		}
+		genesisState, err := spec.State()
+		if err != nil {
+			return fmt.Errorf("create genesis state: %w", err)
+		}
+		initialState = &genesisState
+	}
+
+	kvStore, err := dbFlags.open()
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer kvStore.Close()
+	chain, err := openChain(kvStore)
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+
+	chainID, err := archive.ChainID(chain)
+	if err != nil {
+		return fmt.Errorf("get chain identity: %w", err)
+	}
+	if chainID != (crypto.Hash{}) && chainID != header.ChainID {
+		return fmt.Errorf("%w: archive %x, database %x", archive.ErrChainMismatch, header.ChainID, chainID)
+	}
+
+	trieDB := trie.NewDBWithStore(db.Prefixed(kvStore, trieNamespace))
+	defer trieDB.Close()
+
+	reader, err := archive.NewReader(file)
+	if err != nil {
+		return err
+	}
+	importer := archive.NewImporter(chain, initialState, trieDB)
+	for i := 0; ; i++ {
+		b, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		stateRoot, err := importer.Import(b)
+		if err != nil {
+			return fmt.Errorf("block %d at slot %d: %w", i, b.Header.TimeSlotIndex, err)
+		}
+		fmt.Printf("block %d slot %d state root %x\n", i, b.Header.TimeSlotIndex, stateRoot)
+	}
+	return nil
+}
+
+// openChain opens the chain store in its namespace of the node's database
+// and upgrades its key layout if needed
+func openChain(kvStore db.KVStore) (*store.Chain, error) {
+	chain := store.NewChain(db.Prefixed(kvStore, chainNamespace))
+	if err := chain.Migrate(); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// parseHash parses a hex encoded hash, with or without 0x prefix
+func parseHash(s string) (crypto.Hash, error) {
+	decoded, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	if len(decoded) != crypto.HashSize {
+		return crypto.Hash{}, fmt.Errorf("expected %d bytes, got %d", crypto.HashSize, len(decoded))
+	}
+	return crypto.Hash(decoded), nil
+}
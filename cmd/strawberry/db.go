@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+// runDB runs one of the database maintenance subcommands.
+// strawberry db check [-repair] [-skip-state] -datadir ./data
+func runDB(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected a db subcommand: check")
+	}
+	switch args[0] {
+	case "check":
+		return runDBCheck(args[1:])
+	}
+	return fmt.Errorf("unknown db subcommand %q", args[0])
+}
+
+// checkReport is the report printed by the db check subcommand
+type checkReport struct {
+	store.CheckReport
+	StateRoots  int              `json:"state_roots"`
+	StateIssues []stateNodeIssue `json:"state_issues"`
+}
+
+type stateNodeIssue struct {
+	Kind string `json:"kind"`
+	Hash string `json:"hash"`
+}
+
+// runDBCheck verifies the integrity of the chain store and of the state tries
+// referenced by its headers, and prints a JSON report. It fails if any issue
+// is found, so that it can be used in scripts.
+func runDBCheck(args []string) error {
+	fs := flag.NewFlagSet("db check", flag.ExitOnError)
+	dbFlags := registerStoreFlags(fs)
+	repair := fs.Bool("repair", false, "Delete corrupt and dangling chain store entries")
+	skipState := fs.Bool("skip-state", false, "Do not check the state tries")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dbFlags.dataDir == "" {
+		return errors.New("data directory is required")
+	}
+
+	kvStore, err := dbFlags.open()
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer kvStore.Close()
+	chain, err := openChain(kvStore)
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+
+	chainReport, err := chain.Check(*repair)
+	if err != nil {
+		return fmt.Errorf("check chain store: %w", err)
+	}
+	report := checkReport{CheckReport: chainReport, StateIssues: []stateNodeIssue{}}
+	if report.Issues == nil {
+		report.Issues = []store.Issue{}
+	}
+
+	if !*skipState {
+		trieDB := trie.NewDBWithStore(db.Prefixed(kvStore, trieNamespace))
+		defer trieDB.Close()
+
+		visited := make(map[crypto.Hash]struct{})
+		for _, root := range chainReport.StateRoots {
+			issues, err := trieDB.CheckNodes(root, visited)
+			if err != nil {
+				return fmt.Errorf("check state root %x: %w", root, err)
+			}
+			for _, issue := range issues {
+				kind := "missing_node"
+				if issue.Corrupt {
+					kind = "corrupt_node"
+				}
+				report.StateIssues = append(report.StateIssues, stateNodeIssue{Kind: kind, Hash: hex.EncodeToString(issue.Hash[:])})
+			}
+		}
+		report.StateRoots = len(chainReport.StateRoots)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+
+	unrepaired := len(report.StateIssues)
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			unrepaired++
+		}
+	}
+	if unrepaired > 0 {
+		return fmt.Errorf("found %d unrepaired issues", unrepaired)
+	}
+	return nil
+}
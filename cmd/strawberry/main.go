@@ -3,19 +3,92 @@ package main
 import (
 	"context"
 	"crypto/ed25519"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"os"
 
+	"github.com/eigerco/strawberry/internal/auditing"
+	"github.com/eigerco/strawberry/internal/authoring"
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/finality"
+	"github.com/eigerco/strawberry/internal/genesis"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/pkg/db"
+	"github.com/eigerco/strawberry/pkg/db/pebble"
 	"github.com/eigerco/strawberry/pkg/network/peer"
 )
 
-// main starts a blockchain node.
-// go run main.go -addr localhost:9000
+// Key namespaces of the components sharing the node's database
+var (
+	chainNamespace = []byte("chain/")
+	trieNamespace  = []byte("trie/")
+)
+
+// nodeMigrations upgrade the layout of the namespaces in the node's database,
+// the components migrate their own namespace. Append new migrations at the
+// end, never change or reorder existing ones.
+var nodeMigrations = []db.Migration{
+	{
+		Version:     1,
+		Description: "move the chain store into its namespace",
+		Apply: func(kvStore db.KVStore) error {
+			return db.MoveToNamespace(kvStore, chainNamespace, trieNamespace)
+		},
+	},
+}
+
+// main starts a blockchain node, or runs one of the subcommands.
+// go run main.go -addr localhost:9000 -datadir ./data [-chain-spec spec.json]
+// go run main.go export -datadir ./data -from <hash> -to <hash> out.jamblocks
+// go run main.go import -datadir ./data [-chain-spec spec.json] in.jamblocks
+// go run main.go db check [-repair] -datadir ./data
+// go run main.go statediff [-json] prior.json posterior.json
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			if err := runExport(os.Args[2:]); err != nil {
+				log.Fatalf("export failed: %v", err)
+			}
+			return
+		case "import":
+			if err := runImport(os.Args[2:]); err != nil {
+				log.Fatalf("import failed: %v", err)
+			}
+			return
+		case "db":
+			if err := runDB(os.Args[2:]); err != nil {
+				log.Fatalf("db failed: %v", err)
+			}
+			return
+		case "statediff":
+			if err := runStateDiff(os.Args[2:]); err != nil {
+				log.Fatalf("statediff: %v", err)
+			}
+			return
+		}
+	}
+	runNode()
+}
+
+// runNode starts a blockchain node and blocks forever
+func runNode() {
 	ctx := context.Background()
 	listenAddr := flag.String("addr", "", "Listen address")
+	chainSpecPath := flag.String("chain-spec", "", "Chain spec file, if empty an empty development chain is used")
+	dbFlags := registerStoreFlags(flag.CommandLine)
+	pruning := flag.Bool("prune", true, "Delete forks competing with finalized blocks")
+	retainEpochs := flag.Uint("retain-epochs", 0, "Number of epochs to keep full blocks for when pruning, 0 keeps all blocks")
+	bandersnatchSeed := flag.String("bandersnatch-seed", "", "Hex encoded seed of the validator's bandersnatch key, if empty the node does not author blocks")
+	ed25519Seed := flag.String("ed25519-seed", "", "Hex encoded seed of the validator's Ed25519 key, if empty a random network key is used and the node does not vote on finality")
+	cachedStates := flag.Int("cached-states", chain.DefaultMaxCachedStates, "Number of recent posterior states kept in memory")
 	flag.Parse()
 
 	if *listenAddr == "" {
@@ -26,17 +99,72 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	var votingKey ed25519.PrivateKey
+	if *ed25519Seed != "" {
+		var seed crypto.HexBytes
+		if err := seed.UnmarshalText([]byte(*ed25519Seed)); err != nil || len(seed) != ed25519.SeedSize {
+			log.Fatalf("invalid ed25519 seed: expected %d hex encoded bytes", ed25519.SeedSize)
+		}
+		priv = ed25519.NewKeyFromSeed(seed)
+		pub = priv.Public().(ed25519.PublicKey)
+		votingKey = priv
+	}
 	keys := peer.ValidatorKeys{
 		EdPrv: priv,
 		EdPub: pub,
 	}
+	if *bandersnatchSeed != "" {
+		var seed crypto.BandersnatchSeedKey
+		if err := seed.UnmarshalText([]byte(*bandersnatchSeed)); err != nil {
+			log.Fatalf("invalid bandersnatch seed: %v", err)
+		}
+		keys.BanderPrv, err = bandersnatch.NewPrivateKeyFromSeed(seed)
+		if err != nil {
+			log.Fatalf("failed to create bandersnatch key: %v", err)
+		}
+		keys.BanderPub, err = bandersnatch.Public(keys.BanderPrv)
+		if err != nil {
+			log.Fatalf("failed to derive bandersnatch public key: %v", err)
+		}
+	}
+
+	kvStore, err := dbFlags.open()
+	if err != nil {
+		log.Fatalf("failed to open database: %v", err)
+	}
+	spec, err := loadChainSpec(*chainSpecPath)
+	if err != nil {
+		log.Fatalf("failed to load chain spec: %v", err)
+	}
+	genesisBlock, err := spec.Block()
+	if err != nil {
+		log.Fatalf("failed to create genesis block: %v", err)
+	}
+	bs, err := chain.NewBlockServiceWithStore(db.Prefixed(kvStore, chainNamespace), genesisBlock)
+	if err != nil {
+		log.Fatalf("failed to create block service: %v", err)
+	}
+	trieDB := trie.NewDBWithStore(db.Prefixed(kvStore, trieNamespace))
+	genesisState, err := spec.State()
+	if err != nil {
+		log.Fatalf("failed to create genesis state: %v", err)
+	}
+	states, err := chain.NewStateManager(bs, trieDB, genesisState, chain.StateManagerConfig{MaxCachedStates: *cachedStates})
+	if err != nil {
+		log.Fatalf("failed to create state manager: %v", err)
+	}
+	bs.EnableVoteFinality()
+	importer := chain.NewImporter(bs, states)
+	if *pruning {
+		bs.EnablePruning(ctx, chain.PrunerConfig{RetainEpochs: uint32(*retainEpochs)})
+	}
 
 	address, err := net.ResolveUDPAddr("", *listenAddr)
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("listening on: %v\n", address)
-	node, err := peer.NewNode(ctx, address, keys)
+	node, err := peer.NewNode(ctx, address, keys, bs, importer)
 	if err != nil {
 		panic(err)
 	}
@@ -45,5 +173,147 @@ func main() {
 		panic(err)
 	}
 
+	gadget, err := finality.NewGadget(ctx, states, node, votingKey, finality.Config{})
+	if err != nil {
+		log.Fatalf("failed to create finality gadget: %v", err)
+	}
+	node.OnVote(func(vote finality.SignedVote) {
+		if err := gadget.AddVote(vote); err != nil && !errors.Is(err, finality.ErrDuplicateVote) {
+			log.Printf("rejected finality vote: %v", err)
+		}
+	})
+	gadget.OnEquivocation(func(equivocation finality.Equivocation) {
+		log.Printf("equivocating finality voter %x in round %d", equivocation.Offender(gadget.Voters()), equivocation.First.Vote.Round)
+	})
+	importer.Subscribe(gadget.OnImported)
+	go gadget.Run(ctx)
+	disputes := auditing.NewDisputeCollector(ctx, states, node)
+	importer.Subscribe(disputes.OnImported)
+	bundles := auditing.NewBundleStore()
+	node.OnAuditShardRequest(bundles.AuditShard)
+
+	// Auditing signs with both the bandersnatch and the ed25519 keys
+	var auditor *auditing.Auditor
+	if *bandersnatchSeed != "" && votingKey != nil {
+		fetcher := auditing.NewNetworkBundleFetcher(node, bundles)
+		auditor, err = auditing.NewAuditor(ctx, states, node, fetcher, auditing.NewStateEvaluator(states), keys.BanderPrv, votingKey, auditing.Config{})
+		if err != nil {
+			log.Fatalf("failed to create auditor: %v", err)
+		}
+		auditor.OnResult(disputes.OnResult)
+		importer.Subscribe(auditor.OnImported)
+		node.OnAnnouncement(func(announcement auditing.Announcement) {
+			if err := auditor.AddAnnouncement(announcement); err != nil && !errors.Is(err, auditing.ErrUnknownBlock) {
+				log.Printf("rejected audit announcement: %v", err)
+			}
+		})
+		go auditor.Run(ctx)
+	}
+	node.OnJudgment(func(judgment auditing.Judgment) {
+		if err := disputes.AddJudgment(judgment); err != nil {
+			if !errors.Is(err, auditing.ErrDuplicateJudgment) {
+				log.Printf("rejected judgment: %v", err)
+			}
+			return
+		}
+		if auditor != nil {
+			auditor.AddPublishedJudgment(judgment)
+		}
+	})
+	if *bandersnatchSeed != "" {
+		ticketPool, err := authoring.NewTicketPool(states)
+		if err != nil {
+			log.Fatalf("failed to create ticket pool: %v", err)
+		}
+		importer.Subscribe(ticketPool.OnImported)
+		node.OnTicket(func(epoch jamtime.Epoch, ticket block.TicketProof) error {
+			err := ticketPool.Add(epoch, ticket)
+			// Tickets are forwarded by several proxies, duplicates are expected
+			if err != nil && !errors.Is(err, authoring.ErrTicketDuplicate) {
+				log.Printf("rejected ticket: %v", err)
+			}
+			return err
+		})
+
+		pool := authoring.NewPool(ticketPool)
+		pool.SetDisputeSource(disputes)
+		producer, err := authoring.NewProducer(importer, states, pool, node, keys.BanderPrv)
+		if err != nil {
+			log.Fatalf("failed to create block producer: %v", err)
+		}
+		go producer.Run(ctx)
+
+		tickets := authoring.NewTicketGenerator(ctx, states, node, keys.BanderPrv)
+		tickets.OnTicket(func(ticket authoring.GeneratedTicket) {
+			if err := ticketPool.Add(ticket.Epoch, ticket.Proof); err != nil {
+				log.Printf("rejected own ticket: %v", err)
+			}
+		})
+		importer.Subscribe(tickets.OnImported)
+	}
+
 	select {}
 }
+
+// loadChainSpec reads the chain spec at the given path, an empty path results
+// in an empty development chain spec
+func loadChainSpec(path string) (genesis.Spec, error) {
+	if path == "" {
+		return genesis.Spec{Name: "dev"}, nil
+	}
+	return genesis.Load(path)
+}
+
+// storeFlags holds the command line options of the node's key-value store
+type storeFlags struct {
+	dataDir      *string
+	cacheSize    *int64
+	memTableSize *uint64
+	syncMode     *string
+}
+
+// registerStoreFlags adds the key-value store options to the flag set
+func registerStoreFlags(fs *flag.FlagSet) storeFlags {
+	return storeFlags{
+		dataDir:      fs.String("datadir", "", "Data directory, if empty the chain is kept in memory"),
+		cacheSize:    fs.Int64("db-cache", 64, "Database block cache size in MiB"),
+		memTableSize: fs.Uint64("db-memtable", 32, "Database memtable size in MiB"),
+		syncMode:     fs.String("db-sync", "sync", "Database WAL sync mode: sync, nosync or disabled"),
+	}
+}
+
+// open opens the node's key-value store and upgrades its layout. An empty
+// data directory results in an in-memory store which is discarded when the
+// process exits.
+func (f storeFlags) open() (db.KVStore, error) {
+	kvStore, err := f.openStore()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Migrate(kvStore, nodeMigrations); err != nil {
+		kvStore.Close()
+		return nil, fmt.Errorf("migrate database: %w", err)
+	}
+	return kvStore, nil
+}
+
+func (f storeFlags) openStore() (db.KVStore, error) {
+	if *f.dataDir == "" {
+		return pebble.NewKVStore()
+	}
+
+	config := pebble.DefaultConfig()
+	config.CacheSize = *f.cacheSize * 1024 * 1024
+	config.MemTableSize = *f.memTableSize * 1024 * 1024
+	switch syncMode := *f.syncMode; syncMode {
+	case "sync":
+		config.SyncMode = pebble.SyncModeSync
+	case "nosync":
+		config.SyncMode = pebble.SyncModeNoSync
+	case "disabled":
+		config.SyncMode = pebble.SyncModeDisabled
+	default:
+		return nil, fmt.Errorf("unknown sync mode: %s", syncMode)
+	}
+	return pebble.NewPersistentKVStore(*f.dataDir, config)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/statediff"
+)
+
+// runStateDiff compares two serialized states and prints the differences per component.
+// The states are JSON objects mapping hex encoded state keys to hex encoded values.
+// strawberry statediff [-json] prior.json posterior.json
+func runStateDiff(args []string) error {
+	fs := flag.NewFlagSet("statediff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("expected the prior and posterior state files")
+	}
+
+	prior, err := readSerializedState(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	posterior, err := readSerializedState(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	report, err := statediff.Serialized(prior, posterior)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return fmt.Errorf("write report: %w", err)
+		}
+	} else if err := report.WriteText(os.Stdout); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+
+	if !report.Empty() {
+		return fmt.Errorf("found %d differences", len(report.Differences))
+	}
+	return nil
+}
+
+// readSerializedState reads a JSON object of hex encoded state keys and values
+func readSerializedState(path string) (map[crypto.Hash][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	var encoded map[string]string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, fmt.Errorf("parse state %s: %w", path, err)
+	}
+
+	serialized := make(map[crypto.Hash][]byte, len(encoded))
+	for k, v := range encoded {
+		key, err := parseHash(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state key %q in %s: %w", k, path, err)
+		}
+		value, err := hex.DecodeString(strings.TrimPrefix(v, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %q in %s: %w", k, path, err)
+		}
+		serialized[key] = value
+	}
+	return serialized, nil
+}
@@ -0,0 +1,247 @@
+// Package archive implements a portable file format for sequences of blocks.
+//
+// An archive consists of a fixed size header followed by length-prefixed JAM
+// encoded blocks:
+//
+//	magic (8 bytes) | version (u32) | chain identity (32 bytes) | block count (u32) | checksum (32 bytes)
+//	block length (u32) | JAM encoded block | block length (u32) | JAM encoded block | ...
+//
+// All integers are little endian. The chain identity is the hash of the genesis
+// header of the chain the blocks belong to. The checksum is the blake2b-256 hash
+// of everything following the header.
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+)
+
+const (
+	// Version is the archive format version written by Writer
+	Version uint32 = 1
+
+	// HeaderSize is the size in bytes of the archive header
+	HeaderSize = len(magic) + 4 + crypto.HashSize + 4 + crypto.HashSize
+
+	// MaxBlockSize bounds the length prefix of a single block to protect
+	// readers from allocating arbitrary amounts of memory
+	MaxBlockSize = 64 * 1024 * 1024
+)
+
+var magic = [8]byte{'J', 'A', 'M', 'B', 'L', 'K', 'S', 0}
+
+var (
+	ErrInvalidMagic       = errors.New("not a block archive")
+	ErrUnsupportedVersion = errors.New("unsupported archive version")
+	ErrChecksumMismatch   = errors.New("archive checksum mismatch")
+	ErrCountMismatch      = errors.New("archive block count mismatch")
+	ErrBlockTooLarge      = errors.New("archived block exceeds maximum size")
+	ErrWriterClosed       = errors.New("archive writer closed")
+)
+
+// Header is the metadata stored at the start of every archive
+type Header struct {
+	Version  uint32
+	ChainID  crypto.Hash // Hash of the genesis header
+	Count    uint32      // Number of blocks in the archive
+	Checksum crypto.Hash // blake2b-256 of the archive body
+}
+
+func (h Header) bytes() []byte {
+	buf := make([]byte, 0, HeaderSize)
+	buf = append(buf, magic[:]...)
+	buf = binary.LittleEndian.AppendUint32(buf, h.Version)
+	buf = append(buf, h.ChainID[:]...)
+	buf = binary.LittleEndian.AppendUint32(buf, h.Count)
+	buf = append(buf, h.Checksum[:]...)
+	return buf
+}
+
+func parseHeader(buf []byte) (Header, error) {
+	if !bytes.Equal(buf[:len(magic)], magic[:]) {
+		return Header{}, ErrInvalidMagic
+	}
+	buf = buf[len(magic):]
+
+	var h Header
+	h.Version = binary.LittleEndian.Uint32(buf)
+	if h.Version != Version {
+		return Header{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+	buf = buf[4:]
+	h.ChainID = crypto.Hash(buf[:crypto.HashSize])
+	buf = buf[crypto.HashSize:]
+	h.Count = binary.LittleEndian.Uint32(buf)
+	buf = buf[4:]
+	h.Checksum = crypto.Hash(buf[:crypto.HashSize])
+	return h, nil
+}
+
+// Writer streams blocks into an archive. The header is written up front with
+// an empty checksum and rewritten by Close once all blocks are known, which is
+// why the underlying writer must be seekable.
+type Writer struct {
+	w        io.WriteSeeker
+	header   Header
+	checksum hash.Hash
+	closed   bool
+}
+
+// NewWriter writes a provisional archive header for the given chain to w
+func NewWriter(w io.WriteSeeker, chainID crypto.Hash) (*Writer, error) {
+	checksum, err := blake2b.New256(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create checksum: %w", err)
+	}
+	aw := &Writer{
+		w:        w,
+		header:   Header{Version: Version, ChainID: chainID},
+		checksum: checksum,
+	}
+	if _, err := w.Write(aw.header.bytes()); err != nil {
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+	return aw, nil
+}
+
+// Write appends a block to the archive
+func (w *Writer) Write(b block.Block) error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+	encoded, err := b.Bytes()
+	if err != nil {
+		return fmt.Errorf("encode block: %w", err)
+	}
+	if len(encoded) > MaxBlockSize {
+		return ErrBlockTooLarge
+	}
+	record := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(encoded)), uint32(len(encoded)))
+	record = append(record, encoded...)
+	if _, err := w.w.Write(record); err != nil {
+		return fmt.Errorf("write block: %w", err)
+	}
+	w.checksum.Write(record)
+	w.header.Count++
+	return nil
+}
+
+// Close finalizes the archive by rewriting the header with the block count
+// and checksum. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+	w.closed = true
+	w.header.Checksum = crypto.Hash(w.checksum.Sum(nil))
+
+	end, err := w.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
+	if _, err := w.w.Write(w.header.bytes()); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if _, err := w.w.Seek(end, io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
+	return nil
+}
+
+// Reader streams blocks out of an archive. The checksum covers the whole
+// archive, so it can only be verified once the last block has been read;
+// Next reports a mismatch instead of io.EOF in that case.
+type Reader struct {
+	r        io.Reader
+	header   Header
+	checksum hash.Hash
+	read     uint32
+}
+
+// NewReader reads and validates the archive header from r
+func NewReader(r io.Reader) (*Reader, error) {
+	buf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrInvalidMagic
+		}
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	header, err := parseHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := blake2b.New256(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create checksum: %w", err)
+	}
+	return &Reader{r: r, header: header, checksum: checksum}, nil
+}
+
+// Header returns the archive header
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next returns the next block in the archive. It returns io.EOF after the last
+// block once the block count and checksum have been verified.
+func (r *Reader) Next() (block.Block, error) {
+	encoded, err := r.nextRecord()
+	if err != nil {
+		return block.Block{}, err
+	}
+	b, err := block.BlockFromBytes(encoded)
+	if err != nil {
+		return block.Block{}, fmt.Errorf("decode block %d: %w", r.read-1, err)
+	}
+	return b, nil
+}
+
+// nextRecord returns the encoded bytes of the next block
+func (r *Reader) nextRecord() ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, r.verify()
+		}
+		return nil, fmt.Errorf("read block length: %w", err)
+	}
+	length := binary.LittleEndian.Uint32(prefix[:])
+	if length > MaxBlockSize {
+		return nil, ErrBlockTooLarge
+	}
+	if r.read >= r.header.Count {
+		return nil, ErrCountMismatch
+	}
+
+	encoded := make([]byte, length)
+	if _, err := io.ReadFull(r.r, encoded); err != nil {
+		return nil, fmt.Errorf("read block: %w", err)
+	}
+	r.checksum.Write(prefix[:])
+	r.checksum.Write(encoded)
+	r.read++
+	return encoded, nil
+}
+
+func (r *Reader) verify() error {
+	if r.read != r.header.Count {
+		return fmt.Errorf("%w: header declares %d blocks, read %d", ErrCountMismatch, r.header.Count, r.read)
+	}
+	if crypto.Hash(r.checksum.Sum(nil)) != r.header.Checksum {
+		return ErrChecksumMismatch
+	}
+	return io.EOF
+}
@@ -0,0 +1,202 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/pkg/db/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterReader(t *testing.T) {
+	blocks := createBlocks(t, 3)
+	chainID := testutils.RandomHash(t)
+
+	data := writeArchive(t, chainID, blocks)
+
+	reader, err := NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, Version, reader.Header().Version)
+	assert.Equal(t, chainID, reader.Header().ChainID)
+	assert.Equal(t, uint32(3), reader.Header().Count)
+
+	var read []block.Block
+	for {
+		b, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		read = append(read, b)
+	}
+	assert.Equal(t, blocks, read)
+
+	header, err := Verify(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, reader.Header(), header)
+}
+
+func TestReaderCorruption(t *testing.T) {
+	data := writeArchive(t, testutils.RandomHash(t), createBlocks(t, 2))
+
+	t.Run("invalid magic", func(t *testing.T) {
+		corrupted := bytes.Clone(data)
+		corrupted[0] = 'X'
+		_, err := NewReader(bytes.NewReader(corrupted))
+		require.ErrorIs(t, err, ErrInvalidMagic)
+	})
+
+	t.Run("truncated header", func(t *testing.T) {
+		_, err := NewReader(bytes.NewReader(data[:HeaderSize-1]))
+		require.ErrorIs(t, err, ErrInvalidMagic)
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		corrupted := bytes.Clone(data)
+		corrupted[len(magic)] = 2
+		_, err := NewReader(bytes.NewReader(corrupted))
+		require.ErrorIs(t, err, ErrUnsupportedVersion)
+	})
+
+	t.Run("body modified", func(t *testing.T) {
+		corrupted := bytes.Clone(data)
+		corrupted[len(corrupted)-1] ^= 0xff
+		_, err := Verify(bytes.NewReader(corrupted))
+		require.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+
+	t.Run("block missing", func(t *testing.T) {
+		first := writeArchive(t, crypto.Hash{}, createBlocks(t, 1))
+		truncated := bytes.Clone(data[:len(first)])
+		_, err := Verify(bytes.NewReader(truncated))
+		require.ErrorIs(t, err, ErrCountMismatch)
+	})
+}
+
+func TestWriterClosed(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "out.jamblocks"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	writer, err := NewWriter(file, crypto.Hash{})
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.ErrorIs(t, writer.Write(block.Block{}), ErrWriterClosed)
+	require.ErrorIs(t, writer.Close(), ErrWriterClosed)
+}
+
+func TestExport(t *testing.T) {
+	chain := store.NewChain(memory.NewKVStore())
+	defer chain.Close()
+
+	blocks := createBlocks(t, 4)
+	for _, b := range blocks {
+		require.NoError(t, chain.PutBlock(b))
+	}
+	genesis, err := blocks[0].Header.Hash()
+	require.NoError(t, err)
+	from, err := blocks[1].Header.Hash()
+	require.NoError(t, err)
+	to, err := blocks[3].Header.Hash()
+	require.NoError(t, err)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "out.jamblocks"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	count, err := Export(chain, from, to, file)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	_, err = file.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	reader, err := NewReader(file)
+	require.NoError(t, err)
+	assert.Equal(t, genesis, reader.Header().ChainID)
+	for _, expected := range blocks[1:] {
+		b, err := reader.Next()
+		require.NoError(t, err)
+		assert.Equal(t, expected, b)
+	}
+	_, err = reader.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	// The range must be walkable from to back to from
+	_, err = Export(chain, to, from, file)
+	require.ErrorIs(t, err, ErrNotAncestor)
+}
+
+func TestImporterCheckHeader(t *testing.T) {
+	importer := &Importer{
+		started:       true,
+		lastHash:      testutils.RandomHash(t),
+		lastSlot:      10,
+		lastStateRoot: testutils.RandomHash(t),
+	}
+	valid := block.Header{
+		ParentHash:     importer.lastHash,
+		TimeSlotIndex:  11,
+		PriorStateRoot: importer.lastStateRoot,
+	}
+	require.NoError(t, importer.checkHeader(valid))
+
+	header := valid
+	header.ParentHash = testutils.RandomHash(t)
+	require.ErrorIs(t, importer.checkHeader(header), ErrParentMismatch)
+
+	header = valid
+	header.TimeSlotIndex = 10
+	require.ErrorIs(t, importer.checkHeader(header), ErrTimeslotNotIncreasing)
+
+	header = valid
+	header.PriorStateRoot = testutils.RandomHash(t)
+	require.ErrorIs(t, importer.checkHeader(header), ErrPriorStateRootMismatch)
+
+	// The first block is not checked against a previous one
+	require.NoError(t, (&Importer{}).checkHeader(header))
+}
+
+func writeArchive(t *testing.T, chainID crypto.Hash, blocks []block.Block) []byte {
+	path := filepath.Join(t.TempDir(), "archive.jamblocks")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	writer, err := NewWriter(file, chainID)
+	require.NoError(t, err)
+	for _, b := range blocks {
+		require.NoError(t, writer.Write(b))
+	}
+	require.NoError(t, writer.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
+
+func createBlocks(t *testing.T, n int) []block.Block {
+	var blocks []block.Block
+	parentHash := crypto.Hash{}
+	for i := 0; i < n; i++ {
+		b := block.Block{Header: block.Header{
+			ParentHash:     parentHash,
+			PriorStateRoot: testutils.RandomHash(t),
+			TimeSlotIndex:  jamtime.Timeslot(i + 1),
+		}}
+		hash, err := b.Header.Hash()
+		require.NoError(t, err)
+		parentHash = hash
+		blocks = append(blocks, b)
+	}
+	return blocks
+}
@@ -0,0 +1,169 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/internal/state"
+	merkle "github.com/eigerco/strawberry/internal/state/merkle"
+	"github.com/eigerco/strawberry/internal/statetransition"
+	"github.com/eigerco/strawberry/internal/store"
+)
+
+var (
+	ErrNotAncestor            = errors.New("from block is not an ancestor of to block")
+	ErrChainMismatch          = errors.New("archive belongs to a different chain")
+	ErrParentMismatch         = errors.New("block parent does not match previous block")
+	ErrTimeslotNotIncreasing  = errors.New("block timeslot is not after previous block")
+	ErrPriorStateRootMismatch = errors.New("block prior state root does not match previous posterior state root")
+)
+
+// ChainID returns the identity of the chain kept in the store, which is the
+// hash of its genesis header, or the zero hash if the store is empty.
+func ChainID(chain *store.Chain) (crypto.Hash, error) {
+	genesis, err := chain.GetCanonicalHashAt(0)
+	if err != nil && !errors.Is(err, store.ErrHeaderNotFound) {
+		return crypto.Hash{}, err
+	}
+	return genesis, nil
+}
+
+// Export writes the blocks from `from` to `to`, both inclusive, to an archive.
+// `from` must be an ancestor of `to`. Returns the number of exported blocks.
+func Export(chain *store.Chain, from, to crypto.Hash, w io.WriteSeeker) (int, error) {
+	chainID, err := ChainID(chain)
+	if err != nil {
+		return 0, fmt.Errorf("get chain identity: %w", err)
+	}
+
+	// Collect the hashes first, headers are cheaper to walk than full blocks
+	hashes := []crypto.Hash{to}
+	for current := to; current != from; {
+		header, err := chain.GetHeader(current)
+		if err != nil {
+			if errors.Is(err, store.ErrHeaderNotFound) {
+				return 0, ErrNotAncestor
+			}
+			return 0, fmt.Errorf("get header: %w", err)
+		}
+		current = header.ParentHash
+		hashes = append(hashes, current)
+	}
+
+	aw, err := NewWriter(w, chainID)
+	if err != nil {
+		return 0, err
+	}
+	for i := len(hashes) - 1; i >= 0; i-- {
+		b, err := chain.GetBlock(hashes[i])
+		if err != nil {
+			return 0, fmt.Errorf("get block %x: %w", hashes[i], err)
+		}
+		if err := aw.Write(b); err != nil {
+			return 0, err
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return 0, err
+	}
+	return len(hashes), nil
+}
+
+// Verify reads the whole archive and checks its block count and checksum
+// without decoding or applying the blocks.
+func Verify(r io.Reader) (Header, error) {
+	ar, err := NewReader(r)
+	if err != nil {
+		return Header{}, err
+	}
+	for {
+		if _, err := ar.nextRecord(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return ar.Header(), nil
+			}
+			return Header{}, err
+		}
+	}
+}
+
+// Importer replays blocks on top of a state, checking that consecutive blocks
+// form a chain and that every header commits to the state produced by its
+// parent. Successfully applied blocks are stored in the chain store.
+//
+// The archive may start at any block, so the prior state root of the first
+// imported block is trusted rather than checked against the initial state.
+type Importer struct {
+	chain *store.Chain
+	state *state.State
+	trie  *trie.DB
+
+	started       bool
+	lastHash      crypto.Hash
+	lastSlot      jamtime.Timeslot
+	lastStateRoot crypto.Hash
+}
+
+// NewImporter creates an Importer which applies blocks to the given state
+// and commits the merklized posterior states to the trie database.
+func NewImporter(chain *store.Chain, s *state.State, trieDB *trie.DB) *Importer {
+	return &Importer{chain: chain, state: s, trie: trieDB}
+}
+
+// Import checks and applies a single block and returns the posterior state root
+func (i *Importer) Import(b block.Block) (crypto.Hash, error) {
+	hash, err := b.Header.Hash()
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	if err := i.checkHeader(b.Header); err != nil {
+		return crypto.Hash{}, err
+	}
+
+	posterior, delta, err := statetransition.UpdateState(*i.state, b, i.chain)
+	if err != nil {
+		return crypto.Hash{}, fmt.Errorf("update state: %w", err)
+	}
+	// The trie is built from scratch for the first block, and only updated
+	// for the keys that changed afterwards
+	var stateRoot crypto.Hash
+	if i.started {
+		stateRoot, err = merkle.MerklizeStateDelta(i.lastStateRoot, delta, i.trie)
+	} else {
+		stateRoot, err = merkle.MerklizeState(posterior, i.trie)
+	}
+	if err != nil {
+		return crypto.Hash{}, fmt.Errorf("merklize state: %w", err)
+	}
+	if err := i.chain.PutBlock(b); err != nil {
+		return crypto.Hash{}, fmt.Errorf("store block: %w", err)
+	}
+
+	i.started = true
+	i.lastHash = hash
+	i.lastSlot = b.Header.TimeSlotIndex
+	i.lastStateRoot = stateRoot
+	*i.state = posterior
+	return stateRoot, nil
+}
+
+// checkHeader validates the header against the previously imported block
+func (i *Importer) checkHeader(header block.Header) error {
+	if !i.started {
+		return nil
+	}
+	if header.ParentHash != i.lastHash {
+		return fmt.Errorf("%w: expected %x, got %x", ErrParentMismatch, i.lastHash, header.ParentHash)
+	}
+	if header.TimeSlotIndex <= i.lastSlot {
+		return fmt.Errorf("%w: previous %d, got %d", ErrTimeslotNotIncreasing, i.lastSlot, header.TimeSlotIndex)
+	}
+	if header.PriorStateRoot != i.lastStateRoot {
+		return fmt.Errorf("%w: expected %x, got %x", ErrPriorStateRootMismatch, i.lastStateRoot, header.PriorStateRoot)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package auditing
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+// Signing contexts of the auditing process
+const (
+	AuditContext    = "jam_audit"    // X_U ≡ $jam_audit
+	AnnounceContext = "jam_announce" // X_I ≡ $jam_announce
+)
+
+var (
+	ErrBadValidatorIndex        = errors.New("bad validator index")
+	ErrBadAnnouncementSignature = errors.New("bad announcement signature")
+	ErrBadAnnouncementEvidence  = errors.New("bad announcement evidence")
+)
+
+// AuditedReport identifies a work report an auditor announced to audit
+type AuditedReport struct {
+	CoreIndex  uint16
+	ReportHash crypto.Hash
+}
+
+// ReportEvidence justifies auditing a work report in a tranche after the first
+type ReportEvidence struct {
+	Signature crypto.BandersnatchSignature // s_n(w), its output selects the report
+	NoShows   []uint16                     // Validators announced in the previous tranche without judging
+}
+
+// Announcement is the signed intent of a validator to audit work reports of
+// a block in a tranche, along with the evidence that it was selected to.
+// In tranche 0 the evidence is the VRF signature s0 the reports are selected
+// with, in later tranches there is one ReportEvidence per report.
+type Announcement struct {
+	HeaderHash       crypto.Hash
+	Tranche          uint8
+	Reports          []AuditedReport
+	ValidatorIndex   uint16
+	Signature        crypto.Ed25519Signature
+	Tranche0Evidence crypto.BandersnatchSignature
+	Evidence         []ReportEvidence
+}
+
+// message returns the signed message of the announcement:
+// X_I ⌢ n ⌢ x_n ⌢ H(H)
+func (a Announcement) message() ([]byte, error) {
+	reports, err := jam.Marshal(a.Reports)
+	if err != nil {
+		return nil, err
+	}
+	message := append([]byte(AnnounceContext), a.Tranche)
+	message = append(message, reports...)
+	return append(message, a.HeaderHash[:]...), nil
+}
+
+// Sign signs the announcement as the validator with the given index
+func (a *Announcement) Sign(validatorIndex uint16, privateKey ed25519.PrivateKey) error {
+	message, err := a.message()
+	if err != nil {
+		return fmt.Errorf("encode announcement: %w", err)
+	}
+	a.ValidatorIndex = validatorIndex
+	copy(a.Signature[:], ed25519.Sign(privateKey, message))
+	return nil
+}
+
+// VerifySignature checks the signature of the announcement against the
+// validators of the audited block
+func (a Announcement) VerifySignature(validators safrole.ValidatorsData) error {
+	if int(a.ValidatorIndex) >= len(validators) || validators[a.ValidatorIndex] == nil || len(validators[a.ValidatorIndex].Ed25519) != ed25519.PublicKeySize {
+		return ErrBadValidatorIndex
+	}
+	message, err := a.message()
+	if err != nil {
+		return fmt.Errorf("encode announcement: %w", err)
+	}
+	if !ed25519.Verify(validators[a.ValidatorIndex].Ed25519, message, a.Signature[:]) {
+		return ErrBadAnnouncementSignature
+	}
+	return nil
+}
+
+// tranche0Input is the VRF input of the tranche 0 selection: X_U ⌢ Y(H_v)
+func tranche0Input(blockOutput crypto.BandersnatchOutputHash) []byte {
+	return append([]byte(AuditContext), blockOutput[:]...)
+}
+
+// trancheInput is the VRF input of the selection of a report in a later
+// tranche: X_U ⌢ Y(H_v) ⌢ H(w) n
+func trancheInput(blockOutput crypto.BandersnatchOutputHash, reportHash crypto.Hash, tranche uint8) []byte {
+	input := append([]byte(AuditContext), blockOutput[:]...)
+	input = append(input, reportHash[:]...)
+	return append(input, tranche)
+}
+
+// selectTranche0 returns the reports to audit in tranche 0: those which
+// became available among the first Tranche0Audits cores shuffled by the VRF
+// output of s0, a_0 = {(c, w) | (c, w) ∈ p···+10, w ≠ ∅}. Available maps the
+// cores to the hashes of their reports which became available in the block.
+func selectTranche0(output crypto.BandersnatchOutputHash, available map[uint16]crypto.Hash) ([]AuditedReport, error) {
+	cores := make([]uint32, common.TotalNumberOfCores)
+	for i := range cores {
+		cores[i] = uint32(i)
+	}
+	shuffled, err := common.DeterministicShuffle(cores, crypto.Hash(output))
+	if err != nil {
+		return nil, fmt.Errorf("shuffle cores: %w", err)
+	}
+	var reports []AuditedReport
+	for _, core := range shuffled[:min(Tranche0Audits, len(shuffled))] {
+		if hash, ok := available[uint16(core)]; ok {
+			reports = append(reports, AuditedReport{CoreIndex: uint16(core), ReportHash: hash})
+		}
+	}
+	return reports, nil
+}
+
+// escalates tells whether the VRF output of s_n(w) selects a report with m
+// no-shows in the previous tranche: V/(256F) Y(s_n(w))_0 < m
+func escalates(output crypto.BandersnatchOutputHash, noShows int) bool {
+	return common.NumberOfValidators*int(output[0]) < 256*BiasFactor*noShows
+}
+
+// verifyTranche0 checks the tranche 0 evidence of an announcement against the
+// bandersnatch key of the announcer
+func verifyTranche0(a Announcement, key crypto.BandersnatchPublicKey, blockOutput crypto.BandersnatchOutputHash, available map[uint16]crypto.Hash) error {
+	ok, output := bandersnatch.Verify(key, tranche0Input(blockOutput), []byte{}, a.Tranche0Evidence)
+	if !ok {
+		return fmt.Errorf("%w: invalid VRF signature", ErrBadAnnouncementEvidence)
+	}
+	selected, err := selectTranche0(output, available)
+	if err != nil {
+		return err
+	}
+	if len(selected) != len(a.Reports) {
+		return fmt.Errorf("%w: %d reports selected, %d announced", ErrBadAnnouncementEvidence, len(selected), len(a.Reports))
+	}
+	for i := range selected {
+		if selected[i] != a.Reports[i] {
+			return fmt.Errorf("%w: report of core %d not selected", ErrBadAnnouncementEvidence, a.Reports[i].CoreIndex)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,556 @@
+package auditing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/statetransition"
+	"github.com/eigerco/strawberry/internal/work/results"
+)
+
+const (
+	// DefaultTrancheDuration is the period of an audit tranche, A = 8 seconds
+	DefaultTrancheDuration = 8 * time.Second
+
+	// BiasFactor is the expected number of auditors per no-show, F = 2
+	BiasFactor = 2
+
+	// Tranche0Audits is the number of reports audited by each validator in tranche 0
+	Tranche0Audits = 10
+
+	// maxSessionSlots is how long the audits of a block are tracked
+	maxSessionSlots = jamtime.TimeslotsPerEpoch
+)
+
+var (
+	ErrUnknownBlock  = errors.New("block not being audited")
+	ErrUnknownReport = errors.New("work report not being audited")
+)
+
+// BundleFetcher retrieves the audit bundle of a work report, which the
+// assurers among the validators κ′ of the audited block hold erasure coded.
+// It is implemented by NetworkBundleFetcher.
+type BundleFetcher interface {
+	FetchBundle(ctx context.Context, report block.WorkReport, validators safrole.ValidatorsData) (results.AuditBundle, error)
+}
+
+// Evaluator computes the work report of the work package in an audit bundle,
+// as done by the guarantors. It is implemented by StateEvaluator.
+type Evaluator interface {
+	EvaluateBundle(bundle results.AuditBundle, report block.WorkReport) (*block.WorkReport, error)
+}
+
+// Network broadcasts our audit announcements to the other validators
+type Network interface {
+	BroadcastAnnouncement(ctx context.Context, announcement Announcement) error
+}
+
+// Config holds the parameters of the auditor
+type Config struct {
+	TrancheDuration time.Duration // DefaultTrancheDuration if zero
+}
+
+// Result is the outcome of our audit of a work report
+type Result struct {
+	HeaderHash crypto.Hash
+	CoreIndex  uint16
+	ReportHash crypto.Hash
+	Report     block.WorkReport
+	Valid      bool     // Whether re-evaluating the work package resulted in the same report
+	Judgment   Judgment // Our signed judgment, by our index among the validators of the block
+}
+
+// reportAudit tracks the audit of a work report which became available in a block
+type reportAudit struct {
+	report    block.WorkReport
+	core      uint16
+	announced []map[uint16]struct{} // A_n(w), the announced auditors by tranche
+	positive  map[uint16]struct{}   // J⊤(w)
+	negative  map[uint16]struct{}   // J⊥(w)
+	ours      bool                  // We announced to audit it
+}
+
+// noShows returns the auditors announced in the tranche which did not judge
+// the report yet, ordered by validator index
+func (r *reportAudit) noShows(tranche int) []uint16 {
+	if tranche >= len(r.announced) {
+		return nil
+	}
+	var noShows []uint16
+	for index := range r.announced[tranche] {
+		_, positive := r.positive[index]
+		_, negative := r.negative[index]
+		if !positive && !negative {
+			noShows = append(noShows, index)
+		}
+	}
+	sort.Slice(noShows, func(i, j int) bool { return noShows[i] < noShows[j] })
+	return noShows
+}
+
+// audited tells whether the report is audited, U(w): it has no negative
+// judgment and all auditors of a tranche judged it positively, or a
+// supermajority of the validators judged it positively
+func (r *reportAudit) audited() bool {
+	if len(r.positive) >= common.ValidatorsSuperMajority {
+		return true
+	}
+	if len(r.negative) > 0 {
+		return false
+	}
+	for _, announced := range r.announced {
+		if len(announced) == 0 {
+			continue
+		}
+		all := true
+		for index := range announced {
+			if _, ok := r.positive[index]; !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// session tracks the audits of the reports which became available in a block
+type session struct {
+	hash        crypto.Hash
+	slot        jamtime.Timeslot
+	blockOutput crypto.BandersnatchOutputHash // Y(H_v)
+	validators  safrole.ValidatorsData        // κ′ of the block
+	selfIndex   int                           // Our index among the validators, -1 if we are not one
+	available   map[uint16]crypto.Hash        // Report hashes by core
+	reports     map[crypto.Hash]*reportAudit
+	tranche     int // The last tranche we considered auditing in
+}
+
+// Auditor audits the work reports which became available in the imported
+// blocks (section 17). In tranche 0 every validator audits the reports
+// selected by its VRF signature over the block's VRF output. In every later
+// tranche each report is audited by more validators if auditors announced in
+// the previous tranche did not judge it, and by all validators once it was
+// judged invalid. Auditing fetches the audit bundle and re-evaluates the work
+// package, the report is valid if the result is the same.
+//
+// The judgments of the other validators are added with AddJudgment, our own
+// judgments are added when our audits complete and passed, signed, to the
+// OnResult function.
+type Auditor struct {
+	ctx             context.Context
+	states          *chain.StateManager
+	network         Network
+	fetcher         BundleFetcher
+	evaluator       Evaluator
+	bandersnatchKey crypto.BandersnatchPrivateKey
+	bandersnatchPub crypto.BandersnatchPublicKey
+	ed25519Key      ed25519.PrivateKey
+	config          Config
+
+	mu       sync.Mutex
+	sessions map[crypto.Hash]*session
+	onResult func(Result)
+}
+
+// NewAuditor creates an auditor for the validator with the given keys
+func NewAuditor(ctx context.Context, states *chain.StateManager, network Network, fetcher BundleFetcher, evaluator Evaluator, bandersnatchKey crypto.BandersnatchPrivateKey, ed25519Key ed25519.PrivateKey, config Config) (*Auditor, error) {
+	if config.TrancheDuration == 0 {
+		config.TrancheDuration = DefaultTrancheDuration
+	}
+	bandersnatchPub, err := bandersnatch.Public(bandersnatchKey)
+	if err != nil {
+		return nil, fmt.Errorf("derive bandersnatch public key: %w", err)
+	}
+	return &Auditor{
+		ctx:             ctx,
+		states:          states,
+		network:         network,
+		fetcher:         fetcher,
+		evaluator:       evaluator,
+		bandersnatchKey: bandersnatchKey,
+		bandersnatchPub: bandersnatchPub,
+		ed25519Key:      ed25519Key,
+		config:          config,
+		sessions:        make(map[crypto.Hash]*session),
+	}, nil
+}
+
+// OnResult registers a function called with the outcome of each of our audits
+func (a *Auditor) OnResult(fn func(Result)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onResult = fn
+}
+
+// Run escalates the audits in later tranches until the context is done
+func (a *Auditor) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.config.TrancheDuration / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.tick(now)
+		}
+	}
+}
+
+// OnImported starts auditing the work reports which became available in an
+// imported block, it is meant to be subscribed to the importer
+func (a *Auditor) OnImported(imported chain.ImportedBlock) {
+	if len(imported.Extrinsic.EA) == 0 {
+		return
+	}
+	available, validators, err := a.availableReports(imported)
+	if err != nil {
+		log.Printf("auditing: failed to get the available reports of %x: %v", imported.Hash, err)
+		return
+	}
+	if err := a.begin(imported.Hash, imported.Header, validators, available); err != nil {
+		log.Printf("auditing: failed to audit %x: %v", imported.Hash, err)
+	}
+}
+
+// availableReports returns the work reports which became available in a
+// block, W, and the validators of its posterior state
+func (a *Auditor) availableReports(imported chain.ImportedBlock) ([]block.WorkReport, safrole.ValidatorsData, error) {
+	parent, _, err := a.states.State(imported.Header.ParentHash)
+	if err != nil {
+		return nil, safrole.ValidatorsData{}, fmt.Errorf("get parent state: %w", err)
+	}
+	posterior, _, err := a.states.State(imported.Hash)
+	if err != nil {
+		return nil, safrole.ValidatorsData{}, fmt.Errorf("get state: %w", err)
+	}
+	validators := posterior.ValidatorState.CurrentValidators
+	assignments := statetransition.CalculateIntermediateCoreAssignmentsFromExtrinsics(imported.Extrinsic.ED, parent.CoreAssignments)
+	_, available, err := statetransition.CalculateIntermediateCoreFromAssurances(validators, assignments, imported.Header, imported.Extrinsic.EA)
+	if err != nil {
+		return nil, safrole.ValidatorsData{}, fmt.Errorf("get available reports: %w", err)
+	}
+	reports := make([]block.WorkReport, 0, len(available))
+	for _, report := range available {
+		reports = append(reports, *report)
+	}
+	return reports, validators, nil
+}
+
+// begin starts the audit session of a block and announces our tranche 0 audits
+func (a *Auditor) begin(hash crypto.Hash, header block.Header, validators safrole.ValidatorsData, reports []block.WorkReport) error {
+	if len(reports) == 0 {
+		return nil
+	}
+	blockOutput, err := bandersnatch.OutputHash(header.VRFSignature)
+	if err != nil {
+		return fmt.Errorf("get block VRF output: %w", err)
+	}
+	s := &session{
+		hash:        hash,
+		slot:        header.TimeSlotIndex,
+		blockOutput: blockOutput,
+		validators:  validators,
+		selfIndex:   -1,
+		available:   make(map[uint16]crypto.Hash, len(reports)),
+		reports:     make(map[crypto.Hash]*reportAudit, len(reports)),
+	}
+	for i, validator := range validators {
+		if validator != nil && validator.Bandersnatch == a.bandersnatchPub {
+			s.selfIndex = i
+			break
+		}
+	}
+	for _, report := range reports {
+		reportHash, err := report.Hash()
+		if err != nil {
+			return fmt.Errorf("hash work report: %w", err)
+		}
+		s.available[report.CoreIndex] = reportHash
+		s.reports[reportHash] = &reportAudit{
+			report:   report,
+			core:     report.CoreIndex,
+			positive: make(map[uint16]struct{}),
+			negative: make(map[uint16]struct{}),
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.sessions[hash]; ok {
+		return nil
+	}
+	for h, old := range a.sessions {
+		if old.slot+maxSessionSlots < s.slot {
+			delete(a.sessions, h)
+		}
+	}
+	a.sessions[hash] = s
+	if s.selfIndex < 0 {
+		return nil
+	}
+
+	// s0 ∈ F̄[]κ[v]b⟨X_U ⌢ Y(H_v)⟩
+	s0, err := bandersnatch.Sign(a.bandersnatchKey, tranche0Input(blockOutput), []byte{})
+	if err != nil {
+		return fmt.Errorf("sign tranche 0 evidence: %w", err)
+	}
+	output, err := bandersnatch.OutputHash(s0)
+	if err != nil {
+		return fmt.Errorf("get tranche 0 VRF output: %w", err)
+	}
+	selected, err := selectTranche0(output, s.available)
+	if err != nil {
+		return err
+	}
+	return a.announce(s, Announcement{HeaderHash: hash, Tranche: 0, Reports: selected, Tranche0Evidence: s0})
+}
+
+// tick escalates the audits of every session up to the current tranche
+func (a *Auditor) tick(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range a.sessions {
+		if s.selfIndex < 0 {
+			continue
+		}
+		current := int(now.Sub(s.slot.TimeslotStart().ToTime()) / a.config.TrancheDuration)
+		for s.tranche < current && s.tranche < 255 {
+			s.tranche++
+			if err := a.escalate(s, s.tranche); err != nil {
+				log.Printf("auditing: failed to escalate the audits of %x in tranche %d: %v", s.hash, s.tranche, err)
+			}
+		}
+	}
+}
+
+// escalate announces the reports we audit in a later tranche: those judged
+// invalid, and those selected by our VRF signature for the number of no-shows
+// in the previous tranche. Must be called with the lock held.
+func (a *Auditor) escalate(s *session, tranche int) error {
+	announcement := Announcement{HeaderHash: s.hash, Tranche: uint8(tranche)}
+	for _, core := range sortedCores(s.available) {
+		reportHash := s.available[core]
+		r := s.reports[reportHash]
+		if r.ours || r.audited() {
+			continue
+		}
+		noShows := r.noShows(tranche - 1)
+		if len(noShows) == 0 && len(r.negative) == 0 {
+			continue
+		}
+		// s_n(w) ∈ F̄[]κ[v]b⟨X_U ⌢ Y(H_v) ⌢ H(w) n⟩
+		signature, err := bandersnatch.Sign(a.bandersnatchKey, trancheInput(s.blockOutput, reportHash, uint8(tranche)), []byte{})
+		if err != nil {
+			return fmt.Errorf("sign tranche evidence: %w", err)
+		}
+		output, err := bandersnatch.OutputHash(signature)
+		if err != nil {
+			return fmt.Errorf("get tranche VRF output: %w", err)
+		}
+		// Every validator audits reports judged invalid
+		if len(r.negative) == 0 && !escalates(output, len(noShows)) {
+			continue
+		}
+		announcement.Reports = append(announcement.Reports, AuditedReport{CoreIndex: core, ReportHash: reportHash})
+		announcement.Evidence = append(announcement.Evidence, ReportEvidence{Signature: signature, NoShows: noShows})
+	}
+	if len(announcement.Reports) == 0 {
+		return nil
+	}
+	return a.announce(s, announcement)
+}
+
+// announce signs and broadcasts our announcement and starts the audits. Must
+// be called with the lock held.
+func (a *Auditor) announce(s *session, announcement Announcement) error {
+	if err := announcement.Sign(uint16(s.selfIndex), a.ed25519Key); err != nil {
+		return err
+	}
+	for _, audited := range announcement.Reports {
+		r := s.reports[audited.ReportHash]
+		r.ours = true
+		r.announce(int(announcement.Tranche), uint16(s.selfIndex))
+		go a.audit(s.hash, audited.ReportHash, r.report, s.validators)
+	}
+	go func() {
+		if err := a.network.BroadcastAnnouncement(a.ctx, announcement); err != nil {
+			log.Printf("auditing: failed to broadcast announcement: %v", err)
+		}
+	}()
+	return nil
+}
+
+// announce records an auditor announced for the report in a tranche
+func (r *reportAudit) announce(tranche int, validatorIndex uint16) {
+	for len(r.announced) <= tranche {
+		r.announced = append(r.announced, make(map[uint16]struct{}))
+	}
+	r.announced[tranche][validatorIndex] = struct{}{}
+}
+
+// audit fetches the audit bundle of a report and evaluates the work package
+// again, the report is valid if the evaluation results in the same report.
+// A bundle which can not be fetched, or evaluated for lack of the state it
+// refers to, leaves us as a no-show.
+func (a *Auditor) audit(headerHash, reportHash crypto.Hash, report block.WorkReport, validators safrole.ValidatorsData) {
+	bundle, err := a.fetcher.FetchBundle(a.ctx, report, validators)
+	if err != nil {
+		log.Printf("auditing: failed to fetch the audit bundle of %x: %v", reportHash, err)
+		return
+	}
+	valid := false
+	evaluated, err := a.evaluator.EvaluateBundle(bundle, report)
+	if errors.Is(err, chain.ErrStateUnavailable) {
+		log.Printf("auditing: failed to evaluate the work package of %x: %v", reportHash, err)
+		return
+	}
+	if err == nil {
+		evaluatedHash, err := evaluated.Hash()
+		valid = err == nil && evaluatedHash == reportHash
+	}
+
+	a.mu.Lock()
+	s, ok := a.sessions[headerHash]
+	judgment := Judgment{IsValid: valid, ReportHash: reportHash}
+	if ok {
+		s.reports[reportHash].judge(uint16(s.selfIndex), valid)
+		judgment.EpochIndex = uint32(s.slot.ToEpoch())
+		judgment.Sign(uint16(s.selfIndex), a.ed25519Key)
+	}
+	onResult := a.onResult
+	a.mu.Unlock()
+	if ok && onResult != nil {
+		onResult(Result{HeaderHash: headerHash, CoreIndex: report.CoreIndex, ReportHash: reportHash, Report: report, Valid: valid, Judgment: judgment})
+	}
+}
+
+// judge records the judgment of a validator
+func (r *reportAudit) judge(validatorIndex uint16, valid bool) {
+	if valid {
+		r.positive[validatorIndex] = struct{}{}
+	} else {
+		r.negative[validatorIndex] = struct{}{}
+	}
+}
+
+// AddAnnouncement verifies and records the announcement of another auditor
+func (a *Auditor) AddAnnouncement(announcement Announcement) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[announcement.HeaderHash]
+	if !ok {
+		return fmt.Errorf("%w: %x", ErrUnknownBlock, announcement.HeaderHash)
+	}
+	if err := announcement.VerifySignature(s.validators); err != nil {
+		return err
+	}
+	key := s.validators[announcement.ValidatorIndex].Bandersnatch
+	tranche := int(announcement.Tranche)
+	if tranche == 0 {
+		if err := verifyTranche0(announcement, key, s.blockOutput, s.available); err != nil {
+			return err
+		}
+	} else {
+		if len(announcement.Evidence) != len(announcement.Reports) {
+			return fmt.Errorf("%w: %d reports, evidence for %d", ErrBadAnnouncementEvidence, len(announcement.Reports), len(announcement.Evidence))
+		}
+		for i, audited := range announcement.Reports {
+			r, ok := s.reports[audited.ReportHash]
+			if !ok || r.core != audited.CoreIndex {
+				return fmt.Errorf("%w: %x", ErrUnknownReport, audited.ReportHash)
+			}
+			evidence := announcement.Evidence[i]
+			valid, output := bandersnatch.Verify(key, trancheInput(s.blockOutput, audited.ReportHash, announcement.Tranche), []byte{}, evidence.Signature)
+			if !valid {
+				return fmt.Errorf("%w: invalid VRF signature", ErrBadAnnouncementEvidence)
+			}
+			if len(r.negative) == 0 && !escalates(output, len(evidence.NoShows)) {
+				return fmt.Errorf("%w: report of core %d not selected", ErrBadAnnouncementEvidence, audited.CoreIndex)
+			}
+		}
+	}
+	for _, audited := range announcement.Reports {
+		s.reports[audited.ReportHash].announce(tranche, announcement.ValidatorIndex)
+	}
+	return nil
+}
+
+// AddJudgment records the judgment of a validator on a work report which
+// became available in a block. The signature of the judgment is verified by
+// the caller.
+func (a *Auditor) AddJudgment(headerHash, reportHash crypto.Hash, validatorIndex uint16, valid bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[headerHash]
+	if !ok {
+		return fmt.Errorf("%w: %x", ErrUnknownBlock, headerHash)
+	}
+	r, ok := s.reports[reportHash]
+	if !ok {
+		return fmt.Errorf("%w: %x", ErrUnknownReport, reportHash)
+	}
+	if int(validatorIndex) >= len(s.validators) {
+		return ErrBadValidatorIndex
+	}
+	r.judge(validatorIndex, valid)
+	return nil
+}
+
+// AddPublishedJudgment records a judgment published over CE 145 in every
+// audit of its work report. The signature of the judgment is verified by the
+// caller, judgments of other epochs than the audited block's are ignored.
+func (a *Auditor) AddPublishedJudgment(judgment Judgment) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range a.sessions {
+		r, ok := s.reports[judgment.ReportHash]
+		if !ok || uint32(s.slot.ToEpoch()) != judgment.EpochIndex || int(judgment.ValidatorIndex) >= len(s.validators) {
+			continue
+		}
+		r.judge(judgment.ValidatorIndex, judgment.IsValid)
+	}
+}
+
+// Audited tells whether all work reports which became available in a block
+// are audited. Blocks without available reports, or which are not tracked,
+// are not considered audited.
+func (a *Auditor) Audited(headerHash crypto.Hash) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[headerHash]
+	if !ok {
+		return false
+	}
+	for _, r := range s.reports {
+		if !r.audited() {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedCores returns the cores with available reports in ascending order
+func sortedCores(available map[uint16]crypto.Hash) []uint16 {
+	cores := make([]uint16, 0, len(available))
+	for core := range available {
+		cores = append(cores, core)
+	}
+	sort.Slice(cores, func(i, j int) bool { return cores[i] < cores[j] })
+	return cores
+}
@@ -0,0 +1,295 @@
+package auditing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/internal/work"
+	"github.com/eigerco/strawberry/internal/work/results"
+)
+
+type networkMock struct {
+	mu            sync.Mutex
+	announcements []Announcement
+	judgments     []Judgment
+}
+
+func (n *networkMock) BroadcastJudgment(_ context.Context, judgment Judgment) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.judgments = append(n.judgments, judgment)
+	return nil
+}
+
+func (n *networkMock) published() []Judgment {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Judgment(nil), n.judgments...)
+}
+
+func (n *networkMock) BroadcastAnnouncement(_ context.Context, announcement Announcement) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.announcements = append(n.announcements, announcement)
+	return nil
+}
+
+// announced returns our broadcast announcement of the given tranche, waiting for it
+func (n *networkMock) announced(t *testing.T, tranche uint8) Announcement {
+	var announcement Announcement
+	require.Eventually(t, func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for _, a := range n.announcements {
+			if a.Tranche == tranche {
+				announcement = a
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+	return announcement
+}
+
+// bundles serves the work package of every report by its core, the evaluator
+// returns the report it maps the core to
+type bundles struct {
+	mu          sync.Mutex
+	reports     map[uint16]block.WorkReport
+	unavailable map[uint16]struct{} // Cores whose bundles can not be fetched
+}
+
+func (b *bundles) FetchBundle(_ context.Context, report block.WorkReport, _ safrole.ValidatorsData) (results.AuditBundle, error) {
+	if _, ok := b.unavailable[report.CoreIndex]; ok {
+		return results.AuditBundle{}, errors.New("bundle not available")
+	}
+	return results.AuditBundle{Package: work.Package{AuthorizerService: uint32(report.CoreIndex)}}, nil
+}
+
+func (b *bundles) EvaluateBundle(bundle results.AuditBundle, _ block.WorkReport) (*block.WorkReport, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	report := b.reports[uint16(bundle.Package.AuthorizerService)]
+	return &report, nil
+}
+
+type testValidator struct {
+	bandersnatch crypto.BandersnatchPrivateKey
+	ed25519      ed25519.PrivateKey
+}
+
+// newTestValidators returns validators of which the first ones have keys
+func newTestValidators(t *testing.T, count int) (safrole.ValidatorsData, []testValidator) {
+	var validators safrole.ValidatorsData
+	keys := make([]testValidator, count)
+	for i := range keys {
+		keys[i].bandersnatch = testutils.RandomBandersnatchPrivateKey(t)
+		public, err := bandersnatch.Public(keys[i].bandersnatch)
+		require.NoError(t, err)
+		edPublic, edPrivate, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		keys[i].ed25519 = edPrivate
+		validators[i] = &crypto.ValidatorKey{Bandersnatch: public, Ed25519: edPublic}
+	}
+	return validators, keys
+}
+
+func newTestReports(t *testing.T, cores ...uint16) []block.WorkReport {
+	reports := make([]block.WorkReport, len(cores))
+	for i, core := range cores {
+		reports[i] = block.WorkReport{CoreIndex: core, AuthorizerHash: testutils.RandomHash(t)}
+	}
+	return reports
+}
+
+func newTestHeader(t *testing.T, key crypto.BandersnatchPrivateKey) block.Header {
+	signature, err := bandersnatch.Sign(key, []byte("jam_entropy"), []byte{})
+	require.NoError(t, err)
+	return block.Header{TimeSlotIndex: 1, VRFSignature: signature}
+}
+
+// tranche0Cores returns the cores whose reports the validator audits in
+// tranche 0 if they became available in the block, followed by the others
+func tranche0Cores(t *testing.T, key crypto.BandersnatchPrivateKey, header block.Header) []uint16 {
+	blockOutput, err := bandersnatch.OutputHash(header.VRFSignature)
+	require.NoError(t, err)
+	s0, err := bandersnatch.Sign(key, tranche0Input(blockOutput), []byte{})
+	require.NoError(t, err)
+	output, err := bandersnatch.OutputHash(s0)
+	require.NoError(t, err)
+	cores := make([]uint32, common.TotalNumberOfCores)
+	for i := range cores {
+		cores[i] = uint32(i)
+	}
+	shuffled, err := common.DeterministicShuffle(cores, crypto.Hash(output))
+	require.NoError(t, err)
+	result := make([]uint16, len(shuffled))
+	for i, core := range shuffled {
+		result[i] = uint16(core)
+	}
+	return result
+}
+
+func newTestAuditor(t *testing.T, key testValidator, evaluator *bundles) (*Auditor, *networkMock) {
+	network := &networkMock{}
+	auditor, err := NewAuditor(context.Background(), nil, network, evaluator, evaluator, key.bandersnatch, key.ed25519, Config{})
+	require.NoError(t, err)
+	return auditor, network
+}
+
+func TestAuditor_Tranche0(t *testing.T) {
+	validators, keys := newTestValidators(t, 2)
+	header := newTestHeader(t, keys[0].bandersnatch)
+	hash := testutils.RandomHash(t)
+
+	// Three reports on cores selected for tranche 0 and one on a core which is not
+	cores := tranche0Cores(t, keys[0].bandersnatch, header)
+	reports := newTestReports(t, cores[0], cores[1], cores[2], cores[Tranche0Audits])
+
+	// The report of the third core does not match its work package
+	evaluator := &bundles{reports: map[uint16]block.WorkReport{
+		cores[0]:              reports[0],
+		cores[1]:              reports[1],
+		cores[2]:              newTestReports(t, cores[2])[0],
+		cores[Tranche0Audits]: reports[3],
+	}}
+	auditor, network := newTestAuditor(t, keys[0], evaluator)
+	var mu sync.Mutex
+	results := make(map[uint16]bool)
+	auditor.OnResult(func(result Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[result.CoreIndex] = result.Valid
+	})
+	require.NoError(t, auditor.begin(hash, header, validators, reports))
+
+	// Fewer than 10 reports are available, still only those on the first 10
+	// shuffled cores are audited in tranche 0
+	announcement := network.announced(t, 0)
+	assert.Equal(t, hash, announcement.HeaderHash)
+	require.Len(t, announcement.Reports, 3)
+	for i, audited := range announcement.Reports {
+		assert.Equal(t, cores[i], audited.CoreIndex)
+	}
+	assert.Equal(t, uint16(0), announcement.ValidatorIndex)
+	require.NoError(t, announcement.VerifySignature(validators))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(results) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, map[uint16]bool{cores[0]: true, cores[1]: true, cores[2]: false}, results)
+	assert.False(t, auditor.Audited(hash))
+
+	// Another validator verifies our announcement
+	other, _ := newTestAuditor(t, keys[1], evaluator)
+	require.NoError(t, other.begin(hash, header, validators, reports))
+	require.NoError(t, other.AddAnnouncement(announcement))
+
+	// Announcing the report of a core which was not selected is rejected
+	forged := announcement
+	reportHash, err := reports[3].Hash()
+	require.NoError(t, err)
+	forged.Reports = append(forged.Reports, AuditedReport{CoreIndex: cores[Tranche0Audits], ReportHash: reportHash})
+	require.NoError(t, forged.Sign(0, keys[0].ed25519))
+	assert.ErrorIs(t, other.AddAnnouncement(forged), ErrBadAnnouncementEvidence)
+
+	forged.Reports = announcement.Reports[1:]
+	assert.ErrorIs(t, other.AddAnnouncement(forged), ErrBadAnnouncementSignature)
+	require.NoError(t, forged.Sign(0, keys[0].ed25519))
+	assert.ErrorIs(t, other.AddAnnouncement(forged), ErrBadAnnouncementEvidence)
+	forged.HeaderHash = testutils.RandomHash(t)
+	assert.ErrorIs(t, other.AddAnnouncement(forged), ErrUnknownBlock)
+}
+
+func TestAuditor_Escalation(t *testing.T) {
+	validators, keys := newTestValidators(t, 2)
+	// Reports on every core, more than are audited in tranche 0, one of them can not be fetched
+	var cores []uint16
+	for core := range common.TotalNumberOfCores {
+		cores = append(cores, core)
+	}
+	reports := newTestReports(t, cores...)
+	header := newTestHeader(t, keys[0].bandersnatch)
+	hash := testutils.RandomHash(t)
+	evaluator := &bundles{reports: make(map[uint16]block.WorkReport), unavailable: map[uint16]struct{}{100: {}}}
+	for _, report := range reports {
+		evaluator.reports[report.CoreIndex] = report
+	}
+
+	auditor, network := newTestAuditor(t, keys[0], evaluator)
+	require.NoError(t, auditor.begin(hash, header, validators, reports))
+	tranche0 := network.announced(t, 0)
+	require.Len(t, tranche0.Reports, Tranche0Audits)
+	selected := make(map[crypto.Hash]struct{})
+	for _, audited := range tranche0.Reports {
+		selected[audited.ReportHash] = struct{}{}
+	}
+
+	// A report we did not select is judged invalid by another validator
+	var judged block.WorkReport
+	var judgedHash crypto.Hash
+	for _, report := range reports {
+		reportHash, err := report.Hash()
+		require.NoError(t, err)
+		if _, ok := selected[reportHash]; !ok {
+			judged, judgedHash = report, reportHash
+			break
+		}
+	}
+	require.NoError(t, auditor.AddJudgment(hash, judgedHash, 1, false))
+	assert.ErrorIs(t, auditor.AddJudgment(hash, testutils.RandomHash(t), 1, false), ErrUnknownReport)
+
+	// so we audit it in the next tranche
+	auditor.tick(header.TimeSlotIndex.TimeslotStart().ToTime().Add(DefaultTrancheDuration + time.Second))
+	tranche1 := network.announced(t, 1)
+	require.Len(t, tranche1.Reports, 1)
+	assert.Equal(t, AuditedReport{CoreIndex: judged.CoreIndex, ReportHash: judgedHash}, tranche1.Reports[0])
+
+	// and another validator aware of the negative judgment accepts the evidence
+	other, _ := newTestAuditor(t, keys[1], evaluator)
+	require.NoError(t, other.begin(hash, header, validators, reports))
+	assert.ErrorIs(t, other.AddAnnouncement(tranche1), ErrBadAnnouncementEvidence)
+	require.NoError(t, other.AddJudgment(hash, judgedHash, 1, false))
+	require.NoError(t, other.AddAnnouncement(tranche1))
+}
+
+func TestReportAudit_Audited(t *testing.T) {
+	r := &reportAudit{positive: make(map[uint16]struct{}), negative: make(map[uint16]struct{})}
+	assert.False(t, r.audited())
+
+	r.announce(0, 1)
+	r.announce(0, 2)
+	r.judge(1, true)
+	assert.False(t, r.audited())
+	assert.Equal(t, []uint16{2}, r.noShows(0))
+
+	// All auditors of a tranche judged the report valid
+	r.judge(2, true)
+	assert.True(t, r.audited())
+	assert.Empty(t, r.noShows(0))
+
+	// A negative judgment requires a supermajority of positive ones
+	r.judge(3, false)
+	assert.False(t, r.audited())
+}
+
+func TestEscalates(t *testing.T) {
+	assert.False(t, escalates(crypto.BandersnatchOutputHash{}, 0))
+	assert.True(t, escalates(crypto.BandersnatchOutputHash{}, 1))
+	assert.True(t, escalates(crypto.BandersnatchOutputHash{255}, 1000))
+}
@@ -0,0 +1,225 @@
+package auditing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/eigerco/strawberry/internal/authorization"
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/erasurecoding"
+	"github.com/eigerco/strawberry/internal/refine"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/work"
+	"github.com/eigerco/strawberry/internal/work/results"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+// maxStoredBundles is the number of audit bundles the bundle store holds,
+// enough for the reports of a few blocks on every core
+const maxStoredBundles = 4 * int(common.TotalNumberOfCores)
+
+var (
+	ErrUnknownBundle     = errors.New("audit bundle not known")
+	ErrBadShardIndex     = errors.New("bad shard index")
+	ErrBundleUnavailable = errors.New("not enough audit shards")
+	ErrBundleMismatch    = errors.New("audit bundle does not match the work report")
+)
+
+// ShardNetwork requests audit shards from the assurers over CE 138
+type ShardNetwork interface {
+	RequestAuditShard(ctx context.Context, peerKey ed25519.PublicKey, erasureRoot crypto.Hash, shardIndex uint16) ([]byte, error)
+}
+
+// storedBundle is an audit bundle and its shards, encoded when first requested
+type storedBundle struct {
+	bundle []byte
+	shards [][]byte
+}
+
+// BundleStore holds the audit bundles known locally by erasure root: those of
+// the work packages we guaranteed, and those we reconstructed as auditors.
+// The oldest bundles are dropped beyond maxStoredBundles. It serves their
+// audit shards to the auditors.
+type BundleStore struct {
+	mu      sync.Mutex
+	bundles map[crypto.Hash]*storedBundle
+	order   []crypto.Hash // Erasure roots in insertion order
+}
+
+// NewBundleStore creates an empty bundle store
+func NewBundleStore() *BundleStore {
+	return &BundleStore{bundles: make(map[crypto.Hash]*storedBundle)}
+}
+
+// Put stores the audit bundle with the given erasure root
+func (s *BundleStore) Put(erasureRoot crypto.Hash, bundle []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.bundles[erasureRoot]; ok {
+		return
+	}
+	s.bundles[erasureRoot] = &storedBundle{bundle: bundle}
+	s.order = append(s.order, erasureRoot)
+	for len(s.order) > maxStoredBundles {
+		delete(s.bundles, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Get returns the audit bundle with the given erasure root
+func (s *BundleStore) Get(erasureRoot crypto.Hash) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.bundles[erasureRoot]
+	if !ok {
+		return nil, false
+	}
+	return stored.bundle, true
+}
+
+// AuditShard returns a shard of the erasure coded audit bundle with the given
+// erasure root, as served over CE 138
+func (s *BundleStore) AuditShard(erasureRoot crypto.Hash, shardIndex uint16) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.bundles[erasureRoot]
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownBundle, erasureRoot)
+	}
+	if stored.shards == nil {
+		shards, err := erasurecoding.Encode(work.ZeroPadding(stored.bundle, common.ErasureCodingChunkSize))
+		if err != nil {
+			return nil, fmt.Errorf("encode audit bundle: %w", err)
+		}
+		stored.shards = shards
+	}
+	if int(shardIndex) >= len(stored.shards) {
+		return nil, ErrBadShardIndex
+	}
+	return stored.shards[shardIndex], nil
+}
+
+// NetworkBundleFetcher fetches the audit bundles of work reports from the
+// local bundle store, or reconstructs them from the shards held by the
+// assurers of the audited block.
+type NetworkBundleFetcher struct {
+	network ShardNetwork
+	store   *BundleStore
+}
+
+// NewNetworkBundleFetcher creates a bundle fetcher requesting the shards over
+// the network, and looking up the bundles in the store first
+func NewNetworkBundleFetcher(network ShardNetwork, store *BundleStore) *NetworkBundleFetcher {
+	return &NetworkBundleFetcher{network: network, store: store}
+}
+
+// FetchBundle returns the audit bundle of a work report, reconstructed from
+// the shards of the given validators, κ′ of the block the report became
+// available in. Reconstructed bundles are checked to contain the reported
+// work package, and are then kept in the store to serve their shards to the
+// other auditors.
+func (f *NetworkBundleFetcher) FetchBundle(ctx context.Context, report block.WorkReport, validators safrole.ValidatorsData) (results.AuditBundle, error) {
+	spec := report.WorkPackageSpecification
+	b, stored := f.store.Get(spec.ErasureRoot)
+	if !stored {
+		var err error
+		b, err = f.reconstruct(ctx, report, validators)
+		if err != nil {
+			return results.AuditBundle{}, err
+		}
+	}
+	bundle, err := results.DecodeAuditBundle(b, report.SegmentRootLookup)
+	if err != nil {
+		return results.AuditBundle{}, err
+	}
+	wpBytes, err := jam.Marshal(bundle.Package)
+	if err != nil {
+		return results.AuditBundle{}, fmt.Errorf("marshal work package: %w", err)
+	}
+	if crypto.HashData(wpBytes) != spec.WorkPackageHash {
+		return results.AuditBundle{}, ErrBundleMismatch
+	}
+	if !stored {
+		f.store.Put(spec.ErasureRoot, b)
+	}
+	return bundle, nil
+}
+
+// reconstruct requests the shards of the audit bundle of a report from all
+// the validators at once, and decodes the bundle as soon as enough arrived
+func (f *NetworkBundleFetcher) reconstruct(ctx context.Context, report block.WorkReport, validators safrole.ValidatorsData) ([]byte, error) {
+	spec := report.WorkPackageSpecification
+	chunks := (int(spec.AuditableWorkBundleLength) + common.ErasureCodingChunkSize - 1) / common.ErasureCodingChunkSize
+	shardSize := chunks * erasurecoding.ChunkShardSize
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type response struct {
+		index uint16
+		shard []byte
+	}
+	responses := make(chan response, len(validators))
+	for v, validator := range validators {
+		index := shardIndex(report.CoreIndex, uint16(v))
+		if validator == nil {
+			responses <- response{index: index}
+			continue
+		}
+		go func() {
+			shard, err := f.network.RequestAuditShard(ctx, validator.Ed25519, spec.ErasureRoot, index)
+			if err != nil || len(shard) != shardSize {
+				shard = nil
+			}
+			responses <- response{index: index, shard: shard}
+		}()
+	}
+
+	shards := make([][]byte, len(validators))
+	received := 0
+	for range validators {
+		r := <-responses
+		if r.shard == nil {
+			continue
+		}
+		shards[r.index] = r.shard
+		received++
+		if received == erasurecoding.OriginalShards {
+			return erasurecoding.Decode(shards, int(spec.AuditableWorkBundleLength))
+		}
+	}
+	return nil, fmt.Errorf("%w: received %d of %d", ErrBundleUnavailable, received, erasurecoding.OriginalShards)
+}
+
+// shardIndex returns the index of the shard a validator holds for a core:
+// (cR + v) mod V, with R the number of shards needed to reconstruct the data
+func shardIndex(core, validator uint16) uint16 {
+	return uint16((int(core)*erasurecoding.OriginalShards + int(validator)) % common.NumberOfValidators)
+}
+
+// StateEvaluator evaluates the work packages of audit bundles on the
+// posterior state of their lookup anchor
+type StateEvaluator struct {
+	states *chain.StateManager
+}
+
+// NewStateEvaluator creates an evaluator using the states of the imported blocks
+func NewStateEvaluator(states *chain.StateManager) *StateEvaluator {
+	return &StateEvaluator{states: states}
+}
+
+// EvaluateBundle computes the work report of the work package in an audit
+// bundle, on the core and with the segment-root lookup of the audited report
+func (e *StateEvaluator) EvaluateBundle(bundle results.AuditBundle, report block.WorkReport) (*block.WorkReport, error) {
+	s, _, err := e.states.State(bundle.Package.Context.LookupAnchor.HeaderHash)
+	if err != nil {
+		return nil, fmt.Errorf("get lookup anchor state: %w", err)
+	}
+	computation := results.NewComputation(authorization.New(s), refine.New(s), report.SegmentRootLookup, bundle.SegmentData, bundle.ExtrinsicPreimages)
+	return computation.EvaluateWorkPackage(bundle.Package, report.CoreIndex)
+}
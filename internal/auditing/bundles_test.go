@@ -0,0 +1,94 @@
+package auditing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/internal/work"
+	"github.com/eigerco/strawberry/internal/work/results"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+func TestNetworkBundleFetcher_Store(t *testing.T) {
+	wp := work.Package{AuthorizationToken: []byte("token"), WorkItems: []work.Item{{Payload: []byte("payload")}}}
+	encoded, err := results.EncodeAuditBundle(results.AuditBundle{Package: wp}, nil)
+	require.NoError(t, err)
+	wpBytes, err := jam.Marshal(wp)
+	require.NoError(t, err)
+
+	store := NewBundleStore()
+	erasureRoot := testutils.RandomHash(t)
+	store.Put(erasureRoot, encoded)
+	fetcher := NewNetworkBundleFetcher(nil, store)
+
+	report := block.WorkReport{WorkPackageSpecification: block.WorkPackageSpecification{
+		WorkPackageHash: crypto.HashData(wpBytes),
+		ErasureRoot:     erasureRoot,
+	}}
+	bundle, err := fetcher.FetchBundle(context.Background(), report, safrole.ValidatorsData{})
+	require.NoError(t, err)
+	assert.Equal(t, wp.AuthorizationToken, bundle.Package.AuthorizationToken)
+
+	// A bundle with another work package than the reported one is rejected
+	report.WorkPackageSpecification.WorkPackageHash = testutils.RandomHash(t)
+	_, err = fetcher.FetchBundle(context.Background(), report, safrole.ValidatorsData{})
+	assert.ErrorIs(t, err, ErrBundleMismatch)
+
+	_, err = store.AuditShard(testutils.RandomHash(t), 0)
+	assert.ErrorIs(t, err, ErrUnknownBundle)
+}
+
+func TestBundleStore_Eviction(t *testing.T) {
+	store := NewBundleStore()
+	roots := make([]crypto.Hash, maxStoredBundles+1)
+	for i := range roots {
+		roots[i] = testutils.RandomHash(t)
+		store.Put(roots[i], []byte{byte(i)})
+	}
+	_, ok := store.Get(roots[0])
+	assert.False(t, ok)
+	bundle, ok := store.Get(roots[len(roots)-1])
+	require.True(t, ok)
+	assert.Equal(t, []byte{byte(len(roots) - 1)}, bundle)
+}
+
+// shardRequests records the validators audit shards were requested from
+type shardRequests struct {
+	mu    sync.Mutex
+	peers map[string]uint16
+}
+
+func (n *shardRequests) RequestAuditShard(_ context.Context, peerKey ed25519.PublicKey, _ crypto.Hash, shardIndex uint16) ([]byte, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.peers[string(peerKey)] = shardIndex
+	return nil, errors.New("shard not held")
+}
+
+func TestNetworkBundleFetcher_Validators(t *testing.T) {
+	// The shards are requested from the given validators, κ′ of the audited
+	// block, by their index in it
+	validators, _ := newTestValidators(t, 2)
+	network := &shardRequests{peers: make(map[string]uint16)}
+	fetcher := NewNetworkBundleFetcher(network, NewBundleStore())
+	report := block.WorkReport{CoreIndex: 1, WorkPackageSpecification: block.WorkPackageSpecification{
+		ErasureRoot:               testutils.RandomHash(t),
+		AuditableWorkBundleLength: 100,
+	}}
+	_, err := fetcher.FetchBundle(context.Background(), report, validators)
+	assert.ErrorIs(t, err, ErrBundleUnavailable)
+	assert.Equal(t, map[string]uint16{
+		string(validators[0].Ed25519): shardIndex(1, 0),
+		string(validators[1].Ed25519): shardIndex(1, 1),
+	}, network.peers)
+}
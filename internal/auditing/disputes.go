@@ -0,0 +1,361 @@
+package auditing
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/state"
+	"github.com/eigerco/strawberry/internal/validator"
+)
+
+var (
+	ErrJudgmentAge       = errors.New("judgment not of the current or previous epoch")
+	ErrDuplicateJudgment = errors.New("duplicate judgment")
+)
+
+// JudgmentNetwork publishes our judgments to the other validators
+type JudgmentNetwork interface {
+	BroadcastJudgment(ctx context.Context, judgment Judgment) error
+}
+
+// dispute collects the judgments on a work report
+type dispute struct {
+	epoch     uint32                         // The latest epoch the report was guaranteed or judged in
+	guarantee *block.Guarantee               // Its guarantors are the culprits of a bad verdict
+	judgments map[uint32]map[uint16]Judgment // By epoch and validator index
+	ours      *Judgment                      // Our judgment, published once the report is disputed
+	published bool
+}
+
+// disputed tells whether any validator judged the report invalid
+func (d *dispute) disputed() bool {
+	for _, judgments := range d.judgments {
+		for _, judgment := range judgments {
+			if !judgment.IsValid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DisputeCollector publishes our judgments over CE 145, collects the ones of
+// the other validators and builds the disputes extrinsic once enough of them
+// agree on a verdict (section 10). Our judgments are published when we judge
+// a report invalid, or judged it valid and another validator did not.
+//
+// A verdict takes ⌊2/3V⌋+1 judgments of one epoch: all of them positive for
+// a good report, all negative for a bad one, or ⌊1/3V⌋ positive for a wonky
+// one. A good verdict needs the validators which judged the report invalid as
+// faults, a bad one the guarantors of the report as culprits and the
+// validators which judged it valid as faults.
+type DisputeCollector struct {
+	ctx     context.Context
+	states  *chain.StateManager
+	network JudgmentNetwork
+
+	mu      sync.Mutex
+	reports map[crypto.Hash]*dispute
+}
+
+// NewDisputeCollector creates a dispute collector publishing our judgments
+// to the network
+func NewDisputeCollector(ctx context.Context, states *chain.StateManager, network JudgmentNetwork) *DisputeCollector {
+	return &DisputeCollector{
+		ctx:     ctx,
+		states:  states,
+		network: network,
+		reports: make(map[crypto.Hash]*dispute),
+	}
+}
+
+// report returns the dispute on a work report, creating it if needed. Must
+// be called with the lock held.
+func (c *DisputeCollector) report(reportHash crypto.Hash, epoch uint32) *dispute {
+	r, ok := c.reports[reportHash]
+	if !ok {
+		r = &dispute{judgments: make(map[uint32]map[uint16]Judgment)}
+		c.reports[reportHash] = r
+	}
+	r.epoch = max(r.epoch, epoch)
+	return r
+}
+
+// OnImported records the guarantees of an imported block, the guarantors of
+// a report judged bad are its culprits. Reports which can no longer be
+// judged are dropped. It is meant to be subscribed to the importer.
+func (c *DisputeCollector) OnImported(imported chain.ImportedBlock) {
+	epoch := uint32(imported.Header.TimeSlotIndex.ToEpoch())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, guarantee := range imported.Extrinsic.EG.Guarantees {
+		reportHash, err := guarantee.WorkReport.Hash()
+		if err != nil {
+			log.Printf("disputes: failed to hash work report: %v", err)
+			continue
+		}
+		c.report(reportHash, epoch).guarantee = &guarantee
+	}
+	for reportHash, r := range c.reports {
+		if r.epoch+1 < epoch {
+			delete(c.reports, reportHash)
+		}
+	}
+}
+
+// OnResult records our judgment of an audited report and publishes it if
+// the report is disputed. It is meant to be passed to Auditor.OnResult.
+func (c *DisputeCollector) OnResult(result Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	judgment := result.Judgment
+	r := c.report(judgment.ReportHash, judgment.EpochIndex)
+	r.ours = &judgment
+	c.add(r, judgment)
+}
+
+// AddJudgment verifies and records the judgment of another validator. The
+// judgment is verified against the validators of its epoch as known by the
+// best head, which has to be the current or previous epoch.
+func (c *DisputeCollector) AddJudgment(judgment Judgment) error {
+	head, _, err := c.states.State(c.states.BestHead())
+	if err != nil {
+		return fmt.Errorf("get head state: %w", err)
+	}
+	validators, ok := epochValidators(uint32(head.TimeslotIndex.ToEpoch()), judgment.EpochIndex, head.ValidatorState)
+	if !ok {
+		return ErrJudgmentAge
+	}
+	if err := judgment.VerifySignature(validators); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.report(judgment.ReportHash, judgment.EpochIndex)
+	if _, ok := r.judgments[judgment.EpochIndex][judgment.ValidatorIndex]; ok {
+		return ErrDuplicateJudgment
+	}
+	c.add(r, judgment)
+	return nil
+}
+
+// add records a judgment, the first one of a validator in an epoch is kept,
+// and publishes our judgment once the report is disputed. Must be called
+// with the lock held.
+func (c *DisputeCollector) add(r *dispute, judgment Judgment) {
+	judgments, ok := r.judgments[judgment.EpochIndex]
+	if !ok {
+		judgments = make(map[uint16]Judgment)
+		r.judgments[judgment.EpochIndex] = judgments
+	}
+	if _, ok := judgments[judgment.ValidatorIndex]; !ok {
+		judgments[judgment.ValidatorIndex] = judgment
+	}
+	if r.ours == nil || r.published || !r.disputed() {
+		return
+	}
+	r.published = true
+	ours := *r.ours
+	go func() {
+		if err := c.network.BroadcastJudgment(c.ctx, ours); err != nil {
+			log.Printf("disputes: failed to broadcast judgment: %v", err)
+		}
+	}()
+}
+
+// Disputes builds the disputes extrinsic for a block at the given slot on
+// top of a parent with the given posterior state, from the reports which
+// reached a verdict and are not judged yet. Verdicts, culprits and faults are
+// sorted and unique, and signed by the validators the block is verified
+// against.
+func (c *DisputeCollector) Disputes(parent state.State, slot jamtime.Timeslot) block.DisputeExtrinsic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	judged := make(map[crypto.Hash]struct{})
+	for _, reports := range [][]crypto.Hash{parent.PastJudgements.GoodWorkReports, parent.PastJudgements.BadWorkReports, parent.PastJudgements.WonkyWorkReports} {
+		for _, reportHash := range reports {
+			judged[reportHash] = struct{}{}
+		}
+	}
+	// A validator is reported as offender once
+	offenders := make(map[string]struct{})
+	for _, key := range parent.PastJudgements.OffendingValidators {
+		offenders[string(key)] = struct{}{}
+	}
+
+	reportHashes := make([]crypto.Hash, 0, len(c.reports))
+	for reportHash := range c.reports {
+		if _, ok := judged[reportHash]; !ok {
+			reportHashes = append(reportHashes, reportHash)
+		}
+	}
+	sort.Slice(reportHashes, func(i, j int) bool {
+		return bytes.Compare(reportHashes[i][:], reportHashes[j][:]) < 0
+	})
+
+	var disputes block.DisputeExtrinsic
+	currentEpoch := uint32(slot.ToEpoch())
+	for _, reportHash := range reportHashes {
+		r := c.reports[reportHash]
+		verdict, contradicting, validators, ok := r.verdict(reportHash, currentEpoch, parent.ValidatorState)
+		if !ok {
+			continue
+		}
+		positive := block.CountPositiveJudgments(verdict.Judgements)
+
+		var culprits []block.Culprit
+		if positive == 0 {
+			culprits = r.culprits(reportHash, parent.ValidatorState, offenders)
+		}
+		// A guarantor which judged its report valid is only reported as culprit
+		reported := make(map[string]struct{}, len(culprits))
+		for _, culprit := range culprits {
+			reported[string(culprit.ValidatorEd25519PublicKey)] = struct{}{}
+		}
+		var faults []block.Fault
+		for _, judgment := range contradicting {
+			key := validators[judgment.ValidatorIndex].Ed25519
+			_, offender := offenders[string(key)]
+			_, culprit := reported[string(key)]
+			if !offender && !culprit {
+				faults = append(faults, block.Fault{ReportHash: reportHash, IsValid: judgment.IsValid, ValidatorEd25519PublicKey: key, Signature: judgment.Signature})
+			}
+		}
+
+		// A good verdict needs a fault, a bad one two culprits
+		if positive == common.ValidatorsSuperMajority && len(faults) == 0 {
+			continue
+		}
+		if positive == 0 && len(culprits) < 2 {
+			continue
+		}
+		disputes.Verdicts = append(disputes.Verdicts, verdict)
+		disputes.Culprits = append(disputes.Culprits, culprits...)
+		disputes.Faults = append(disputes.Faults, faults...)
+		for key := range reported {
+			offenders[key] = struct{}{}
+		}
+		for _, fault := range faults {
+			offenders[string(fault.ValidatorEd25519PublicKey)] = struct{}{}
+		}
+	}
+
+	sort.Slice(disputes.Culprits, func(i, j int) bool {
+		return bytes.Compare(disputes.Culprits[i].ValidatorEd25519PublicKey, disputes.Culprits[j].ValidatorEd25519PublicKey) < 0
+	})
+	sort.Slice(disputes.Faults, func(i, j int) bool {
+		return bytes.Compare(disputes.Faults[i].ValidatorEd25519PublicKey, disputes.Faults[j].ValidatorEd25519PublicKey) < 0
+	})
+	return disputes
+}
+
+// verdict builds the verdict on a report from the judgments of the current
+// epoch, or of the previous one if they do not reach a verdict. Returns the
+// verdict, the judgments contradicting it and the validators of its epoch.
+func (d *dispute) verdict(reportHash crypto.Hash, currentEpoch uint32, validators validator.ValidatorState) (block.Verdict, []Judgment, safrole.ValidatorsData, bool) {
+	const (
+		superMajority = common.ValidatorsSuperMajority // ⌊2/3V⌋+1
+		oneThird      = common.NumberOfValidators / 3  // ⌊1/3V⌋
+	)
+	epochs := []uint32{currentEpoch}
+	if currentEpoch > 0 {
+		epochs = append(epochs, currentEpoch-1)
+	}
+	for _, epoch := range epochs {
+		set, _ := epochValidators(currentEpoch, epoch, validators)
+		// The judgments were verified against the validators known when they
+		// were added, they are checked again against the ones of the block
+		var positive, negative []Judgment
+		for _, judgment := range d.judgments[epoch] {
+			if judgment.VerifySignature(set) != nil {
+				continue
+			}
+			if judgment.IsValid {
+				positive = append(positive, judgment)
+			} else {
+				negative = append(negative, judgment)
+			}
+		}
+		sortJudgments(positive)
+		sortJudgments(negative)
+
+		var votes, contradicting []Judgment
+		switch {
+		case len(positive) >= superMajority:
+			votes, contradicting = positive[:superMajority], negative
+		case len(negative) >= superMajority:
+			votes, contradicting = negative[:superMajority], positive
+		case len(positive) >= oneThird && len(negative) >= superMajority-oneThird:
+			votes = append(append(votes, positive[:oneThird]...), negative[:superMajority-oneThird]...)
+			sortJudgments(votes)
+		default:
+			continue
+		}
+
+		verdict := block.Verdict{ReportHash: reportHash, EpochIndex: epoch}
+		for i, judgment := range votes {
+			verdict.Judgements[i] = block.Judgement{IsValid: judgment.IsValid, ValidatorIndex: judgment.ValidatorIndex, Signature: judgment.Signature}
+		}
+		return verdict, contradicting, set, true
+	}
+	return block.Verdict{}, nil, safrole.ValidatorsData{}, false
+}
+
+// culprits returns the guarantors of a report which are not offenders yet.
+// The guarantors are validators of the current or previous rotation, so
+// their keys are those of the current or archived validators signing the
+// guarantee.
+func (d *dispute) culprits(reportHash crypto.Hash, validators validator.ValidatorState, offenders map[string]struct{}) []block.Culprit {
+	if d.guarantee == nil {
+		return nil
+	}
+	message := append([]byte(state.SignatureContextGuarantee), reportHash[:]...)
+	var culprits []block.Culprit
+	for _, credential := range d.guarantee.Credentials {
+		for _, set := range []safrole.ValidatorsData{validators.CurrentValidators, validators.ArchivedValidators} {
+			if int(credential.ValidatorIndex) >= len(set) || set[credential.ValidatorIndex] == nil {
+				continue
+			}
+			key := set[credential.ValidatorIndex].Ed25519
+			if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, message, credential.Signature[:]) {
+				continue
+			}
+			if _, ok := offenders[string(key)]; !ok {
+				culprits = append(culprits, block.Culprit{ReportHash: reportHash, ValidatorEd25519PublicKey: key, Signature: credential.Signature})
+			}
+			break
+		}
+	}
+	return culprits
+}
+
+// epochValidators returns the validators judging in an epoch: κ for the
+// current epoch and λ for the previous one
+func epochValidators(currentEpoch, epoch uint32, validators validator.ValidatorState) (safrole.ValidatorsData, bool) {
+	switch {
+	case epoch == currentEpoch:
+		return validators.CurrentValidators, true
+	case epoch+1 == currentEpoch:
+		return validators.ArchivedValidators, true
+	}
+	return safrole.ValidatorsData{}, false
+}
+
+// sortJudgments orders judgments by validator index
+func sortJudgments(judgments []Judgment) {
+	sort.Slice(judgments, func(i, j int) bool { return judgments[i].ValidatorIndex < judgments[j].ValidatorIndex })
+}
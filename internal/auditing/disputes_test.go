@@ -0,0 +1,135 @@
+package auditing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/state"
+	"github.com/eigerco/strawberry/internal/statetransition"
+	"github.com/eigerco/strawberry/internal/testutils"
+)
+
+// newTestValidatorSet returns a full validator set with ed25519 keys only
+func newTestValidatorSet(t *testing.T) (safrole.ValidatorsData, []ed25519.PrivateKey) {
+	var validators safrole.ValidatorsData
+	keys := make([]ed25519.PrivateKey, len(validators))
+	for i := range validators {
+		public, private, err := testutils.RandomED25519Keys(t)
+		require.NoError(t, err)
+		validators[i] = &crypto.ValidatorKey{Ed25519: public}
+		keys[i] = private
+	}
+	return validators, keys
+}
+
+// judge adds the judgments of the validators in [from, to)
+func judge(c *DisputeCollector, keys []ed25519.PrivateKey, epoch uint32, reportHash crypto.Hash, valid bool, from, to int) {
+	for i := from; i < to; i++ {
+		judgment := Judgment{EpochIndex: epoch, IsValid: valid, ReportHash: reportHash}
+		judgment.Sign(uint16(i), keys[i])
+		c.add(c.report(reportHash, epoch), judgment)
+	}
+}
+
+func TestDisputeCollector_Disputes(t *testing.T) {
+	const (
+		superMajority = common.ValidatorsSuperMajority
+		oneThird      = common.NumberOfValidators / 3
+		epoch         = 1
+	)
+	current, currentKeys := newTestValidatorSet(t)
+	archived, archivedKeys := newTestValidatorSet(t)
+	parent := state.State{}
+	parent.ValidatorState.CurrentValidators = current
+	parent.ValidatorState.ArchivedValidators = archived
+	slot := jamtime.Timeslot(epoch*jamtime.TimeslotsPerEpoch + 1)
+
+	network := &networkMock{}
+	c := NewDisputeCollector(context.Background(), nil, network)
+
+	// A bad report guaranteed by the first two validators, one of which judged it valid
+	bad := block.WorkReport{CoreIndex: 1, AuthorizerHash: testutils.RandomHash(t)}
+	badHash, err := bad.Hash()
+	require.NoError(t, err)
+	guarantee := block.Guarantee{WorkReport: bad, Timeslot: slot - 1}
+	for i := uint16(0); i < 2; i++ {
+		var signature crypto.Ed25519Signature
+		copy(signature[:], ed25519.Sign(currentKeys[i], append([]byte(state.SignatureContextGuarantee), badHash[:]...)))
+		guarantee.Credentials = append(guarantee.Credentials, block.CredentialSignature{ValidatorIndex: i, Signature: signature})
+	}
+	c.OnImported(chain.ImportedBlock{
+		Header:    block.Header{TimeSlotIndex: slot - 1},
+		Extrinsic: block.Extrinsic{EG: block.GuaranteesExtrinsic{Guarantees: []block.Guarantee{guarantee}}},
+	})
+	judge(c, currentKeys, epoch, badHash, true, 0, 2)
+	judge(c, currentKeys, epoch, badHash, false, 2, superMajority+2)
+
+	// A good report judged in the previous epoch, the last validator judged it invalid
+	goodHash := testutils.RandomHash(t)
+	judge(c, archivedKeys, epoch-1, goodHash, true, 0, superMajority)
+	judge(c, archivedKeys, epoch-1, goodHash, false, common.NumberOfValidators-1, common.NumberOfValidators)
+
+	// A wonky report
+	wonkyHash := testutils.RandomHash(t)
+	judge(c, currentKeys, epoch, wonkyHash, true, 0, oneThird)
+	judge(c, currentKeys, epoch, wonkyHash, false, oneThird, superMajority)
+
+	// Reports without enough judgments, or judged too long ago, are left out
+	pendingHash := testutils.RandomHash(t)
+	judge(c, currentKeys, epoch, pendingHash, false, 0, superMajority-1)
+	judge(c, archivedKeys, epoch+1, pendingHash, false, 0, superMajority)
+
+	disputes := c.Disputes(parent, slot)
+	require.Len(t, disputes.Verdicts, 3)
+	assert.Len(t, disputes.Culprits, 2)
+	// The guarantor judging its report valid is only a culprit
+	assert.Len(t, disputes.Faults, 1)
+
+	judgements, err := statetransition.CalculateNewJudgements(slot, disputes, parent.PastJudgements, parent.ValidatorState)
+	require.NoError(t, err)
+	assert.Equal(t, []crypto.Hash{badHash}, judgements.BadWorkReports)
+	assert.Equal(t, []crypto.Hash{goodHash}, judgements.GoodWorkReports)
+	assert.Equal(t, []crypto.Hash{wonkyHash}, judgements.WonkyWorkReports)
+	assert.ElementsMatch(t, []ed25519.PublicKey{current[0].Ed25519, current[1].Ed25519, archived[common.NumberOfValidators-1].Ed25519}, judgements.OffendingValidators)
+
+	// Reports judged by the parent are not disputed again
+	parent.PastJudgements = judgements
+	assert.Empty(t, c.Disputes(parent, slot).Verdicts)
+}
+
+func TestDisputeCollector_Publish(t *testing.T) {
+	network := &networkMock{}
+	c := NewDisputeCollector(context.Background(), nil, network)
+	_, keys := newTestValidatorSet(t)
+	reportHash := testutils.RandomHash(t)
+
+	// A positive judgment is only published once the report is disputed
+	ours := Judgment{IsValid: true, ReportHash: reportHash}
+	ours.Sign(0, keys[0])
+	c.OnResult(Result{ReportHash: reportHash, Valid: true, Judgment: ours})
+	judge(c, keys, 0, reportHash, true, 1, 2)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, network.published())
+
+	judge(c, keys, 0, reportHash, false, 2, 3)
+	require.Eventually(t, func() bool { return len(network.published()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, ours, network.published()[0])
+
+	// A negative one is published right away
+	negative := Judgment{ReportHash: testutils.RandomHash(t)}
+	negative.Sign(0, keys[0])
+	c.OnResult(Result{ReportHash: negative.ReportHash, Judgment: negative})
+	require.Eventually(t, func() bool { return len(network.published()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, negative, network.published()[1])
+}
@@ -0,0 +1,10 @@
+package auditing
+
+// Begin starts the audit of the reports which became available in a block
+var Begin = (*Auditor).begin
+
+// Tranche0Cores returns the cores audited in tranche 0 by a validator
+var Tranche0Cores = tranche0Cores
+
+// NewTestHeader returns a header sealed with the VRF output of the key
+var NewTestHeader = newTestHeader
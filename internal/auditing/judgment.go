@@ -0,0 +1,51 @@
+package auditing
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/state"
+)
+
+var ErrBadJudgmentSignature = errors.New("bad judgment signature")
+
+// Judgment is the signed statement of a validator on the validity of a work
+// report, as published over CE 145. The validator index and signature are
+// those of the validator set of the epoch.
+type Judgment struct {
+	EpochIndex     uint32
+	ValidatorIndex uint16
+	IsValid        bool
+	ReportHash     crypto.Hash
+	Signature      crypto.Ed25519Signature
+}
+
+// judgmentMessage returns the signed message of a judgment: X_⊺ ⌢ H(w) if
+// the report is valid, X_⊥ ⌢ H(w) otherwise
+func judgmentMessage(valid bool, reportHash crypto.Hash) []byte {
+	context := state.SignatureContextValid
+	if !valid {
+		context = state.SignatureContextInvalid
+	}
+	return append([]byte(context), reportHash[:]...)
+}
+
+// Sign signs the judgment as the validator with the given index
+func (j *Judgment) Sign(validatorIndex uint16, privateKey ed25519.PrivateKey) {
+	j.ValidatorIndex = validatorIndex
+	copy(j.Signature[:], ed25519.Sign(privateKey, judgmentMessage(j.IsValid, j.ReportHash)))
+}
+
+// VerifySignature checks the signature of the judgment against the
+// validators of its epoch
+func (j Judgment) VerifySignature(validators safrole.ValidatorsData) error {
+	if int(j.ValidatorIndex) >= len(validators) || validators[j.ValidatorIndex] == nil || len(validators[j.ValidatorIndex].Ed25519) != ed25519.PublicKeySize {
+		return ErrBadValidatorIndex
+	}
+	if !ed25519.Verify(validators[j.ValidatorIndex].Ed25519, judgmentMessage(j.IsValid, j.ReportHash), j.Signature[:]) {
+		return ErrBadJudgmentSignature
+	}
+	return nil
+}
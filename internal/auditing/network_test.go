@@ -0,0 +1,130 @@
+package auditing_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/auditing"
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/internal/work"
+	"github.com/eigerco/strawberry/internal/work/results"
+	"github.com/eigerco/strawberry/pkg/network/peer"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+// tokenEvaluator stands in for the PVM invocations of the evaluation of a
+// work package: the resulting report is authorized by the hash of its token
+type tokenEvaluator struct{}
+
+func (tokenEvaluator) EvaluateBundle(bundle results.AuditBundle, report block.WorkReport) (*block.WorkReport, error) {
+	return &block.WorkReport{
+		CoreIndex:                report.CoreIndex,
+		WorkPackageSpecification: report.WorkPackageSpecification,
+		AuthorizerHash:           crypto.HashData(bundle.Package.AuthorizationToken),
+	}, nil
+}
+
+// newTestNode starts a node listening on a free local port
+func newTestNode(t *testing.T, keys peer.ValidatorKeys) (*peer.Node, *net.UDPAddr) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	address := conn.LocalAddr().(*net.UDPAddr)
+	require.NoError(t, conn.Close())
+
+	bs, err := chain.NewBlockService()
+	require.NoError(t, err)
+	node, err := peer.NewNode(context.Background(), address, keys, bs, nil)
+	require.NoError(t, err)
+	require.NoError(t, node.Start())
+	t.Cleanup(func() { _ = node.Stop() })
+	return node, address
+}
+
+func TestNetworkBundleFetcher_Audit(t *testing.T) {
+	// The guarantor holds the bundle of the work package it guaranteed
+	wp := work.Package{AuthorizationToken: []byte("token"), WorkItems: []work.Item{{Payload: []byte("payload")}}}
+	encoded, err := results.EncodeAuditBundle(results.AuditBundle{Package: wp}, nil)
+	require.NoError(t, err)
+	wpBytes, err := jam.Marshal(wp)
+	require.NoError(t, err)
+	spec := block.WorkPackageSpecification{
+		WorkPackageHash:           crypto.HashData(wpBytes),
+		ErasureRoot:               testutils.RandomHash(t),
+		AuditableWorkBundleLength: uint32(len(encoded)),
+	}
+	guarantorPub, guarantorPrv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	guarantor, guarantorAddress := newTestNode(t, peer.ValidatorKeys{EdPub: guarantorPub, EdPrv: guarantorPrv})
+	guarantorBundles := auditing.NewBundleStore()
+	guarantorBundles.Put(spec.ErasureRoot, encoded)
+	guarantor.OnAuditShardRequest(guarantorBundles.AuditShard)
+
+	// The auditor is the first validator, the guarantor holds the shards of
+	// all the others
+	auditorBander := testutils.RandomBandersnatchPrivateKey(t)
+	auditorBanderPub, err := bandersnatch.Public(auditorBander)
+	require.NoError(t, err)
+	auditorPub, auditorPrv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	var validators safrole.ValidatorsData
+	validators[0] = &crypto.ValidatorKey{Bandersnatch: auditorBanderPub, Ed25519: auditorPub}
+	for i := 1; i < len(validators); i++ {
+		validators[i] = &crypto.ValidatorKey{Ed25519: guarantorPub}
+	}
+	node, _ := newTestNode(t, peer.ValidatorKeys{EdPub: auditorPub, EdPrv: auditorPrv, BanderPrv: auditorBander, BanderPub: auditorBanderPub})
+	require.NoError(t, node.ConnectToPeer(guarantorAddress))
+
+	auditorBundles := auditing.NewBundleStore()
+	fetcher := auditing.NewNetworkBundleFetcher(node, auditorBundles)
+	auditor, err := auditing.NewAuditor(context.Background(), nil, node, fetcher, tokenEvaluator{}, auditorBander, auditorPrv, auditing.Config{})
+	require.NoError(t, err)
+	var mu sync.Mutex
+	judged := make(map[uint16]auditing.Judgment)
+	auditor.OnResult(func(result auditing.Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		judged[result.CoreIndex] = result.Judgment
+	})
+
+	// Of two reports of the work package audited in tranche 0, the second
+	// one does not match its evaluation
+	header := auditing.NewTestHeader(t, auditorBander)
+	cores := auditing.Tranche0Cores(t, auditorBander, header)
+	reports := []block.WorkReport{
+		{CoreIndex: cores[0], WorkPackageSpecification: spec, AuthorizerHash: crypto.HashData(wp.AuthorizationToken)},
+		{CoreIndex: cores[1], WorkPackageSpecification: spec, AuthorizerHash: testutils.RandomHash(t)},
+	}
+	require.NoError(t, auditing.Begin(auditor, testutils.RandomHash(t), header, validators, reports))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(judged) == len(reports)
+	}, 10*time.Second, 10*time.Millisecond)
+	for i, report := range reports {
+		reportHash, err := report.Hash()
+		require.NoError(t, err)
+		judgment := judged[report.CoreIndex]
+		assert.Equal(t, reportHash, judgment.ReportHash)
+		assert.Equal(t, i == 0, judgment.IsValid)
+		assert.Equal(t, uint16(0), judgment.ValidatorIndex)
+		assert.NoError(t, judgment.VerifySignature(validators))
+	}
+
+	// The auditor keeps the reconstructed bundle to serve its shards in turn
+	bundle, ok := auditorBundles.Get(spec.ErasureRoot)
+	require.True(t, ok)
+	assert.Equal(t, encoded, bundle)
+}
@@ -0,0 +1,211 @@
+package authoring
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/service"
+	"github.com/eigerco/strawberry/internal/state"
+)
+
+// TicketSource supplies ticket proofs for a new block, sorted by their ticket
+// identifiers and already verified against the parent state
+type TicketSource interface {
+	Tickets(parent state.State, slot jamtime.Timeslot) []block.TicketProof
+}
+
+// DisputeSource supplies the disputes for a new block, with verdicts which
+// are not judged yet by the parent state
+type DisputeSource interface {
+	Disputes(parent state.State, slot jamtime.Timeslot) block.DisputeExtrinsic
+}
+
+// Pool collects the extrinsics received from peers and local subsystems until
+// they are included in a block. Selecting extrinsics for a block only does
+// cheap checks against the parent state, the block is validated in full when
+// it is imported.
+type Pool struct {
+	mu         sync.Mutex
+	tickets    TicketSource
+	disputeSrc DisputeSource
+	preimages  map[crypto.Hash]block.Preimage             // By hash of the preimage
+	guarantees map[uint16]block.Guarantee                 // By core, a newer guarantee replaces an older one
+	assurances map[crypto.Hash]map[uint16]block.Assurance // By anchor and validator index
+	disputes   block.DisputeExtrinsic
+}
+
+// NewPool creates an empty pool. Ticket proofs are taken from the optional
+// ticket source.
+func NewPool(tickets TicketSource) *Pool {
+	return &Pool{
+		tickets:    tickets,
+		preimages:  make(map[crypto.Hash]block.Preimage),
+		guarantees: make(map[uint16]block.Guarantee),
+		assurances: make(map[crypto.Hash]map[uint16]block.Assurance),
+	}
+}
+
+// AddPreimage adds a preimage requested by a service
+func (p *Pool) AddPreimage(preimage block.Preimage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.preimages[crypto.HashData(preimage.Data)] = preimage
+}
+
+// AddGuarantee adds a guaranteed work report, replacing an older guarantee for the same core
+func (p *Pool) AddGuarantee(guarantee block.Guarantee) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	core := guarantee.WorkReport.CoreIndex
+	if existing, ok := p.guarantees[core]; ok && existing.Timeslot > guarantee.Timeslot {
+		return
+	}
+	p.guarantees[core] = guarantee
+}
+
+// AddAssurance adds an availability assurance, it can only be included in a
+// child of its anchor block
+func (p *Pool) AddAssurance(assurance block.Assurance) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	byValidator, ok := p.assurances[assurance.Anchor]
+	if !ok {
+		byValidator = make(map[uint16]block.Assurance)
+		p.assurances[assurance.Anchor] = byValidator
+	}
+	byValidator[assurance.ValidatorIndex] = assurance
+}
+
+// SetDisputes sets the disputes to include in the next block
+func (p *Pool) SetDisputes(disputes block.DisputeExtrinsic) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disputes = disputes
+}
+
+// SetDisputeSource sets the source of the disputes included in a block when
+// none were set with SetDisputes
+func (p *Pool) SetDisputeSource(source DisputeSource) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disputeSrc = source
+}
+
+// Extrinsic selects the extrinsics for a block at the given slot, built on
+// top of the parent block with the given posterior state:
+// - Tickets from the ticket source
+// - Preimages which are solicited by their service
+// - Guarantees for cores which have no pending report, made since the start
+// of the previous rotation, ordered by core
+// - Assurances anchored on the parent block, ordered by validator index
+// - The pending disputes, or those of the dispute source
+func (p *Pool) Extrinsic(parentHash crypto.Hash, parent state.State, slot jamtime.Timeslot) block.Extrinsic {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var extrinsic block.Extrinsic
+	if p.tickets != nil {
+		extrinsic.ET.TicketProofs = p.tickets.Tickets(parent, slot)
+	}
+
+	for hash, preimage := range p.preimages {
+		if isSolicited(parent.Services, block.ServiceId(preimage.ServiceIndex), hash, len(preimage.Data)) {
+			extrinsic.EP = append(extrinsic.EP, preimage)
+		}
+	}
+	sort.Slice(extrinsic.EP, func(i, j int) bool {
+		if extrinsic.EP[i].ServiceIndex != extrinsic.EP[j].ServiceIndex {
+			return extrinsic.EP[i].ServiceIndex < extrinsic.EP[j].ServiceIndex
+		}
+		return bytes.Compare(extrinsic.EP[i].Data, extrinsic.EP[j].Data) < 0
+	})
+
+	for core, guarantee := range p.guarantees {
+		if int(core) < len(parent.CoreAssignments) && parent.CoreAssignments[core] == nil && guarantee.Timeslot <= slot && guarantee.Timeslot >= previousRotationStart(slot) {
+			extrinsic.EG.Guarantees = append(extrinsic.EG.Guarantees, guarantee)
+		}
+	}
+	sort.Slice(extrinsic.EG.Guarantees, func(i, j int) bool {
+		return extrinsic.EG.Guarantees[i].WorkReport.CoreIndex < extrinsic.EG.Guarantees[j].WorkReport.CoreIndex
+	})
+
+	for _, assurance := range p.assurances[parentHash] {
+		extrinsic.EA = append(extrinsic.EA, assurance)
+	}
+	sort.Slice(extrinsic.EA, func(i, j int) bool {
+		return extrinsic.EA[i].ValidatorIndex < extrinsic.EA[j].ValidatorIndex
+	})
+
+	extrinsic.ED = p.disputes
+	if p.disputeSrc != nil && len(p.disputes.Verdicts) == 0 && len(p.disputes.Culprits) == 0 && len(p.disputes.Faults) == 0 {
+		extrinsic.ED = p.disputeSrc.Disputes(parent, slot)
+	}
+	return extrinsic
+}
+
+// Remove drops the extrinsics which were included in an imported block, along
+// with the assurances which can no longer be included
+func (p *Pool) Remove(b block.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, preimage := range b.Extrinsic.EP {
+		delete(p.preimages, crypto.HashData(preimage.Data))
+	}
+	for _, guarantee := range b.Extrinsic.EG.Guarantees {
+		core := guarantee.WorkReport.CoreIndex
+		if existing, ok := p.guarantees[core]; ok &&
+			existing.WorkReport.WorkPackageSpecification.WorkPackageHash == guarantee.WorkReport.WorkPackageSpecification.WorkPackageHash {
+			delete(p.guarantees, core)
+		}
+	}
+	delete(p.assurances, b.Header.ParentHash)
+	if len(b.Extrinsic.ED.Verdicts) > 0 || len(b.Extrinsic.ED.Culprits) > 0 || len(b.Extrinsic.ED.Faults) > 0 {
+		p.disputes = block.DisputeExtrinsic{}
+	}
+}
+
+// Prune drops the extrinsics which can no longer be included in a block after
+// the given slot: guarantees made before the start of the previous rotation
+// and assurances anchored on blocks which are not leaves
+func (p *Pool) Prune(slot jamtime.Timeslot, leaves map[crypto.Hash]jamtime.Timeslot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for core, guarantee := range p.guarantees {
+		if guarantee.Timeslot < previousRotationStart(slot) {
+			delete(p.guarantees, core)
+		}
+	}
+	for anchor := range p.assurances {
+		if _, ok := leaves[anchor]; !ok {
+			delete(p.assurances, anchor)
+		}
+	}
+}
+
+// previousRotationStart returns the first slot of the rotation before the one
+// of the given slot, R(⌊τ′/R⌋ - 1), the oldest slot of an includable guarantee
+func previousRotationStart(slot jamtime.Timeslot) jamtime.Timeslot {
+	rotation := slot / common.ValidatorRotationPeriod
+	if rotation == 0 {
+		return 0
+	}
+	return (rotation - 1) * common.ValidatorRotationPeriod
+}
+
+// isSolicited tells whether a service requested the preimage and does not have it yet
+func isSolicited(services service.ServiceState, serviceID block.ServiceId, hash crypto.Hash, length int) bool {
+	account, ok := services[serviceID]
+	if !ok {
+		return false
+	}
+	if _, ok := account.PreimageLookup[hash]; ok {
+		return false
+	}
+	timeslots, ok := account.PreimageMeta[service.PreImageMetaKey{Hash: hash, Length: service.PreimageLength(length)}]
+	return ok && len(timeslots) == 0
+}
@@ -0,0 +1,99 @@
+package authoring
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/service"
+	"github.com/eigerco/strawberry/internal/state"
+	"github.com/eigerco/strawberry/internal/testutils"
+)
+
+func TestPool_Extrinsic(t *testing.T) {
+	solicited := block.Preimage{ServiceIndex: 1, Data: []byte("solicited")}
+	unsolicited := block.Preimage{ServiceIndex: 1, Data: []byte("unsolicited")}
+	parent := state.State{
+		Services: service.ServiceState{
+			1: {
+				PreimageLookup: map[crypto.Hash][]byte{},
+				PreimageMeta: map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{
+					{Hash: crypto.HashData(solicited.Data), Length: service.PreimageLength(len(solicited.Data))}: {},
+				},
+			},
+		},
+	}
+	parent.CoreAssignments[0] = &state.Assignment{WorkReport: &block.WorkReport{}}
+	parentHash := testutils.RandomHash(t)
+
+	pool := NewPool(nil)
+	pool.AddPreimage(unsolicited)
+	pool.AddPreimage(solicited)
+	busyCore := block.Guarantee{WorkReport: block.WorkReport{CoreIndex: 0}}
+	freeCore := block.Guarantee{WorkReport: block.WorkReport{CoreIndex: 1}}
+	pool.AddGuarantee(freeCore)
+	pool.AddGuarantee(busyCore)
+	anchored := []block.Assurance{
+		{Anchor: parentHash, ValidatorIndex: 2},
+		{Anchor: parentHash, ValidatorIndex: 1},
+	}
+	for _, assurance := range anchored {
+		pool.AddAssurance(assurance)
+	}
+	pool.AddAssurance(block.Assurance{Anchor: testutils.RandomHash(t), ValidatorIndex: 3})
+
+	extrinsic := pool.Extrinsic(parentHash, parent, 1)
+	assert.Equal(t, block.PreimageExtrinsic{solicited}, extrinsic.EP)
+	assert.Equal(t, []block.Guarantee{freeCore}, extrinsic.EG.Guarantees)
+	assert.Equal(t, block.AssurancesExtrinsic{anchored[1], anchored[0]}, extrinsic.EA)
+
+	// Included extrinsics are not selected again
+	pool.Remove(block.Block{Header: block.Header{ParentHash: parentHash}, Extrinsic: extrinsic})
+	extrinsic = pool.Extrinsic(parentHash, parent, 2)
+	assert.Empty(t, extrinsic.EP)
+	assert.Empty(t, extrinsic.EG.Guarantees)
+	assert.Empty(t, extrinsic.EA)
+}
+
+// disputeSource returns the same disputes for every block
+type disputeSource block.DisputeExtrinsic
+
+func (d disputeSource) Disputes(state.State, jamtime.Timeslot) block.DisputeExtrinsic {
+	return block.DisputeExtrinsic(d)
+}
+
+func TestPool_DisputeSource(t *testing.T) {
+	sourced := block.DisputeExtrinsic{Verdicts: []block.Verdict{{ReportHash: testutils.RandomHash(t)}}}
+	pool := NewPool(nil)
+	pool.SetDisputeSource(disputeSource(sourced))
+	assert.Equal(t, sourced, pool.Extrinsic(testutils.RandomHash(t), state.State{}, 1).ED)
+
+	// Disputes set explicitly take precedence
+	set := block.DisputeExtrinsic{Verdicts: []block.Verdict{{ReportHash: testutils.RandomHash(t)}}}
+	pool.SetDisputes(set)
+	assert.Equal(t, set, pool.Extrinsic(testutils.RandomHash(t), state.State{}, 1).ED)
+}
+
+func TestPool_Prune(t *testing.T) {
+	const r = common.ValidatorRotationPeriod
+	leaf := testutils.RandomHash(t)
+	pool := NewPool(nil)
+	stale := block.Guarantee{WorkReport: block.WorkReport{CoreIndex: 0}, Timeslot: r - 1}
+	recent := block.Guarantee{WorkReport: block.WorkReport{CoreIndex: 1}, Timeslot: r}
+	pool.AddGuarantee(stale)
+	pool.AddGuarantee(recent)
+	pool.AddAssurance(block.Assurance{Anchor: leaf, ValidatorIndex: 1})
+	pool.AddAssurance(block.Assurance{Anchor: testutils.RandomHash(t), ValidatorIndex: 2})
+
+	// Guarantees older than the previous rotation are not included
+	slot := 2*r + 1
+	assert.Equal(t, []block.Guarantee{recent}, pool.Extrinsic(leaf, state.State{}, slot).EG.Guarantees)
+
+	pool.Prune(slot, map[crypto.Hash]jamtime.Timeslot{leaf: slot - 1})
+	assert.Len(t, pool.guarantees, 1)
+	assert.Equal(t, map[crypto.Hash]map[uint16]block.Assurance{leaf: {1: {Anchor: leaf, ValidatorIndex: 1}}}, pool.assurances)
+}
@@ -0,0 +1,186 @@
+package authoring
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/state"
+	"github.com/eigerco/strawberry/internal/statetransition"
+)
+
+// ErrNotSlotAuthor is returned when our validator may not author the block of a slot
+var ErrNotSlotAuthor = errors.New("not the author of the slot")
+
+// Announcer sends the header of a new block to the peers
+type Announcer interface {
+	AnnounceBlock(ctx context.Context, header block.Header)
+}
+
+// Producer authors blocks in the slots owned by our validator. A slot is
+// owned either through a winning ticket in the sealing key series, or through
+// our bandersnatch key in the fallback sealing keys. New blocks are built on
+// top of the best head from the extrinsics in the pool, imported locally and
+// announced to the peers.
+type Producer struct {
+	importer   *chain.Importer
+	states     *chain.StateManager
+	pool       *Pool
+	announcer  Announcer
+	privateKey crypto.BandersnatchPrivateKey
+	publicKey  crypto.BandersnatchPublicKey
+}
+
+// NewProducer creates a Producer sealing blocks with the given bandersnatch key
+func NewProducer(importer *chain.Importer, states *chain.StateManager, pool *Pool, announcer Announcer, privateKey crypto.BandersnatchPrivateKey) (*Producer, error) {
+	publicKey, err := bandersnatch.Public(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("derive public key: %w", err)
+	}
+	p := &Producer{
+		importer:   importer,
+		states:     states,
+		pool:       pool,
+		announcer:  announcer,
+		privateKey: privateKey,
+		publicKey:  publicKey,
+	}
+	importer.Subscribe(p.onImported)
+	return p, nil
+}
+
+// onImported drops the extrinsics included in every imported block, ours or
+// a peer's, and the ones which can no longer be included from the pool
+func (p *Producer) onImported(imported chain.ImportedBlock) {
+	p.pool.Remove(block.Block{Header: imported.Header, Extrinsic: imported.Extrinsic})
+
+	bs := p.states.BlockService()
+	bs.Mu.RLock()
+	leaves := maps.Clone(bs.KnownLeaves)
+	bs.Mu.RUnlock()
+	p.pool.Prune(imported.Header.TimeSlotIndex, leaves)
+}
+
+// Run tries to author a block at the start of every timeslot until the
+// context is cancelled
+func (p *Producer) Run(ctx context.Context) {
+	for {
+		next := jamtime.CurrentTimeslot() + 1
+		timer := time.NewTimer(time.Until(next.TimeslotStart().ToTime()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := p.Produce(ctx, next); err != nil && !errors.Is(err, ErrNotSlotAuthor) {
+			fmt.Printf("Failed to author block for slot %d: %v\n", next, err)
+		}
+	}
+}
+
+// Produce authors a block for the slot on top of the best head, imports it
+// and announces it to the peers. Returns ErrNotSlotAuthor if the slot is not
+// ours.
+func (p *Producer) Produce(ctx context.Context, slot jamtime.Timeslot) (chain.ImportedBlock, error) {
+	b, err := p.Build(p.states.BestHead(), slot)
+	if err != nil {
+		return chain.ImportedBlock{}, err
+	}
+	imported, err := p.importer.Import(b)
+	if err != nil {
+		return chain.ImportedBlock{}, fmt.Errorf("import authored block: %w", err)
+	}
+	if p.announcer != nil {
+		p.announcer.AnnounceBlock(ctx, b.Header)
+	}
+	return imported, nil
+}
+
+// Build assembles and seals a block for the slot on top of the given parent.
+// The epoch and winning tickets markers are set when due. Returns
+// ErrNotSlotAuthor if the slot is not ours.
+func (p *Producer) Build(parentHash crypto.Hash, slot jamtime.Timeslot) (block.Block, error) {
+	parent, parentRoot, err := p.states.State(parentHash)
+	if err != nil {
+		return block.Block{}, fmt.Errorf("get parent state: %w", err)
+	}
+	if slot <= parent.TimeslotIndex {
+		return block.Block{}, fmt.Errorf("slot %d is not after the parent's slot %d", slot, parent.TimeslotIndex)
+	}
+
+	extrinsic := p.pool.Extrinsic(parentHash, parent, slot)
+	extrinsicHash, err := extrinsic.Hash()
+	if err != nil {
+		return block.Block{}, fmt.Errorf("hash extrinsic: %w", err)
+	}
+	offenders := offendersOf(extrinsic.ED)
+
+	// The seal uses the posterior sealing keys and entropy. They do not depend
+	// on the VRF output of the block, so they can be computed before sealing.
+	entropyPool, validatorState, markers, err := statetransition.UpdateSafroleState(statetransition.SafroleInput{
+		TimeSlot:  slot,
+		Tickets:   extrinsic.ET.TicketProofs,
+		Offenders: offenders,
+	}, parent.TimeslotIndex, parent.EntropyPool, parent.ValidatorState)
+	if err != nil {
+		return block.Block{}, fmt.Errorf("update safrole state: %w", err)
+	}
+	authorIndex, ok := p.authorIndex(validatorState.CurrentValidators)
+	if !ok {
+		return block.Block{}, fmt.Errorf("%w: not an active validator", ErrNotSlotAuthor)
+	}
+
+	header := block.Header{
+		ParentHash:           parentHash,
+		PriorStateRoot:       parentRoot,
+		ExtrinsicHash:        extrinsicHash,
+		TimeSlotIndex:        slot,
+		EpochMarker:          markers.EpochMark,
+		WinningTicketsMarker: markers.WinningTicketMark,
+		OffendersMarkers:     offenders,
+		BlockAuthorIndex:     authorIndex,
+	}
+	sealState := parent
+	sealState.EntropyPool = entropyPool
+	sealState.ValidatorState = validatorState
+	if err := state.SealBlock(&header, &sealState, p.privateKey); err != nil {
+		if errors.Is(err, state.ErrBlockSealInvalidAuthor) {
+			return block.Block{}, ErrNotSlotAuthor
+		}
+		return block.Block{}, fmt.Errorf("seal block: %w", err)
+	}
+	return block.Block{Header: header, Extrinsic: extrinsic}, nil
+}
+
+// authorIndex finds our validator in the given validator set
+func (p *Producer) authorIndex(validators safrole.ValidatorsData) (uint16, bool) {
+	for i, validator := range validators {
+		if validator != nil && validator.Bandersnatch == p.publicKey {
+			return uint16(i), true
+		}
+	}
+	return 0, false
+}
+
+// offendersOf returns the keys of the culprits and faults in the disputes,
+// they make up the offenders marker
+func offendersOf(disputes block.DisputeExtrinsic) []ed25519.PublicKey {
+	var offenders []ed25519.PublicKey
+	for _, culprit := range disputes.Culprits {
+		offenders = append(offenders, culprit.ValidatorEd25519PublicKey)
+	}
+	for _, fault := range disputes.Faults {
+		offenders = append(offenders, fault.ValidatorEd25519PublicKey)
+	}
+	return offenders
+}
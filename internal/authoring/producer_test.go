@@ -0,0 +1,110 @@
+package authoring
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/genesis"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/pkg/db/pebble"
+)
+
+type announcerMock struct {
+	headers []block.Header
+}
+
+func (a *announcerMock) AnnounceBlock(_ context.Context, header block.Header) {
+	a.headers = append(a.headers, header)
+}
+
+// newTestChain creates a chain whose validators all share one bandersnatch
+// key, so that every slot falls back to that key
+func newTestChain(t *testing.T) (*chain.Importer, *chain.StateManager, crypto.BandersnatchPrivateKey) {
+	privateKey := testutils.RandomBandersnatchPrivateKey(t)
+	publicKey, err := bandersnatch.Public(privateKey)
+	require.NoError(t, err)
+	spec := genesis.Spec{Name: "test"}
+	for range common.NumberOfValidators {
+		spec.Validators = append(spec.Validators, genesis.Validator{
+			Bandersnatch: publicKey[:],
+			Ed25519:      crypto.HexBytes(testutils.RandomED25519PublicKey(t)),
+			Bls:          make([]byte, crypto.BLSSize),
+			Metadata:     make([]byte, crypto.MetadataSize),
+		})
+	}
+	genesisBlock, err := spec.Block()
+	require.NoError(t, err)
+	genesisState, err := spec.State()
+	require.NoError(t, err)
+
+	kvStore, err := pebble.NewKVStore()
+	require.NoError(t, err)
+	bs, err := chain.NewBlockServiceWithStore(kvStore, genesisBlock)
+	require.NoError(t, err)
+	trieDB, err := trie.NewDB()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		trieDB.Close()
+		bs.Store.Close()
+	})
+	states, err := chain.NewStateManager(bs, trieDB, genesisState, chain.StateManagerConfig{})
+	require.NoError(t, err)
+	return chain.NewImporter(bs, states), states, privateKey
+}
+
+func TestProducer(t *testing.T) {
+	importer, states, privateKey := newTestChain(t)
+	announcer := &announcerMock{}
+	pool := NewPool(nil)
+	producer, err := NewProducer(importer, states, pool, announcer, privateKey)
+	require.NoError(t, err)
+
+	// A preimage no service asked for is not included
+	pool.AddPreimage(block.Preimage{ServiceIndex: 1, Data: []byte{1, 2, 3}})
+
+	for slot := jamtime.Timeslot(1); slot <= 2; slot++ {
+		imported, err := producer.Produce(context.Background(), slot)
+		require.NoError(t, err)
+		assert.Equal(t, imported.Hash, states.BestHead())
+		assert.Equal(t, slot, imported.Header.TimeSlotIndex)
+		assert.Empty(t, imported.Extrinsic.EP)
+
+		s, root, err := states.State(imported.Hash)
+		require.NoError(t, err)
+		assert.Equal(t, imported.StateRoot, root)
+		assert.Equal(t, slot, s.TimeslotIndex)
+	}
+	require.Len(t, announcer.headers, 2)
+	assert.Equal(t, jamtime.Timeslot(2), announcer.headers[1].TimeSlotIndex)
+}
+
+func TestProducer_NotSlotAuthor(t *testing.T) {
+	importer, states, _ := newTestChain(t)
+	producer, err := NewProducer(importer, states, NewPool(nil), nil, testutils.RandomBandersnatchPrivateKey(t))
+	require.NoError(t, err)
+
+	_, err = producer.Produce(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrNotSlotAuthor)
+}
+
+func TestProducer_EpochMarker(t *testing.T) {
+	importer, states, privateKey := newTestChain(t)
+	producer, err := NewProducer(importer, states, NewPool(nil), nil, privateKey)
+	require.NoError(t, err)
+
+	// The first block of the next epoch carries the epoch marker
+	imported, err := producer.Produce(context.Background(), jamtime.TimeslotsPerEpoch)
+	require.NoError(t, err)
+	require.NotNil(t, imported.Header.EpochMarker)
+	assert.Nil(t, imported.Header.WinningTicketsMarker)
+}
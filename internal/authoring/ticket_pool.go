@@ -0,0 +1,260 @@
+package authoring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/state"
+	"github.com/eigerco/strawberry/internal/statetransition"
+)
+
+var (
+	ErrTicketWrongEpoch = errors.New("ticket for another epoch")
+	ErrTicketDuplicate  = errors.New("duplicate ticket")
+	ErrTicketNotWinning = errors.New("ticket cannot win")
+)
+
+// TicketPoolMetrics counts the tickets in the pool and the ones it rejected
+type TicketPoolMetrics struct {
+	Size       int    // Tickets currently in the pool
+	Accepted   uint64 // Tickets added since the pool was created
+	WrongEpoch uint64 // Tickets rejected because they are not for the current epoch
+	Invalid    uint64 // Tickets rejected because of a bad attempt or ring VRF proof
+	Duplicate  uint64 // Tickets rejected because they are already known or included
+	NotWinning uint64 // Tickets rejected because the pool holds E better tickets
+}
+
+// poolTicket is a verified ticket proof together with its identifier
+type poolTicket struct {
+	ticket block.Ticket
+	proof  block.TicketProof
+}
+
+// TicketPool collects the Safrole tickets received from peers and our own
+// ticket generator for inclusion in the tickets extrinsic. Every ticket is
+// verified once when it is added, against the ring commitment γz and entropy
+// η2 of the epoch the tickets are submitted in. Only the E best tickets are
+// kept since no others can make it into the sealing keys. The pool rotates
+// when the first block of an epoch is imported.
+type TicketPool struct {
+	states *chain.StateManager
+
+	mu             sync.Mutex
+	epoch          jamtime.Epoch // The epoch the pooled tickets are used in
+	entropy        crypto.Hash   // η2 of the submission epoch
+	ringCommitment crypto.RingCommitment
+	verifier       *bandersnatch.RingVrfVerifier // Cached for the submission epoch
+	tickets        []poolTicket                  // Ordered by ticket identifier
+	seen           map[crypto.Hash]struct{}      // Hashes of the proofs which were already verified
+	included       map[crypto.BandersnatchOutputHash]struct{}
+	metrics        TicketPoolMetrics
+}
+
+// NewTicketPool creates a ticket pool for the epoch of the current best head
+func NewTicketPool(states *chain.StateManager) (*TicketPool, error) {
+	p := &TicketPool{states: states}
+	s, _, err := states.State(states.BestHead())
+	if err != nil {
+		return nil, fmt.Errorf("get head state: %w", err)
+	}
+	if err := p.rotate(s); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Add verifies a ticket for the given epoch and adds it to the pool. Returns
+// an error if the ticket is rejected.
+func (p *TicketPool) Add(epoch jamtime.Epoch, proof block.TicketProof) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if epoch != p.epoch {
+		p.metrics.WrongEpoch++
+		return ErrTicketWrongEpoch
+	}
+
+	// The same ticket is usually received from several proxy validators, it
+	// is only verified the first time
+	key := proofKey(proof)
+	if _, ok := p.seen[key]; ok {
+		p.metrics.Duplicate++
+		return ErrTicketDuplicate
+	}
+	p.seen[key] = struct{}{}
+
+	ticket, err := statetransition.VerifyTicketProof(p.verifier, p.ringCommitment, p.entropy, proof)
+	if err != nil {
+		p.metrics.Invalid++
+		return err
+	}
+	if _, ok := p.included[ticket.Identifier]; ok {
+		p.metrics.Duplicate++
+		return ErrTicketDuplicate
+	}
+
+	i := sort.Search(len(p.tickets), func(i int) bool {
+		return bytes.Compare(p.tickets[i].ticket.Identifier[:], ticket.Identifier[:]) >= 0
+	})
+	if i < len(p.tickets) && p.tickets[i].ticket.Identifier == ticket.Identifier {
+		p.metrics.Duplicate++
+		return ErrTicketDuplicate
+	}
+	if i >= jamtime.TimeslotsPerEpoch {
+		p.metrics.NotWinning++
+		return ErrTicketNotWinning
+	}
+	p.tickets = append(p.tickets, poolTicket{})
+	copy(p.tickets[i+1:], p.tickets[i:])
+	p.tickets[i] = poolTicket{ticket: ticket, proof: proof}
+	if len(p.tickets) > jamtime.TimeslotsPerEpoch {
+		p.tickets = p.tickets[:jamtime.TimeslotsPerEpoch]
+	}
+	p.metrics.Accepted++
+	return nil
+}
+
+// Tickets selects up to K of the best pooled tickets for a block at the given
+// slot, built on top of a parent block with the given posterior state. The
+// tickets are ordered by identifier and exclude the ones in the accumulator γa
+// as well as the ones which would not make it into the accumulator. Returns no
+// tickets outside of the submission period of the pool's epoch.
+func (p *TicketPool) Tickets(parent state.State, slot jamtime.Timeslot) []block.TicketProof {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !slot.IsTicketSubmissionPeriod() || slot.ToEpoch() != parent.TimeslotIndex.ToEpoch() || slot.ToEpoch()+1 != p.epoch {
+		return nil
+	}
+	safroleState := parent.ValidatorState.SafroleState
+	// The parent may be on a fork with different entropy
+	if parent.EntropyPool[2] != p.entropy || safroleState.RingCommitment != p.ringCommitment {
+		return nil
+	}
+
+	accumulated := make(map[crypto.BandersnatchOutputHash]struct{}, len(safroleState.TicketAccumulator))
+	identifiers := make([]crypto.BandersnatchOutputHash, 0, len(safroleState.TicketAccumulator)+common.MaxTicketExtrinsicSize)
+	for _, ticket := range safroleState.TicketAccumulator {
+		accumulated[ticket.Identifier] = struct{}{}
+		identifiers = append(identifiers, ticket.Identifier)
+	}
+	var candidates []poolTicket
+	for _, t := range p.tickets {
+		if len(candidates) == common.MaxTicketExtrinsicSize {
+			break
+		}
+		if _, ok := accumulated[t.ticket.Identifier]; ok {
+			continue
+		}
+		candidates = append(candidates, t)
+		identifiers = append(identifiers, t.ticket.Identifier)
+	}
+
+	// Every included ticket must be among the E best of the accumulator and
+	// the new tickets. Equation 6.35: n ⊆ γ′a (v.0.5.4)
+	sort.Slice(identifiers, func(i, j int) bool {
+		return bytes.Compare(identifiers[i][:], identifiers[j][:]) < 0
+	})
+	var worst *crypto.BandersnatchOutputHash
+	if len(identifiers) > jamtime.TimeslotsPerEpoch {
+		worst = &identifiers[jamtime.TimeslotsPerEpoch-1]
+	}
+	tickets := make([]block.TicketProof, 0, len(candidates))
+	for _, t := range candidates {
+		if worst != nil && bytes.Compare(t.ticket.Identifier[:], worst[:]) > 0 {
+			break
+		}
+		tickets = append(tickets, t.proof)
+	}
+	return tickets
+}
+
+// OnImported rotates the pool when the first block of an epoch is imported
+// and drops the tickets included in a new best block. It is meant to be
+// subscribed to the importer.
+func (p *TicketPool) OnImported(imported chain.ImportedBlock) {
+	if imported.Header.EpochMarker != nil {
+		p.mu.Lock()
+		rotate := imported.Header.TimeSlotIndex.ToEpoch()+1 > p.epoch
+		p.mu.Unlock()
+		if rotate {
+			s, _, err := p.states.State(imported.Hash)
+			if err != nil {
+				fmt.Printf("Failed to rotate ticket pool: %v\n", err)
+				return
+			}
+			if err := p.rotate(s); err != nil {
+				fmt.Printf("Failed to rotate ticket pool: %v\n", err)
+			}
+			return
+		}
+	}
+	if !imported.BestHead || len(imported.Extrinsic.ET.TicketProofs) == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	included := make(map[crypto.Hash]struct{}, len(imported.Extrinsic.ET.TicketProofs))
+	for _, proof := range imported.Extrinsic.ET.TicketProofs {
+		included[proofKey(proof)] = struct{}{}
+	}
+	remaining := p.tickets[:0]
+	for _, t := range p.tickets {
+		if _, ok := included[proofKey(t.proof)]; ok {
+			p.included[t.ticket.Identifier] = struct{}{}
+			continue
+		}
+		remaining = append(remaining, t)
+	}
+	p.tickets = remaining
+}
+
+// Metrics returns the current size of the pool and the rejection counters
+func (p *TicketPool) Metrics() TicketPoolMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	metrics := p.metrics
+	metrics.Size = len(p.tickets)
+	return metrics
+}
+
+// rotate empties the pool and prepares it for the tickets submitted in the
+// epoch of the given state
+func (p *TicketPool) rotate(s state.State) error {
+	verifier, err := s.ValidatorState.SafroleState.RingVerifier()
+	if err != nil {
+		return fmt.Errorf("create ring verifier: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.verifier != nil {
+		p.verifier.Free()
+	}
+	p.epoch = s.TimeslotIndex.ToEpoch() + 1
+	p.entropy = s.EntropyPool[2]
+	p.ringCommitment = s.ValidatorState.SafroleState.RingCommitment
+	p.verifier = verifier
+	p.tickets = nil
+	p.seen = make(map[crypto.Hash]struct{})
+	p.included = make(map[crypto.BandersnatchOutputHash]struct{})
+	for _, ticket := range s.ValidatorState.SafroleState.TicketAccumulator {
+		p.included[ticket.Identifier] = struct{}{}
+	}
+	return nil
+}
+
+// proofKey identifies a ticket proof before its identifier is known
+func proofKey(proof block.TicketProof) crypto.Hash {
+	return crypto.HashData(append([]byte{proof.EntryIndex}, proof.Proof[:]...))
+}
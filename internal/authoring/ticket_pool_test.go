@@ -0,0 +1,76 @@
+package authoring
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/statetransition"
+)
+
+func TestTicketPool(t *testing.T) {
+	importer, states, privateKey := newTestChain(t)
+	ticketPool, err := NewTicketPool(states)
+	require.NoError(t, err)
+	importer.Subscribe(ticketPool.OnImported)
+	producer, err := NewProducer(importer, states, NewPool(ticketPool), nil, privateKey)
+	require.NoError(t, err)
+
+	// The first block of epoch 1 starts the submission of tickets for epoch 2
+	imported, err := producer.Produce(context.Background(), jamtime.TimeslotsPerEpoch)
+	require.NoError(t, err)
+	s, _, err := states.State(imported.Hash)
+	require.NoError(t, err)
+	generated, err := GenerateTickets(privateKey, s)
+	require.NoError(t, err)
+	require.Len(t, generated, common.MaxTicketAttempts)
+
+	for _, ticket := range generated {
+		require.NoError(t, ticketPool.Add(ticket.Epoch, ticket.Proof))
+	}
+	assert.ErrorIs(t, ticketPool.Add(generated[0].Epoch, generated[0].Proof), ErrTicketDuplicate)
+	assert.ErrorIs(t, ticketPool.Add(generated[0].Epoch-1, generated[0].Proof), ErrTicketWrongEpoch)
+	invalid := generated[0].Proof
+	invalid.Proof[0] ^= 0xff
+	assert.ErrorIs(t, ticketPool.Add(generated[0].Epoch, invalid), statetransition.ErrBadTicketProof)
+	assert.Equal(t, TicketPoolMetrics{
+		Size:       common.MaxTicketAttempts,
+		Accepted:   common.MaxTicketAttempts,
+		WrongEpoch: 1,
+		Invalid:    1,
+		Duplicate:  1,
+	}, ticketPool.Metrics())
+
+	// Tickets are supplied ordered by identifier, and only in the submission period
+	slot := jamtime.Timeslot(jamtime.TimeslotsPerEpoch + 1)
+	tickets := ticketPool.Tickets(s, slot)
+	require.Len(t, tickets, common.MaxTicketAttempts)
+	expected := generated
+	if bytes.Compare(expected[0].Identifier[:], expected[1].Identifier[:]) > 0 {
+		expected = []GeneratedTicket{generated[1], generated[0]}
+	}
+	for i, ticket := range tickets {
+		assert.Equal(t, expected[i].Proof, ticket)
+	}
+	assert.Empty(t, ticketPool.Tickets(s, jamtime.Timeslot(2*jamtime.TimeslotsPerEpoch-1)))
+
+	// The tickets are included in the next block and dropped from the pool
+	imported, err = producer.Produce(context.Background(), slot)
+	require.NoError(t, err)
+	assert.Equal(t, tickets, imported.Extrinsic.ET.TicketProofs)
+	s, _, err = states.State(imported.Hash)
+	require.NoError(t, err)
+	assert.Len(t, s.ValidatorState.SafroleState.TicketAccumulator, common.MaxTicketAttempts)
+	assert.Zero(t, ticketPool.Metrics().Size)
+	assert.Empty(t, ticketPool.Tickets(s, slot+1))
+
+	// The pool rotates with the next epoch
+	_, err = producer.Produce(context.Background(), 2*jamtime.TimeslotsPerEpoch)
+	require.NoError(t, err)
+	assert.ErrorIs(t, ticketPool.Add(generated[0].Epoch, generated[0].Proof), ErrTicketWrongEpoch)
+}
@@ -0,0 +1,211 @@
+package authoring
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/state"
+)
+
+// winningThreshold is the ticket identifier below which a ticket is expected
+// to be among the E best of the V·N tickets submitted for an epoch:
+// 2^256 · E / (V·N)
+var winningThreshold = func() *big.Int {
+	threshold := new(big.Int).Lsh(big.NewInt(1), 256)
+	threshold.Mul(threshold, big.NewInt(jamtime.TimeslotsPerEpoch))
+	return threshold.Div(threshold, big.NewInt(common.NumberOfValidators*common.MaxTicketAttempts))
+}()
+
+// GeneratedTicket is a ticket of our validator together with its identifier,
+// which is only known to us until the ticket is used to seal a block
+type GeneratedTicket struct {
+	Epoch      jamtime.Epoch                 // The epoch the ticket is used in
+	Proof      block.TicketProof             // The ring VRF proof of the ticket
+	Identifier crypto.BandersnatchOutputHash // Y(p), the score of the ticket
+	Proxy      uint16                        // Index of the validator in γk which distributes the ticket
+}
+
+// LikelyToWin tells whether the ticket is expected to make it into the
+// sealing keys, assuming that every validator submits all its attempts
+func (t GeneratedTicket) LikelyToWin() bool {
+	return new(big.Int).SetBytes(t.Identifier[:]).Cmp(winningThreshold) < 0
+}
+
+// GenerateTickets creates all ticket attempts of our validator for the next
+// epoch, from the posterior state of the first block of the current epoch.
+// The tickets are ring VRF proofs against the next validators γk with the
+// context XT ⌢ η2 ++ attempt. Returns no tickets if we are not among the
+// next validators.
+func GenerateTickets(privateKey crypto.BandersnatchPrivateKey, s state.State) ([]GeneratedTicket, error) {
+	publicKey, err := bandersnatch.Public(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("derive public key: %w", err)
+	}
+	nextValidators := s.ValidatorState.SafroleState.NextValidators
+	ring := make([]crypto.BandersnatchPublicKey, len(nextValidators))
+	proverIndex := -1
+	for i, validator := range nextValidators {
+		if validator == nil {
+			continue
+		}
+		ring[i] = validator.Bandersnatch
+		if validator.Bandersnatch == publicKey && proverIndex < 0 {
+			proverIndex = i
+		}
+	}
+	if proverIndex < 0 {
+		return nil, nil
+	}
+
+	prover, err := bandersnatch.NewRingProver(privateKey, ring, uint(proverIndex))
+	if err != nil {
+		return nil, err
+	}
+	defer prover.Free()
+
+	epoch := s.TimeslotIndex.ToEpoch() + 1
+	tickets := make([]GeneratedTicket, 0, common.MaxTicketAttempts)
+	for attempt := uint8(0); attempt < common.MaxTicketAttempts; attempt++ {
+		vrfInputData := append([]byte(state.TicketSealContext), s.EntropyPool[2][:]...)
+		vrfInputData = append(vrfInputData, attempt)
+		proof, err := prover.Sign(vrfInputData, []byte{})
+		if err != nil {
+			return nil, fmt.Errorf("sign ticket: %w", err)
+		}
+		// The output of the ring VRF only depends on the input and our key, so
+		// it is the same as the one of a plain VRF signature
+		signature, err := bandersnatch.Sign(privateKey, vrfInputData, []byte{})
+		if err != nil {
+			return nil, fmt.Errorf("sign ticket identifier: %w", err)
+		}
+		identifier, err := bandersnatch.OutputHash(signature)
+		if err != nil {
+			return nil, fmt.Errorf("ticket identifier: %w", err)
+		}
+		tickets = append(tickets, GeneratedTicket{
+			Epoch:      epoch,
+			Proof:      block.TicketProof{EntryIndex: attempt, Proof: proof},
+			Identifier: identifier,
+			// The proxy is selected by the last 4 bytes of the identifier
+			Proxy: uint16(binary.BigEndian.Uint32(identifier[len(identifier)-4:]) % common.NumberOfValidators),
+		})
+	}
+	return tickets, nil
+}
+
+// TicketSubmitter distributes tickets to the other validators
+type TicketSubmitter interface {
+	// SendTicket sends a ticket to its proxy validator (CE 131)
+	SendTicket(ctx context.Context, epoch jamtime.Epoch, ticket block.TicketProof, proxy ed25519.PublicKey) error
+	// BroadcastTicket sends a ticket to all validators (CE 132)
+	BroadcastTicket(ctx context.Context, epoch jamtime.Epoch, ticket block.TicketProof) error
+}
+
+// TicketGenerator creates and submits the tickets of our validator once per
+// epoch, when the first block of the epoch is imported. Only the tickets which
+// are likely to win are submitted.
+type TicketGenerator struct {
+	ctx        context.Context
+	states     *chain.StateManager
+	submitter  TicketSubmitter
+	privateKey crypto.BandersnatchPrivateKey
+
+	mu        sync.Mutex
+	lastEpoch jamtime.Epoch // The last epoch tickets were generated for
+	onTicket  func(GeneratedTicket)
+}
+
+// NewTicketGenerator creates a TicketGenerator for the validator with the
+// given bandersnatch key. Ticket generation stops when the context is done.
+func NewTicketGenerator(ctx context.Context, states *chain.StateManager, submitter TicketSubmitter, privateKey crypto.BandersnatchPrivateKey) *TicketGenerator {
+	return &TicketGenerator{
+		ctx:        ctx,
+		states:     states,
+		submitter:  submitter,
+		privateKey: privateKey,
+	}
+}
+
+// OnTicket registers a function called for every submitted ticket, e.g. to
+// add our own tickets to the local ticket pool
+func (g *TicketGenerator) OnTicket(fn func(GeneratedTicket)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onTicket = fn
+}
+
+// OnImported generates and submits our tickets in the background if the
+// imported block is the first one of an epoch. It is meant to be subscribed
+// to the importer.
+func (g *TicketGenerator) OnImported(imported chain.ImportedBlock) {
+	if imported.Header.EpochMarker == nil {
+		return
+	}
+	// Tickets are submitted once per epoch, also when there are several forks
+	epoch := imported.Header.TimeSlotIndex.ToEpoch() + 1
+	g.mu.Lock()
+	if epoch <= g.lastEpoch {
+		g.mu.Unlock()
+		return
+	}
+	g.lastEpoch = epoch
+	g.mu.Unlock()
+
+	go func() {
+		if err := g.generate(imported.Hash); err != nil {
+			fmt.Printf("Failed to generate tickets for epoch %d: %v\n", epoch, err)
+		}
+	}()
+}
+
+// generate creates and submits the tickets from the posterior state of the given block
+func (g *TicketGenerator) generate(blockHash crypto.Hash) error {
+	s, _, err := g.states.State(blockHash)
+	if err != nil {
+		return fmt.Errorf("get state: %w", err)
+	}
+	tickets, err := GenerateTickets(g.privateKey, s)
+	if err != nil {
+		return err
+	}
+	for _, ticket := range tickets {
+		if !ticket.LikelyToWin() {
+			continue
+		}
+		if err := g.submit(s, ticket); err != nil {
+			fmt.Printf("Failed to submit ticket: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// submit sends a ticket to its proxy validator, or to all validators if we
+// are the proxy
+func (g *TicketGenerator) submit(s state.State, ticket GeneratedTicket) error {
+	g.mu.Lock()
+	onTicket := g.onTicket
+	g.mu.Unlock()
+	if onTicket != nil {
+		onTicket(ticket)
+	}
+
+	publicKey, err := bandersnatch.Public(g.privateKey)
+	if err != nil {
+		return err
+	}
+	proxy := s.ValidatorState.SafroleState.NextValidators[ticket.Proxy]
+	if proxy == nil || proxy.Bandersnatch == publicKey {
+		return g.submitter.BroadcastTicket(g.ctx, ticket.Epoch, ticket.Proof)
+	}
+	return g.submitter.SendTicket(g.ctx, ticket.Epoch, ticket.Proof, proxy.Ed25519)
+}
@@ -0,0 +1,114 @@
+package authoring
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/state"
+)
+
+type submitterMock struct {
+	sent      []block.TicketProof
+	broadcast []block.TicketProof
+}
+
+func (s *submitterMock) SendTicket(_ context.Context, _ jamtime.Epoch, ticket block.TicketProof, _ ed25519.PublicKey) error {
+	s.sent = append(s.sent, ticket)
+	return nil
+}
+
+func (s *submitterMock) BroadcastTicket(_ context.Context, _ jamtime.Epoch, ticket block.TicketProof) error {
+	s.broadcast = append(s.broadcast, ticket)
+	return nil
+}
+
+func TestGenerateTickets(t *testing.T) {
+	importer, states, privateKey := newTestChain(t)
+	producer, err := NewProducer(importer, states, NewPool(nil), nil, privateKey)
+	require.NoError(t, err)
+	imported, err := producer.Produce(context.Background(), jamtime.TimeslotsPerEpoch)
+	require.NoError(t, err)
+	s, _, err := states.State(imported.Hash)
+	require.NoError(t, err)
+
+	tickets, err := GenerateTickets(privateKey, s)
+	require.NoError(t, err)
+	require.Len(t, tickets, common.MaxTicketAttempts)
+
+	// The proofs verify against the ring commitment and yield the identifiers
+	safroleState := s.ValidatorState.SafroleState
+	verifier, err := safroleState.RingVerifier()
+	require.NoError(t, err)
+	defer verifier.Free()
+	for i, ticket := range tickets {
+		assert.Equal(t, jamtime.Epoch(2), ticket.Epoch)
+		assert.Equal(t, uint8(i), ticket.Proof.EntryIndex)
+		assert.Less(t, int(ticket.Proxy), common.NumberOfValidators)
+
+		vrfInputData := append([]byte(state.TicketSealContext), s.EntropyPool[2][:]...)
+		vrfInputData = append(vrfInputData, ticket.Proof.EntryIndex)
+		ok, identifier := verifier.Verify(vrfInputData, []byte{}, safroleState.RingCommitment, ticket.Proof.Proof)
+		require.True(t, ok)
+		assert.Equal(t, ticket.Identifier, identifier)
+	}
+
+	// A key outside of the next validators has no tickets
+	other, err := bandersnatch.NewPrivateKeyFromSeed(crypto.BandersnatchSeedKey{1})
+	require.NoError(t, err)
+	tickets, err = GenerateTickets(other, s)
+	require.NoError(t, err)
+	assert.Empty(t, tickets)
+}
+
+func TestGeneratedTicket_LikelyToWin(t *testing.T) {
+	assert.True(t, GeneratedTicket{Identifier: crypto.BandersnatchOutputHash{}}.LikelyToWin())
+	assert.True(t, GeneratedTicket{Identifier: crypto.BandersnatchOutputHash{0x01}}.LikelyToWin())
+
+	highest := crypto.BandersnatchOutputHash{}
+	for i := range highest {
+		highest[i] = 0xff
+	}
+	assert.Equal(t, jamtime.TimeslotsPerEpoch >= common.NumberOfValidators*common.MaxTicketAttempts,
+		GeneratedTicket{Identifier: highest}.LikelyToWin())
+}
+
+func TestTicketGenerator(t *testing.T) {
+	importer, states, privateKey := newTestChain(t)
+	producer, err := NewProducer(importer, states, NewPool(nil), nil, privateKey)
+	require.NoError(t, err)
+	imported, err := producer.Produce(context.Background(), jamtime.TimeslotsPerEpoch)
+	require.NoError(t, err)
+	s, _, err := states.State(imported.Hash)
+	require.NoError(t, err)
+
+	submitter := &submitterMock{}
+	generator := NewTicketGenerator(context.Background(), states, submitter, privateKey)
+	var submitted []crypto.BandersnatchOutputHash
+	generator.OnTicket(func(ticket GeneratedTicket) {
+		submitted = append(submitted, ticket.Identifier)
+	})
+	require.NoError(t, generator.generate(imported.Hash))
+
+	// Only the likely winners are submitted. All validators share our key, so
+	// we are our own proxy and broadcast the tickets.
+	tickets, err := GenerateTickets(privateKey, s)
+	require.NoError(t, err)
+	var expected []crypto.BandersnatchOutputHash
+	for _, ticket := range tickets {
+		if ticket.LikelyToWin() {
+			expected = append(expected, ticket.Identifier)
+		}
+	}
+	assert.Equal(t, expected, submitted)
+	assert.Len(t, submitter.broadcast, len(expected))
+	assert.Empty(t, submitter.sent)
+}
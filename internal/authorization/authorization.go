@@ -20,6 +20,11 @@ type Authorization struct {
 	state state.State
 }
 
+// New creates the Is-Authorized invocation on top of the given state
+func New(s state.State) *Authorization {
+	return &Authorization{state: s}
+}
+
 // InvokePVM ΨI(P, NC) → Y ∪ J
 func (a *Authorization) InvokePVM(
 	workPackage work.Package, // p
@@ -1,20 +1,26 @@
 package block
 
-import "github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+import (
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
 
 // Block represents the main block structure
 type Block struct {
-	Header    Header
-	Extrinsic Extrinsic
+	Header    Header    `json:"header"`
+	Extrinsic Extrinsic `json:"extrinsic"`
 }
 
 // Extrinsic represents the block extrinsic data
 type Extrinsic struct {
-	ET TicketExtrinsic
-	EP PreimageExtrinsic
-	EG GuaranteesExtrinsic
-	EA AssurancesExtrinsic
-	ED DisputeExtrinsic
+	ET TicketExtrinsic     `json:"tickets"`
+	EP PreimageExtrinsic   `json:"preimages"`
+	EG GuaranteesExtrinsic `json:"guarantees"`
+	EA AssurancesExtrinsic `json:"assurances"`
+	ED DisputeExtrinsic    `json:"disputes"`
 }
 
 // Bytes returns the Jam encoded bytes of the block
@@ -34,3 +40,38 @@ func BlockFromBytes(data []byte) (Block, error) {
 	}
 	return block, nil
 }
+
+// Hash returns the extrinsic hash committed to by the header (Hx).
+// Implements equations 5.4-5.6 in the graypaper (v0.5.4):
+// Hx ≡ H(E(H#(a))) where a = [E(ET), E(EP), g, E(EA), E(ED)]
+// and g ≡ E(↕[(H(w), E4(t), ↕a) | (w, t, a) <− EG])
+func (e Extrinsic) Hash() (crypto.Hash, error) {
+	type guaranteeDigest struct {
+		WorkReportHash crypto.Hash
+		Timeslot       jamtime.Timeslot
+		Credentials    []CredentialSignature
+	}
+	guarantees := make([]guaranteeDigest, 0, len(e.EG.Guarantees))
+	for _, g := range e.EG.Guarantees {
+		reportHash, err := g.WorkReport.Hash()
+		if err != nil {
+			return crypto.Hash{}, fmt.Errorf("hash work report: %w", err)
+		}
+		guarantees = append(guarantees, guaranteeDigest{
+			WorkReportHash: reportHash,
+			Timeslot:       g.Timeslot,
+			Credentials:    g.Credentials,
+		})
+	}
+
+	var hashes []byte
+	for _, component := range []any{e.ET, e.EP, guarantees, e.EA, e.ED} {
+		encoded, err := jam.Marshal(component)
+		if err != nil {
+			return crypto.Hash{}, fmt.Errorf("marshal extrinsic: %w", err)
+		}
+		hash := crypto.HashData(encoded)
+		hashes = append(hashes, hash[:]...)
+	}
+	return crypto.HashData(hashes), nil
+}
@@ -199,3 +199,24 @@ func randomTicketProof(t *testing.T) [ticketProofSize]byte {
 
 	return hash
 }
+
+func Test_ExtrinsicHash(t *testing.T) {
+	empty, err := Extrinsic{}.Hash()
+	require.NoError(t, err)
+
+	// The hash commits to the hashes of the encoded components
+	var hashes []byte
+	for range 5 {
+		hash := crypto.HashData([]byte{0}) // Empty sequences encode as a zero length
+		hashes = append(hashes, hash[:]...)
+	}
+	encodedDisputes, err := jam.Marshal(DisputeExtrinsic{})
+	require.NoError(t, err)
+	disputesHash := crypto.HashData(encodedDisputes)
+	copy(hashes[4*crypto.HashSize:], disputesHash[:])
+	assert.Equal(t, crypto.HashData(hashes), empty)
+
+	withPreimage, err := Extrinsic{EP: PreimageExtrinsic{{ServiceIndex: 1, Data: []byte("data")}}}.Hash()
+	require.NoError(t, err)
+	assert.NotEqual(t, empty, withPreimage)
+}
@@ -13,9 +13,9 @@ type DisputeExtrinsic struct {
 }
 
 type Verdict struct {
-	ReportHash crypto.Hash                               // H, hash of the work report
-	EpochIndex uint32                                    // ⌊τ/E⌋ - N2, epoch index
-	Judgements [common.ValidatorsSuperMajority]Judgement // ⟦{⊺,⊥},NV,E⟧⌊2/3V⌋+1
+	ReportHash crypto.Hash                               `json:"target"` // H, hash of the work report
+	EpochIndex uint32                                    `json:"age"`    // ⌊τ/E⌋ - N2, epoch index
+	Judgements [common.ValidatorsSuperMajority]Judgement `json:"votes"`  // ⟦{⊺,⊥},NV,E⟧⌊2/3V⌋+1
 }
 
 type Culprit struct {
@@ -33,9 +33,9 @@ type Fault struct {
 
 // Judgement represents a single judgment with a signature
 type Judgement struct {
-	IsValid        bool                    // v: {⊺,⊥}
-	ValidatorIndex uint16                  // i: NV
-	Signature      crypto.Ed25519Signature // s: E
+	IsValid        bool                    `json:"vote"`      // v: {⊺,⊥}
+	ValidatorIndex uint16                  `json:"index"`     // i: NV
+	Signature      crypto.Ed25519Signature `json:"signature"` // s: E
 }
 
 // CountPositiveJudgments counts the number of positive judgments in a verdict
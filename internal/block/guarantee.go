@@ -15,15 +15,15 @@ type GuaranteesExtrinsic struct {
 
 // Guarantee represents a single guarantee within the E_G extrinsic
 type Guarantee struct {
-	WorkReport  WorkReport            // The work report being guaranteed
-	Timeslot    jamtime.Timeslot      // The timeslot when this guarantee was made
-	Credentials []CredentialSignature // The credentials proving the guarantee's validity
+	WorkReport  WorkReport            `json:"report"`     // The work report being guaranteed
+	Timeslot    jamtime.Timeslot      `json:"slot"`       // The timeslot when this guarantee was made
+	Credentials []CredentialSignature `json:"signatures"` // The credentials proving the guarantee's validity
 }
 
 // CredentialSignature represents a single signature within the credential
 type CredentialSignature struct {
-	ValidatorIndex uint16                  // Index of the validator providing this signature
-	Signature      crypto.Ed25519Signature // The Ed25519 signature
+	ValidatorIndex uint16                  `json:"validator_index"` // Index of the validator providing this signature
+	Signature      crypto.Ed25519Signature `json:"signature"`       // The Ed25519 signature
 }
 
 // WorkReport represents a work report in the JAM state (equation 11.2 v0.5.4)
@@ -38,11 +38,11 @@ type WorkReport struct {
 }
 
 type WorkPackageSpecification struct {
-	WorkPackageHash           crypto.Hash // Hash of the work-package (h)
-	AuditableWorkBundleLength uint32      // Length of the auditable work bundle (l)
-	ErasureRoot               crypto.Hash // Erasure root (u) - is the root of a binary Merkle tree which functions as a commitment to all data required for the auditing of the report and for use by later workpackages should they need to retrieve any data yielded. It is thus used by assurers to verify the correctness of data they have been sent by guarantors, and it is later verified as correct by auditors.
-	SegmentRoot               crypto.Hash // Segment root (e) - root of a constant-depth, left-biased and zero-hash-padded binary Merkle tree committing to the hashes of each of the exported segments of each work-item. These are used by guarantors to verify the correctness of any reconstructed segments they are called upon to import for evaluation of some later work-package.
-	SegmentCount              uint16      // Segment count (n)
+	WorkPackageHash           crypto.Hash `json:"hash"`          // Hash of the work-package (h)
+	AuditableWorkBundleLength uint32      `json:"length"`        // Length of the auditable work bundle (l)
+	ErasureRoot               crypto.Hash `json:"erasure_root"`  // Erasure root (u) - is the root of a binary Merkle tree which functions as a commitment to all data required for the auditing of the report and for use by later workpackages should they need to retrieve any data yielded. It is thus used by assurers to verify the correctness of data they have been sent by guarantors, and it is later verified as correct by auditors.
+	SegmentRoot               crypto.Hash `json:"exports_root"`  // Segment root (e) - root of a constant-depth, left-biased and zero-hash-padded binary Merkle tree committing to the hashes of each of the exported segments of each work-item. These are used by guarantors to verify the correctness of any reconstructed segments they are called upon to import for evaluation of some later work-package.
+	SegmentCount              uint16      `json:"exports_count"` // Segment count (n)
 }
 
 // RefinementContext describes the context of the chain at the point that the report’s corresponding work-package was evaluated. 11.4 GP 0.5.4
@@ -78,11 +78,11 @@ type ServiceId uint32
 
 // WorkResult is the data conduit by which services’ states may be altered through the computation done within a work-package.
 type WorkResult struct {
-	ServiceId              ServiceId               // Service ID (s) - The index of the service whose state is to be altered and thus whose refine code was already executed.
-	ServiceHashCode        crypto.Hash             // Hash of the service code (c) - The hash of the code of the service at the time of being reported.
-	PayloadHash            crypto.Hash             // Hash of the payload (l) - The hash of the payload within the work item which was executed in the refine stage to give this result. Provided to the accumulation logic of the service later on.
-	GasPrioritizationRatio uint64                  // Gas prioritization ratio (g) - used when determining how much gas should be allocated to execute of this item’s accumulate.
-	Output                 WorkResultOutputOrError // Output of the work result (o) ∈ Y ∪ J: Output or error (Y is the set of octet strings, J is the set of work execution errors)
+	ServiceId              ServiceId               `json:"service_id"`     // Service ID (s) - The index of the service whose state is to be altered and thus whose refine code was already executed.
+	ServiceHashCode        crypto.Hash             `json:"code_hash"`      // Hash of the service code (c) - The hash of the code of the service at the time of being reported.
+	PayloadHash            crypto.Hash             `json:"payload_hash"`   // Hash of the payload (l) - The hash of the payload within the work item which was executed in the refine stage to give this result. Provided to the accumulation logic of the service later on.
+	GasPrioritizationRatio uint64                  `json:"accumulate_gas"` // Gas prioritization ratio (g) - used when determining how much gas should be allocated to execute of this item’s accumulate.
+	Output                 WorkResultOutputOrError `json:"result"`         // Output of the work result (o) ∈ Y ∪ J: Output or error (Y is the set of octet strings, J is the set of work execution errors)
 }
 
 // WorkResultOutputOrError represents either the successful output or an error from a work result
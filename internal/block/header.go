@@ -27,9 +27,9 @@ type Header struct {
 // EpochMarker consists of epoch randomness and a sequence of
 // Bandersnatch keys defining the Bandersnatch validator keys (kb) beginning in the next epoch.
 type EpochMarker struct {
-	Entropy        crypto.Hash
-	TicketsEntropy crypto.Hash
-	Keys           [common.NumberOfValidators]crypto.BandersnatchPublicKey
+	Entropy        crypto.Hash                                             `json:"entropy"`
+	TicketsEntropy crypto.Hash                                             `json:"tickets_entropy"`
+	Keys           [common.NumberOfValidators]crypto.BandersnatchPublicKey `json:"validators"`
 }
 
 type WinningTicketMarker [jamtime.TimeslotsPerEpoch]Ticket
@@ -0,0 +1,440 @@
+package block
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+)
+
+// JSON encoding of blocks, following the field names of the JAM test vectors.
+// Types whose JSON form matches their fields are encoded through struct tags,
+// the others implement json.Marshaler and json.Unmarshaler below.
+
+type headerJSON struct {
+	Parent          crypto.Hash                  `json:"parent"`
+	ParentStateRoot crypto.Hash                  `json:"parent_state_root"`
+	ExtrinsicHash   crypto.Hash                  `json:"extrinsic_hash"`
+	Slot            jamtime.Timeslot             `json:"slot"`
+	EpochMark       *EpochMarker                 `json:"epoch_mark"`
+	TicketsMark     *WinningTicketMarker         `json:"tickets_mark"`
+	OffendersMark   []crypto.HexBytes            `json:"offenders_mark"`
+	AuthorIndex     uint16                       `json:"author_index"`
+	EntropySource   crypto.BandersnatchSignature `json:"entropy_source"`
+	Seal            crypto.BandersnatchSignature `json:"seal"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (h Header) MarshalJSON() ([]byte, error) {
+	offenders := make([]crypto.HexBytes, len(h.OffendersMarkers))
+	for i, key := range h.OffendersMarkers {
+		offenders[i] = crypto.HexBytes(key)
+	}
+	return json.Marshal(headerJSON{
+		Parent:          h.ParentHash,
+		ParentStateRoot: h.PriorStateRoot,
+		ExtrinsicHash:   h.ExtrinsicHash,
+		Slot:            h.TimeSlotIndex,
+		EpochMark:       h.EpochMarker,
+		TicketsMark:     h.WinningTicketsMarker,
+		OffendersMark:   offenders,
+		AuthorIndex:     h.BlockAuthorIndex,
+		EntropySource:   h.VRFSignature,
+		Seal:            h.BlockSealSignature,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (h *Header) UnmarshalJSON(data []byte) error {
+	var v headerJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	offenders := make([]ed25519.PublicKey, len(v.OffendersMark))
+	for i, key := range v.OffendersMark {
+		publicKey, err := ed25519Key(key)
+		if err != nil {
+			return fmt.Errorf("offender %d: %w", i, err)
+		}
+		offenders[i] = publicKey
+	}
+	*h = Header{
+		ParentHash:           v.Parent,
+		PriorStateRoot:       v.ParentStateRoot,
+		ExtrinsicHash:        v.ExtrinsicHash,
+		TimeSlotIndex:        v.Slot,
+		EpochMarker:          v.EpochMark,
+		WinningTicketsMarker: v.TicketsMark,
+		OffendersMarkers:     offenders,
+		BlockAuthorIndex:     v.AuthorIndex,
+		VRFSignature:         v.EntropySource,
+		BlockSealSignature:   v.Seal,
+	}
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (t TicketExtrinsic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(nonNil(t.TicketProofs))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (t *TicketExtrinsic) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &t.TicketProofs)
+}
+
+type ticketProofJSON struct {
+	Attempt   uint8           `json:"attempt"`
+	Signature crypto.HexBytes `json:"signature"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (t TicketProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ticketProofJSON{Attempt: t.EntryIndex, Signature: t.Proof[:]})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (t *TicketProof) UnmarshalJSON(data []byte) error {
+	var v ticketProofJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Signature) != ticketProofSize {
+		return fmt.Errorf("expected %d bytes ticket proof, got %d", ticketProofSize, len(v.Signature))
+	}
+	t.EntryIndex = v.Attempt
+	copy(t.Proof[:], v.Signature)
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (p PreimageExtrinsic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(nonNil([]Preimage(p)))
+}
+
+type preimageJSON struct {
+	Requester uint32          `json:"requester"`
+	Blob      crypto.HexBytes `json:"blob"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (p Preimage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(preimageJSON{Requester: p.ServiceIndex, Blob: p.Data})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (p *Preimage) UnmarshalJSON(data []byte) error {
+	var v preimageJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*p = Preimage{ServiceIndex: v.Requester, Data: v.Blob}
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (g GuaranteesExtrinsic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(nonNil(g.Guarantees))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (g *GuaranteesExtrinsic) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &g.Guarantees)
+}
+
+type workReportJSON struct {
+	PackageSpec       WorkPackageSpecification `json:"package_spec"`
+	Context           RefinementContext        `json:"context"`
+	CoreIndex         uint16                   `json:"core_index"`
+	AuthorizerHash    crypto.Hash              `json:"authorizer_hash"`
+	AuthOutput        crypto.HexBytes          `json:"auth_output"`
+	SegmentRootLookup []segmentRootLookupJSON  `json:"segment_root_lookup"`
+	Results           []WorkResult             `json:"results"`
+}
+
+type segmentRootLookupJSON struct {
+	WorkPackageHash crypto.Hash `json:"work_package_hash"`
+	SegmentTreeRoot crypto.Hash `json:"segment_tree_root"`
+}
+
+// MarshalJSON implements the json.Marshaler interface. The segment-root
+// lookup is encoded as a list ordered by work-package hash.
+func (w WorkReport) MarshalJSON() ([]byte, error) {
+	lookup := make([]segmentRootLookupJSON, 0, len(w.SegmentRootLookup))
+	for workPackageHash, segmentRoot := range w.SegmentRootLookup {
+		lookup = append(lookup, segmentRootLookupJSON{WorkPackageHash: workPackageHash, SegmentTreeRoot: segmentRoot})
+	}
+	sort.Slice(lookup, func(i, j int) bool {
+		return bytes.Compare(lookup[i].WorkPackageHash[:], lookup[j].WorkPackageHash[:]) < 0
+	})
+	return json.Marshal(workReportJSON{
+		PackageSpec:       w.WorkPackageSpecification,
+		Context:           w.RefinementContext,
+		CoreIndex:         w.CoreIndex,
+		AuthorizerHash:    w.AuthorizerHash,
+		AuthOutput:        nonNil(w.Output),
+		SegmentRootLookup: lookup,
+		Results:           nonNil(w.WorkResults),
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (w *WorkReport) UnmarshalJSON(data []byte) error {
+	var v workReportJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	lookup := make(map[crypto.Hash]crypto.Hash, len(v.SegmentRootLookup))
+	for _, entry := range v.SegmentRootLookup {
+		lookup[entry.WorkPackageHash] = entry.SegmentTreeRoot
+	}
+	*w = WorkReport{
+		WorkPackageSpecification: v.PackageSpec,
+		RefinementContext:        v.Context,
+		CoreIndex:                v.CoreIndex,
+		AuthorizerHash:           v.AuthorizerHash,
+		Output:                   v.AuthOutput,
+		SegmentRootLookup:        lookup,
+		WorkResults:              v.Results,
+	}
+	return nil
+}
+
+type refinementContextJSON struct {
+	Anchor           crypto.Hash      `json:"anchor"`
+	StateRoot        crypto.Hash      `json:"state_root"`
+	BeefyRoot        crypto.Hash      `json:"beefy_root"`
+	LookupAnchor     crypto.Hash      `json:"lookup_anchor"`
+	LookupAnchorSlot jamtime.Timeslot `json:"lookup_anchor_slot"`
+	Prerequisites    []crypto.Hash    `json:"prerequisites"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (r RefinementContext) MarshalJSON() ([]byte, error) {
+	return json.Marshal(refinementContextJSON{
+		Anchor:           r.Anchor.HeaderHash,
+		StateRoot:        r.Anchor.PosteriorStateRoot,
+		BeefyRoot:        r.Anchor.PosteriorBeefyRoot,
+		LookupAnchor:     r.LookupAnchor.HeaderHash,
+		LookupAnchorSlot: r.LookupAnchor.Timeslot,
+		Prerequisites:    nonNil(r.PrerequisiteWorkPackage),
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (r *RefinementContext) UnmarshalJSON(data []byte) error {
+	var v refinementContextJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = RefinementContext{
+		Anchor: RefinementContextAnchor{
+			HeaderHash:         v.Anchor,
+			PosteriorStateRoot: v.StateRoot,
+			PosteriorBeefyRoot: v.BeefyRoot,
+		},
+		LookupAnchor: RefinementContextLookupAnchor{
+			HeaderHash: v.LookupAnchor,
+			Timeslot:   v.LookupAnchorSlot,
+		},
+		PrerequisiteWorkPackage: v.Prerequisites,
+	}
+	return nil
+}
+
+// workResultErrorNames are the JSON names of the work execution errors
+var workResultErrorNames = map[WorkResultError]string{
+	OutOfGas:              "out_of_gas",
+	UnexpectedTermination: "panic",
+	CodeNotAvailable:      "bad_code",
+	CodeTooLarge:          "code_oversize",
+}
+
+// MarshalJSON implements the json.Marshaler interface. The output is encoded
+// as {"ok": "0x..."} and errors as {"<error>": null}.
+func (wer WorkResultOutputOrError) MarshalJSON() ([]byte, error) {
+	switch v := wer.Inner.(type) {
+	case []byte:
+		return json.Marshal(map[string]crypto.HexBytes{"ok": v})
+	case WorkResultError:
+		name, ok := workResultErrorNames[v]
+		if !ok {
+			return nil, fmt.Errorf("unknown work result error %d", v)
+		}
+		return json.Marshal(map[string]any{name: nil})
+	}
+	return nil, fmt.Errorf("unsupported work result %T", wer.Inner)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (wer *WorkResultOutputOrError) UnmarshalJSON(data []byte) error {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v) != 1 {
+		return fmt.Errorf("expected a single work result, got %d", len(v))
+	}
+	for name, value := range v {
+		if name == "ok" {
+			var output crypto.HexBytes
+			if err := json.Unmarshal(value, &output); err != nil {
+				return err
+			}
+			wer.Inner = []byte(output)
+			return nil
+		}
+		for workResultError, errorName := range workResultErrorNames {
+			if name == errorName {
+				wer.Inner = workResultError
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown work result %q", name)
+	}
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (a AssurancesExtrinsic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(nonNil([]Assurance(a)))
+}
+
+type assuranceJSON struct {
+	Anchor         crypto.Hash             `json:"anchor"`
+	Bitfield       crypto.HexBytes         `json:"bitfield"`
+	ValidatorIndex uint16                  `json:"validator_index"`
+	Signature      crypto.Ed25519Signature `json:"signature"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (a Assurance) MarshalJSON() ([]byte, error) {
+	return json.Marshal(assuranceJSON{
+		Anchor:         a.Anchor,
+		Bitfield:       a.Bitfield[:],
+		ValidatorIndex: a.ValidatorIndex,
+		Signature:      a.Signature,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (a *Assurance) UnmarshalJSON(data []byte) error {
+	var v assuranceJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Bitfield) != int(AvailBitfieldBytes) {
+		return fmt.Errorf("expected %d bytes bitfield, got %d", AvailBitfieldBytes, len(v.Bitfield))
+	}
+	*a = Assurance{
+		Anchor:         v.Anchor,
+		ValidatorIndex: v.ValidatorIndex,
+		Signature:      v.Signature,
+	}
+	copy(a.Bitfield[:], v.Bitfield)
+	return nil
+}
+
+type disputeExtrinsicJSON struct {
+	Verdicts []Verdict `json:"verdicts"`
+	Culprits []Culprit `json:"culprits"`
+	Faults   []Fault   `json:"faults"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (d DisputeExtrinsic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(disputeExtrinsicJSON{
+		Verdicts: nonNil(d.Verdicts),
+		Culprits: nonNil(d.Culprits),
+		Faults:   nonNil(d.Faults),
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (d *DisputeExtrinsic) UnmarshalJSON(data []byte) error {
+	var v disputeExtrinsicJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*d = DisputeExtrinsic(v)
+	return nil
+}
+
+type culpritJSON struct {
+	Target    crypto.Hash             `json:"target"`
+	Key       crypto.HexBytes         `json:"key"`
+	Signature crypto.Ed25519Signature `json:"signature"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (c Culprit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(culpritJSON{
+		Target:    c.ReportHash,
+		Key:       crypto.HexBytes(c.ValidatorEd25519PublicKey),
+		Signature: c.Signature,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (c *Culprit) UnmarshalJSON(data []byte) error {
+	var v culpritJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	key, err := ed25519Key(v.Key)
+	if err != nil {
+		return err
+	}
+	*c = Culprit{ReportHash: v.Target, ValidatorEd25519PublicKey: key, Signature: v.Signature}
+	return nil
+}
+
+type faultJSON struct {
+	Target    crypto.Hash             `json:"target"`
+	Vote      bool                    `json:"vote"`
+	Key       crypto.HexBytes         `json:"key"`
+	Signature crypto.Ed25519Signature `json:"signature"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (f Fault) MarshalJSON() ([]byte, error) {
+	return json.Marshal(faultJSON{
+		Target:    f.ReportHash,
+		Vote:      f.IsValid,
+		Key:       crypto.HexBytes(f.ValidatorEd25519PublicKey),
+		Signature: f.Signature,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (f *Fault) UnmarshalJSON(data []byte) error {
+	var v faultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	key, err := ed25519Key(v.Key)
+	if err != nil {
+		return err
+	}
+	*f = Fault{ReportHash: v.Target, IsValid: v.Vote, ValidatorEd25519PublicKey: key, Signature: v.Signature}
+	return nil
+}
+
+func ed25519Key(key crypto.HexBytes) (ed25519.PublicKey, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected %d bytes ed25519 key, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// nonNil returns an empty slice instead of nil, so that it is encoded as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
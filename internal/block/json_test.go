@@ -0,0 +1,54 @@
+package block
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/testutils"
+)
+
+func Test_HeaderJSON(t *testing.T) {
+	h := Header{
+		ParentHash:       testutils.RandomHash(t),
+		TimeSlotIndex:    42,
+		OffendersMarkers: []ed25519.PublicKey{testutils.RandomED25519PublicKey(t)},
+		BlockAuthorIndex: 3,
+	}
+	data, err := json.Marshal(h)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"epoch_mark":null`)
+
+	var decoded Header
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, h, decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"offenders_mark": ["0x01"]}`), &decoded))
+}
+
+func Test_WorkResultOutputOrErrorJSON(t *testing.T) {
+	tests := map[string]WorkResultOutputOrError{
+		`{"ok":"0x0102"}`:        {[]byte{1, 2}},
+		`{"out_of_gas":null}`:    {OutOfGas},
+		`{"panic":null}`:         {UnexpectedTermination},
+		`{"bad_code":null}`:      {CodeNotAvailable},
+		`{"code_oversize":null}`: {CodeTooLarge},
+	}
+	for expected, result := range tests {
+		t.Run(expected, func(t *testing.T) {
+			data, err := json.Marshal(result)
+			require.NoError(t, err)
+			assert.JSONEq(t, expected, string(data))
+
+			var decoded WorkResultOutputOrError
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			assert.Equal(t, result, decoded)
+		})
+	}
+
+	var decoded WorkResultOutputOrError
+	assert.Error(t, json.Unmarshal([]byte(`{"unknown":null}`), &decoded))
+}
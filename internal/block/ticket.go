@@ -9,8 +9,8 @@ const (
 
 // Ticket represents a single ticket (C in equation 50)
 type Ticket struct {
-	Identifier crypto.BandersnatchOutputHash // y ∈ H 32bytes hash
-	EntryIndex uint8                         // r ∈ Nn (0, 1)
+	Identifier crypto.BandersnatchOutputHash `json:"id"`      // y ∈ H 32bytes hash
+	EntryIndex uint8                         `json:"attempt"` // r ∈ Nn (0, 1)
 }
 
 func (t Ticket) TicketOrKeyType() {}
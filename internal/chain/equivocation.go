@@ -0,0 +1,131 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/store"
+)
+
+// Offenders returns the Ed25519 keys of the block authors which sealed two
+// different headers for the same timeslot, in the order their evidence is
+// stored. The evidence itself is available through Store.GetEquivocations.
+func (bs *BlockService) Offenders() ([]ed25519.PublicKey, error) {
+	equivocations, err := bs.Store.GetEquivocations()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{})
+	var offenders []ed25519.PublicKey
+	for _, equivocation := range equivocations {
+		if _, ok := seen[string(equivocation.Offender)]; ok {
+			continue
+		}
+		seen[string(equivocation.Offender)] = struct{}{}
+		offenders = append(offenders, equivocation.Offender)
+	}
+	return offenders, nil
+}
+
+// detectEquivocation looks for a stored header with the timeslot and author
+// index of a block being imported, whose seal is valid as well and whose
+// author has the same key. Each author key is looked up in the validators κ′
+// of its own chain, the posterior state of the block. Returns nil if there is
+// none.
+func (im *Importer) detectEquivocation(hash crypto.Hash, header block.Header, posterior *stateEntry) (*store.Equivocation, error) {
+	others, err := im.blockService.Store.GetHeadersAtTimeslot(header.TimeSlotIndex)
+	if err != nil {
+		return nil, fmt.Errorf("get headers at timeslot: %w", err)
+	}
+	var author ed25519.PublicKey
+	for _, other := range others {
+		if other.BlockAuthorIndex != header.BlockAuthorIndex {
+			continue
+		}
+		otherHash, err := other.Hash()
+		if err != nil {
+			return nil, fmt.Errorf("hash header: %w", err)
+		}
+		if otherHash == hash {
+			continue
+		}
+		otherAuthor, err := im.authorKey(otherHash, other)
+		if err != nil {
+			return nil, err
+		}
+		if otherAuthor == nil {
+			continue
+		}
+		if author == nil {
+			author, err = validatorKey(posterior.state.ValidatorState.CurrentValidators, header.BlockAuthorIndex)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !author.Equal(otherAuthor) {
+			continue
+		}
+		return &store.Equivocation{
+			Offender: author,
+			First:    other,
+			Second:   header,
+		}, nil
+	}
+	return nil, nil
+}
+
+// authorKey returns the Ed25519 key of the author of a stored header in the
+// validators κ′ of its own chain, or nil if its seal is not known to be
+// valid. Imported blocks passed the seal check on import, their author is
+// looked up in their posterior state. A header received without its block is
+// checked against the posterior state of its parent if both are in the same
+// epoch, which then has the same validators. Otherwise its sealing keys are
+// not known without its block.
+func (im *Importer) authorKey(hash crypto.Hash, header block.Header) (ed25519.PublicKey, error) {
+	chain := im.blockService.Store
+	if _, err := chain.GetBlock(hash); err == nil {
+		posterior, err := im.states.entry(hash)
+		if err != nil {
+			if errors.Is(err, ErrStateUnavailable) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return validatorKey(posterior.state.ValidatorState.CurrentValidators, header.BlockAuthorIndex)
+	} else if !errors.Is(err, store.ErrBlockNotFound) {
+		return nil, fmt.Errorf("get block: %w", err)
+	}
+	parent, err := chain.GetHeader(header.ParentHash)
+	if err != nil {
+		if errors.Is(err, store.ErrHeaderNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get parent header: %w", err)
+	}
+	if parent.TimeSlotIndex.ToEpoch() != header.TimeSlotIndex.ToEpoch() {
+		return nil, nil
+	}
+	parentState, err := im.states.entry(header.ParentHash)
+	if err != nil {
+		if errors.Is(err, ErrStateUnavailable) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if verifySeal(header, parentState.state) != nil {
+		return nil, nil
+	}
+	return validatorKey(parentState.state.ValidatorState.CurrentValidators, header.BlockAuthorIndex)
+}
+
+// validatorKey returns the Ed25519 key of the validator with the given index
+func validatorKey(validators safrole.ValidatorsData, index uint16) (ed25519.PublicKey, error) {
+	if int(index) >= len(validators) || validators[index] == nil {
+		return nil, fmt.Errorf("block author index %d out of range", index)
+	}
+	return validators[index].Ed25519, nil
+}
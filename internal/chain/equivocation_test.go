@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImporter_Equivocation(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	bs := im.blockService
+	genesisHash := bs.Genesis
+
+	x1, err := im.Import(sealedBlock(t, im, genesisHash, 1, privateKey))
+	require.NoError(t, err)
+	x2, err := im.Import(sealedBlock(t, im, x1.Hash, 2, privateKey))
+	require.NoError(t, err)
+	assert.Nil(t, x2.Equivocation)
+
+	// The same author seals another block for the second timeslot
+	y2, err := im.Import(sealedBlock(t, im, genesisHash, 2, privateKey))
+	require.NoError(t, err)
+	require.NotNil(t, y2.Equivocation)
+	genesisState, _, err := im.states.State(genesisHash)
+	require.NoError(t, err)
+	offender := genesisState.ValidatorState.CurrentValidators[0].Ed25519
+	assert.Equal(t, offender, y2.Equivocation.Offender)
+	assert.Equal(t, x2.Header, y2.Equivocation.First)
+	assert.Equal(t, y2.Header, y2.Equivocation.Second)
+
+	// The evidence is stored and the offender surfaced
+	equivocations, err := bs.Store.GetEquivocations()
+	require.NoError(t, err)
+	require.Len(t, equivocations, 1)
+	assert.Equal(t, *y2.Equivocation, equivocations[0])
+	offenders, err := bs.Offenders()
+	require.NoError(t, err)
+	assert.Equal(t, []ed25519.PublicKey{offender}, offenders)
+
+	// All test blocks share the author, so none of them is the best head
+	assert.False(t, y2.BestHead)
+	assert.Equal(t, genesisHash, im.states.BestHead())
+
+	// The exclusion survives a restart
+	restarted, err := NewStateManager(bs, im.states.trie, genesisState, StateManagerConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, genesisHash, restarted.BestHead())
+}
+
+func TestImporter_EquivocationOtherAuthor(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	genesisHash := im.blockService.Genesis
+
+	x1, err := im.Import(sealedBlock(t, im, genesisHash, 1, privateKey))
+	require.NoError(t, err)
+	x2, err := im.Import(sealedBlock(t, im, x1.Hash, 2, privateKey))
+	require.NoError(t, err)
+
+	// On the chain of the second block another validator holds the same index
+	entry, ok := im.states.cache[x2.Hash]
+	require.True(t, ok)
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	author := *entry.state.ValidatorState.CurrentValidators[x2.Header.BlockAuthorIndex]
+	author.Ed25519 = otherKey
+	entry.state.ValidatorState.CurrentValidators[x2.Header.BlockAuthorIndex] = &author
+
+	// so a block for the same timeslot and index on another chain is no equivocation
+	y2, err := im.Import(sealedBlock(t, im, genesisHash, 2, privateKey))
+	require.NoError(t, err)
+	assert.Nil(t, y2.Equivocation)
+	offenders, err := im.blockService.Offenders()
+	require.NoError(t, err)
+	assert.Empty(t, offenders)
+}
@@ -0,0 +1,253 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/store"
+)
+
+// forkNode is the fork choice information of a block after the finalized
+// block, derived from the headers of its chain
+type forkNode struct {
+	parent       crypto.Hash
+	slot         jamtime.Timeslot
+	marker       bool   // A winning tickets marker is in this block or an earlier block of its epoch
+	ticketSealed bool   // Sealed with a ticket rather than a fallback key
+	tickets      uint32 // Ticket-sealed blocks after the finalized block, up to this one
+	length       uint32 // Blocks after the finalized block, up to this one
+}
+
+// heavier tells whether the chain of the node is preferred over the other's:
+// more ticket-sealed blocks, then more blocks
+func (n *forkNode) heavier(other *forkNode) bool {
+	if n.tickets != other.tickets {
+		return n.tickets > other.tickets
+	}
+	return n.length > other.length
+}
+
+// forkChoice implements the best chain rule of the graypaper (section 19).
+// The best head is the imported block with the most ticket-sealed blocks since
+// the finalized block, among the chains which contain neither a block with a
+// work report judged bad nor a block by an equivocating author.
+//
+// Whether a block is sealed with a ticket follows from the headers: the
+// sealing keys of an epoch are the tickets of the previous epoch if that epoch
+// emitted the winning tickets marker and directly precedes it, otherwise they
+// are the fallback keys.
+type forkChoice struct {
+	store        *store.Chain
+	finalized    LatestFinalized
+	nodes        map[crypto.Hash]*forkNode
+	reports      map[crypto.Hash][]crypto.Hash     // Hashes of the work reports guaranteed in a block
+	badReports   map[crypto.Hash]struct{}          // Work reports judged bad in any imported block, ψb
+	equivocators map[string]struct{}               // Ed25519 keys of the equivocating authors
+	authors      map[crypto.Hash]ed25519.PublicKey // Ed25519 keys of the block authors
+}
+
+func newForkChoice(chain *store.Chain, finalized LatestFinalized) (*forkChoice, error) {
+	fc := &forkChoice{
+		store:        chain,
+		badReports:   make(map[crypto.Hash]struct{}),
+		equivocators: make(map[string]struct{}),
+	}
+	if err := fc.reset(finalized); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// reset drops the information of the blocks before the new finalized block.
+// The sealing mode of the finalized block is derived from the headers of its
+// epoch and the two before it.
+func (fc *forkChoice) reset(finalized LatestFinalized) error {
+	var headers []crypto.Hash
+	parents := make(map[crypto.Hash]crypto.Hash)
+	slots := make(map[crypto.Hash]jamtime.Timeslot)
+	markers := make(map[crypto.Hash]bool)
+	epoch := finalized.TimeSlotIndex.ToEpoch()
+	current := finalized.Hash
+	for {
+		header, err := fc.store.GetHeader(current)
+		if err != nil {
+			return fmt.Errorf("get header: %w", err)
+		}
+		if len(headers) > 0 && header.TimeSlotIndex.ToEpoch()+2 < epoch {
+			break
+		}
+		headers = append(headers, current)
+		parents[current] = header.ParentHash
+		slots[current] = header.TimeSlotIndex
+		markers[current] = header.WinningTicketsMarker != nil
+		if header.TimeSlotIndex == 0 || header.ParentHash == (crypto.Hash{}) {
+			break
+		}
+		current = header.ParentHash
+	}
+
+	// The oldest block is either the genesis block, sealed with fallback keys,
+	// or two epochs back, where only its winning tickets marker matters
+	base := &forkNode{}
+	for i := len(headers) - 1; i >= 0; i-- {
+		hash := headers[i]
+		base = fc.child(base, slots[hash], parents[hash], markers[hash], i == len(headers)-1)
+	}
+	base.tickets, base.length = 0, 0
+
+	fc.finalized = finalized
+	fc.nodes = map[crypto.Hash]*forkNode{finalized.Hash: base}
+	fc.reports = make(map[crypto.Hash][]crypto.Hash)
+	fc.authors = make(map[crypto.Hash]ed25519.PublicKey)
+	return nil
+}
+
+// child derives the node of a block from the node of its parent
+func (fc *forkChoice) child(parent *forkNode, slot jamtime.Timeslot, parentHash crypto.Hash, marker, first bool) *forkNode {
+	n := &forkNode{parent: parentHash, slot: slot, length: parent.length + 1}
+	sameEpoch := !first && slot.ToEpoch() == parent.slot.ToEpoch()
+	switch {
+	case sameEpoch:
+		n.ticketSealed = parent.ticketSealed
+	case !first:
+		// Equation 69: γ′s ≡ Z(γa) if e′ = e + 1 ∧ m ≥ Y ∧ |γa| = E
+		n.ticketSealed = slot.ToEpoch() == parent.slot.ToEpoch()+1 && parent.marker
+	}
+	n.marker = marker || (sameEpoch && parent.marker)
+	n.tickets = parent.tickets
+	if n.ticketSealed {
+		n.tickets++
+	}
+	return n
+}
+
+// node returns the fork choice information of a block. Blocks which do not
+// descend from the finalized block result in an error.
+func (fc *forkChoice) node(hash crypto.Hash) (*forkNode, error) {
+	type pendingHeader struct {
+		hash, parent crypto.Hash
+		slot         jamtime.Timeslot
+		marker       bool
+	}
+	var path []pendingHeader
+	current := hash
+	n, ok := fc.nodes[current]
+	for !ok {
+		header, err := fc.store.GetHeader(current)
+		if err != nil {
+			return nil, fmt.Errorf("get header: %w", err)
+		}
+		if header.TimeSlotIndex <= fc.finalized.TimeSlotIndex {
+			return nil, fmt.Errorf("block %x does not descend from the finalized block", hash)
+		}
+		path = append(path, pendingHeader{current, header.ParentHash, header.TimeSlotIndex, header.WinningTicketsMarker != nil})
+		current = header.ParentHash
+		n, ok = fc.nodes[current]
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		n = fc.child(n, path[i].slot, path[i].parent, path[i].marker, false)
+		fc.nodes[path[i].hash] = n
+	}
+	return n, nil
+}
+
+// viable returns the newest block of the chain of the given block, up to and
+// including it, which is not excluded: neither it nor an ancestor after the
+// finalized block contains a work report judged bad or is by an equivocating
+// author. The author of a block is looked up with the given function, only if
+// there are equivocating authors.
+func (fc *forkChoice) viable(hash crypto.Hash, author func(crypto.Hash) (ed25519.PublicKey, error)) (crypto.Hash, error) {
+	if len(fc.badReports) == 0 && len(fc.equivocators) == 0 {
+		return hash, nil
+	}
+	viable := hash
+	for current := hash; current != fc.finalized.Hash; {
+		n, err := fc.node(current)
+		if err != nil {
+			return crypto.Hash{}, err
+		}
+		bad, err := fc.bad(current, author)
+		if err != nil {
+			return crypto.Hash{}, err
+		}
+		if bad {
+			viable = n.parent
+		}
+		current = n.parent
+	}
+	return viable, nil
+}
+
+// bad tells whether a block itself contains a work report judged bad or is by
+// an equivocating author
+func (fc *forkChoice) bad(hash crypto.Hash, author func(crypto.Hash) (ed25519.PublicKey, error)) (bool, error) {
+	if len(fc.badReports) > 0 {
+		reports, err := fc.blockReports(hash)
+		if err != nil {
+			return false, err
+		}
+		for _, report := range reports {
+			if _, ok := fc.badReports[report]; ok {
+				return true, nil
+			}
+		}
+	}
+	if len(fc.equivocators) > 0 {
+		key, ok := fc.authors[hash]
+		if !ok {
+			var err error
+			if key, err = author(hash); err != nil {
+				return false, err
+			}
+			fc.authors[hash] = key
+		}
+		if _, ok := fc.equivocators[string(key)]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// blockReports returns the hashes of the work reports guaranteed in a block
+func (fc *forkChoice) blockReports(hash crypto.Hash) ([]crypto.Hash, error) {
+	if reports, ok := fc.reports[hash]; ok {
+		return reports, nil
+	}
+	b, err := fc.store.GetBlock(hash)
+	if err != nil {
+		return nil, fmt.Errorf("get block: %w", err)
+	}
+	reports := make([]crypto.Hash, 0, len(b.Extrinsic.EG.Guarantees))
+	for _, guarantee := range b.Extrinsic.EG.Guarantees {
+		report, err := guarantee.WorkReport.Hash()
+		if err != nil {
+			return nil, fmt.Errorf("hash work report: %w", err)
+		}
+		reports = append(reports, report)
+	}
+	fc.reports[hash] = reports
+	return reports, nil
+}
+
+// addBadReports adds work reports judged bad, returns whether any was new
+func (fc *forkChoice) addBadReports(reports []crypto.Hash) bool {
+	added := false
+	for _, report := range reports {
+		if _, ok := fc.badReports[report]; !ok {
+			fc.badReports[report] = struct{}{}
+			added = true
+		}
+	}
+	return added
+}
+
+// addEquivocator adds the key of an equivocating author, returns whether it was new
+func (fc *forkChoice) addEquivocator(key ed25519.PublicKey) bool {
+	if _, ok := fc.equivocators[string(key)]; ok {
+		return false
+	}
+	fc.equivocators[string(key)] = struct{}{}
+	return true
+}
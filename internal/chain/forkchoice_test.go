@@ -0,0 +1,138 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/pkg/db/pebble"
+)
+
+func newTestForkChoiceStore(t *testing.T) (*store.Chain, crypto.Hash) {
+	kvStore, err := pebble.NewKVStore()
+	require.NoError(t, err)
+	chain := store.NewChain(kvStore)
+	t.Cleanup(func() {
+		chain.Close()
+	})
+	genesis := block.Block{Header: block.Header{}}
+	require.NoError(t, chain.PutBlock(genesis))
+	hash, err := genesis.Header.Hash()
+	require.NoError(t, err)
+	return chain, hash
+}
+
+// putChain stores blocks on top of the parent at the given slots, the ones
+// in markers emit the winning tickets marker
+func putChain(t *testing.T, chain *store.Chain, parent crypto.Hash, slots []jamtime.Timeslot, markers ...jamtime.Timeslot) []crypto.Hash {
+	var hashes []crypto.Hash
+	for _, slot := range slots {
+		header := block.Header{ParentHash: parent, TimeSlotIndex: slot}
+		for _, marker := range markers {
+			if marker == slot {
+				header.WinningTicketsMarker = &block.WinningTicketMarker{}
+			}
+		}
+		require.NoError(t, chain.PutBlock(block.Block{Header: header}))
+		hash, err := header.Hash()
+		require.NoError(t, err)
+		hashes = append(hashes, hash)
+		parent = hash
+	}
+	return hashes
+}
+
+func TestForkChoice_TicketSealed(t *testing.T) {
+	chain, genesisHash := newTestForkChoiceStore(t)
+	const e = jamtime.TimeslotsPerEpoch
+
+	// The first epoch emits the winning tickets marker, the next one is sealed with tickets
+	tickets := putChain(t, chain, genesisHash, []jamtime.Timeslot{1, e, e + 1}, 1)
+	// Without the marker the next epoch is sealed with the fallback keys
+	fallback := putChain(t, chain, genesisHash, []jamtime.Timeslot{2, e + 1, e + 2, e + 3})
+	// Tickets are only used for the directly following epoch
+	skipped := putChain(t, chain, tickets[0], []jamtime.Timeslot{2*e + 1, 2*e + 2, 2*e + 3, 2*e + 4})
+
+	fc, err := newForkChoice(chain, LatestFinalized{Hash: genesisHash})
+	require.NoError(t, err)
+	ticketsHead, err := fc.node(tickets[2])
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), ticketsHead.tickets)
+	assert.Equal(t, uint32(3), ticketsHead.length)
+	fallbackHead, err := fc.node(fallback[3])
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), fallbackHead.tickets)
+	skippedHead, err := fc.node(skipped[3])
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), skippedHead.tickets)
+
+	// The chain with more ticket-sealed blocks wins over the longer one
+	assert.True(t, ticketsHead.heavier(fallbackHead))
+	assert.False(t, fallbackHead.heavier(ticketsHead))
+	// The longer one wins if the ticket-sealed blocks are equal
+	assert.True(t, skippedHead.heavier(fallbackHead))
+
+	// The sealing mode is derived again from the headers after finalization
+	require.NoError(t, fc.reset(LatestFinalized{Hash: tickets[1], TimeSlotIndex: e}))
+	child := putChain(t, chain, tickets[1], []jamtime.Timeslot{e + 5})
+	n, err := fc.node(child[0])
+	require.NoError(t, err)
+	assert.True(t, n.ticketSealed)
+	assert.Equal(t, uint32(1), n.tickets)
+	_, err = fc.node(fallback[3])
+	assert.Error(t, err)
+}
+
+func TestForkChoice_Viable(t *testing.T) {
+	chain, genesisHash := newTestForkChoiceStore(t)
+	hashes := putChain(t, chain, genesisHash, []jamtime.Timeslot{1})
+
+	// A block guaranteeing a work report which is later judged bad
+	report := block.WorkReport{CoreIndex: 1, AuthorizerHash: testutils.RandomHash(t)}
+	reportHash, err := report.Hash()
+	require.NoError(t, err)
+	reported := block.Block{Header: block.Header{ParentHash: hashes[0], TimeSlotIndex: 2}}
+	reported.Extrinsic.EG.Guarantees = []block.Guarantee{{WorkReport: report, Timeslot: 2}}
+	require.NoError(t, chain.PutBlock(reported))
+	reportedHash, err := reported.Header.Hash()
+	require.NoError(t, err)
+	hashes = append(hashes, reportedHash)
+	hashes = append(hashes, putChain(t, chain, reportedHash, []jamtime.Timeslot{3})...)
+
+	fc, err := newForkChoice(chain, LatestFinalized{Hash: genesisHash})
+	require.NoError(t, err)
+	equivocator := testutils.RandomED25519PublicKey(t)
+	authors := map[crypto.Hash]ed25519.PublicKey{
+		hashes[0]: testutils.RandomED25519PublicKey(t),
+		hashes[1]: testutils.RandomED25519PublicKey(t),
+		hashes[2]: equivocator,
+	}
+	author := func(hash crypto.Hash) (ed25519.PublicKey, error) {
+		return authors[hash], nil
+	}
+
+	viable, err := fc.viable(hashes[2], author)
+	require.NoError(t, err)
+	assert.Equal(t, hashes[2], viable)
+
+	// Blocks by an equivocating author are excluded
+	assert.True(t, fc.addEquivocator(equivocator))
+	assert.False(t, fc.addEquivocator(equivocator))
+	viable, err = fc.viable(hashes[2], author)
+	require.NoError(t, err)
+	assert.Equal(t, hashes[1], viable)
+
+	// So are the chains containing a work report judged bad
+	assert.True(t, fc.addBadReports([]crypto.Hash{reportHash}))
+	assert.False(t, fc.addBadReports([]crypto.Hash{reportHash}))
+	viable, err = fc.viable(hashes[2], author)
+	require.NoError(t, err)
+	assert.Equal(t, hashes[0], viable)
+}
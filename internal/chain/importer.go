@@ -0,0 +1,223 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/state"
+	merkle "github.com/eigerco/strawberry/internal/state/merkle"
+	"github.com/eigerco/strawberry/internal/statetransition"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/pkg/network"
+)
+
+// Reasons for rejecting a block
+var (
+	ErrBlockKnown               = errors.New("block already imported")
+	ErrUnknownParent            = errors.New("parent block not imported")
+	ErrNotDescendantOfFinalized = errors.New("block is not a descendant of the latest finalized block")
+	ErrTimeslotNotIncreasing    = errors.New("block timeslot is not after its parent's")
+	ErrFutureBlock              = errors.New("block timeslot is in the future")
+	ErrExtrinsicHashMismatch    = errors.New("extrinsic hash does not match the extrinsic")
+	ErrPriorStateRootMismatch   = errors.New("prior state root does not match the parent's posterior state root")
+	ErrInvalidSeal              = errors.New("invalid block seal or VRF signature")
+	ErrInvalidStateTransition   = errors.New("state transition failed")
+)
+
+// ImportedBlock describes a block which was imported
+type ImportedBlock struct {
+	Hash      crypto.Hash
+	Header    block.Header
+	Extrinsic block.Extrinsic
+	StateRoot crypto.Hash       // Root of the posterior state
+	Delta     merkle.StateDelta // Changes from the parent's posterior state
+	BestHead  bool              // Whether the block became the best head
+
+	// Equivocation is the evidence if the author of the block sealed another
+	// header for the same timeslot, nil otherwise
+	Equivocation *store.Equivocation
+}
+
+// Importer validates blocks and applies them on top of the posterior state of
+// their parent. Imported blocks are stored, their posterior state is handed
+// to the state manager, and they are added to the leaves of the block service
+// and announced to the subscribers.
+type Importer struct {
+	mu           sync.Mutex // Serializes imports
+	blockService *BlockService
+	states       *StateManager
+
+	subscribersMu sync.RWMutex
+	subscribers   []func(ImportedBlock)
+}
+
+// NewImporter creates an Importer on top of the block service, using the
+// state manager for the posterior states of the blocks
+func NewImporter(bs *BlockService, states *StateManager) *Importer {
+	return &Importer{blockService: bs, states: states}
+}
+
+// Subscribe registers a function which is called for every imported block,
+// after the block and its state were stored
+func (im *Importer) Subscribe(fn func(ImportedBlock)) {
+	im.subscribersMu.Lock()
+	defer im.subscribersMu.Unlock()
+	im.subscribers = append(im.subscribers, fn)
+}
+
+// Import validates a block against its parent and applies it. Invalid blocks
+// are rejected with one of the errors above, leaving the store unchanged.
+func (im *Importer) Import(b block.Block) (ImportedBlock, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	chain := im.blockService.Store
+	header := b.Header
+	hash, err := header.Hash()
+	if err != nil {
+		return ImportedBlock{}, fmt.Errorf("hash header: %w", err)
+	}
+	if _, err := chain.GetBlock(hash); err == nil {
+		return ImportedBlock{}, fmt.Errorf("%w: %x", ErrBlockKnown, hash)
+	} else if !errors.Is(err, store.ErrBlockNotFound) {
+		return ImportedBlock{}, fmt.Errorf("get block: %w", err)
+	}
+
+	// The parent must be imported, its posterior state is the prior state of the block
+	parent, err := chain.GetBlock(header.ParentHash)
+	if err != nil {
+		if errors.Is(err, store.ErrBlockNotFound) {
+			return ImportedBlock{}, fmt.Errorf("%w: %x", ErrUnknownParent, header.ParentHash)
+		}
+		return ImportedBlock{}, fmt.Errorf("get parent block: %w", err)
+	}
+
+	if header.TimeSlotIndex <= parent.Header.TimeSlotIndex {
+		return ImportedBlock{}, fmt.Errorf("%w: parent %d, got %d", ErrTimeslotNotIncreasing, parent.Header.TimeSlotIndex, header.TimeSlotIndex)
+	}
+	if header.TimeSlotIndex.IsInFuture() {
+		return ImportedBlock{}, fmt.Errorf("%w: %d", ErrFutureBlock, header.TimeSlotIndex)
+	}
+	isDescendant, err := im.blockService.isDescendantOfFinalized(&header)
+	if err != nil {
+		return ImportedBlock{}, fmt.Errorf("check descendant of finalized: %w", err)
+	}
+	if !isDescendant {
+		return ImportedBlock{}, fmt.Errorf("%w: %x", ErrNotDescendantOfFinalized, hash)
+	}
+	extrinsicHash, err := b.Extrinsic.Hash()
+	if err != nil {
+		return ImportedBlock{}, fmt.Errorf("hash extrinsic: %w", err)
+	}
+	if extrinsicHash != header.ExtrinsicHash {
+		return ImportedBlock{}, fmt.Errorf("%w: expected %x, got %x", ErrExtrinsicHashMismatch, extrinsicHash, header.ExtrinsicHash)
+	}
+
+	imported, err := im.applyAndStore(hash, parent.Header, b)
+	if err != nil {
+		return ImportedBlock{}, err
+	}
+	im.states.notifyHead()
+
+	imported.Hash, imported.Header, imported.Extrinsic = hash, header, b.Extrinsic
+	network.LogBlockEvent(time.Now(), "imported", hash, header.TimeSlotIndex.ToEpoch(), header.TimeSlotIndex)
+	im.subscribersMu.RLock()
+	defer im.subscribersMu.RUnlock()
+	for _, fn := range im.subscribers {
+		fn(imported)
+	}
+	return imported, nil
+}
+
+// applyAndStore runs the state transition of a block which passed the checks
+// not needing its parent's state, verifies the seal and stores the block.
+// If its author sealed another header for the same timeslot, the evidence is
+// stored and the author's blocks are excluded from the best head selection.
+// Returns the posterior state root, the state delta, whether the block became
+// the best head and the evidence of an equivocation.
+func (im *Importer) applyAndStore(hash crypto.Hash, parent block.Header, b block.Block) (ImportedBlock, error) {
+	chain := im.blockService.Store
+	header := b.Header
+	im.states.mu.Lock()
+	defer im.states.mu.Unlock()
+	parentState, err := im.states.entry(header.ParentHash)
+	if err != nil {
+		return ImportedBlock{}, fmt.Errorf("get parent state: %w", err)
+	}
+	if header.PriorStateRoot != parentState.root {
+		return ImportedBlock{}, fmt.Errorf("%w: expected %x, got %x", ErrPriorStateRootMismatch, parentState.root, header.PriorStateRoot)
+	}
+
+	// The seal is made with the sealing keys and entropy of the posterior
+	// state. Within an epoch they are the same as the parent's. The first
+	// block of an epoch rotates them, which only depends on the parent state
+	// and the timeslot, so the seal is checked before the full transition.
+	sealState := parentState.state
+	if header.TimeSlotIndex.ToEpoch() > parent.TimeSlotIndex.ToEpoch() {
+		entropyPool, validatorState, _, err := statetransition.UpdateSafroleState(statetransition.SafroleInput{
+			TimeSlot:  header.TimeSlotIndex,
+			Offenders: header.OffendersMarkers,
+		}, sealState.TimeslotIndex, sealState.EntropyPool, sealState.ValidatorState)
+		if err != nil {
+			return ImportedBlock{}, fmt.Errorf("%w: %w", ErrInvalidStateTransition, err)
+		}
+		sealState.EntropyPool = entropyPool
+		sealState.ValidatorState = validatorState
+	}
+	if err := verifySeal(header, sealState); err != nil {
+		return ImportedBlock{}, err
+	}
+	posterior, delta, err := im.states.apply(parentState, b)
+	if err != nil {
+		return ImportedBlock{}, fmt.Errorf("%w: %w", ErrInvalidStateTransition, err)
+	}
+
+	equivocation, err := im.detectEquivocation(hash, header, posterior)
+	if err != nil {
+		return ImportedBlock{}, fmt.Errorf("detect equivocation: %w", err)
+	}
+
+	// The equivocation is evidence on its own, it is kept if the import fails
+	if equivocation != nil {
+		if err := chain.PutEquivocation(*equivocation); err != nil {
+			return ImportedBlock{}, fmt.Errorf("store equivocation: %w", err)
+		}
+	}
+	if err := chain.PutBlock(b); err != nil {
+		return ImportedBlock{}, fmt.Errorf("store block: %w", err)
+	}
+	bestHead, err := im.states.insert(hash, header, posterior)
+	if err != nil {
+		// A stored block is taken for imported, its parent would no longer be a leaf
+		if err := chain.DeleteBlock(hash); err != nil {
+			fmt.Printf("Failed to delete block %x after a failed import: %v\n", hash, err)
+		}
+		return ImportedBlock{}, fmt.Errorf("insert state: %w", err)
+	}
+	if equivocation != nil {
+		fmt.Printf("Equivocation: author %x sealed %x and another header in timeslot %d\n", equivocation.Offender, hash, header.TimeSlotIndex)
+		im.states.excludeAuthor(equivocation.Offender)
+		bestHead = im.states.head == hash
+	}
+	im.blockService.addStoredHeader(hash, header)
+	if err := im.states.discardFinalized(); err != nil {
+		// Log but don't fail, the states are discarded on the next import
+		fmt.Printf("Failed to discard finalized states: %v\n", err)
+	}
+	return ImportedBlock{StateRoot: posterior.root, Delta: delta, BestHead: bestHead, Equivocation: equivocation}, nil
+}
+
+func verifySeal(header block.Header, s state.State) error {
+	ok, err := state.VerifyBlockSeal(&header, &s)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSeal, err)
+	}
+	if !ok {
+		return ErrInvalidSeal
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/genesis"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/internal/state"
+	"github.com/eigerco/strawberry/internal/statetransition"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/pkg/db/pebble"
+)
+
+// newTestImporter creates an importer on a chain whose validators all share
+// one key, so that every block can be sealed with it
+func newTestImporter(t *testing.T, config StateManagerConfig) (*Importer, crypto.BandersnatchPrivateKey) {
+	privateKey := testutils.RandomBandersnatchPrivateKey(t)
+	publicKey, err := bandersnatch.Public(privateKey)
+	require.NoError(t, err)
+	validator := genesis.Validator{
+		Bandersnatch: publicKey[:],
+		Ed25519:      crypto.HexBytes(testutils.RandomED25519PublicKey(t)),
+		Bls:          make([]byte, crypto.BLSSize),
+		Metadata:     make([]byte, crypto.MetadataSize),
+	}
+	spec := genesis.Spec{Name: "test"}
+	for range common.NumberOfValidators {
+		spec.Validators = append(spec.Validators, validator)
+	}
+	genesisBlock, err := spec.Block()
+	require.NoError(t, err)
+	genesisState, err := spec.State()
+	require.NoError(t, err)
+
+	kvStore, err := pebble.NewKVStore()
+	require.NoError(t, err)
+	bs, err := NewBlockServiceWithStore(kvStore, genesisBlock)
+	require.NoError(t, err)
+	trieDB, err := trie.NewDB()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		trieDB.Close()
+		bs.Store.Close()
+	})
+
+	states, err := NewStateManager(bs, trieDB, genesisState, config)
+	require.NoError(t, err)
+	return NewImporter(bs, states), privateKey
+}
+
+// sealedBlock builds an empty block on top of the parent, sealed for the given slot
+func sealedBlock(t *testing.T, im *Importer, parentHash crypto.Hash, slot jamtime.Timeslot, privateKey crypto.BandersnatchPrivateKey) block.Block {
+	parentState, parentRoot, err := im.states.State(parentHash)
+	require.NoError(t, err)
+	extrinsicHash, err := block.Extrinsic{}.Hash()
+	require.NoError(t, err)
+	header := block.Header{
+		ParentHash:     parentHash,
+		PriorStateRoot: parentRoot,
+		ExtrinsicHash:  extrinsicHash,
+		TimeSlotIndex:  slot,
+	}
+	require.NoError(t, state.SealBlock(&header, &parentState, privateKey))
+	return block.Block{Header: header}
+}
+
+func TestImporter(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	bs := im.blockService
+
+	var events []ImportedBlock
+	im.Subscribe(func(imported ImportedBlock) {
+		events = append(events, imported)
+	})
+
+	b := sealedBlock(t, im, bs.Genesis, 1, privateKey)
+	imported, err := im.Import(b)
+	require.NoError(t, err)
+	hash, err := b.Header.Hash()
+	require.NoError(t, err)
+	assert.Equal(t, hash, imported.Hash)
+	assert.Equal(t, []ImportedBlock{imported}, events)
+
+	// The block and its posterior state are stored
+	stored, err := bs.Store.GetBlock(hash)
+	require.NoError(t, err)
+	assert.Equal(t, b, stored)
+	posterior, root, err := im.states.State(hash)
+	require.NoError(t, err)
+	assert.Equal(t, imported.StateRoot, root)
+	assert.Equal(t, jamtime.Timeslot(1), posterior.TimeslotIndex)
+	assert.Equal(t, map[crypto.Hash]jamtime.Timeslot{hash: 1}, bs.KnownLeaves)
+
+	// and blocks can be built on top of it
+	child := sealedBlock(t, im, hash, 2, privateKey)
+	_, err = im.Import(child)
+	require.NoError(t, err)
+
+	_, err = im.Import(b)
+	assert.ErrorIs(t, err, ErrBlockKnown)
+}
+
+func TestImporter_EpochBoundary(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	genesisHash := im.blockService.Genesis
+	parentState, parentRoot, err := im.states.State(genesisHash)
+	require.NoError(t, err)
+
+	// The first block of an epoch is sealed with the rotated keys and entropy
+	slot := jamtime.Timeslot(jamtime.TimeslotsPerEpoch)
+	entropyPool, validatorState, _, err := statetransition.UpdateSafroleState(statetransition.SafroleInput{TimeSlot: slot},
+		parentState.TimeslotIndex, parentState.EntropyPool, parentState.ValidatorState)
+	require.NoError(t, err)
+	sealState := parentState
+	sealState.EntropyPool = entropyPool
+	sealState.ValidatorState = validatorState
+	newBlock := func(extrinsic block.Extrinsic) block.Block {
+		extrinsicHash, err := extrinsic.Hash()
+		require.NoError(t, err)
+		header := block.Header{
+			ParentHash:     genesisHash,
+			PriorStateRoot: parentRoot,
+			ExtrinsicHash:  extrinsicHash,
+			TimeSlotIndex:  slot,
+		}
+		require.NoError(t, state.SealBlock(&header, &sealState, privateKey))
+		return block.Block{Header: header, Extrinsic: extrinsic}
+	}
+
+	// The seal is checked before the state transition, which would fail too
+	invalid := newBlock(block.Extrinsic{ED: block.DisputeExtrinsic{Verdicts: []block.Verdict{{ReportHash: testutils.RandomHash(t)}}}})
+	invalid.Header.BlockSealSignature[0] ^= 0xff
+	_, err = im.Import(invalid)
+	assert.ErrorIs(t, err, ErrInvalidSeal)
+
+	_, err = im.Import(newBlock(block.Extrinsic{}))
+	require.NoError(t, err)
+}
+
+func TestImporter_InvalidBlocks(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	genesisHash := im.blockService.Genesis
+	valid := sealedBlock(t, im, genesisHash, 1, privateKey)
+
+	tests := map[string]struct {
+		block    func() block.Block
+		expected error
+	}{
+		"unknown parent": {
+			block: func() block.Block {
+				b := valid
+				b.Header.ParentHash = testutils.RandomHash(t)
+				return b
+			},
+			expected: ErrUnknownParent,
+		},
+		"timeslot not increasing": {
+			block: func() block.Block {
+				return sealedBlock(t, im, genesisHash, 0, privateKey)
+			},
+			expected: ErrTimeslotNotIncreasing,
+		},
+		"future timeslot": {
+			block: func() block.Block {
+				return sealedBlock(t, im, genesisHash, jamtime.CurrentTimeslot()+10, privateKey)
+			},
+			expected: ErrFutureBlock,
+		},
+		"extrinsic hash mismatch": {
+			block: func() block.Block {
+				b := valid
+				b.Extrinsic.EP = block.PreimageExtrinsic{{ServiceIndex: 1, Data: []byte{1}}}
+				return b
+			},
+			expected: ErrExtrinsicHashMismatch,
+		},
+		"prior state root mismatch": {
+			block: func() block.Block {
+				b := valid
+				b.Header.PriorStateRoot = testutils.RandomHash(t)
+				return b
+			},
+			expected: ErrPriorStateRootMismatch,
+		},
+		"invalid seal": {
+			block: func() block.Block {
+				b := valid
+				b.Header.BlockSealSignature[0] ^= 0xff
+				return b
+			},
+			expected: ErrInvalidSeal,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := tc.block()
+			_, err := im.Import(b)
+			assert.ErrorIs(t, err, tc.expected)
+
+			// Nothing is stored for rejected blocks
+			hash, err := b.Header.Hash()
+			require.NoError(t, err)
+			_, err = im.blockService.Store.GetBlock(hash)
+			assert.ErrorIs(t, err, store.ErrBlockNotFound)
+		})
+	}
+}
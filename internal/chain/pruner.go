@@ -0,0 +1,133 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/store"
+)
+
+// PrunerConfig controls which data the Pruner removes from the chain store.
+type PrunerConfig struct {
+	// RetainEpochs is the number of epochs before the latest finalized block
+	// for which full blocks are kept. Older blocks keep only their header.
+	// Zero keeps all block bodies.
+	RetainEpochs uint32
+}
+
+// PruneResult describes the outcome of a single pruning run.
+type PruneResult struct {
+	Finalized     crypto.Hash   // The finalized block the run was triggered by
+	PrunedForks   []crypto.Hash // Hashes of the deleted fork headers
+	PrunedBodies  int           // Number of deleted block bodies
+	ReclaimedKeys int           // Total number of deleted keys
+}
+
+// Pruner removes data that is no longer needed once blocks are finalized:
+// - Forks competing with the finalized chain are deleted entirely
+// - Block bodies older than the retention window are deleted, headers are kept forever
+//
+// Pruning runs in the background, triggered by Notify. Requests that arrive
+// while a run is in progress are coalesced, since pruning up to the newest
+// finalized block also covers all older ones.
+type Pruner struct {
+	store    *store.Chain
+	config   PrunerConfig
+	onPruned func(PruneResult)
+	requests chan LatestFinalized
+
+	mu             sync.Mutex
+	lastFinalized  crypto.Hash      // The finalized block of the last successful run
+	bodiesPrunedTo jamtime.Timeslot // Block bodies before this timeslot are already deleted
+	reclaimed      atomic.Uint64
+}
+
+// NewPruner creates a new Pruner for the given chain store. The optional
+// onPruned callback is invoked after every successful background run.
+func NewPruner(chain *store.Chain, config PrunerConfig, onPruned func(PruneResult)) *Pruner {
+	return &Pruner{
+		store:    chain,
+		config:   config,
+		onPruned: onPruned,
+		requests: make(chan LatestFinalized, 1),
+	}
+}
+
+// Start runs the pruning loop in the background until the context is cancelled.
+func (p *Pruner) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case finalized := <-p.requests:
+				result, err := p.Prune(finalized)
+				if err != nil {
+					fmt.Printf("Failed to prune chain store: %v\n", err)
+					continue
+				}
+				if p.onPruned != nil {
+					p.onPruned(result)
+				}
+			}
+		}
+	}()
+}
+
+// Notify schedules a pruning run for the given finalized block. It never
+// blocks, a pending request for an older finalized block is replaced.
+func (p *Pruner) Notify(finalized LatestFinalized) {
+	for {
+		select {
+		case p.requests <- finalized:
+			return
+		default:
+			// Drop the stale pending request and retry
+			select {
+			case <-p.requests:
+			default:
+			}
+		}
+	}
+}
+
+// Prune synchronously deletes the forks competing with the given finalized
+// block and the block bodies that fall outside the retention window.
+func (p *Pruner) Prune(finalized LatestFinalized) (PruneResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := PruneResult{Finalized: finalized.Hash}
+
+	forks, keys, err := p.store.PruneForks(finalized.Hash, p.lastFinalized)
+	if err != nil {
+		return PruneResult{}, fmt.Errorf("prune forks: %w", err)
+	}
+	p.lastFinalized = finalized.Hash
+	result.PrunedForks = forks
+	result.ReclaimedKeys += keys
+
+	retainSlots := jamtime.Timeslot(p.config.RetainEpochs) * jamtime.TimeslotsPerEpoch
+	if p.config.RetainEpochs > 0 && finalized.TimeSlotIndex > retainSlots {
+		cutoff := finalized.TimeSlotIndex - retainSlots
+		bodies, err := p.store.DeleteBlockBodies(p.bodiesPrunedTo, cutoff)
+		if err != nil {
+			return PruneResult{}, fmt.Errorf("prune block bodies: %w", err)
+		}
+		p.bodiesPrunedTo = cutoff
+		result.PrunedBodies = bodies
+		result.ReclaimedKeys += bodies
+	}
+
+	p.reclaimed.Add(uint64(result.ReclaimedKeys))
+	return result, nil
+}
+
+// Reclaimed returns the total number of keys deleted by this pruner.
+func (p *Pruner) Reclaimed() uint64 {
+	return p.reclaimed.Load()
+}
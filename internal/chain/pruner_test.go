@@ -0,0 +1,90 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/pkg/db/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruner(t *testing.T) {
+	chain := store.NewChain(memory.NewKVStore())
+	defer chain.Close()
+
+	// One block per epoch: 0 -> 1 -> 2 -> 3
+	var hashes []crypto.Hash
+	parentHash := crypto.Hash{}
+	for i := uint32(0); i < 4; i++ {
+		b := block.Block{Header: block.Header{
+			ParentHash:    parentHash,
+			TimeSlotIndex: jamtime.Timeslot(i * jamtime.TimeslotsPerEpoch),
+		}}
+		require.NoError(t, chain.PutBlock(b))
+		hash, err := b.Header.Hash()
+		require.NoError(t, err)
+		hashes = append(hashes, hash)
+		parentHash = hash
+	}
+
+	// Fork on top of block 1
+	fork := block.Block{Header: block.Header{
+		ParentHash:    hashes[1],
+		TimeSlotIndex: jamtime.Timeslot(2*jamtime.TimeslotsPerEpoch + 1),
+	}}
+	require.NoError(t, chain.PutBlock(fork))
+	forkHash, err := fork.Header.Hash()
+	require.NoError(t, err)
+
+	pruner := NewPruner(chain, PrunerConfig{RetainEpochs: 1}, nil)
+	result, err := pruner.Prune(LatestFinalized{Hash: hashes[3], TimeSlotIndex: 3 * jamtime.TimeslotsPerEpoch})
+	require.NoError(t, err)
+
+	assert.Equal(t, hashes[3], result.Finalized)
+	assert.Equal(t, []crypto.Hash{forkHash}, result.PrunedForks)
+	assert.Equal(t, 2, result.PrunedBodies)
+	assert.Equal(t, uint64(result.ReclaimedKeys), pruner.Reclaimed())
+
+	_, err = chain.GetHeader(forkHash)
+	require.ErrorIs(t, err, store.ErrHeaderNotFound)
+
+	// Bodies outside the retention window are gone, headers are kept
+	for i, hash := range hashes {
+		_, err := chain.GetHeader(hash)
+		require.NoError(t, err)
+		_, err = chain.GetBlock(hash)
+		if i < 2 {
+			require.ErrorIs(t, err, store.ErrBlockNotFound)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	// Pruning the same finalized block again reclaims nothing
+	result, err = pruner.Prune(LatestFinalized{Hash: hashes[3], TimeSlotIndex: 3 * jamtime.TimeslotsPerEpoch})
+	require.NoError(t, err)
+	assert.Empty(t, result.PrunedForks)
+	assert.Zero(t, result.ReclaimedKeys)
+}
+
+func TestPrunerKeepsBodiesByDefault(t *testing.T) {
+	chain := store.NewChain(memory.NewKVStore())
+	defer chain.Close()
+
+	b := block.Block{Header: block.Header{TimeSlotIndex: 1}}
+	require.NoError(t, chain.PutBlock(b))
+	hash, err := b.Header.Hash()
+	require.NoError(t, err)
+
+	pruner := NewPruner(chain, PrunerConfig{}, nil)
+	result, err := pruner.Prune(LatestFinalized{Hash: hash, TimeSlotIndex: 100 * jamtime.TimeslotsPerEpoch})
+	require.NoError(t, err)
+	assert.Zero(t, result.PrunedBodies)
+
+	_, err = chain.GetBlock(hash)
+	require.NoError(t, err)
+}
@@ -1,6 +1,7 @@
 package chain
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -8,12 +9,21 @@ import (
 
 	"github.com/eigerco/strawberry/internal/block"
 	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/genesis"
 	"github.com/eigerco/strawberry/internal/jamtime"
 	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/pkg/db"
 	"github.com/eigerco/strawberry/pkg/db/pebble"
 	"github.com/eigerco/strawberry/pkg/network"
 )
 
+var (
+	// ErrGenesisMismatch is returned when the store holds a chain with another genesis block
+	ErrGenesisMismatch = errors.New("store belongs to a chain with another genesis block")
+	// ErrNotFinalizable is returned when finalizing a block which does not descend from the latest finalized block
+	ErrNotFinalizable = errors.New("block does not descend from the latest finalized block")
+)
+
 // BlockService manages the node's view of the blockchain state, including:
 // - Known leaf blocks (blocks with no known children)
 // - Latest finalized block
@@ -26,11 +36,16 @@ type BlockService struct {
 	KnownLeaves     map[crypto.Hash]jamtime.Timeslot // Maps leaf block hashes to their timeslots
 	LatestFinalized LatestFinalized                  // Tracks the most recently finalized block
 	Store           *store.Chain                     // Persistent block storage
+	Genesis         crypto.Hash                      // Hash of the genesis header, identifies the chain
+
+	pruner       *Pruner // Removes stale forks and old block bodies, nil if pruning is disabled
+	voteFinality bool    // Blocks are only finalized with a justification, see EnableVoteFinality
 }
 
 // LatestFinalized represents the latest finalized block in the chain.
-// A block is considered finalized when it has a chain of 5 descendant blocks
-// built on top of it according to the finalization rules.
+// A block is considered finalized when a supermajority of the validators voted
+// for it, or without a finality gadget when it has a chain of 5 descendant
+// blocks built on top of it.
 type LatestFinalized struct {
 	Hash          crypto.Hash      // Hash of the finalized block
 	TimeSlotIndex jamtime.Timeslot // Timeslot of the finalized block
@@ -46,62 +61,144 @@ type Leaf struct {
 
 // NewBlockService initializes a new BlockService with:
 // - Empty leaf block set
-// - Persistent block storage using PebbleDB
-// - Genesis block as the latest finalized block
+// - In-memory block storage using PebbleDB
+// - The genesis block of an empty chain spec as the latest finalized block
 func NewBlockService() (*BlockService, error) {
 	kvStore, err := pebble.NewKVStore()
 	if err != nil {
 		return nil, err
 	}
+	genesisBlock, err := genesis.Spec{}.Block()
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockServiceWithStore(kvStore, genesisBlock)
+}
+
+// NewBlockServiceWithStore initializes a new BlockService on top of the given
+// key-value store. This allows the block service to use a persistent database
+// and to share it with other components such as the state trie. If the store
+// already holds a finalized block, the service resumes from it instead of
+// starting from the given genesis block. A store holding another chain is
+// rejected with ErrGenesisMismatch.
+func NewBlockServiceWithStore(kvStore db.KVStore, genesisBlock block.Block) (*BlockService, error) {
 	chain := store.NewChain(kvStore)
+	if err := chain.Migrate(); err != nil {
+		return nil, err
+	}
+	genesisHash, err := genesisBlock.Header.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash genesis block: %w", err)
+	}
+	storedGenesis, err := chain.GetCanonicalHashAt(0)
+	if err == nil && storedGenesis != genesisHash {
+		return nil, fmt.Errorf("%w: expected %x, store has %x", ErrGenesisMismatch, genesisHash, storedGenesis)
+	}
+	if err != nil && !errors.Is(err, store.ErrHeaderNotFound) {
+		return nil, fmt.Errorf("failed to get stored genesis block: %w", err)
+	}
 	bs := &BlockService{
 		Store:       chain,
+		Genesis:     genesisHash,
 		KnownLeaves: make(map[crypto.Hash]jamtime.Timeslot),
 	}
 	// Initialize by finding leaves and finalized block
-	if err := bs.initializeState(); err != nil {
+	if err := bs.initializeState(genesisBlock); err != nil {
 		// Log error but continue - we can recover state as we process blocks
 		fmt.Printf("Failed to initialize block manager state: %v\n", err)
 	}
 	return bs, nil
 }
 
-// initializeState sets up the initial blockchain state:
-// 1. Creates and stores the genesis block
+// initializeState sets up the initial blockchain state. If a finalized block
+// was persisted by a previous run, it is restored together with the known
+// leaves. Otherwise:
+// 1. Stores the genesis block
 // 2. Sets genesis as the latest finalized block
-//
-// TODO: This is still a `mock` implementation.
-func (bs *BlockService) initializeState() error {
-	// For now use genesis block
-	genesisHeader := block.Header{
-		ParentHash:       crypto.Hash{1},
-		TimeSlotIndex:    jamtime.Timeslot(1),
-		BlockAuthorIndex: 0,
-	}
-	hash, err := genesisHeader.Hash()
-	fmt.Printf("hash: %v\n", hash)
+func (bs *BlockService) initializeState(genesisBlock block.Block) error {
+	restored, err := bs.restoreState()
 	if err != nil {
-		return fmt.Errorf("failed to hash genesis block: %w", err)
-	}
-	if err := bs.Store.PutHeader(genesisHeader); err != nil {
-		return fmt.Errorf("failed to store genesis block: %w", err)
+		return fmt.Errorf("failed to restore state: %w", err)
 	}
-	b := block.Block{
-		Header: genesisHeader,
+	if restored {
+		return nil
 	}
-	if err := bs.Store.PutBlock(b); err != nil {
+
+	if err := bs.Store.PutBlock(genesisBlock); err != nil {
 		return fmt.Errorf("failed to store genesis block: %w", err)
 	}
+	if err := bs.Store.PutLatestFinalized(bs.Genesis); err != nil {
+		return fmt.Errorf("failed to store latest finalized block: %w", err)
+	}
 	bs.Mu.Lock()
 	defer bs.Mu.Unlock()
 	bs.LatestFinalized = LatestFinalized{
-		Hash:          hash,
-		TimeSlotIndex: genesisHeader.TimeSlotIndex,
+		Hash:          bs.Genesis,
+		TimeSlotIndex: genesisBlock.Header.TimeSlotIndex,
 	}
 	return nil
 }
 
-// checkFinalization determines if a block can be finalized by:
+// restoreState loads the latest finalized block from the store and rebuilds
+// the set of known leaves from the headers built on top of it.
+// Returns false if the store holds no finalized block.
+func (bs *BlockService) restoreState() (bool, error) {
+	finalizedHash, err := bs.Store.GetLatestFinalized()
+	if err != nil {
+		if errors.Is(err, store.ErrNoFinalized) {
+			return false, nil
+		}
+		return false, err
+	}
+	finalizedHeader, err := bs.Store.GetHeader(finalizedHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to get finalized header: %w", err)
+	}
+
+	// Collect all headers after the finalized block along with their parents.
+	// A header that is nobody's parent is a leaf.
+	descendants := make(map[crypto.Hash]jamtime.Timeslot)
+	parents := make(map[crypto.Hash]struct{})
+	var hashErr error
+	if _, err := bs.Store.FindHeader(func(header block.Header) bool {
+		if header.TimeSlotIndex <= finalizedHeader.TimeSlotIndex {
+			return false
+		}
+		hash, err := header.Hash()
+		if err != nil {
+			hashErr = err
+			return true
+		}
+		descendants[hash] = header.TimeSlotIndex
+		parents[header.ParentHash] = struct{}{}
+		return false
+	}); err != nil {
+		return false, fmt.Errorf("failed to scan headers: %w", err)
+	}
+	if hashErr != nil {
+		return false, fmt.Errorf("failed to hash header: %w", hashErr)
+	}
+
+	bs.Mu.Lock()
+	defer bs.Mu.Unlock()
+	bs.LatestFinalized = LatestFinalized{
+		Hash:          finalizedHash,
+		TimeSlotIndex: finalizedHeader.TimeSlotIndex,
+	}
+	for hash, slot := range descendants {
+		if _, ok := parents[hash]; !ok {
+			bs.KnownLeaves[hash] = slot
+		}
+	}
+	if len(bs.KnownLeaves) == 0 {
+		bs.KnownLeaves[finalizedHash] = finalizedHeader.TimeSlotIndex
+	}
+	return true, nil
+}
+
+// checkFinalization is the fallback finality rule of nodes which run without a
+// finality gadget, it gives no safety guarantee under forks. It determines if
+// a block can be finalized by:
 // 1. Walking back 5 generations from the given block hash
 // 2. If a complete chain of 5 blocks exists, finalizing the oldest block
 // 3. Updating the latest finalized pointer
@@ -143,53 +240,116 @@ func (bs *BlockService) checkFinalization(hash crypto.Hash) error {
 	return nil
 }
 
-// HandleNewHeader processes a new block header announcement by:
-// 1. Storing the header in persistent storage
-// 2. Updating the leaf block set (removing parent, adding new block)
-// 3. Checking if the parent block can now be finalized
-//
-// This implements the core block processing logic required by UP 0 protocol,
-// maintaining the node's view of chain tips and finalization status.
-func (bs *BlockService) HandleNewHeader(header *block.Header) error {
-	// Get the header hash
-	hash, err := header.Hash()
-	if err != nil {
-		return fmt.Errorf("failed to hash header: %w", err)
-	}
-	// Need to verify this block is a descendant of latest finalized block
-	// before considering it as a potential leaf
-	isDescendant, err := bs.isDescendantOfFinalized(header)
-	if err != nil {
-		return fmt.Errorf("failed to check if block is descendant of finalized: %w", err)
-	}
-	if !isDescendant {
-		return fmt.Errorf("block %s is not a descendant of latest finalized block", hash)
-	}
-
-	// First store the header
-	if err := bs.Store.PutHeader(*header); err != nil {
-		return fmt.Errorf("failed to store header: %w", err)
-	}
-
-	// Only update leaves if this is a descendant of finalized block
+// addStoredHeader updates the leaf block set and the finalization status for a
+// header which was stored and verified to descend from the finalized block
+func (bs *BlockService) addStoredHeader(hash crypto.Hash, header block.Header) {
 	bs.RemoveLeaf(header.ParentHash)
 	bs.AddLeaf(hash, header.TimeSlotIndex)
 
+	bs.Mu.RLock()
+	voteFinality := bs.voteFinality
+	bs.Mu.RUnlock()
+	if voteFinality {
+		return
+	}
 	// Check if this creates a finalization condition starting from parent
 	if err := bs.checkFinalization(header.ParentHash); err != nil {
 		// Log but don't fail on finalization check errors
 		fmt.Printf("Failed to check finalization: %v\n", err)
 	}
+}
+
+// EnableVoteFinality disables the five-descendant finality rule, blocks are
+// then only finalized through Finalize with a justification from the finality
+// gadget.
+func (bs *BlockService) EnableVoteFinality() {
+	bs.Mu.Lock()
+	defer bs.Mu.Unlock()
+	bs.voteFinality = true
+}
 
+// Finalize marks a stored block as the latest finalized block and stores its
+// encoded justification alongside its header. The block must descend from the
+// latest finalized block, otherwise ErrNotFinalizable is returned.
+func (bs *BlockService) Finalize(hash crypto.Hash, justification []byte) error {
+	header, err := bs.Store.GetHeader(hash)
+	if err != nil {
+		return fmt.Errorf("failed to get header: %w", err)
+	}
+	bs.Mu.RLock()
+	finalizedSlot := bs.LatestFinalized.TimeSlotIndex
+	bs.Mu.RUnlock()
+	if header.TimeSlotIndex <= finalizedSlot {
+		return ErrNotFinalizable
+	}
+	isDescendant, err := bs.isDescendantOfFinalized(&header)
+	if err != nil {
+		return fmt.Errorf("failed to check if block is descendant of finalized: %w", err)
+	}
+	if !isDescendant {
+		return ErrNotFinalizable
+	}
+	if err := bs.Store.PutJustification(hash, justification); err != nil {
+		return fmt.Errorf("failed to store justification: %w", err)
+	}
+	bs.UpdateLatestFinalized(hash, header.TimeSlotIndex)
 	return nil
 }
 
-// UpdateLatestFinalized updates the latest finalized block pointer.
+// UpdateLatestFinalized updates the latest finalized block pointer and
+// persists it so that it survives a restart. The chain ending at the block is
+// made canonical if it is not already.
 func (bs *BlockService) UpdateLatestFinalized(hash crypto.Hash, slot jamtime.Timeslot) {
 	bs.Mu.Lock()
 	defer bs.Mu.Unlock()
 	bs.LatestFinalized = LatestFinalized{Hash: hash, TimeSlotIndex: slot}
+	if err := bs.Store.PutLatestFinalized(hash); err != nil {
+		// Log but don't fail, the in-memory pointer is still valid
+		fmt.Printf("Failed to persist latest finalized block: %v\n", err)
+	}
+	if err := bs.makeCanonical(hash); err != nil {
+		fmt.Printf("Failed to update canonical chain: %v\n", err)
+	}
 	network.LogBlockEvent(time.Now(), "finalizing", hash, slot.ToEpoch(), slot)
+	if bs.pruner != nil {
+		bs.pruner.Notify(bs.LatestFinalized)
+	}
+}
+
+// makeCanonical marks the chain ending at a block as canonical unless the
+// block is already on it, keeping the canonical blocks after it
+func (bs *BlockService) makeCanonical(hash crypto.Hash) error {
+	number, err := bs.Store.GetBlockNumber(hash)
+	if err != nil {
+		return fmt.Errorf("get block number: %w", err)
+	}
+	canonical, err := bs.Store.GetCanonicalHashAt(number)
+	if err == nil && canonical == hash {
+		return nil
+	}
+	if err != nil && !errors.Is(err, store.ErrHeaderNotFound) {
+		return fmt.Errorf("get canonical hash: %w", err)
+	}
+	return bs.Store.SetCanonicalHead(hash)
+}
+
+// EnablePruning starts a background pruner that runs every time the latest
+// finalized block changes. Pruned forks are removed from the known leaves.
+func (bs *BlockService) EnablePruning(ctx context.Context, config PrunerConfig) {
+	pruner := NewPruner(bs.Store, config, func(result PruneResult) {
+		bs.Mu.Lock()
+		for _, hash := range result.PrunedForks {
+			delete(bs.KnownLeaves, hash)
+		}
+		bs.Mu.Unlock()
+		fmt.Printf("Pruned %d fork blocks and %d block bodies, reclaimed %d keys\n",
+			len(result.PrunedForks), result.PrunedBodies, result.ReclaimedKeys)
+	})
+	pruner.Start(ctx)
+
+	bs.Mu.Lock()
+	defer bs.Mu.Unlock()
+	bs.pruner = pruner
 }
 
 // AddLeaf adds a block to the set of known leaves.
@@ -5,7 +5,9 @@ import (
 
 	"github.com/eigerco/strawberry/internal/block"
 	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/genesis"
 	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/pkg/db/pebble"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -75,3 +77,116 @@ func TestCheckFinalization(t *testing.T) {
 	require.NoError(t, err) // Should return nil error as per our implementation
 	assert.Equal(t, prevFinalized, bs.LatestFinalized, "finalization should not change with invalid hash")
 }
+
+// putHeader stores a header and adds it to the leaves, as done for imported blocks
+func putHeader(t *testing.T, bs *BlockService, header *block.Header) {
+	require.NoError(t, bs.Store.PutHeader(*header))
+	hash, err := header.Hash()
+	require.NoError(t, err)
+	bs.addStoredHeader(hash, *header)
+}
+
+func TestBlockServiceRestart(t *testing.T) {
+	dir := t.TempDir()
+	kvStore, err := pebble.NewPersistentKVStore(dir, pebble.DefaultConfig())
+	require.NoError(t, err)
+
+	genesisBlock, err := genesis.Spec{}.Block()
+	require.NoError(t, err)
+	bs, err := NewBlockServiceWithStore(kvStore, genesisBlock)
+	require.NoError(t, err)
+	genesis := bs.LatestFinalized
+
+	// Build a chain of 7 headers on top of genesis so that finalization advances
+	parentHash := genesis.Hash
+	var leaf crypto.Hash
+	for i := uint32(1); i <= 7; i++ {
+		header := &block.Header{
+			ParentHash:    parentHash,
+			TimeSlotIndex: genesis.TimeSlotIndex + jamtime.Timeslot(i),
+		}
+		putHeader(t, bs, header)
+		leaf, err = header.Hash()
+		require.NoError(t, err)
+		parentHash = leaf
+	}
+	finalized := bs.LatestFinalized
+	require.NotEqual(t, genesis, finalized)
+	require.NoError(t, bs.Store.Close())
+
+	// Reopen the same database and verify the service resumes where it stopped
+	kvStore, err = pebble.NewPersistentKVStore(dir, pebble.DefaultConfig())
+	require.NoError(t, err)
+	restarted, err := NewBlockServiceWithStore(kvStore, genesisBlock)
+	require.NoError(t, err)
+	defer restarted.Store.Close()
+
+	assert.Equal(t, finalized, restarted.LatestFinalized)
+	assert.Equal(t, map[crypto.Hash]jamtime.Timeslot{leaf: genesis.TimeSlotIndex + 7}, restarted.KnownLeaves)
+}
+
+func TestBlockServiceGenesis(t *testing.T) {
+	kvStore, err := pebble.NewKVStore()
+	require.NoError(t, err)
+
+	spec := genesis.Spec{Timeslot: 12}
+	genesisBlock, err := spec.Block()
+	require.NoError(t, err)
+	genesisHash, err := spec.Hash()
+	require.NoError(t, err)
+
+	bs, err := NewBlockServiceWithStore(kvStore, genesisBlock)
+	require.NoError(t, err)
+	assert.Equal(t, genesisHash, bs.Genesis)
+	assert.Equal(t, LatestFinalized{Hash: genesisHash, TimeSlotIndex: 12}, bs.LatestFinalized)
+
+	stored, err := bs.Store.GetBlock(genesisHash)
+	require.NoError(t, err)
+	assert.Equal(t, genesisBlock, stored)
+	canonical, err := bs.Store.GetCanonicalHashAt(0)
+	require.NoError(t, err)
+	assert.Equal(t, genesisHash, canonical)
+
+	// The same store can not be used for another chain
+	otherBlock, err := genesis.Spec{Timeslot: 13}.Block()
+	require.NoError(t, err)
+	_, err = NewBlockServiceWithStore(kvStore, otherBlock)
+	assert.ErrorIs(t, err, ErrGenesisMismatch)
+}
+
+func TestBlockServiceVoteFinality(t *testing.T) {
+	bs, err := NewBlockService()
+	require.NoError(t, err)
+	bs.EnableVoteFinality()
+	genesis := bs.LatestFinalized
+
+	// Descendants no longer finalize a block
+	parentHash := genesis.Hash
+	var hashes []crypto.Hash
+	for i := uint32(1); i <= 7; i++ {
+		header := &block.Header{
+			ParentHash:    parentHash,
+			TimeSlotIndex: genesis.TimeSlotIndex + jamtime.Timeslot(i),
+		}
+		putHeader(t, bs, header)
+		parentHash, err = header.Hash()
+		require.NoError(t, err)
+		hashes = append(hashes, parentHash)
+	}
+	assert.Equal(t, genesis, bs.LatestFinalized)
+
+	// A fork of the first block
+	fork := &block.Header{ParentHash: genesis.Hash, TimeSlotIndex: genesis.TimeSlotIndex + 2}
+	putHeader(t, bs, fork)
+	forkHash, err := fork.Hash()
+	require.NoError(t, err)
+
+	require.NoError(t, bs.Finalize(hashes[2], []byte{1, 2, 3}))
+	assert.Equal(t, LatestFinalized{Hash: hashes[2], TimeSlotIndex: genesis.TimeSlotIndex + 3}, bs.LatestFinalized)
+	justification, err := bs.Store.GetJustification(hashes[2])
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, justification)
+
+	assert.ErrorIs(t, bs.Finalize(hashes[1], nil), ErrNotFinalizable)
+	assert.ErrorIs(t, bs.Finalize(forkHash, nil), ErrNotFinalizable)
+}
@@ -0,0 +1,545 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/internal/service"
+	"github.com/eigerco/strawberry/internal/state"
+	merkle "github.com/eigerco/strawberry/internal/state/merkle"
+	"github.com/eigerco/strawberry/internal/statetransition"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/pkg/network"
+)
+
+// DefaultMaxCachedStates is the number of posterior states kept in memory if
+// the configuration does not set it
+const DefaultMaxCachedStates = 32
+
+// ErrStateUnavailable is returned when the posterior state of a block can
+// neither be loaded nor re-executed from a stored ancestor
+var ErrStateUnavailable = errors.New("state not available")
+
+// StateManagerConfig controls how many states the StateManager holds in memory.
+type StateManagerConfig struct {
+	// MaxCachedStates is the number of posterior states kept in memory. Zero
+	// uses DefaultMaxCachedStates.
+	MaxCachedStates int
+}
+
+// stateEntry is the posterior state of a block in all the forms needed to
+// build on top of it
+type stateEntry struct {
+	state      state.State
+	root       crypto.Hash
+	serialized map[crypto.Hash][]byte
+	slot       jamtime.Timeslot
+}
+
+// StateManager provides the posterior state of every block which descends
+// from the latest finalized block, and tracks the best head among them
+// according to the fork choice rule.
+//
+// Recent states are held in memory. Only some states are persisted:
+// - The state of the latest finalized block
+// - The state of the first block of every epoch after it
+//
+// Any other state is re-executed from the nearest ancestor whose state is
+// held in memory or stored. States before the latest finalized block are
+// discarded once finalization moves past them.
+type StateManager struct {
+	mu           sync.Mutex
+	blockService *BlockService
+	trie         *trie.DB
+	config       StateManagerConfig
+
+	cache      map[crypto.Hash]*stateEntry // Recently used posterior states
+	forkChoice *forkChoice
+	head       crypto.Hash     // The best imported block
+	finalized  LatestFinalized // The finalized block the states were last discarded for
+
+	notifyMu     sync.Mutex // Serializes the best head notifications
+	notifiedHead crypto.Hash
+	subscribers  []func(crypto.Hash)
+}
+
+// NewStateManager creates a StateManager on top of the block service. The
+// genesis state is the posterior state of the genesis block, it is stored if
+// nothing was finalized yet and the store does not hold it. Posterior state
+// tries are committed to the trie db.
+func NewStateManager(bs *BlockService, trieDB *trie.DB, genesisState state.State, config StateManagerConfig) (*StateManager, error) {
+	if config.MaxCachedStates <= 0 {
+		config.MaxCachedStates = DefaultMaxCachedStates
+	}
+	bs.Mu.RLock()
+	finalized := bs.LatestFinalized
+	bs.Mu.RUnlock()
+
+	sm := &StateManager{
+		blockService: bs,
+		trie:         trieDB,
+		config:       config,
+		cache:        make(map[crypto.Hash]*stateEntry),
+		head:         finalized.Hash,
+		notifiedHead: finalized.Hash,
+		finalized:    finalized,
+	}
+	if finalized.Hash == bs.Genesis {
+		ok, err := bs.Store.HasState(bs.Genesis)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			serialized, err := merkle.SerializeState(genesisState)
+			if err != nil {
+				return nil, fmt.Errorf("serialize genesis state: %w", err)
+			}
+			root, err := merkle.MerklizeState(genesisState, trieDB)
+			if err != nil {
+				return nil, fmt.Errorf("merklize genesis state: %w", err)
+			}
+			if err := putState(bs.Store, bs.Genesis, &stateEntry{state: genesisState, root: root, serialized: serialized}); err != nil {
+				return nil, fmt.Errorf("store genesis state: %w", err)
+			}
+		}
+	}
+
+	forkChoice, err := newForkChoice(bs.Store, finalized)
+	if err != nil {
+		return nil, fmt.Errorf("create fork choice: %w", err)
+	}
+	sm.forkChoice = forkChoice
+	equivocations, err := bs.Store.GetEquivocations()
+	if err != nil {
+		return nil, fmt.Errorf("get equivocations: %w", err)
+	}
+	for _, equivocation := range equivocations {
+		sm.forkChoice.addEquivocator(equivocation.Offender)
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	entry, err := sm.entry(finalized.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("get finalized state: %w", err)
+	}
+	sm.forkChoice.addBadReports(entry.state.PastJudgements.BadWorkReports)
+	sm.selectHead()
+	sm.notifiedHead = sm.head
+	return sm, nil
+}
+
+// State returns the posterior state of an imported block and its root. The
+// returned state is a copy which the caller may modify.
+func (sm *StateManager) State(hash crypto.Hash) (state.State, crypto.Hash, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	entry, err := sm.entry(hash)
+	if err != nil {
+		return state.State{}, crypto.Hash{}, err
+	}
+	return entry.state.Clone(), entry.root, nil
+}
+
+// BestHead returns the hash of the best head: among the imported blocks whose
+// chain after the latest finalized block has no block with a work report
+// judged bad and no block by an excluded author, the one with the most
+// ticket-sealed blocks after the latest finalized block
+func (sm *StateManager) BestHead() crypto.Hash {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.head
+}
+
+// SubscribeBestHead registers a function which is called with the new best
+// head whenever it changes
+func (sm *StateManager) SubscribeBestHead(fn func(crypto.Hash)) {
+	sm.notifyMu.Lock()
+	defer sm.notifyMu.Unlock()
+	sm.subscribers = append(sm.subscribers, fn)
+}
+
+// ExcludeAuthor excludes the blocks authored by the validator with the given
+// Ed25519 key, and the chains containing them, from the best head selection
+func (sm *StateManager) ExcludeAuthor(key ed25519.PublicKey) {
+	sm.mu.Lock()
+	sm.excludeAuthor(key)
+	sm.mu.Unlock()
+	sm.notifyHead()
+}
+
+// excludeAuthor is ExcludeAuthor with the lock held, the subscribers are not notified
+func (sm *StateManager) excludeAuthor(key ed25519.PublicKey) {
+	if sm.forkChoice.addEquivocator(key) {
+		sm.selectHead()
+	}
+}
+
+// BlockService returns the block service the states belong to
+func (sm *StateManager) BlockService() *BlockService {
+	return sm.blockService
+}
+
+// Finalize finalizes an imported block with the justification of the finality
+// gadget and discards the states it makes obsolete
+func (sm *StateManager) Finalize(hash crypto.Hash, justification []byte) error {
+	defer sm.notifyHead()
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if err := sm.blockService.Finalize(hash, justification); err != nil {
+		return err
+	}
+	return sm.discardFinalized()
+}
+
+// entry returns the posterior state of a block. If it is neither cached nor
+// stored, the blocks after the nearest ancestor with a known state are
+// re-executed, caching their states.
+func (sm *StateManager) entry(hash crypto.Hash) (*stateEntry, error) {
+	var pending []block.Block
+	current := hash
+	var base *stateEntry
+	for base == nil {
+		if entry, ok := sm.cache[current]; ok {
+			base = entry
+			break
+		}
+		entry, err := sm.load(current)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			sm.cacheEntry(current, entry)
+			base = entry
+			break
+		}
+		b, err := sm.blockService.Store.GetBlock(current)
+		if err != nil {
+			if errors.Is(err, store.ErrBlockNotFound) {
+				return nil, fmt.Errorf("%w: block %x not found", ErrStateUnavailable, current)
+			}
+			return nil, fmt.Errorf("get block: %w", err)
+		}
+		pending = append(pending, b)
+		current = b.Header.ParentHash
+	}
+
+	// Re-execute from the oldest block
+	for i := len(pending) - 1; i >= 0; i-- {
+		next, _, err := sm.apply(base, pending[i])
+		if err != nil {
+			return nil, fmt.Errorf("re-execute block: %w", err)
+		}
+		hash, err := pending[i].Header.Hash()
+		if err != nil {
+			return nil, fmt.Errorf("hash header: %w", err)
+		}
+		sm.cacheEntry(hash, next)
+		base = next
+	}
+	return base, nil
+}
+
+// load reads a stored state, returning nil if it is not stored
+func (sm *StateManager) load(hash crypto.Hash) (*stateEntry, error) {
+	root, serialized, services, err := sm.blockService.Store.GetState(hash)
+	if err != nil {
+		if errors.Is(err, store.ErrStateNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get state: %w", err)
+	}
+	// The service keys of the serialization are lossy, only the basic
+	// components are rebuilt from it
+	components := make(map[crypto.Hash][]byte)
+	for key, value := range serialized {
+		info, err := merkle.ClassifyStateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("classify state key: %w", err)
+		}
+		if info.Kind == merkle.StateKeyComponent {
+			components[key] = value
+		}
+	}
+	s, err := merkle.DeserializeState(components)
+	if err != nil {
+		return nil, fmt.Errorf("deserialize state: %w", err)
+	}
+	s.Services, err = service.DecodeServiceState(services)
+	if err != nil {
+		return nil, fmt.Errorf("decode services: %w", err)
+	}
+	return &stateEntry{state: s, root: root, serialized: serialized, slot: s.TimeslotIndex}, nil
+}
+
+// putState stores the state of an entry as the posterior state of a block
+func putState(chain *store.Chain, hash crypto.Hash, entry *stateEntry) error {
+	services, err := entry.state.Services.Encode()
+	if err != nil {
+		return fmt.Errorf("encode services: %w", err)
+	}
+	return chain.PutState(hash, entry.root, entry.serialized, services)
+}
+
+// apply runs the state transition of a block on top of the posterior state
+// of its parent. Nothing is cached or stored.
+func (sm *StateManager) apply(parent *stateEntry, b block.Block) (*stateEntry, merkle.StateDelta, error) {
+	posterior, delta, err := statetransition.UpdateState(parent.state, b, sm.blockService.Store)
+	if err != nil {
+		return nil, merkle.StateDelta{}, err
+	}
+	root, err := merkle.MerklizeStateDelta(parent.root, delta, sm.trie)
+	if err != nil {
+		return nil, merkle.StateDelta{}, fmt.Errorf("merklize state: %w", err)
+	}
+	return &stateEntry{
+		state:      posterior,
+		root:       root,
+		serialized: delta.Apply(parent.serialized),
+		slot:       b.Header.TimeSlotIndex,
+	}, delta, nil
+}
+
+// insert adds the posterior state of a newly imported block. The state is
+// persisted if the block is the first of an epoch. Returns whether the block
+// became the best head. On failure the block is forgotten, the caller deletes
+// it from the store.
+func (sm *StateManager) insert(hash crypto.Hash, header block.Header, entry *stateEntry) (_ bool, err error) {
+	parent, err := sm.blockService.Store.GetHeader(header.ParentHash)
+	if err != nil {
+		return false, fmt.Errorf("get parent header: %w", err)
+	}
+	if header.TimeSlotIndex.ToEpoch() > parent.TimeSlotIndex.ToEpoch() {
+		if err := putState(sm.blockService.Store, hash, entry); err != nil {
+			return false, fmt.Errorf("store state: %w", err)
+		}
+	}
+	sm.cacheEntry(hash, entry)
+	defer func() {
+		if err != nil {
+			delete(sm.cache, hash)
+			delete(sm.forkChoice.nodes, hash)
+		}
+	}()
+
+	// Newly judged work reports may exclude any fork, including the best head's
+	if sm.forkChoice.addBadReports(entry.state.PastJudgements.BadWorkReports) {
+		sm.selectHead()
+		return sm.head == hash, nil
+	}
+	n, err := sm.forkChoice.node(hash)
+	if err != nil {
+		return false, err
+	}
+	viable, err := sm.forkChoice.viable(hash, sm.author)
+	if err != nil {
+		return false, err
+	}
+	head, err := sm.forkChoice.node(sm.head)
+	if err != nil || viable != hash || !n.heavier(head) {
+		return false, err
+	}
+	sm.setHead(hash)
+	return true, nil
+}
+
+// cacheEntry holds a state in memory, evicting the oldest states beyond the
+// configured limit. The best head is never evicted.
+func (sm *StateManager) cacheEntry(hash crypto.Hash, entry *stateEntry) {
+	sm.cache[hash] = entry
+	for len(sm.cache) > sm.config.MaxCachedStates {
+		var oldest crypto.Hash
+		found := false
+		for h, e := range sm.cache {
+			if h == sm.head || h == hash {
+				continue
+			}
+			if !found || e.slot < sm.cache[oldest].slot {
+				oldest, found = h, true
+			}
+		}
+		if !found {
+			return
+		}
+		delete(sm.cache, oldest)
+	}
+}
+
+// selectHead picks the best imported block as the best head, preferring the
+// current head on ties. Excluded leaves are replaced by their newest ancestor
+// which is not excluded. The finalized block is the head if there is none.
+func (sm *StateManager) selectHead() {
+	bs := sm.blockService
+	bs.Mu.RLock()
+	leaves := make([]crypto.Hash, 0, len(bs.KnownLeaves)+1)
+	for hash := range bs.KnownLeaves {
+		leaves = append(leaves, hash)
+	}
+	bs.Mu.RUnlock()
+
+	fc := sm.forkChoice
+	best, bestNode := sm.finalized.Hash, fc.nodes[sm.finalized.Hash]
+	for _, candidate := range append([]crypto.Hash{sm.head}, leaves...) {
+		if !sm.imported(candidate) {
+			continue
+		}
+		viable, err := fc.viable(candidate, sm.author)
+		if err != nil {
+			continue
+		}
+		n, err := fc.node(viable)
+		if err != nil || !n.heavier(bestNode) {
+			continue
+		}
+		best, bestNode = viable, n
+	}
+	sm.setHead(best)
+}
+
+// setHead changes the best head and makes its chain canonical, the
+// subscribers are notified by notifyHead once the lock is released
+func (sm *StateManager) setHead(hash crypto.Hash) {
+	if hash == sm.head {
+		return
+	}
+	if n, err := sm.forkChoice.node(hash); err == nil && n.parent != sm.head {
+		network.LogBlockEvent(time.Now(), "reorg", hash, n.slot.ToEpoch(), n.slot)
+	}
+	sm.head = hash
+	if err := sm.blockService.Store.SetCanonicalHead(hash); err != nil {
+		fmt.Printf("Failed to update canonical chain: %v\n", err)
+	}
+}
+
+// notifyHead calls the subscribers if the best head changed since they were
+// last called. Must not be called with the lock held.
+func (sm *StateManager) notifyHead() {
+	sm.notifyMu.Lock()
+	defer sm.notifyMu.Unlock()
+	sm.mu.Lock()
+	head := sm.head
+	sm.mu.Unlock()
+	if head == sm.notifiedHead {
+		return
+	}
+	sm.notifiedHead = head
+	for _, fn := range sm.subscribers {
+		fn(head)
+	}
+}
+
+// author returns the Ed25519 key of the author of an imported block, from the
+// current validators of its posterior state
+func (sm *StateManager) author(hash crypto.Hash) (ed25519.PublicKey, error) {
+	header, err := sm.blockService.Store.GetHeader(hash)
+	if err != nil {
+		return nil, fmt.Errorf("get header: %w", err)
+	}
+	entry, err := sm.entry(hash)
+	if err != nil {
+		return nil, err
+	}
+	validators := entry.state.ValidatorState.CurrentValidators
+	if int(header.BlockAuthorIndex) >= len(validators) || validators[header.BlockAuthorIndex] == nil {
+		return nil, fmt.Errorf("block author index %d out of range", header.BlockAuthorIndex)
+	}
+	return validators[header.BlockAuthorIndex].Ed25519, nil
+}
+
+// imported tells whether the block body is stored, headers received from
+// announcements are not imported
+func (sm *StateManager) imported(hash crypto.Hash) bool {
+	_, err := sm.blockService.Store.GetBlock(hash)
+	return err == nil
+}
+
+// discardFinalized persists the state of the latest finalized block and
+// discards the states before it, along with the states of blocks which do not
+// descend from it, both held in memory and stored. The best head is selected
+// again if it was on a discarded fork.
+func (sm *StateManager) discardFinalized() error {
+	bs := sm.blockService
+	bs.Mu.RLock()
+	finalized := bs.LatestFinalized
+	bs.Mu.RUnlock()
+	if finalized.Hash == sm.finalized.Hash {
+		return nil
+	}
+
+	entry, err := sm.entry(finalized.Hash)
+	if err != nil {
+		return fmt.Errorf("get finalized state: %w", err)
+	}
+	ok, err := bs.Store.HasState(finalized.Hash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := putState(bs.Store, finalized.Hash, entry); err != nil {
+			return fmt.Errorf("store finalized state: %w", err)
+		}
+	}
+
+	// Delete the stored states of the ancestors down to the previous finalized block
+	ancestors := map[crypto.Hash]struct{}{finalized.Hash: {}}
+	current := finalized.Hash
+	for current != sm.finalized.Hash {
+		header, err := bs.Store.GetHeader(current)
+		if err != nil {
+			return fmt.Errorf("get header: %w", err)
+		}
+		if header.TimeSlotIndex <= sm.finalized.TimeSlotIndex {
+			break
+		}
+		current = header.ParentHash
+		ancestors[current] = struct{}{}
+		if err := bs.Store.DeleteState(current); err != nil {
+			return err
+		}
+	}
+
+	// and those of the blocks after the previous finalized block on the forks
+	// which do not contain the finalized block
+	queue := []crypto.Hash{sm.finalized.Hash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		children, err := bs.Store.GetChildren(hash)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if child == finalized.Hash {
+				continue
+			}
+			if _, ok := ancestors[child]; !ok {
+				if err := bs.Store.DeleteState(child); err != nil {
+					return err
+				}
+			}
+			queue = append(queue, child)
+		}
+	}
+
+	sm.finalized = finalized
+	if err := sm.forkChoice.reset(finalized); err != nil {
+		return fmt.Errorf("reset fork choice: %w", err)
+	}
+	for hash, entry := range sm.cache {
+		if hash == finalized.Hash {
+			continue
+		}
+		if _, err := sm.forkChoice.node(hash); err != nil || entry.slot <= finalized.TimeSlotIndex {
+			delete(sm.cache, hash)
+		}
+	}
+	sm.selectHead()
+	return nil
+}
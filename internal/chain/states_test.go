@@ -0,0 +1,247 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/service"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/internal/testutils"
+)
+
+func TestStateManager_Forks(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	genesisHash := im.blockService.Genesis
+
+	a1, err := im.Import(sealedBlock(t, im, genesisHash, 1, privateKey))
+	require.NoError(t, err)
+	assert.True(t, a1.BestHead)
+	assert.Equal(t, a1.Hash, im.states.BestHead())
+
+	// A competing fork of the same weight does not replace the head
+	b1, err := im.Import(sealedBlock(t, im, genesisHash, 2, privateKey))
+	require.NoError(t, err)
+	assert.False(t, b1.BestHead)
+	assert.Equal(t, a1.Hash, im.states.BestHead())
+
+	// A heavier one does
+	b2, err := im.Import(sealedBlock(t, im, b1.Hash, 3, privateKey))
+	require.NoError(t, err)
+	assert.True(t, b2.BestHead)
+	assert.Equal(t, b2.Hash, im.states.BestHead())
+
+	// Both forks can be extended and keep their own states
+	a2, err := im.Import(sealedBlock(t, im, a1.Hash, 4, privateKey))
+	require.NoError(t, err)
+	assert.False(t, a2.BestHead)
+	assert.Equal(t, b2.Hash, im.states.BestHead())
+
+	for hash, slot := range map[crypto.Hash]jamtime.Timeslot{a1.Hash: 1, b1.Hash: 2, b2.Hash: 3, a2.Hash: 4} {
+		s, _, err := im.states.State(hash)
+		require.NoError(t, err)
+		assert.Equal(t, slot, s.TimeslotIndex)
+	}
+}
+
+func TestStateManager_CanonicalChain(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	bs := im.blockService
+	genesisHash := bs.Genesis
+
+	assertCanonical := func(expected ...crypto.Hash) {
+		t.Helper()
+		for i, hash := range append([]crypto.Hash{genesisHash}, expected...) {
+			canonical, err := bs.Store.GetCanonicalHashAt(uint32(i))
+			require.NoError(t, err)
+			assert.Equal(t, hash, canonical, "block number %d", i)
+		}
+		_, err := bs.Store.GetCanonicalHashAt(uint32(len(expected) + 1))
+		assert.ErrorIs(t, err, store.ErrHeaderNotFound)
+	}
+
+	a1, err := im.Import(sealedBlock(t, im, genesisHash, 1, privateKey))
+	require.NoError(t, err)
+	a2, err := im.Import(sealedBlock(t, im, a1.Hash, 2, privateKey))
+	require.NoError(t, err)
+	assertCanonical(a1.Hash, a2.Hash)
+
+	// A heavier fork replaces the canonical chain
+	b1, err := im.Import(sealedBlock(t, im, genesisHash, 3, privateKey))
+	require.NoError(t, err)
+	b2, err := im.Import(sealedBlock(t, im, b1.Hash, 4, privateKey))
+	require.NoError(t, err)
+	assertCanonical(a1.Hash, a2.Hash)
+	b3, err := im.Import(sealedBlock(t, im, b2.Hash, 5, privateKey))
+	require.NoError(t, err)
+	require.Equal(t, b3.Hash, im.states.BestHead())
+	assertCanonical(b1.Hash, b2.Hash, b3.Hash)
+
+	// And the first one takes it back once it is heavier again
+	a3, err := im.Import(sealedBlock(t, im, a2.Hash, 6, privateKey))
+	require.NoError(t, err)
+	a4, err := im.Import(sealedBlock(t, im, a3.Hash, 7, privateKey))
+	require.NoError(t, err)
+	require.Equal(t, a4.Hash, im.states.BestHead())
+	assertCanonical(a1.Hash, a2.Hash, a3.Hash, a4.Hash)
+
+	// Finalizing a block on the other fork makes its chain canonical
+	require.NoError(t, im.states.Finalize(b2.Hash, nil))
+	assert.Equal(t, b3.Hash, im.states.BestHead())
+	assertCanonical(b1.Hash, b2.Hash, b3.Hash)
+}
+
+func TestStateManager_ReExecution(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{MaxCachedStates: 1})
+	parent := im.blockService.Genesis
+	var last ImportedBlock
+	for slot := jamtime.Timeslot(1); slot <= 3; slot++ {
+		var err error
+		last, err = im.Import(sealedBlock(t, im, parent, slot, privateKey))
+		require.NoError(t, err)
+		parent = last.Hash
+	}
+
+	// Only the genesis state is stored, a new state manager has to re-execute
+	// all blocks to get the state of the last one
+	ok, err := im.blockService.Store.HasState(last.Hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	genesisState, _, err := im.states.State(im.blockService.Genesis)
+	require.NoError(t, err)
+	restarted, err := NewStateManager(im.blockService, im.states.trie, genesisState, StateManagerConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, last.Hash, restarted.BestHead())
+	s, root, err := restarted.State(last.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, last.StateRoot, root)
+	assert.Equal(t, jamtime.Timeslot(3), s.TimeslotIndex)
+}
+
+func TestStateManager_ReloadServices(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	bs := im.blockService
+	genesisState, _, err := im.states.State(bs.Genesis)
+	require.NoError(t, err)
+
+	// A service whose storage keys and solicited preimages the state
+	// serialization does not keep in full
+	genesisState.Services[7] = service.ServiceAccount{
+		Storage: map[crypto.Hash][]byte{
+			testutils.RandomHash(t): {1, 2, 3},
+			testutils.RandomHash(t): {4},
+		},
+		PreimageLookup: map[crypto.Hash][]byte{},
+		PreimageMeta: map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{
+			{Hash: testutils.RandomHash(t), Length: 10}: {},
+			{Hash: testutils.RandomHash(t), Length: 20}: {},
+		},
+		Balance: 1000,
+	}
+	require.NoError(t, bs.Store.DeleteState(bs.Genesis))
+	states, err := NewStateManager(bs, im.states.trie, genesisState, StateManagerConfig{MaxCachedStates: 1})
+	require.NoError(t, err)
+	im = NewImporter(bs, states)
+
+	parent := bs.Genesis
+	var last ImportedBlock
+	for slot := jamtime.Timeslot(1); slot <= 3; slot++ {
+		last, err = im.Import(sealedBlock(t, im, parent, slot, privateKey))
+		require.NoError(t, err)
+		parent = last.Hash
+	}
+
+	// The genesis state was evicted and is loaded from the store as it was
+	_, ok := states.cache[bs.Genesis]
+	require.False(t, ok)
+	reloaded, _, err := states.State(bs.Genesis)
+	require.NoError(t, err)
+	assert.Equal(t, genesisState.Services, reloaded.Services)
+
+	// and re-executing the blocks on it gives the same state
+	restarted, err := NewStateManager(bs, states.trie, genesisState, StateManagerConfig{})
+	require.NoError(t, err)
+	s, root, err := restarted.State(last.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, last.StateRoot, root)
+	assert.Equal(t, genesisState.Services, s.Services)
+}
+
+func TestStateManager_DiscardFinalized(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	bs := im.blockService
+	genesisHash := bs.Genesis
+
+	fork, err := im.Import(sealedBlock(t, im, genesisHash, 1, privateKey))
+	require.NoError(t, err)
+	// As if it was the first block of an epoch
+	require.NoError(t, bs.Store.PutState(fork.Hash, fork.StateRoot, nil, nil))
+
+	// The seventh block on the other fork finalizes its first block
+	parent := genesisHash
+	var hashes []crypto.Hash
+	for slot := jamtime.Timeslot(2); slot <= 8; slot++ {
+		imported, err := im.Import(sealedBlock(t, im, parent, slot, privateKey))
+		require.NoError(t, err)
+		hashes = append(hashes, imported.Hash)
+		parent = imported.Hash
+	}
+	require.Equal(t, hashes[0], bs.LatestFinalized.Hash)
+	assert.Equal(t, hashes[len(hashes)-1], im.states.BestHead())
+
+	// The finalized state is persisted and the older one deleted
+	ok, err := bs.Store.HasState(hashes[0])
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = bs.Store.HasState(genesisHash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = bs.Store.HasState(fork.Hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// States before the finalized block and on competing forks are gone
+	_, _, err = im.states.State(genesisHash)
+	assert.ErrorIs(t, err, ErrStateUnavailable)
+	_, _, err = im.states.State(fork.Hash)
+	assert.ErrorIs(t, err, ErrStateUnavailable)
+
+	_, _, err = im.states.State(hashes[len(hashes)-1])
+	assert.NoError(t, err)
+}
+
+func TestStateManager_BestHead(t *testing.T) {
+	im, privateKey := newTestImporter(t, StateManagerConfig{})
+	genesisHash := im.blockService.Genesis
+	var heads []crypto.Hash
+	im.states.SubscribeBestHead(func(head crypto.Hash) {
+		heads = append(heads, head)
+	})
+
+	a1, err := im.Import(sealedBlock(t, im, genesisHash, 1, privateKey))
+	require.NoError(t, err)
+	b1, err := im.Import(sealedBlock(t, im, genesisHash, 2, privateKey))
+	require.NoError(t, err)
+	assert.False(t, b1.BestHead)
+	assert.Equal(t, []crypto.Hash{a1.Hash}, heads)
+
+	// All test blocks share an author, excluding it leaves only the finalized block
+	genesisState, _, err := im.states.State(genesisHash)
+	require.NoError(t, err)
+	author := genesisState.ValidatorState.CurrentValidators[0].Ed25519
+	im.states.ExcludeAuthor(author)
+	assert.Equal(t, genesisHash, im.states.BestHead())
+	assert.Equal(t, []crypto.Hash{a1.Hash, genesisHash}, heads)
+
+	// Blocks of the excluded author do not become the best head
+	a2, err := im.Import(sealedBlock(t, im, a1.Hash, 3, privateKey))
+	require.NoError(t, err)
+	assert.False(t, a2.BestHead)
+	assert.Equal(t, genesisHash, im.states.BestHead())
+	im.states.ExcludeAuthor(author)
+	assert.Len(t, heads, 2)
+}
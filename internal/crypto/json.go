@@ -0,0 +1,159 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Keys, hashes and signatures are encoded in JSON as 0x prefixed hex strings,
+// as in the JAM test vectors.
+
+// HexBytes is a byte slice encoded in JSON as a 0x prefixed hex string
+type HexBytes []byte
+
+// MarshalText encodes the bytes as a 0x prefixed hex string
+func (h HexBytes) MarshalText() ([]byte, error) {
+	return marshalHex(h)
+}
+
+// UnmarshalText decodes a hex string with an optional 0x prefix
+func (h *HexBytes) UnmarshalText(text []byte) error {
+	decoded, err := hex.DecodeString(strings.TrimPrefix(string(text), "0x"))
+	if err != nil {
+		return err
+	}
+	*h = decoded
+	return nil
+}
+
+func marshalHex(b []byte) ([]byte, error) {
+	return []byte("0x" + hex.EncodeToString(b)), nil
+}
+
+// unmarshalHex decodes a hex string into a fixed size destination
+func unmarshalHex(text []byte, dst []byte) error {
+	var decoded HexBytes
+	if err := decoded.UnmarshalText(text); err != nil {
+		return err
+	}
+	if len(decoded) != len(dst) {
+		return fmt.Errorf("expected %d bytes, got %d", len(dst), len(decoded))
+	}
+	copy(dst, decoded)
+	return nil
+}
+
+func (h Hash) MarshalText() ([]byte, error) {
+	return marshalHex(h[:])
+}
+
+func (h *Hash) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, h[:])
+}
+
+func (s Ed25519Signature) MarshalText() ([]byte, error) {
+	return marshalHex(s[:])
+}
+
+func (s *Ed25519Signature) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, s[:])
+}
+
+func (k BlsKey) MarshalText() ([]byte, error) {
+	return marshalHex(k[:])
+}
+
+func (k *BlsKey) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, k[:])
+}
+
+func (k BandersnatchPublicKey) MarshalText() ([]byte, error) {
+	return marshalHex(k[:])
+}
+
+func (k *BandersnatchPublicKey) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, k[:])
+}
+
+func (k *BandersnatchSeedKey) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, k[:])
+}
+
+func (s BandersnatchSignature) MarshalText() ([]byte, error) {
+	return marshalHex(s[:])
+}
+
+func (s *BandersnatchSignature) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, s[:])
+}
+
+func (h BandersnatchOutputHash) MarshalText() ([]byte, error) {
+	return marshalHex(h[:])
+}
+
+func (h *BandersnatchOutputHash) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, h[:])
+}
+
+func (s RingVrfSignature) MarshalText() ([]byte, error) {
+	return marshalHex(s[:])
+}
+
+func (s *RingVrfSignature) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, s[:])
+}
+
+func (k MetadataKey) MarshalText() ([]byte, error) {
+	return marshalHex(k[:])
+}
+
+func (k *MetadataKey) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, k[:])
+}
+
+func (c RingCommitment) MarshalText() ([]byte, error) {
+	return marshalHex(c[:])
+}
+
+func (c *RingCommitment) UnmarshalText(text []byte) error {
+	return unmarshalHex(text, c[:])
+}
+
+// validatorKeyJSON is the JSON representation of ValidatorKey
+type validatorKeyJSON struct {
+	Bandersnatch BandersnatchPublicKey `json:"bandersnatch"`
+	Ed25519      HexBytes              `json:"ed25519"`
+	Bls          BlsKey                `json:"bls"`
+	Metadata     MetadataKey           `json:"metadata"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (k ValidatorKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(validatorKeyJSON{
+		Bandersnatch: k.Bandersnatch,
+		Ed25519:      HexBytes(k.Ed25519),
+		Bls:          k.Bls,
+		Metadata:     k.Metadata,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (k *ValidatorKey) UnmarshalJSON(data []byte) error {
+	var v validatorKeyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Ed25519) != ed25519.PublicKeySize {
+		return fmt.Errorf("expected %d bytes ed25519 key, got %d", ed25519.PublicKeySize, len(v.Ed25519))
+	}
+	*k = ValidatorKey{
+		Bandersnatch: v.Bandersnatch,
+		Ed25519:      ed25519.PublicKey(v.Ed25519),
+		Bls:          v.Bls,
+		Metadata:     v.Metadata,
+	}
+	return nil
+}
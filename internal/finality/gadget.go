@@ -0,0 +1,588 @@
+package finality
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+const (
+	// DefaultRoundTimeout is the time after which a round which did not
+	// finalize a block is abandoned for the next one
+	DefaultRoundTimeout = 2 * jamtime.TimeslotDuration
+
+	// maxFutureRounds limits how far ahead of the current round votes are kept
+	maxFutureRounds = 4
+)
+
+var (
+	ErrDuplicateVote = errors.New("duplicate vote")
+	ErrVoteRound     = errors.New("vote for an old or distant round")
+)
+
+// Network broadcasts our votes to the other voters
+type Network interface {
+	BroadcastVote(ctx context.Context, vote SignedVote) error
+}
+
+// Config holds the parameters of the finality gadget
+type Config struct {
+	RoundTimeout time.Duration // DefaultRoundTimeout if zero
+}
+
+// roundVotes holds the votes of one round and the weights they give to the
+// blocks after the latest finalized block
+type roundVotes struct {
+	votes        [2]map[uint16]SignedVote // By kind and validator index, the first vote of equivocating voters
+	weights      [2]map[crypto.Hash]int   // Votes for a block or its descendants, by kind
+	pending      [2][]SignedVote          // Votes for blocks which are not imported yet, by kind
+	equivocators [2]map[uint16]SignedVote // By kind and validator index, the second vote of equivocating voters
+}
+
+func newRoundVotes() *roundVotes {
+	r := &roundVotes{}
+	for kind := range r.votes {
+		r.votes[kind] = make(map[uint16]SignedVote)
+		r.weights[kind] = make(map[crypto.Hash]int)
+		r.equivocators[kind] = make(map[uint16]SignedVote)
+	}
+	return r
+}
+
+// all returns the votes of the round, those of the equivocators in the order
+// they were received
+func (r *roundVotes) all() []SignedVote {
+	var votes []SignedVote
+	for kind := range r.votes {
+		for _, vote := range r.votes[kind] {
+			votes = append(votes, vote)
+		}
+	}
+	for kind := range r.equivocators {
+		for _, vote := range r.equivocators[kind] {
+			votes = append(votes, vote)
+		}
+	}
+	return votes
+}
+
+// voters counts the distinct voters of the round
+func (r *roundVotes) voters() int {
+	voters := make(map[uint16]struct{})
+	for _, votes := range r.votes {
+		for index := range votes {
+			voters[index] = struct{}{}
+		}
+	}
+	return len(voters)
+}
+
+// Gadget is a GRANDPA-style finality gadget. In every round the voters, the
+// current validators κ of the latest finalized block, prevote for their best
+// head. Once a supermajority of 2/3V + 1 prevotes is for a block or its
+// descendants, the highest such block is precommitted. A block with a
+// supermajority of precommits for it or its descendants is finalized, with the
+// precommits stored as its justification. Voters signing two different votes
+// of the same kind in a round are reported as equivocating, and count as
+// voting for every block.
+//
+// A node which is not a voter runs the gadget as an observer, finalizing
+// blocks from the votes it receives without voting itself.
+type Gadget struct {
+	ctx        context.Context
+	states     *chain.StateManager
+	network    Network
+	privateKey ed25519.PrivateKey
+	config     Config
+
+	mu             sync.Mutex
+	round          uint64
+	finalized      chain.LatestFinalized
+	voters         safrole.ValidatorsData
+	selfIndex      int // Our index among the voters, -1 if we are not a voter
+	rounds         map[uint64]*roundVotes
+	headers        map[crypto.Hash]block.Header // Headers after the finalized block
+	prevoted       bool
+	precommitted   bool
+	advanced       chan struct{} // Signalled when a round ends early
+	onEquivocation func(Equivocation)
+	onFinalized    func(Justification)
+}
+
+// NewGadget creates a finality gadget. The private key is the Ed25519 key of
+// our validator, or nil to only observe the votes. The block service must
+// have vote finality enabled.
+func NewGadget(ctx context.Context, states *chain.StateManager, network Network, privateKey ed25519.PrivateKey, config Config) (*Gadget, error) {
+	if config.RoundTimeout == 0 {
+		config.RoundTimeout = DefaultRoundTimeout
+	}
+	g := &Gadget{
+		ctx:        ctx,
+		states:     states,
+		network:    network,
+		privateKey: privateKey,
+		config:     config,
+		advanced:   make(chan struct{}, 1),
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.loadVoters(); err != nil {
+		return nil, err
+	}
+	g.rounds = map[uint64]*roundVotes{0: newRoundVotes()}
+	return g, nil
+}
+
+// OnEquivocation registers a function called for every equivocating voter
+func (g *Gadget) OnEquivocation(fn func(Equivocation)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onEquivocation = fn
+}
+
+// OnFinalized registers a function called for every block finalized by the gadget
+func (g *Gadget) OnFinalized(fn func(Justification)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onFinalized = fn
+}
+
+// Voters returns the voter set of the current round
+func (g *Gadget) Voters() safrole.ValidatorsData {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.voters
+}
+
+// Run starts a new round every time a round finalizes a block or times out,
+// until the context is done. A round the gadget already moved to, after a
+// finalization or to catch up with the other voters, is started as is.
+func (g *Gadget) Run(ctx context.Context) {
+	var started uint64
+	for {
+		g.mu.Lock()
+		round := g.round
+		if round <= started {
+			round = started + 1
+		}
+		g.mu.Unlock()
+		g.startRound(round)
+		started = round
+
+		timer := time.NewTimer(g.config.RoundTimeout)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-g.advanced:
+			timer.Stop()
+		}
+	}
+}
+
+// Evidence returns the stored evidence of the equivocating voters, ordered by
+// round, vote kind and voter index
+func (g *Gadget) Evidence() ([]Evidence, error) {
+	encoded, err := g.states.BlockService().Store.GetVoteEquivocations()
+	if err != nil {
+		return nil, err
+	}
+	evidence := make([]Evidence, len(encoded))
+	for i, e := range encoded {
+		if err := jam.Unmarshal(e, &evidence[i]); err != nil {
+			return nil, fmt.Errorf("decode vote equivocation: %w", err)
+		}
+	}
+	return evidence, nil
+}
+
+// AddVote verifies a vote received from the network and counts it. The
+// evidence of an equivocation is stored before it is reported.
+func (g *Gadget) AddVote(vote SignedVote) error {
+	g.mu.Lock()
+	equivocation, justification, err := g.addVote(vote)
+	onEquivocation, onFinalized := g.onEquivocation, g.onFinalized
+	g.mu.Unlock()
+
+	if equivocation != nil && onEquivocation != nil {
+		onEquivocation(*equivocation)
+	}
+	if justification != nil && onFinalized != nil {
+		onFinalized(*justification)
+	}
+	return err
+}
+
+// putEvidence stores an equivocation along with the key of the offender among
+// the current voters
+func (g *Gadget) putEvidence(equivocation Equivocation) error {
+	encoded, err := jam.Marshal(Evidence{Offender: equivocation.Offender(g.voters), Equivocation: equivocation})
+	if err != nil {
+		return fmt.Errorf("encode vote equivocation: %w", err)
+	}
+	first := equivocation.First
+	return g.states.BlockService().Store.PutVoteEquivocation(first.Vote.Round, uint8(first.Vote.Kind), first.ValidatorIndex, encoded)
+}
+
+// OnImported counts the votes waiting for the imported block, and prevotes if
+// we did not yet in the current round. It is meant to be subscribed to the
+// importer.
+func (g *Gadget) OnImported(chain.ImportedBlock) {
+	g.mu.Lock()
+	justification := g.prevote()
+	if justification == nil {
+		var err error
+		if justification, err = g.progress(); err != nil {
+			fmt.Printf("Failed to progress finality round: %v\n", err)
+		}
+	}
+	onFinalized := g.onFinalized
+	g.mu.Unlock()
+	if justification != nil && onFinalized != nil {
+		onFinalized(*justification)
+	}
+}
+
+// startRound moves to the given round and prevotes for the best head
+func (g *Gadget) startRound(round uint64) {
+	g.mu.Lock()
+	if round > g.round {
+		g.enterRound(round)
+	}
+	justification := g.prevote()
+	onFinalized := g.onFinalized
+	g.mu.Unlock()
+	if justification != nil && onFinalized != nil {
+		onFinalized(*justification)
+	}
+}
+
+// prevote votes for the best head if we are a voter which did not prevote in
+// the current round yet. Nothing is voted while the best head is finalized.
+func (g *Gadget) prevote() *Justification {
+	if g.prevoted || g.selfIndex < 0 {
+		return nil
+	}
+	head := g.states.BestHead()
+	header, err := g.header(head)
+	if err != nil {
+		fmt.Printf("Failed to get best head for prevote: %v\n", err)
+		return nil
+	}
+	if header.TimeSlotIndex <= g.finalized.TimeSlotIndex {
+		return nil
+	}
+	g.prevoted = true
+	return g.vote(Vote{Round: g.round, Kind: Prevote, Hash: head, Slot: header.TimeSlotIndex})
+}
+
+// enterRound drops the votes of the previous rounds
+func (g *Gadget) enterRound(round uint64) {
+	for r := range g.rounds {
+		if r < round {
+			delete(g.rounds, r)
+		}
+	}
+	if _, ok := g.rounds[round]; !ok {
+		g.rounds[round] = newRoundVotes()
+	}
+	g.round = round
+	g.prevoted = false
+	g.precommitted = false
+}
+
+// vote signs, counts and broadcasts our own vote. Returns the justification
+// if our vote finalized a block.
+func (g *Gadget) vote(vote Vote) *Justification {
+	signed, err := SignVote(vote, uint16(g.selfIndex), g.privateKey)
+	if err != nil {
+		fmt.Printf("Failed to sign %v: %v\n", vote.Kind, err)
+		return nil
+	}
+	_, justification, err := g.addVote(signed)
+	if err != nil {
+		fmt.Printf("Failed to count own %v: %v\n", vote.Kind, err)
+	}
+	go func() {
+		if err := g.network.BroadcastVote(g.ctx, signed); err != nil {
+			fmt.Printf("Failed to broadcast %v: %v\n", vote.Kind, err)
+		}
+	}()
+	return justification
+}
+
+// addVote counts a vote and progresses the round. Returns the equivocation
+// if the vote conflicts with an earlier one, whose evidence is stored, and the
+// justification if the vote finalized a block.
+func (g *Gadget) addVote(vote SignedVote) (*Equivocation, *Justification, error) {
+	equivocation, err := g.countVote(vote)
+	if err != nil {
+		return nil, nil, err
+	}
+	justification, err := g.progress()
+	return equivocation, justification, err
+}
+
+// countVote verifies a vote and counts it in its round. Returns the
+// equivocation if the vote conflicts with an earlier one.
+func (g *Gadget) countVote(vote SignedVote) (*Equivocation, error) {
+	if vote.Vote.Round < g.round || vote.Vote.Round > g.round+maxFutureRounds {
+		return nil, ErrVoteRound
+	}
+	if vote.Vote.Kind != Prevote && vote.Vote.Kind != Precommit {
+		return nil, fmt.Errorf("unknown vote kind %d", vote.Vote.Kind)
+	}
+	if err := vote.Verify(g.voters); err != nil {
+		return nil, err
+	}
+	round, ok := g.rounds[vote.Vote.Round]
+	if !ok {
+		round = newRoundVotes()
+		g.rounds[vote.Vote.Round] = round
+	}
+
+	kind := vote.Vote.Kind
+	var equivocation *Equivocation
+	if existing, ok := round.votes[kind][vote.ValidatorIndex]; ok {
+		if existing.Vote == vote.Vote {
+			return nil, ErrDuplicateVote
+		}
+		if _, ok := round.equivocators[kind][vote.ValidatorIndex]; ok {
+			return nil, ErrDuplicateVote
+		}
+		// Equivocators count as voting for every block, their first vote no longer counts
+		round.equivocators[kind][vote.ValidatorIndex] = vote
+		g.removeVote(round, kind, existing)
+		equivocation = &Equivocation{First: existing, Second: vote}
+		if err := g.putEvidence(*equivocation); err != nil {
+			fmt.Printf("Failed to store equivocation of voter %d: %v\n", vote.ValidatorIndex, err)
+		}
+	} else {
+		round.votes[kind][vote.ValidatorIndex] = vote
+		if err := g.addWeight(round.weights[kind], vote.Vote.Hash, 1); err != nil {
+			// The vote is counted once its block is imported
+			round.pending[kind] = append(round.pending[kind], vote)
+		}
+	}
+	return equivocation, nil
+}
+
+// addWeight adds a vote, or removes it with a negative delta, to the weights
+// of a block and its ancestors after the finalized block. Votes for blocks not
+// descending from it are ignored.
+func (g *Gadget) addWeight(weights map[crypto.Hash]int, hash crypto.Hash, delta int) error {
+	var ancestry []crypto.Hash
+	for hash != g.finalized.Hash {
+		header, err := g.header(hash)
+		if err != nil {
+			return err
+		}
+		if header.TimeSlotIndex <= g.finalized.TimeSlotIndex {
+			return nil
+		}
+		ancestry = append(ancestry, hash)
+		hash = header.ParentHash
+	}
+	for _, hash := range ancestry {
+		weights[hash] += delta
+	}
+	return nil
+}
+
+// removeVote takes a counted or pending vote out of the weights of a round
+func (g *Gadget) removeVote(round *roundVotes, kind VoteKind, vote SignedVote) {
+	for i, pending := range round.pending[kind] {
+		if pending == vote {
+			round.pending[kind] = append(round.pending[kind][:i], round.pending[kind][i+1:]...)
+			return
+		}
+	}
+	if err := g.addWeight(round.weights[kind], vote.Vote.Hash, -1); err != nil {
+		fmt.Printf("Failed to remove %v of equivocator %d: %v\n", kind, vote.ValidatorIndex, err)
+	}
+}
+
+// supermajority returns the highest block with a supermajority of votes of
+// the given kind for it or its descendants. Each voter counts once, the
+// equivocators for every block.
+func (g *Gadget) supermajority(round *roundVotes, kind VoteKind) (crypto.Hash, jamtime.Timeslot, bool) {
+	var (
+		best     crypto.Hash
+		bestSlot jamtime.Timeslot
+		found    bool
+	)
+	equivocators := len(round.equivocators[kind])
+	for hash, weight := range round.weights[kind] {
+		if weight+equivocators < common.ValidatorsSuperMajority {
+			continue
+		}
+		header, err := g.header(hash)
+		if err != nil {
+			continue
+		}
+		if !found || header.TimeSlotIndex > bestSlot {
+			best, bestSlot, found = hash, header.TimeSlotIndex, true
+		}
+	}
+	return best, bestSlot, found
+}
+
+// progress precommits once the current round has a prevote supermajority,
+// finalizes a block with a precommit supermajority, and catches up with a
+// later round once a third of the voters is voting in it
+func (g *Gadget) progress() (*Justification, error) {
+	round := g.rounds[g.round]
+	for kind, pending := range round.pending {
+		remaining := pending[:0]
+		for _, vote := range pending {
+			if err := g.addWeight(round.weights[kind], vote.Vote.Hash, 1); err != nil {
+				remaining = append(remaining, vote)
+			}
+		}
+		round.pending[kind] = remaining
+	}
+
+	if g.prevoted && !g.precommitted && g.selfIndex >= 0 {
+		if hash, slot, ok := g.supermajority(round, Prevote); ok {
+			g.precommitted = true
+			if justification := g.vote(Vote{Round: g.round, Kind: Precommit, Hash: hash, Slot: slot}); justification != nil {
+				return justification, nil
+			}
+		}
+	}
+
+	if hash, slot, ok := g.supermajority(round, Precommit); ok {
+		return g.finalize(round, hash, slot)
+	}
+
+	for r, votes := range g.rounds {
+		if r > g.round && votes.voters() > common.NumberOfValidators-common.ValidatorsSuperMajority {
+			g.enterRound(r)
+			g.signalAdvanced()
+			return nil, nil
+		}
+	}
+	return nil, nil
+}
+
+// finalize stores the justification of a block, finalizes it and moves to
+// the next round with the voters of the new finalized block. The votes
+// already received for the later rounds are counted again with those voters.
+func (g *Gadget) finalize(round *roundVotes, hash crypto.Hash, slot jamtime.Timeslot) (*Justification, error) {
+	justification := Justification{Round: g.round, Hash: hash, Slot: slot}
+	for _, precommit := range round.votes[Precommit] {
+		ok, err := g.descends(hash, slot, precommit.Vote.Hash)
+		if err == nil && ok {
+			justification.Precommits = append(justification.Precommits, precommit)
+		}
+	}
+	sort.Slice(justification.Precommits, func(i, j int) bool {
+		return justification.Precommits[i].ValidatorIndex < justification.Precommits[j].ValidatorIndex
+	})
+	// Equivocators count towards the supermajority but a justification needs
+	// a distinct precommit of each voter
+	if len(justification.Precommits) < common.ValidatorsSuperMajority {
+		return nil, nil
+	}
+	encoded, err := jam.Marshal(justification)
+	if err != nil {
+		return nil, fmt.Errorf("encode justification: %w", err)
+	}
+	if err := g.states.Finalize(hash, encoded); err != nil {
+		return nil, fmt.Errorf("finalize block: %w", err)
+	}
+
+	var later []SignedVote
+	for r, votes := range g.rounds {
+		if r > g.round {
+			later = append(later, votes.all()...)
+		}
+	}
+	if err := g.loadVoters(); err != nil {
+		return nil, err
+	}
+	g.headers = make(map[crypto.Hash]block.Header)
+	g.rounds = make(map[uint64]*roundVotes)
+	g.enterRound(g.round + 1)
+	for _, vote := range later {
+		// Votes of voters which left are rejected, the equivocations were
+		// reported already
+		_, _ = g.countVote(vote)
+	}
+	g.signalAdvanced()
+	return &justification, nil
+}
+
+// descends tells whether a block is the given ancestor or descends from it
+func (g *Gadget) descends(ancestor crypto.Hash, ancestorSlot jamtime.Timeslot, hash crypto.Hash) (bool, error) {
+	for hash != ancestor {
+		header, err := g.header(hash)
+		if err != nil {
+			return false, err
+		}
+		if header.TimeSlotIndex <= ancestorSlot {
+			return false, nil
+		}
+		hash = header.ParentHash
+	}
+	return true, nil
+}
+
+// loadVoters takes the voters from the current validators of the latest
+// finalized block
+func (g *Gadget) loadVoters() error {
+	bs := g.states.BlockService()
+	bs.Mu.RLock()
+	g.finalized = bs.LatestFinalized
+	bs.Mu.RUnlock()
+	s, _, err := g.states.State(g.finalized.Hash)
+	if err != nil {
+		return fmt.Errorf("get finalized state: %w", err)
+	}
+	g.voters = s.ValidatorState.CurrentValidators
+	g.selfIndex = -1
+	if g.privateKey != nil {
+		publicKey := g.privateKey.Public().(ed25519.PublicKey)
+		for i, validator := range g.voters {
+			if validator != nil && publicKey.Equal(validator.Ed25519) {
+				g.selfIndex = i
+				break
+			}
+		}
+	}
+	g.headers = make(map[crypto.Hash]block.Header)
+	return nil
+}
+
+// header returns a header from the chain store, caching it for the round
+func (g *Gadget) header(hash crypto.Hash) (block.Header, error) {
+	if header, ok := g.headers[hash]; ok {
+		return header, nil
+	}
+	header, err := g.states.BlockService().Store.GetHeader(hash)
+	if err != nil {
+		return block.Header{}, err
+	}
+	g.headers[hash] = header
+	return header, nil
+}
+
+func (g *Gadget) signalAdvanced() {
+	select {
+	case g.advanced <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,261 @@
+package finality
+
+import (
+	"context"
+	"crypto/ed25519"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/authoring"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
+	"github.com/eigerco/strawberry/internal/genesis"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/pkg/db/pebble"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+type networkMock struct {
+	mu    sync.Mutex
+	votes []SignedVote
+}
+
+func (n *networkMock) BroadcastVote(_ context.Context, vote SignedVote) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.votes = append(n.votes, vote)
+	return nil
+}
+
+// voted returns our broadcast vote of the given kind, waiting for it
+func (n *networkMock) voted(t *testing.T, kind VoteKind) SignedVote {
+	var vote SignedVote
+	require.Eventually(t, func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for _, v := range n.votes {
+			if v.Vote.Kind == kind {
+				vote = v
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+	return vote
+}
+
+// newTestChain creates a chain with vote finality where all validators share
+// a bandersnatch key and each has its own Ed25519 key, and produces blocks
+// at the given slots
+func newTestChain(t *testing.T, slots ...jamtime.Timeslot) (*chain.StateManager, *chain.Importer, []ed25519.PrivateKey, []crypto.Hash) {
+	bandersnatchKey := testutils.RandomBandersnatchPrivateKey(t)
+	bandersnatchPublic, err := bandersnatch.Public(bandersnatchKey)
+	require.NoError(t, err)
+	spec := genesis.Spec{Name: "test"}
+	keys := make([]ed25519.PrivateKey, common.NumberOfValidators)
+	for i := range keys {
+		publicKey, privateKey, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		keys[i] = privateKey
+		spec.Validators = append(spec.Validators, genesis.Validator{
+			Bandersnatch: bandersnatchPublic[:],
+			Ed25519:      crypto.HexBytes(publicKey),
+			Bls:          make([]byte, crypto.BLSSize),
+			Metadata:     make([]byte, crypto.MetadataSize),
+		})
+	}
+	genesisBlock, err := spec.Block()
+	require.NoError(t, err)
+	genesisState, err := spec.State()
+	require.NoError(t, err)
+
+	kvStore, err := pebble.NewKVStore()
+	require.NoError(t, err)
+	bs, err := chain.NewBlockServiceWithStore(kvStore, genesisBlock)
+	require.NoError(t, err)
+	bs.EnableVoteFinality()
+	trieDB, err := trie.NewDB()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		trieDB.Close()
+		bs.Store.Close()
+	})
+	states, err := chain.NewStateManager(bs, trieDB, genesisState, chain.StateManagerConfig{})
+	require.NoError(t, err)
+	importer := chain.NewImporter(bs, states)
+
+	producer, err := authoring.NewProducer(importer, states, authoring.NewPool(nil), nil, bandersnatchKey)
+	require.NoError(t, err)
+	var hashes []crypto.Hash
+	for _, slot := range slots {
+		imported, err := producer.Produce(context.Background(), slot)
+		require.NoError(t, err)
+		hashes = append(hashes, imported.Hash)
+	}
+	return states, importer, keys, hashes
+}
+
+func signVote(t *testing.T, keys []ed25519.PrivateKey, index int, vote Vote) SignedVote {
+	signed, err := SignVote(vote, uint16(index), keys[index])
+	require.NoError(t, err)
+	return signed
+}
+
+func TestGadget(t *testing.T) {
+	states, _, keys, hashes := newTestChain(t, 1, 2, 3)
+	network := &networkMock{}
+	gadget, err := NewGadget(context.Background(), states, network, keys[0], Config{})
+	require.NoError(t, err)
+	var justifications []Justification
+	gadget.OnFinalized(func(justification Justification) {
+		justifications = append(justifications, justification)
+	})
+
+	// We prevote for the best head
+	gadget.startRound(1)
+	prevote := network.voted(t, Prevote)
+	assert.Equal(t, Vote{Round: 1, Kind: Prevote, Hash: hashes[2], Slot: 3}, prevote.Vote)
+
+	// A supermajority prevoted for the second block or its descendant
+	for i := 1; i < common.ValidatorsSuperMajority; i++ {
+		vote := Vote{Round: 1, Kind: Prevote, Hash: hashes[1], Slot: 2}
+		if i%2 == 0 {
+			vote = Vote{Round: 1, Kind: Prevote, Hash: hashes[2], Slot: 3}
+		}
+		require.NoError(t, gadget.AddVote(signVote(t, keys, i, vote)))
+	}
+	precommit := network.voted(t, Precommit)
+	assert.Equal(t, Vote{Round: 1, Kind: Precommit, Hash: hashes[1], Slot: 2}, precommit.Vote)
+
+	// Precommits for descendants count for the block
+	for i := 1; i < common.ValidatorsSuperMajority-1; i++ {
+		require.NoError(t, gadget.AddVote(signVote(t, keys, i, Vote{Round: 1, Kind: Precommit, Hash: hashes[2], Slot: 3})))
+	}
+	assert.Equal(t, chain.LatestFinalized{Hash: states.BlockService().Genesis}, states.BlockService().LatestFinalized)
+	last := common.ValidatorsSuperMajority - 1
+	require.NoError(t, gadget.AddVote(signVote(t, keys, last, Vote{Round: 1, Kind: Precommit, Hash: hashes[1], Slot: 2})))
+
+	bs := states.BlockService()
+	assert.Equal(t, chain.LatestFinalized{Hash: hashes[1], TimeSlotIndex: 2}, bs.LatestFinalized)
+	require.Len(t, justifications, 1)
+	encoded, err := bs.Store.GetJustification(hashes[1])
+	require.NoError(t, err)
+	var justification Justification
+	require.NoError(t, jam.Unmarshal(encoded, &justification))
+	assert.Equal(t, justifications[0], justification)
+	assert.Equal(t, hashes[1], justification.Hash)
+	assert.Len(t, justification.Precommits, common.ValidatorsSuperMajority)
+	require.NoError(t, justification.Verify(gadget.Voters(), bs.Store.Reader))
+
+	justification.Precommits = justification.Precommits[1:]
+	assert.ErrorIs(t, justification.Verify(gadget.Voters(), bs.Store.Reader), ErrBadJustification)
+
+	// The gadget moved to the next round
+	assert.ErrorIs(t, gadget.AddVote(signVote(t, keys, 1, Vote{Round: 1, Kind: Prevote, Hash: hashes[2], Slot: 3})), ErrVoteRound)
+}
+
+func TestGadget_LaterRoundVotes(t *testing.T) {
+	states, _, keys, hashes := newTestChain(t, 1, 2, 3)
+	network := &networkMock{}
+	gadget, err := NewGadget(context.Background(), states, network, keys[0], Config{})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go gadget.Run(ctx)
+	network.voted(t, Prevote)
+
+	// A vote for the next round arrives before the first round finalizes
+	later := signVote(t, keys, 1, Vote{Round: 2, Kind: Prevote, Hash: hashes[2], Slot: 3})
+	require.NoError(t, gadget.AddVote(later))
+	for i := 1; i < common.ValidatorsSuperMajority; i++ {
+		require.NoError(t, gadget.AddVote(signVote(t, keys, i, Vote{Round: 1, Kind: Prevote, Hash: hashes[1], Slot: 2})))
+	}
+	network.voted(t, Precommit)
+	for i := 1; i < common.ValidatorsSuperMajority; i++ {
+		require.NoError(t, gadget.AddVote(signVote(t, keys, i, Vote{Round: 1, Kind: Precommit, Hash: hashes[1], Slot: 2})))
+	}
+	assert.Equal(t, chain.LatestFinalized{Hash: hashes[1], TimeSlotIndex: 2}, states.BlockService().LatestFinalized)
+
+	// The vote is kept, and we prevote in that round next
+	assert.ErrorIs(t, gadget.AddVote(later), ErrDuplicateVote)
+	require.Eventually(t, func() bool {
+		network.mu.Lock()
+		defer network.mu.Unlock()
+		for _, vote := range network.votes {
+			if vote.Vote.Kind == Prevote && vote.Vote.Round == 2 {
+				return vote.Vote.Hash == hashes[2]
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+}
+
+func TestGadget_Equivocation(t *testing.T) {
+	states, _, keys, hashes := newTestChain(t, 1, 2)
+	gadget, err := NewGadget(context.Background(), states, &networkMock{}, nil, Config{})
+	require.NoError(t, err)
+	var equivocations []Equivocation
+	gadget.OnEquivocation(func(equivocation Equivocation) {
+		equivocations = append(equivocations, equivocation)
+	})
+	gadget.startRound(1)
+
+	first := signVote(t, keys, 1, Vote{Round: 1, Kind: Prevote, Hash: hashes[0], Slot: 1})
+	second := signVote(t, keys, 1, Vote{Round: 1, Kind: Prevote, Hash: hashes[1], Slot: 2})
+	require.NoError(t, gadget.AddVote(first))
+	assert.ErrorIs(t, gadget.AddVote(first), ErrDuplicateVote)
+	require.NoError(t, gadget.AddVote(second))
+	require.Len(t, equivocations, 1)
+	assert.Equal(t, Equivocation{First: first, Second: second}, equivocations[0])
+	assert.Equal(t, keys[1].Public(), equivocations[0].Offender(gadget.Voters()))
+
+	// The evidence is stored along with the offender and outlives the gadget
+	restarted, err := NewGadget(context.Background(), states, &networkMock{}, nil, Config{})
+	require.NoError(t, err)
+	evidence, err := restarted.Evidence()
+	require.NoError(t, err)
+	assert.Equal(t, []Evidence{{Offender: keys[1].Public().(ed25519.PublicKey), Equivocation: equivocations[0]}}, evidence)
+
+	// Further votes of the equivocator are ignored
+	third := signVote(t, keys, 1, Vote{Round: 1, Kind: Prevote, Hash: testutils.RandomHash(t), Slot: 2})
+	assert.ErrorIs(t, gadget.AddVote(third), ErrDuplicateVote)
+
+	// Votes signed by another voter are rejected
+	forged := signVote(t, keys, 2, Vote{Round: 1, Kind: Prevote, Hash: hashes[1], Slot: 2})
+	forged.ValidatorIndex = 3
+	assert.ErrorIs(t, gadget.AddVote(forged), ErrBadVoteSignature)
+}
+
+func TestGadget_EquivocatorCountedOnce(t *testing.T) {
+	states, _, keys, hashes := newTestChain(t, 1, 2)
+	gadget, err := NewGadget(context.Background(), states, &networkMock{}, nil, Config{})
+	require.NoError(t, err)
+	gadget.startRound(1)
+	supermajority := func() bool {
+		gadget.mu.Lock()
+		defer gadget.mu.Unlock()
+		_, _, ok := gadget.supermajority(gadget.rounds[1], Prevote)
+		return ok
+	}
+
+	// One voter short of a supermajority for the first block, one of them equivocating
+	for i := 1; i < common.ValidatorsSuperMajority-1; i++ {
+		require.NoError(t, gadget.AddVote(signVote(t, keys, i, Vote{Round: 1, Kind: Prevote, Hash: hashes[0], Slot: 1})))
+	}
+	equivocator := common.ValidatorsSuperMajority - 1
+	require.NoError(t, gadget.AddVote(signVote(t, keys, equivocator, Vote{Round: 1, Kind: Prevote, Hash: hashes[0], Slot: 1})))
+	require.NoError(t, gadget.AddVote(signVote(t, keys, equivocator, Vote{Round: 1, Kind: Prevote, Hash: testutils.RandomHash(t), Slot: 2})))
+	assert.False(t, supermajority())
+
+	// The next distinct voter tips the count
+	require.NoError(t, gadget.AddVote(signVote(t, keys, equivocator+1, Vote{Round: 1, Kind: Prevote, Hash: hashes[1], Slot: 2})))
+	assert.True(t, supermajority())
+}
@@ -0,0 +1,170 @@
+package finality
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/store"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+// Signing contexts of the finality votes
+const (
+	PrevoteContext   = "jam_finality_prevote"
+	PrecommitContext = "jam_finality_precommit"
+)
+
+var (
+	ErrBadVoteSignature      = errors.New("bad vote signature")
+	ErrBadVoteValidatorIndex = errors.New("bad vote validator index")
+	ErrBadJustification      = errors.New("bad justification")
+)
+
+// VoteKind is the stage of a finality round a vote belongs to
+type VoteKind uint8
+
+const (
+	Prevote VoteKind = iota
+	Precommit
+)
+
+func (k VoteKind) String() string {
+	if k == Prevote {
+		return "prevote"
+	}
+	return "precommit"
+}
+
+// Vote is a vote for a block and, implicitly, for all of its ancestors
+type Vote struct {
+	Round uint64
+	Kind  VoteKind
+	Hash  crypto.Hash      // Hash of the header voted for
+	Slot  jamtime.Timeslot // Timeslot of the header voted for
+}
+
+// message returns the signed message of the vote: the context of the vote
+// kind followed by the encoded vote
+func (v Vote) message() ([]byte, error) {
+	encoded, err := jam.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	context := PrevoteContext
+	if v.Kind == Precommit {
+		context = PrecommitContext
+	}
+	return append([]byte(context), encoded...), nil
+}
+
+// SignedVote is a vote signed by the Ed25519 key of one of the voters
+type SignedVote struct {
+	Vote           Vote
+	ValidatorIndex uint16
+	Signature      crypto.Ed25519Signature
+}
+
+// SignVote signs a vote as the voter with the given index
+func SignVote(vote Vote, validatorIndex uint16, privateKey ed25519.PrivateKey) (SignedVote, error) {
+	message, err := vote.message()
+	if err != nil {
+		return SignedVote{}, fmt.Errorf("encode vote: %w", err)
+	}
+	signed := SignedVote{Vote: vote, ValidatorIndex: validatorIndex}
+	copy(signed.Signature[:], ed25519.Sign(privateKey, message))
+	return signed, nil
+}
+
+// Verify checks the signature of the vote against the voter set
+func (v SignedVote) Verify(voters safrole.ValidatorsData) error {
+	if int(v.ValidatorIndex) >= len(voters) || voters[v.ValidatorIndex] == nil || len(voters[v.ValidatorIndex].Ed25519) != ed25519.PublicKeySize {
+		return ErrBadVoteValidatorIndex
+	}
+	message, err := v.Vote.message()
+	if err != nil {
+		return fmt.Errorf("encode vote: %w", err)
+	}
+	if !ed25519.Verify(voters[v.ValidatorIndex].Ed25519, message, v.Signature[:]) {
+		return ErrBadVoteSignature
+	}
+	return nil
+}
+
+// Equivocation is proof that a voter signed two different votes of the same
+// kind in the same round
+type Equivocation struct {
+	First  SignedVote
+	Second SignedVote
+}
+
+// Offender returns the Ed25519 key of the equivocating voter
+func (e Equivocation) Offender(voters safrole.ValidatorsData) ed25519.PublicKey {
+	if int(e.First.ValidatorIndex) >= len(voters) || voters[e.First.ValidatorIndex] == nil {
+		return nil
+	}
+	return voters[e.First.ValidatorIndex].Ed25519
+}
+
+// Evidence is an equivocation as stored in the chain store, along with the
+// key of the offender, as the voters change with the finalized block
+type Evidence struct {
+	Offender     ed25519.PublicKey
+	Equivocation Equivocation
+}
+
+// Justification proves the finality of a block: the precommits of a
+// supermajority of the voters for the block or its descendants in one round
+type Justification struct {
+	Round      uint64
+	Hash       crypto.Hash
+	Slot       jamtime.Timeslot
+	Precommits []SignedVote // Ordered by validator index
+}
+
+// Verify checks that the justification holds valid precommits of at least
+// 2/3V + 1 distinct voters, each for the justified block or a descendant of
+// it in the chain store
+func (j Justification) Verify(voters safrole.ValidatorsData, chain *store.Reader) error {
+	if len(j.Precommits) < common.ValidatorsSuperMajority {
+		return fmt.Errorf("%w: %d precommits", ErrBadJustification, len(j.Precommits))
+	}
+	for i, precommit := range j.Precommits {
+		if i > 0 && precommit.ValidatorIndex <= j.Precommits[i-1].ValidatorIndex {
+			return fmt.Errorf("%w: precommits not ordered by validator index", ErrBadJustification)
+		}
+		if precommit.Vote.Round != j.Round || precommit.Vote.Kind != Precommit {
+			return fmt.Errorf("%w: unexpected vote %v in round %d", ErrBadJustification, precommit.Vote.Kind, precommit.Vote.Round)
+		}
+		if err := precommit.Verify(voters); err != nil {
+			return fmt.Errorf("%w: %w", ErrBadJustification, err)
+		}
+		ok, err := isDescendant(chain, j.Hash, j.Slot, precommit.Vote.Hash)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: precommit for %x does not descend from %x", ErrBadJustification, precommit.Vote.Hash, j.Hash)
+		}
+	}
+	return nil
+}
+
+// isDescendant tells whether a block is the given ancestor or descends from it
+func isDescendant(chain *store.Reader, ancestor crypto.Hash, ancestorSlot jamtime.Timeslot, hash crypto.Hash) (bool, error) {
+	for hash != ancestor {
+		header, err := chain.GetHeader(hash)
+		if err != nil {
+			return false, fmt.Errorf("get header: %w", err)
+		}
+		if header.TimeSlotIndex <= ancestorSlot {
+			return false, nil
+		}
+		hash = header.ParentHash
+	}
+	return true, nil
+}
@@ -0,0 +1,318 @@
+// Package genesis loads chain specifications, which describe the genesis
+// block and state every node of a network starts from.
+//
+// A chain spec is a JSON document, binary values are hex encoded with an
+// optional 0x prefix:
+//
+//	{
+//	  "name": "dev",
+//	  "timeslot": 0,
+//	  "entropy_pool": ["0x...", "0x...", "0x...", "0x..."],
+//	  "validators": [{"bandersnatch": "0x...", "ed25519": "0x...", "bls": "0x...", "metadata": "0x..."}],
+//	  "services": [{"id": 0, "code": "0x...", "balance": 0, "min_item_gas": 0, "min_memo_gas": 0}],
+//	  "privileged_services": {"manager": 0, "assign": 0, "designate": 0, "always_accumulate": {"0": 1000}},
+//	  "authorizer_pools": [["0x..."]],
+//	  "authorizer_queues": [["0x..."]]
+//	}
+//
+// Missing validators, entropy and authorizers are filled with zero values.
+package genesis
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/service"
+	"github.com/eigerco/strawberry/internal/state"
+)
+
+var ErrInvalidSpec = errors.New("invalid chain spec")
+
+// Spec is a chain specification
+type Spec struct {
+	Name               string              `json:"name"`
+	Timeslot           jamtime.Timeslot    `json:"timeslot"`     // Timeslot of the genesis block (τ)
+	EntropyPool        []crypto.HexBytes   `json:"entropy_pool"` // Initial entropy pool (η), at most EntropyPoolSize entries
+	Validators         []Validator         `json:"validators"`   // Initial validator set (κ, λ, ι, γk), at most NumberOfValidators entries
+	Services           []Service           `json:"services"`     // Initial service accounts (δ)
+	PrivilegedServices PrivilegedServices  `json:"privileged_services"`
+	AuthorizerPools    [][]crypto.HexBytes `json:"authorizer_pools"`  // Authorizer hashes of each core (α)
+	AuthorizerQueues   [][]crypto.HexBytes `json:"authorizer_queues"` // Pending authorizer hashes of each core (φ)
+}
+
+// Validator holds the keys of a validator
+type Validator struct {
+	Bandersnatch crypto.HexBytes `json:"bandersnatch"`
+	Ed25519      crypto.HexBytes `json:"ed25519"`
+	Bls          crypto.HexBytes `json:"bls"`
+	Metadata     crypto.HexBytes `json:"metadata"`
+}
+
+// Service is a service account present at genesis. Its code is added as a
+// preimage available since the genesis timeslot.
+type Service struct {
+	Id         block.ServiceId `json:"id"`
+	Code       crypto.HexBytes `json:"code"`
+	Balance    uint64          `json:"balance"`
+	MinItemGas uint64          `json:"min_item_gas"` // Gas limit for accumulation (g)
+	MinMemoGas uint64          `json:"min_memo_gas"` // Gas limit for on_transfer (m)
+}
+
+// PrivilegedServices holds the privileged service IDs (χ)
+type PrivilegedServices struct {
+	Manager          block.ServiceId            `json:"manager"`
+	Assign           block.ServiceId            `json:"assign"`
+	Designate        block.ServiceId            `json:"designate"`
+	AlwaysAccumulate map[block.ServiceId]uint64 `json:"always_accumulate"`
+}
+
+// Load reads a chain spec from a JSON file
+func Load(path string) (Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Spec{}, fmt.Errorf("read chain spec: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes a chain spec from JSON and checks its sizes
+func Parse(data []byte) (Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return Spec{}, fmt.Errorf("%w: %w", ErrInvalidSpec, err)
+	}
+	if err := spec.Validate(); err != nil {
+		return Spec{}, err
+	}
+	return spec, nil
+}
+
+// Validate checks that every value of the spec fits in the genesis state
+func (s Spec) Validate() error {
+	if len(s.EntropyPool) > state.EntropyPoolSize {
+		return fmt.Errorf("%w: %d entropy pool entries, at most %d", ErrInvalidSpec, len(s.EntropyPool), state.EntropyPoolSize)
+	}
+	for i, entropy := range s.EntropyPool {
+		if len(entropy) != crypto.HashSize {
+			return fmt.Errorf("%w: entropy %d is %d bytes", ErrInvalidSpec, i, len(entropy))
+		}
+	}
+
+	if len(s.Validators) > common.NumberOfValidators {
+		return fmt.Errorf("%w: %d validators, at most %d", ErrInvalidSpec, len(s.Validators), common.NumberOfValidators)
+	}
+	for i, v := range s.Validators {
+		keys := []struct {
+			name  string
+			value []byte
+			size  int
+		}{
+			{"bandersnatch", v.Bandersnatch, crypto.BandersnatchSize},
+			{"ed25519", v.Ed25519, ed25519.PublicKeySize},
+			{"bls", v.Bls, crypto.BLSSize},
+			{"metadata", v.Metadata, crypto.MetadataSize},
+		}
+		for _, key := range keys {
+			if len(key.value) != key.size {
+				return fmt.Errorf("%w: validator %d %s key is %d bytes, expected %d", ErrInvalidSpec, i, key.name, len(key.value), key.size)
+			}
+		}
+	}
+
+	services := make(map[block.ServiceId]struct{})
+	for _, svc := range s.Services {
+		if _, ok := services[svc.Id]; ok {
+			return fmt.Errorf("%w: duplicate service %d", ErrInvalidSpec, svc.Id)
+		}
+		services[svc.Id] = struct{}{}
+	}
+
+	if len(s.AuthorizerPools) > int(common.TotalNumberOfCores) {
+		return fmt.Errorf("%w: authorizer pools for %d cores, at most %d", ErrInvalidSpec, len(s.AuthorizerPools), common.TotalNumberOfCores)
+	}
+	for core, pool := range s.AuthorizerPools {
+		if len(pool) > state.MaxAuthorizersPerCore {
+			return fmt.Errorf("%w: %d authorizers in the pool of core %d, at most %d", ErrInvalidSpec, len(pool), core, state.MaxAuthorizersPerCore)
+		}
+		if err := validateHashes(pool); err != nil {
+			return fmt.Errorf("%w: authorizer pool of core %d: %w", ErrInvalidSpec, core, err)
+		}
+	}
+	if len(s.AuthorizerQueues) > int(common.TotalNumberOfCores) {
+		return fmt.Errorf("%w: authorizer queues for %d cores, at most %d", ErrInvalidSpec, len(s.AuthorizerQueues), common.TotalNumberOfCores)
+	}
+	for core, queue := range s.AuthorizerQueues {
+		if len(queue) > state.PendingAuthorizersQueueSize {
+			return fmt.Errorf("%w: %d authorizers in the queue of core %d, at most %d", ErrInvalidSpec, len(queue), core, state.PendingAuthorizersQueueSize)
+		}
+		if err := validateHashes(queue); err != nil {
+			return fmt.Errorf("%w: authorizer queue of core %d: %w", ErrInvalidSpec, core, err)
+		}
+	}
+	return nil
+}
+
+func validateHashes(hashes []crypto.HexBytes) error {
+	for i, hash := range hashes {
+		if len(hash) != crypto.HashSize {
+			return fmt.Errorf("hash %d is %d bytes", i, len(hash))
+		}
+	}
+	return nil
+}
+
+// Header returns the genesis header. It has no parent and no prior state,
+// and its epoch marker commits to the initial entropy and validator set.
+func (s Spec) Header() (block.Header, error) {
+	if err := s.Validate(); err != nil {
+		return block.Header{}, err
+	}
+	extrinsicHash, err := block.Extrinsic{}.Hash()
+	if err != nil {
+		return block.Header{}, fmt.Errorf("hash genesis extrinsic: %w", err)
+	}
+	entropy := s.entropyPool()
+	validators := s.validators()
+	epochMarker := &block.EpochMarker{
+		Entropy:        entropy[1],
+		TicketsEntropy: entropy[2],
+	}
+	for i, v := range validators {
+		epochMarker.Keys[i] = v.Bandersnatch
+	}
+	return block.Header{
+		ExtrinsicHash: extrinsicHash,
+		TimeSlotIndex: s.Timeslot,
+		EpochMarker:   epochMarker,
+	}, nil
+}
+
+// Block returns the genesis block, which has an empty extrinsic
+func (s Spec) Block() (block.Block, error) {
+	header, err := s.Header()
+	if err != nil {
+		return block.Block{}, err
+	}
+	return block.Block{Header: header}, nil
+}
+
+// Hash returns the hash of the genesis header, which identifies the chain
+func (s Spec) Hash() (crypto.Hash, error) {
+	header, err := s.Header()
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	return header.Hash()
+}
+
+// chainHashLength is the number of hex digits of the genesis hash used in protocol IDs
+const chainHashLength = 8
+
+// ChainHash returns the chain identifier used in the network protocol IDs,
+// the first 8 nibbles of the genesis header hash. JAMNP-S.
+func ChainHash(genesisHash crypto.Hash) string {
+	return hex.EncodeToString(genesisHash[:])[:chainHashLength]
+}
+
+// State returns the genesis state. The initial validators are the current,
+// archived, queued and next validators, and the sealing keys of the first
+// epoch are the fallback keys.
+func (s Spec) State() (state.State, error) {
+	if err := s.Validate(); err != nil {
+		return state.State{}, err
+	}
+	genesisState := state.State{
+		Services:    make(service.ServiceState),
+		EntropyPool: s.entropyPool(),
+		PrivilegedServices: service.PrivilegedServices{
+			ManagerServiceId:        s.PrivilegedServices.Manager,
+			AssignServiceId:         s.PrivilegedServices.Assign,
+			DesignateServiceId:      s.PrivilegedServices.Designate,
+			AmountOfGasPerServiceId: make(map[block.ServiceId]uint64),
+		},
+		TimeslotIndex: s.Timeslot,
+	}
+	for serviceId, gas := range s.PrivilegedServices.AlwaysAccumulate {
+		genesisState.PrivilegedServices.AmountOfGasPerServiceId[serviceId] = gas
+	}
+
+	for _, svc := range s.Services {
+		codeHash := crypto.HashData(svc.Code)
+		genesisState.Services[svc.Id] = service.ServiceAccount{
+			Storage:        make(map[crypto.Hash][]byte),
+			PreimageLookup: map[crypto.Hash][]byte{codeHash: svc.Code},
+			PreimageMeta: map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{
+				{Hash: codeHash, Length: service.PreimageLength(len(svc.Code))}: {s.Timeslot},
+			},
+			CodeHash:               codeHash,
+			Balance:                svc.Balance,
+			GasLimitForAccumulator: svc.MinItemGas,
+			GasLimitOnTransfer:     svc.MinMemoGas,
+		}
+	}
+
+	for core, pool := range s.AuthorizerPools {
+		for _, hash := range pool {
+			genesisState.CoreAuthorizersPool[core] = append(genesisState.CoreAuthorizersPool[core], crypto.Hash(hash))
+		}
+	}
+	for core, queue := range s.AuthorizerQueues {
+		for i, hash := range queue {
+			genesisState.PendingAuthorizersQueues[core][i] = crypto.Hash(hash)
+		}
+	}
+
+	validators := s.validators()
+	genesisState.ValidatorState.CurrentValidators = validators
+	genesisState.ValidatorState.ArchivedValidators = validators
+	genesisState.ValidatorState.QueuedValidators = validators
+	genesisState.ValidatorState.SafroleState.NextValidators = validators
+
+	ringCommitment, err := genesisState.ValidatorState.SafroleState.CalculateRingCommitment()
+	if err != nil {
+		return state.State{}, fmt.Errorf("calculate ring commitment: %w", err)
+	}
+	genesisState.ValidatorState.SafroleState.RingCommitment = ringCommitment
+	fallbackKeys, err := safrole.SelectFallbackKeys(genesisState.EntropyPool[2], validators)
+	if err != nil {
+		return state.State{}, fmt.Errorf("select fallback keys: %w", err)
+	}
+	genesisState.ValidatorState.SafroleState.SealingKeySeries.Set(fallbackKeys)
+
+	return genesisState, nil
+}
+
+// entropyPool returns the initial entropy pool, padded with zero hashes
+func (s Spec) entropyPool() state.EntropyPool {
+	var pool state.EntropyPool
+	for i, entropy := range s.EntropyPool {
+		pool[i] = crypto.Hash(entropy)
+	}
+	return pool
+}
+
+// validators returns the initial validator set, padded with zero keys
+func (s Spec) validators() safrole.ValidatorsData {
+	var validators safrole.ValidatorsData
+	for i := range validators {
+		key := &crypto.ValidatorKey{Ed25519: make(ed25519.PublicKey, ed25519.PublicKeySize)}
+		if i < len(s.Validators) {
+			v := s.Validators[i]
+			key.Bandersnatch = crypto.BandersnatchPublicKey(v.Bandersnatch)
+			copy(key.Ed25519, v.Ed25519)
+			key.Bls = crypto.BlsKey(v.Bls)
+			key.Metadata = crypto.MetadataKey(v.Metadata)
+		}
+		validators[i] = key
+	}
+	return validators
+}
@@ -0,0 +1,169 @@
+package genesis
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/service"
+	merkle "github.com/eigerco/strawberry/internal/state/merkle"
+	"github.com/eigerco/strawberry/internal/testutils"
+)
+
+func randomSpec(t *testing.T) Spec {
+	bandersnatchKey := testutils.RandomBandersnatchPublicKey(t)
+	validator := Validator{
+		Bandersnatch: bandersnatchKey[:],
+		Ed25519:      crypto.HexBytes(testutils.RandomED25519PublicKey(t)),
+		Bls:          make([]byte, crypto.BLSSize),
+		Metadata:     make([]byte, crypto.MetadataSize),
+	}
+	authorizer := testutils.RandomHash(t)
+	entropy := testutils.RandomHash(t)
+	return Spec{
+		Name:        "test",
+		Timeslot:    5,
+		EntropyPool: []crypto.HexBytes{entropy[:], entropy[:], entropy[:]},
+		Validators:  []Validator{validator},
+		Services: []Service{
+			{Id: 7, Code: []byte{1, 2, 3}, Balance: 1000, MinItemGas: 10, MinMemoGas: 20},
+		},
+		PrivilegedServices: PrivilegedServices{
+			Manager:          7,
+			Assign:           7,
+			Designate:        7,
+			AlwaysAccumulate: map[block.ServiceId]uint64{7: 100},
+		},
+		AuthorizerPools:  [][]crypto.HexBytes{{authorizer[:]}},
+		AuthorizerQueues: [][]crypto.HexBytes{{}, {authorizer[:]}},
+	}
+}
+
+func TestLoad(t *testing.T) {
+	spec := randomSpec(t)
+	data, err := json.Marshal(spec)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "spec.json")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, spec, loaded)
+}
+
+func TestParse_HexWithoutPrefix(t *testing.T) {
+	spec, err := Parse([]byte(`{"services": [{"id": 1, "code": "0102"}], "privileged_services": {"always_accumulate": {"1": 5}}}`))
+	require.NoError(t, err)
+	require.Len(t, spec.Services, 1)
+	assert.Equal(t, crypto.HexBytes{1, 2}, spec.Services[0].Code)
+	assert.Equal(t, map[block.ServiceId]uint64{1: 5}, spec.PrivilegedServices.AlwaysAccumulate)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"malformed json":    `{`,
+		"malformed hex":     `{"entropy_pool": ["0xzz"]}`,
+		"short entropy":     `{"entropy_pool": ["0x01"]}`,
+		"short key":         `{"validators": [{"bandersnatch": "0x01"}]}`,
+		"duplicate service": `{"services": [{"id": 1}, {"id": 1}]}`,
+		"short authorizer":  `{"authorizer_pools": [["0x01"]]}`,
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(input))
+			assert.ErrorIs(t, err, ErrInvalidSpec)
+		})
+	}
+}
+
+func TestHeader(t *testing.T) {
+	spec := randomSpec(t)
+	header, err := spec.Header()
+	require.NoError(t, err)
+
+	assert.Equal(t, crypto.Hash{}, header.ParentHash)
+	assert.Equal(t, crypto.Hash{}, header.PriorStateRoot)
+	assert.Equal(t, spec.Timeslot, header.TimeSlotIndex)
+	require.NotNil(t, header.EpochMarker)
+	assert.Equal(t, crypto.Hash(spec.EntropyPool[1]), header.EpochMarker.Entropy)
+	assert.Equal(t, crypto.BandersnatchPublicKey(spec.Validators[0].Bandersnatch), header.EpochMarker.Keys[0])
+	assert.Equal(t, crypto.BandersnatchPublicKey{}, header.EpochMarker.Keys[1])
+
+	// Every part of the header committed to changes the chain
+	hash, err := spec.Hash()
+	require.NoError(t, err)
+	otherKey := testutils.RandomBandersnatchPublicKey(t)
+	spec.Validators[0].Bandersnatch = otherKey[:]
+	otherHash, err := spec.Hash()
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, otherHash)
+}
+
+func TestChainHash(t *testing.T) {
+	hash := crypto.Hash{0xab, 0xcd, 0x12, 0x34, 0x56}
+	assert.Equal(t, "abcd1234", ChainHash(hash))
+}
+
+func TestState(t *testing.T) {
+	spec := randomSpec(t)
+	genesisState, err := spec.State()
+	require.NoError(t, err)
+
+	assert.Equal(t, spec.Timeslot, genesisState.TimeslotIndex)
+	assert.Equal(t, crypto.Hash(spec.EntropyPool[2]), genesisState.EntropyPool[2])
+	assert.Equal(t, crypto.Hash{}, genesisState.EntropyPool[3])
+
+	// Services hold their code as a preimage
+	code := []byte{1, 2, 3}
+	codeHash := crypto.HashData(code)
+	account, ok := genesisState.Services[7]
+	require.True(t, ok)
+	assert.Equal(t, codeHash, account.CodeHash)
+	assert.Equal(t, code, account.Code())
+	assert.Equal(t, service.PreimageHistoricalTimeslots{spec.Timeslot},
+		account.PreimageMeta[service.PreImageMetaKey{Hash: codeHash, Length: 3}])
+	assert.Equal(t, uint64(1000), account.Balance)
+	assert.Equal(t, uint64(10), account.GasLimitForAccumulator)
+	assert.Equal(t, uint64(20), account.GasLimitOnTransfer)
+
+	assert.Equal(t, service.PrivilegedServices{
+		ManagerServiceId:        7,
+		AssignServiceId:         7,
+		DesignateServiceId:      7,
+		AmountOfGasPerServiceId: map[block.ServiceId]uint64{7: 100},
+	}, genesisState.PrivilegedServices)
+
+	authorizer := crypto.Hash(spec.AuthorizerPools[0][0])
+	assert.Equal(t, []crypto.Hash{authorizer}, genesisState.CoreAuthorizersPool[0])
+	assert.Equal(t, crypto.Hash{}, genesisState.PendingAuthorizersQueues[0][0])
+	assert.Equal(t, authorizer, genesisState.PendingAuthorizersQueues[1][0])
+
+	// All validator sets start with the initial validators
+	validators := genesisState.ValidatorState
+	key := validators.CurrentValidators[0]
+	assert.Equal(t, crypto.BandersnatchPublicKey(spec.Validators[0].Bandersnatch), key.Bandersnatch)
+	assert.Equal(t, []byte(spec.Validators[0].Ed25519), []byte(key.Ed25519))
+	assert.Equal(t, validators.CurrentValidators, validators.ArchivedValidators)
+	assert.Equal(t, validators.CurrentValidators, validators.QueuedValidators)
+	assert.Equal(t, validators.CurrentValidators, validators.SafroleState.NextValidators)
+	assert.NotEqual(t, crypto.RingCommitment{}, validators.SafroleState.RingCommitment)
+	fallbackKeys, err := safrole.SelectFallbackKeys(genesisState.EntropyPool[2], validators.CurrentValidators)
+	require.NoError(t, err)
+	assert.Equal(t, fallbackKeys, validators.SafroleState.SealingKeySeries.Get())
+
+	// The genesis state can be serialized and restored
+	serialized, err := merkle.SerializeState(genesisState)
+	require.NoError(t, err)
+	restored, err := merkle.DeserializeState(serialized)
+	require.NoError(t, err)
+	reserialized, err := merkle.SerializeState(restored)
+	require.NoError(t, err)
+	assert.Equal(t, serialized, reserialized)
+}
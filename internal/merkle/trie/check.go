@@ -0,0 +1,93 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+// NodeIssue describes a node of the trie that is missing or corrupt
+type NodeIssue struct {
+	Hash    crypto.Hash // Hash under which the node is referenced
+	Corrupt bool        // The node is present but its content does not match the hash
+}
+
+// CheckNodes walks the trie under the given root and reports every node that
+// is referenced but missing from the db, or whose content does not hash to
+// its key. Nodes already in visited are skipped and every node found intact
+// is added to it, so that many roots sharing subtrees can be checked cheaply.
+//
+// Branch nodes only keep the last 255 bits of the left child hash, so the
+// left child is looked up under both possible values of the first bit.
+func (s *DB) CheckNodes(root crypto.Hash, visited map[crypto.Hash]struct{}) ([]NodeIssue, error) {
+	var issues []NodeIssue
+	queue := [][]crypto.Hash{{root}}
+	for len(queue) > 0 {
+		candidates := queue[0]
+		queue = queue[1:]
+
+		if candidates[0] == (crypto.Hash{}) {
+			continue // Empty subtree
+		}
+		if _, ok := visited[candidates[0]]; ok {
+			continue
+		}
+		if len(candidates) > 1 {
+			if _, ok := visited[candidates[1]]; ok {
+				continue
+			}
+		}
+
+		hash, node, found, err := s.findNode(candidates)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			issues = append(issues, NodeIssue{Hash: candidates[0]})
+			continue
+		}
+		if crypto.HashData(node[:]) != hash {
+			issues = append(issues, NodeIssue{Hash: hash, Corrupt: true})
+			continue
+		}
+		visited[hash] = struct{}{}
+
+		if node.IsBranch() {
+			queue = append(queue, leftChildCandidates(node), []crypto.Hash{crypto.Hash(node[32:])})
+		}
+	}
+	return issues, nil
+}
+
+// findNode returns the first of the candidate hashes that is stored, together with its node
+func (s *DB) findNode(candidates []crypto.Hash) (crypto.Hash, Node, bool, error) {
+	for _, hash := range candidates {
+		data, err := s.store.Get(hash[:])
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				continue
+			}
+			return crypto.Hash{}, Node{}, false, fmt.Errorf("get node: %w", err)
+		}
+		if len(data) != NodeSize {
+			return hash, Node{}, true, nil // Reported as corrupt by the caller
+		}
+		return hash, Node(data), true, nil
+	}
+	return crypto.Hash{}, Node{}, false, nil
+}
+
+// leftChildCandidates returns the two hashes the left child of a branch may
+// have, as the first bit of the hash is dropped by the encoding
+func leftChildCandidates(branch Node) []crypto.Hash {
+	var left crypto.Hash
+	copy(left[:], branch[:32])
+	if left == (crypto.Hash{}) {
+		return []crypto.Hash{left}
+	}
+	withBit := left
+	withBit[0] |= 0b10000000
+	return []crypto.Hash{left, withBit}
+}
@@ -0,0 +1,89 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/pkg/db/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomPairs(t *testing.T, n int) [][2][]byte {
+	pairs := make([][2][]byte, n)
+	for i := range pairs {
+		key := testutils.RandomHash(t)
+		value := testutils.RandomHash(t)
+		// Alternate embedded and regular leaves
+		if i%2 == 0 {
+			value := append(value[:], value[:]...)
+			pairs[i] = [2][]byte{key[:], value}
+			continue
+		}
+		pairs[i] = [2][]byte{key[:], value[:]}
+	}
+	return pairs
+}
+
+func TestCheckNodes(t *testing.T) {
+	store := memory.NewKVStore()
+	db := NewDBWithStore(store)
+	defer db.Close()
+
+	root, err := db.MerklizeAndCommit(randomPairs(t, 16))
+	require.NoError(t, err)
+
+	// Branches only keep 255 bits of their left child hash, every node must still be found
+	visited := make(map[crypto.Hash]struct{})
+	issues, err := db.CheckNodes(root, visited)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+	assert.GreaterOrEqual(t, len(visited), 31) // At least 16 leaves and 15 branches
+
+	// Visited nodes are not checked again
+	issues, err = db.CheckNodes(root, visited)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+
+	// Empty trie
+	issues, err = db.CheckNodes(crypto.Hash{}, map[crypto.Hash]struct{}{})
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestCheckNodes_MissingAndCorrupt(t *testing.T) {
+	store := memory.NewKVStore()
+	db := NewDBWithStore(store)
+	defer db.Close()
+
+	// Spread the keys on both sides of the root
+	pairs := randomPairs(t, 4)
+	for i := range pairs {
+		pairs[i][0][0] = byte(i) << 6
+	}
+	root, err := db.MerklizeAndCommit(pairs)
+	require.NoError(t, err)
+	rootNode, err := db.Get(root)
+	require.NoError(t, err)
+	require.True(t, rootNode.IsBranch())
+	right := crypto.Hash(rootNode[32:])
+
+	// Corrupt the right child of the root
+	require.NoError(t, store.Put(right[:], make([]byte, NodeSize)))
+	issues, err := db.CheckNodes(root, map[crypto.Hash]struct{}{})
+	require.NoError(t, err)
+	assert.Equal(t, []NodeIssue{{Hash: right, Corrupt: true}}, issues)
+
+	// Remove it altogether
+	require.NoError(t, store.Delete(right[:]))
+	issues, err = db.CheckNodes(root, map[crypto.Hash]struct{}{})
+	require.NoError(t, err)
+	assert.Equal(t, []NodeIssue{{Hash: right}}, issues)
+
+	// Missing root
+	missing := testutils.RandomHash(t)
+	issues, err = db.CheckNodes(missing, map[crypto.Hash]struct{}{})
+	require.NoError(t, err)
+	assert.Equal(t, []NodeIssue{{Hash: missing}}, issues)
+}
@@ -15,16 +15,21 @@ type DB struct {
 	rootLock sync.RWMutex
 }
 
-// NewDB creates a new trie db
+// NewDB creates a new trie db backed by an in-memory store
 func NewDB() (*DB, error) {
 	store, err := pebble.NewKVStore()
 	if err != nil {
 		return nil, fmt.Errorf(ErrFailedStoreInit, err)
 	}
-	tdb := &DB{
+	return NewDBWithStore(store), nil
+}
+
+// NewDBWithStore creates a new trie db on top of an existing store,
+// allowing the trie to share a database with other components
+func NewDBWithStore(store db.KVStore) *DB {
+	return &DB{
 		store: store,
 	}
-	return tdb, err
 }
 
 // MerklizeAndCommit writes a series of key-value pairs to the trie
@@ -72,3 +77,40 @@ func (s *DB) Root() crypto.Hash {
 func (s *DB) Close() error {
 	return s.store.Close()
 }
+
+// Snapshot is a consistent read-only view of the trie db, capturing the root
+// together with the nodes stored at the time it was taken
+type Snapshot struct {
+	snapshot db.Snapshot
+	root     crypto.Hash
+}
+
+// NewSnapshot captures the current root and trie nodes. Snapshots must be
+// closed before the db.
+func (s *DB) NewSnapshot() (*Snapshot, error) {
+	// Hold the root lock so that no commit lands between reading the root and taking the snapshot
+	s.rootLock.RLock()
+	defer s.rootLock.RUnlock()
+
+	snapshot, err := s.store.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{snapshot: snapshot, root: s.root}, nil
+}
+
+func (s *Snapshot) Get(hash crypto.Hash) (Node, error) {
+	data, err := s.snapshot.Get(hash[:])
+	if err != nil {
+		return Node{}, err
+	}
+	return Node(data), nil
+}
+
+func (s *Snapshot) Root() crypto.Hash {
+	return s.root
+}
+
+func (s *Snapshot) Close() error {
+	return s.snapshot.Close()
+}
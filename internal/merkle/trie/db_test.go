@@ -3,6 +3,8 @@ package trie
 import (
 	"bytes"
 	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/pkg/db/memory"
+	"github.com/eigerco/strawberry/pkg/db/pebble"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -186,3 +188,52 @@ func TestConcurrency(t *testing.T) {
 		<-done
 	}
 }
+
+func TestNewDBWithStore(t *testing.T) {
+	store := memory.NewKVStore()
+	db := NewDBWithStore(store)
+	defer db.Close()
+
+	root, err := db.MerklizeAndCommit([][2][]byte{
+		{[]byte("key1"), []byte("value1")},
+	})
+	require.NoError(t, err)
+
+	// Nodes are written to the shared store
+	node, err := store.Get(root[:])
+	require.NoError(t, err)
+	assert.Len(t, node, NodeSize)
+}
+
+func TestSnapshot(t *testing.T) {
+	db, err := NewDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	root, err := db.MerklizeAndCommit([][2][]byte{
+		{[]byte("key1"), []byte("value1")},
+		{[]byte("key2"), []byte("value2")},
+	})
+	require.NoError(t, err)
+
+	snapshot, err := db.NewSnapshot()
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	newRoot, err := db.MerklizeAndCommit([][2][]byte{
+		{[]byte("key3"), []byte("value3")},
+		{[]byte("key4"), []byte("value4")},
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, root, newRoot)
+
+	// The snapshot keeps the root it was taken at and cannot see newer nodes
+	assert.Equal(t, root, snapshot.Root())
+	_, err = snapshot.Get(root)
+	require.NoError(t, err)
+	_, err = snapshot.Get(newRoot)
+	require.ErrorIs(t, err, pebble.ErrNotFound)
+
+	_, err = db.Get(newRoot)
+	require.NoError(t, err)
+}
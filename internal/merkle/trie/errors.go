@@ -7,6 +7,9 @@ var (
 	ErrNotBranchNode                = errors.New("node is not a branch node")
 	ErrNotEmbeddedLeaf              = errors.New("node is not an embedded-value leaf node")
 	ErrEmbeddedLeafInsteadOfRegular = errors.New("node is an embedded leaf, expected regular leaf")
+	ErrNodeNotFound                 = errors.New("trie node not found")
+	ErrInvalidProof                 = errors.New("invalid trie proof")
+	ErrValueMismatch                = errors.New("proven value does not match")
 )
 
 const (
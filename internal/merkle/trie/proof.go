@@ -0,0 +1,155 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+)
+
+// Prove returns the nodes on the path of the key in the trie under the given
+// root, starting with the root node. The path ends at the leaf holding the
+// key, proving inclusion, or at an empty subtree or a leaf holding another
+// key, proving exclusion. The proof of any key in an empty trie is empty.
+func (s *DB) Prove(root crypto.Hash, key []byte) ([]Node, error) {
+	var stateKey StateKey
+	copy(stateKey[:], key)
+
+	var proof []Node
+	candidates := []crypto.Hash{root}
+	for depth := 0; candidates[0] != (crypto.Hash{}); depth++ {
+		_, node, found, err := s.findNode(candidates)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %x", ErrNodeNotFound, candidates[0])
+		}
+		proof = append(proof, node)
+		if node.IsLeaf() {
+			break
+		}
+		if depth == 8*StateKeySize-1 {
+			return nil, fmt.Errorf("%w: path longer than the key", ErrInvalidProof)
+		}
+		if bit(stateKey[:], depth) {
+			candidates = []crypto.Hash{crypto.Hash(node[32:])}
+		} else {
+			candidates = leftChildCandidates(node)
+		}
+	}
+	return proof, nil
+}
+
+// ProofResult is what a verified proof shows about a key
+type ProofResult struct {
+	Present bool   // Whether the key is in the trie
+	Value   []byte // Value held by a present key, or its hash if IsHash
+	IsHash  bool   // Whether only the hash of the value is in the leaf
+}
+
+// VerifyProof checks a proof produced by Prove against the root and tells
+// whether the key is present in the trie. For a present key the value held
+// by its leaf is returned: the value itself for an embedded leaf, otherwise
+// only its hash. VerifyProofResult tells the two apart. Callers with a value
+// obtained elsewhere should check it with VerifyValue instead of comparing it
+// to the returned one.
+//
+// Branch nodes only keep the last 255 bits of their left child hash, so the
+// first bit of a left child hash is not verified.
+func VerifyProof(root crypto.Hash, key []byte, proof []Node) ([]byte, bool, error) {
+	result, err := VerifyProofResult(root, key, proof)
+	if err != nil {
+		return nil, false, err
+	}
+	return result.Value, result.Present, nil
+}
+
+// VerifyProofResult checks a proof like VerifyProof, and also tells whether
+// the value of a present key is the value itself or its hash
+func VerifyProofResult(root crypto.Hash, key []byte, proof []Node) (ProofResult, error) {
+	leaf, present, err := verifyPath(root, key, proof)
+	if err != nil || !present {
+		return ProofResult{}, err
+	}
+	if leaf.IsEmbeddedLeaf() {
+		value, err := leaf.GetLeafValue()
+		if err != nil {
+			return ProofResult{}, err
+		}
+		return ProofResult{Present: true, Value: value}, nil
+	}
+	valueHash, err := leaf.GetLeafValueHash()
+	if err != nil {
+		return ProofResult{}, err
+	}
+	return ProofResult{Present: true, Value: valueHash[:], IsHash: true}, nil
+}
+
+// VerifyValue checks that the proof shows the key holding the given value in
+// the trie under the root. It returns ErrValueMismatch if the key holds
+// another value or is absent.
+func VerifyValue(root crypto.Hash, key, value []byte, proof []Node) error {
+	leaf, present, err := verifyPath(root, key, proof)
+	if err != nil {
+		return err
+	}
+	if !present {
+		return fmt.Errorf("%w: key is absent", ErrValueMismatch)
+	}
+	var stateKey StateKey
+	copy(stateKey[:], key)
+	if EncodeLeafNode(stateKey, value) != leaf {
+		return ErrValueMismatch
+	}
+	return nil
+}
+
+// verifyPath walks the proof from the root along the path of the key and
+// returns the leaf holding the key if present
+func verifyPath(root crypto.Hash, key []byte, proof []Node) (Node, bool, error) {
+	var stateKey StateKey
+	copy(stateKey[:], key)
+
+	expected := root
+	left := false
+	for depth, node := range proof {
+		if expected == (crypto.Hash{}) {
+			return Node{}, false, fmt.Errorf("%w: nodes past an empty subtree", ErrInvalidProof)
+		}
+		hash := crypto.HashData(node[:])
+		if left {
+			hash[0] &= 0b01111111
+		}
+		if hash != expected {
+			return Node{}, false, fmt.Errorf("%w: node %d does not match its parent", ErrInvalidProof, depth)
+		}
+
+		if node.IsLeaf() {
+			if depth != len(proof)-1 {
+				return Node{}, false, fmt.Errorf("%w: nodes past a leaf", ErrInvalidProof)
+			}
+			if size, err := node.GetEmbeddedValueSize(); err == nil && size > EmbeddedValueMaxSize {
+				return Node{}, false, fmt.Errorf("%w: embedded value of %d bytes", ErrInvalidProof, size)
+			}
+			if !bytes.Equal(node[1:32], stateKey[:StateKeySize-1]) {
+				return Node{}, false, nil
+			}
+			return node, true, nil
+		}
+
+		if depth == 8*StateKeySize-1 {
+			return Node{}, false, fmt.Errorf("%w: path longer than the key", ErrInvalidProof)
+		}
+		left = !bit(stateKey[:], depth)
+		if left {
+			copy(expected[:], node[:32])
+		} else {
+			copy(expected[:], node[32:])
+		}
+	}
+	if expected != (crypto.Hash{}) {
+		return Node{}, false, fmt.Errorf("%w: proof ends before a leaf or empty subtree", ErrInvalidProof)
+	}
+	return Node{}, false, nil
+}
@@ -0,0 +1,122 @@
+package trie
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/pkg/db/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProve(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	db := NewDBWithStore(memory.NewKVStore())
+	defer db.Close()
+
+	kvs := make(map[StateKey][]byte)
+	var pairs [][2][]byte
+	for range 64 {
+		key, value := randomKeyValue(r)
+		kvs[key] = value
+		pairs = append(pairs, [2][]byte{key[:], value})
+	}
+	root, err := db.MerklizeAndCommit(pairs)
+	require.NoError(t, err)
+
+	// Inclusion
+	for key, value := range kvs {
+		proof, err := db.Prove(root, key[:])
+		require.NoError(t, err)
+
+		proven, present, err := VerifyProof(root, key[:], proof)
+		require.NoError(t, err)
+		require.True(t, present)
+		result, err := VerifyProofResult(root, key[:], proof)
+		require.NoError(t, err)
+		require.True(t, result.Present)
+		assert.Equal(t, proven, result.Value)
+		if len(value) <= EmbeddedValueMaxSize {
+			assert.False(t, result.IsHash)
+			assert.Equal(t, value, proven)
+		} else {
+			valueHash := crypto.HashData(value)
+			assert.True(t, result.IsHash)
+			assert.Equal(t, valueHash[:], proven)
+		}
+		require.NoError(t, VerifyValue(root, key[:], value, proof))
+		require.ErrorIs(t, VerifyValue(root, key[:], append(value, 0), proof), ErrValueMismatch)
+	}
+
+	// Exclusion
+	for range 64 {
+		key, value := randomKeyValue(r)
+		proof, err := db.Prove(root, key[:])
+		require.NoError(t, err)
+
+		_, present, err := VerifyProof(root, key[:], proof)
+		require.NoError(t, err)
+		assert.False(t, present)
+		require.ErrorIs(t, VerifyValue(root, key[:], value, proof), ErrValueMismatch)
+	}
+}
+
+func TestProve_EmptyTrie(t *testing.T) {
+	db := NewDBWithStore(memory.NewKVStore())
+	defer db.Close()
+
+	key := StateKey{1}
+	proof, err := db.Prove(crypto.Hash{}, key[:])
+	require.NoError(t, err)
+	assert.Empty(t, proof)
+
+	_, present, err := VerifyProof(crypto.Hash{}, key[:], proof)
+	require.NoError(t, err)
+	assert.False(t, present)
+}
+
+func TestVerifyProof_Invalid(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	db := NewDBWithStore(memory.NewKVStore())
+	defer db.Close()
+
+	var pairs [][2][]byte
+	for range 16 {
+		key, value := randomKeyValue(r)
+		pairs = append(pairs, [2][]byte{key[:], value})
+	}
+	root, err := db.MerklizeAndCommit(pairs)
+	require.NoError(t, err)
+	key := pairs[0][0]
+	proof, err := db.Prove(root, key)
+	require.NoError(t, err)
+	require.Greater(t, len(proof), 1)
+
+	// Wrong root
+	_, _, err = VerifyProof(testutils.RandomHash(t), key, proof)
+	require.ErrorIs(t, err, ErrInvalidProof)
+
+	// Tampered leaf
+	tampered := append([]Node(nil), proof...)
+	tampered[len(tampered)-1][40] ^= 1
+	_, _, err = VerifyProof(root, key, tampered)
+	require.ErrorIs(t, err, ErrInvalidProof)
+
+	// Truncated
+	_, _, err = VerifyProof(root, key, proof[:len(proof)-1])
+	require.ErrorIs(t, err, ErrInvalidProof)
+
+	// Extended past the leaf
+	_, _, err = VerifyProof(root, key, append(append([]Node(nil), proof...), proof[0]))
+	require.ErrorIs(t, err, ErrInvalidProof)
+
+	// Proof of another key, it either diverges from the path or proves the key absent
+	_, present, err := VerifyProof(root, pairs[1][0], proof)
+	assert.True(t, err != nil || !present)
+
+	// Missing nodes
+	_, err = db.Prove(crypto.Hash{1}, key)
+	require.ErrorIs(t, err, ErrNodeNotFound)
+}
@@ -0,0 +1,241 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+)
+
+// subtreeKind tells what an updated subtree consists of
+type subtreeKind int
+
+const (
+	subtreeUnchanged subtreeKind = iota // Not touched by the update, not loaded
+	subtreeEmpty
+	subtreeLeaf
+	subtreeBranch
+)
+
+// subtree is the result of updating a subtree of the trie
+type subtree struct {
+	kind subtreeKind
+	// Hash of the subtree root. For unchanged left children the first bit is
+	// unknown, candidates holds both possible hashes.
+	hash       crypto.Hash
+	candidates []crypto.Hash
+}
+
+// change is a single insert or delete applied by Update
+type change struct {
+	key     StateKey
+	value   []byte
+	deleted bool
+}
+
+// entry is a leaf of a subtree being rebuilt, either an inserted key or a
+// leaf kept from the prior trie
+type entry struct {
+	key  StateKey
+	leaf Node
+}
+
+// Update applies a set of inserts and deletes to the trie under the prior root
+// and commits the new nodes, returning the new root. Only the nodes on the
+// paths of the changed keys are read and rewritten, the resulting root is the
+// same Merklize would compute over the full resulting set of key-value pairs.
+// Inserting an existing key replaces its value and deleting a missing key is
+// a no-op. A key both inserted and deleted is inserted. Nodes of the prior
+// trie are kept, so the prior root stays readable.
+//
+// Leaves only record the first 31 bytes of their key, keys are therefore told
+// apart by those bytes: an insert whose key shares them with a stored leaf
+// replaces that leaf.
+func (s *DB) Update(root crypto.Hash, inserts [][2][]byte, deletes [][]byte) (crypto.Hash, error) {
+	byKey := make(map[StateKey]change, len(inserts)+len(deletes))
+	for _, key := range deletes {
+		var stateKey StateKey
+		copy(stateKey[:], key)
+		byKey[stateKey] = change{key: stateKey, deleted: true}
+	}
+	for _, kv := range inserts {
+		var stateKey StateKey
+		copy(stateKey[:], kv[0])
+		byKey[stateKey] = change{key: stateKey, value: kv[1]}
+	}
+	changes := make([]change, 0, len(byKey))
+	for _, c := range byKey {
+		changes = append(changes, c)
+	}
+
+	s.rootLock.Lock()
+	defer s.rootLock.Unlock()
+
+	batch := s.store.NewBatch()
+	defer func() {
+		if err := batch.Close(); err != nil {
+			panic(fmt.Sprintf(ErrFailedBatchCommit, err))
+		}
+	}()
+	storeNode := func(hash crypto.Hash, node Node) error {
+		return batch.Put(hash[:], node[:])
+	}
+
+	result, err := s.update([]crypto.Hash{root}, 0, changes, storeNode)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	newRoot := root
+	if result.kind != subtreeUnchanged {
+		newRoot = result.hash
+	}
+
+	if err := batch.Commit(); err != nil {
+		return crypto.Hash{}, err
+	}
+	s.root = newRoot
+	return newRoot, nil
+}
+
+// update applies the changes to the subtree at the given depth whose root is
+// one of the candidate hashes
+func (s *DB) update(candidates []crypto.Hash, depth int, changes []change, storeNode func(crypto.Hash, Node) error) (subtree, error) {
+	if candidates[0] == (crypto.Hash{}) {
+		var entries []entry
+		for _, c := range changes {
+			if !c.deleted {
+				entries = append(entries, entry{key: c.key, leaf: EncodeLeafNode(c.key, c.value)})
+			}
+		}
+		return build(entries, depth, storeNode)
+	}
+	if len(changes) == 0 {
+		return subtree{kind: subtreeUnchanged, hash: candidates[0], candidates: candidates}, nil
+	}
+
+	_, node, found, err := s.findNode(candidates)
+	if err != nil {
+		return subtree{}, err
+	}
+	if !found {
+		return subtree{}, fmt.Errorf("%w: %x", ErrNodeNotFound, candidates[0])
+	}
+
+	if node.IsLeaf() {
+		keep := true
+		var entries []entry
+		for _, c := range changes {
+			if bytes.Equal(c.key[:StateKeySize-1], node[1:32]) {
+				keep = false
+			}
+			if !c.deleted {
+				entries = append(entries, entry{key: c.key, leaf: EncodeLeafNode(c.key, c.value)})
+			}
+		}
+		if keep {
+			var key StateKey
+			copy(key[:], node[1:32])
+			entries = append(entries, entry{key: key, leaf: node})
+		}
+		return build(entries, depth, storeNode)
+	}
+
+	var leftChanges, rightChanges []change
+	for _, c := range changes {
+		if bit(c.key[:], depth) {
+			rightChanges = append(rightChanges, c)
+		} else {
+			leftChanges = append(leftChanges, c)
+		}
+	}
+	left, err := s.update(leftChildCandidates(node), depth+1, leftChanges, storeNode)
+	if err != nil {
+		return subtree{}, err
+	}
+	right, err := s.update([]crypto.Hash{crypto.Hash(node[32:])}, depth+1, rightChanges, storeNode)
+	if err != nil {
+		return subtree{}, err
+	}
+	return s.join(left, right, storeNode)
+}
+
+// join combines two updated sibling subtrees. A subtree holding a single leaf
+// is that leaf, whatever its depth, so a leaf left without a sibling moves up.
+func (s *DB) join(left, right subtree, storeNode func(crypto.Hash, Node) error) (subtree, error) {
+	if left.kind == subtreeEmpty || right.kind == subtreeEmpty {
+		other := left
+		if left.kind == subtreeEmpty {
+			other = right
+		}
+		if other.kind == subtreeUnchanged {
+			resolved, err := s.resolve(other)
+			if err != nil {
+				return subtree{}, err
+			}
+			other = resolved
+		}
+		if other.kind == subtreeEmpty || other.kind == subtreeLeaf {
+			return other, nil
+		}
+	}
+
+	node := EncodeBranchNode(left.hash, right.hash)
+	hash := crypto.HashData(node[:])
+	if err := storeNode(hash, node); err != nil {
+		return subtree{}, err
+	}
+	return subtree{kind: subtreeBranch, hash: hash}, nil
+}
+
+// resolve loads the root of an unchanged subtree to find out its kind and full hash
+func (s *DB) resolve(t subtree) (subtree, error) {
+	hash, node, found, err := s.findNode(t.candidates)
+	if err != nil {
+		return subtree{}, err
+	}
+	if !found {
+		return subtree{}, fmt.Errorf("%w: %x", ErrNodeNotFound, t.hash)
+	}
+	if node.IsLeaf() {
+		return subtree{kind: subtreeLeaf, hash: hash}, nil
+	}
+	return subtree{kind: subtreeBranch, hash: hash}, nil
+}
+
+// build computes the subtree holding the given leaves, the same way Merklize does
+func build(entries []entry, depth int, storeNode func(crypto.Hash, Node) error) (subtree, error) {
+	switch len(entries) {
+	case 0:
+		return subtree{kind: subtreeEmpty}, nil
+	case 1:
+		hash := crypto.HashData(entries[0].leaf[:])
+		if err := storeNode(hash, entries[0].leaf); err != nil {
+			return subtree{}, err
+		}
+		return subtree{kind: subtreeLeaf, hash: hash}, nil
+	}
+
+	var leftEntries, rightEntries []entry
+	for _, e := range entries {
+		if bit(e.key[:], depth) {
+			rightEntries = append(rightEntries, e)
+		} else {
+			leftEntries = append(leftEntries, e)
+		}
+	}
+	left, err := build(leftEntries, depth+1, storeNode)
+	if err != nil {
+		return subtree{}, err
+	}
+	right, err := build(rightEntries, depth+1, storeNode)
+	if err != nil {
+		return subtree{}, err
+	}
+
+	node := EncodeBranchNode(left.hash, right.hash)
+	hash := crypto.HashData(node[:])
+	if err := storeNode(hash, node); err != nil {
+		return subtree{}, err
+	}
+	return subtree{kind: subtreeBranch, hash: hash}, nil
+}
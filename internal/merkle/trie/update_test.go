@@ -0,0 +1,134 @@
+package trie
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/pkg/db/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// merklizeMap computes the reference root of a key-value set
+func merklizeMap(t *testing.T, kvs map[StateKey][]byte) crypto.Hash {
+	var pairs [][2][]byte
+	for key, value := range kvs {
+		pairs = append(pairs, [2][]byte{key[:], value})
+	}
+	root, err := Merklize(pairs, 0, nil)
+	require.NoError(t, err)
+	return root
+}
+
+func randomKeyValue(r *rand.Rand) (StateKey, []byte) {
+	var key StateKey
+	r.Read(key[:])
+	// Mix embedded and regular leaves
+	value := make([]byte, r.Intn(2*EmbeddedValueMaxSize)+1)
+	r.Read(value)
+	return key, value
+}
+
+func TestUpdate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	db := NewDBWithStore(memory.NewKVStore())
+	defer db.Close()
+
+	kvs := make(map[StateKey][]byte)
+	root := crypto.Hash{}
+	for round := range 50 {
+		var inserts [][2][]byte
+		var deletes [][]byte
+
+		// Delete some of the existing keys, and a key that is not in the trie
+		for key := range kvs {
+			if r.Intn(4) == 0 {
+				deletes = append(deletes, key[:])
+				delete(kvs, key)
+			}
+		}
+		missing, _ := randomKeyValue(r)
+		deletes = append(deletes, missing[:])
+
+		// Update some of the remaining keys and insert new ones
+		for key := range kvs {
+			if r.Intn(4) == 0 {
+				_, value := randomKeyValue(r)
+				inserts = append(inserts, [2][]byte{key[:], value})
+				kvs[key] = value
+			}
+		}
+		for range r.Intn(10) {
+			key, value := randomKeyValue(r)
+			inserts = append(inserts, [2][]byte{key[:], value})
+			kvs[key] = value
+		}
+
+		newRoot, err := db.Update(root, inserts, deletes)
+		require.NoError(t, err)
+		require.Equal(t, merklizeMap(t, kvs), newRoot, "round %d", round)
+		assert.Equal(t, newRoot, db.Root())
+
+		issues, err := db.CheckNodes(newRoot, map[crypto.Hash]struct{}{})
+		require.NoError(t, err)
+		require.Empty(t, issues)
+		root = newRoot
+	}
+
+	// Deleting everything empties the trie
+	var deletes [][]byte
+	for key := range kvs {
+		deletes = append(deletes, key[:])
+	}
+	root, err := db.Update(root, nil, deletes)
+	require.NoError(t, err)
+	assert.Equal(t, crypto.Hash{}, root)
+}
+
+func TestUpdate_Collapse(t *testing.T) {
+	db := NewDBWithStore(memory.NewKVStore())
+	defer db.Close()
+
+	// Three keys sharing a long prefix and one far apart
+	keys := []StateKey{{0x00, 0x01}, {0x00, 0x02}, {0x00, 0x03}, {0xff}}
+	var pairs [][2][]byte
+	for _, key := range keys {
+		pairs = append(pairs, [2][]byte{key[:], key[:2]})
+	}
+	root, err := db.MerklizeAndCommit(pairs)
+	require.NoError(t, err)
+
+	// Removing two of the close keys moves the last one up next to the far key
+	root, err = db.Update(root, nil, [][]byte{keys[1][:], keys[2][:]})
+	require.NoError(t, err)
+	assert.Equal(t, merklizeMap(t, map[StateKey][]byte{keys[0]: keys[0][:2], keys[3]: keys[3][:2]}), root)
+
+	// Removing the far key leaves a single leaf as the root
+	root, err = db.Update(root, nil, [][]byte{keys[3][:]})
+	require.NoError(t, err)
+	assert.Equal(t, merklizeMap(t, map[StateKey][]byte{keys[0]: keys[0][:2]}), root)
+	node, err := db.Get(root)
+	require.NoError(t, err)
+	assert.True(t, node.IsLeaf())
+}
+
+func TestUpdate_NoChanges(t *testing.T) {
+	db := NewDBWithStore(memory.NewKVStore())
+	defer db.Close()
+
+	root, err := db.MerklizeAndCommit(randomPairs(t, 4))
+	require.NoError(t, err)
+
+	updated, err := db.Update(root, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, root, updated)
+}
+
+func TestUpdate_MissingNode(t *testing.T) {
+	db := NewDBWithStore(memory.NewKVStore())
+	defer db.Close()
+
+	_, err := db.Update(crypto.Hash{1}, [][2][]byte{{make([]byte, StateKeySize), []byte("value")}}, nil)
+	require.ErrorIs(t, err, ErrNodeNotFound)
+}
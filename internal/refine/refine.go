@@ -26,6 +26,11 @@ type Refine struct {
 	state state.State
 }
 
+// New creates the Refine invocation on top of the given state
+func New(s state.State) *Refine {
+	return &Refine{state: s}
+}
+
 // InvokePVM ΨR(N,P,Y, ⟦⟦G⟧⟧, N) → (Y ∪ J, ⟦Y⟧)
 func (r *Refine) InvokePVM(
 	itemIndex uint32, // i
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+// encodedAccount is the encoding of a service account with its full storage
+// and preimage metadata keys, which the state serialization truncates
+type encodedAccount struct {
+	Storage                map[crypto.Hash][]byte
+	PreimageLookup         map[crypto.Hash][]byte
+	PreimageMeta           []encodedPreimageMeta // Ordered by hash and length
+	CodeHash               crypto.Hash
+	Balance                uint64
+	GasLimitForAccumulator uint64
+	GasLimitOnTransfer     uint64
+}
+
+type encodedPreimageMeta struct {
+	Key       PreImageMetaKey
+	Timeslots PreimageHistoricalTimeslots
+}
+
+// Encode encodes the service state without losing any of it, unlike the
+// state serialization
+func (ss ServiceState) Encode() ([]byte, error) {
+	encoded := make(map[block.ServiceId]encodedAccount, len(ss))
+	for id, account := range ss {
+		e := encodedAccount{
+			Storage:                account.Storage,
+			PreimageLookup:         account.PreimageLookup,
+			PreimageMeta:           make([]encodedPreimageMeta, 0, len(account.PreimageMeta)),
+			CodeHash:               account.CodeHash,
+			Balance:                account.Balance,
+			GasLimitForAccumulator: account.GasLimitForAccumulator,
+			GasLimitOnTransfer:     account.GasLimitOnTransfer,
+		}
+		for key, timeslots := range account.PreimageMeta {
+			e.PreimageMeta = append(e.PreimageMeta, encodedPreimageMeta{Key: key, Timeslots: timeslots})
+		}
+		sort.Slice(e.PreimageMeta, func(i, j int) bool {
+			a, b := e.PreimageMeta[i].Key, e.PreimageMeta[j].Key
+			if c := bytes.Compare(a.Hash[:], b.Hash[:]); c != 0 {
+				return c < 0
+			}
+			return a.Length < b.Length
+		})
+		encoded[id] = e
+	}
+	return jam.Marshal(encoded)
+}
+
+// DecodeServiceState decodes a service state encoded with ServiceState.Encode
+func DecodeServiceState(b []byte) (ServiceState, error) {
+	var encoded map[block.ServiceId]encodedAccount
+	if err := jam.Unmarshal(b, &encoded); err != nil {
+		return nil, err
+	}
+	ss := make(ServiceState, len(encoded))
+	for id, e := range encoded {
+		account := ServiceAccount{
+			Storage:                e.Storage,
+			PreimageLookup:         e.PreimageLookup,
+			PreimageMeta:           make(map[PreImageMetaKey]PreimageHistoricalTimeslots, len(e.PreimageMeta)),
+			CodeHash:               e.CodeHash,
+			Balance:                e.Balance,
+			GasLimitForAccumulator: e.GasLimitForAccumulator,
+			GasLimitOnTransfer:     e.GasLimitOnTransfer,
+		}
+		if account.Storage == nil {
+			account.Storage = make(map[crypto.Hash][]byte)
+		}
+		if account.PreimageLookup == nil {
+			account.PreimageLookup = make(map[crypto.Hash][]byte)
+		}
+		for _, meta := range e.PreimageMeta {
+			// An empty list is a solicited preimage, keep it apart from a missing entry
+			if meta.Timeslots == nil {
+				meta.Timeslots = PreimageHistoricalTimeslots{}
+			}
+			account.PreimageMeta[meta.Key] = meta.Timeslots
+		}
+		ss[id] = account
+	}
+	return ss, nil
+}
@@ -1,6 +1,9 @@
 package service
 
 import (
+	"maps"
+	"slices"
+
 	"github.com/eigerco/strawberry/internal/block"
 	"github.com/eigerco/strawberry/internal/common"
 	"github.com/eigerco/strawberry/internal/crypto"
@@ -28,6 +31,35 @@ type ServiceAccount struct {
 	GasLimitOnTransfer     uint64                                          // Gas limit for on_transfer (m)
 }
 
+// Clone returns a copy of the service state which can be modified without
+// affecting the original
+func (ss ServiceState) Clone() ServiceState {
+	if ss == nil {
+		return nil
+	}
+	clone := make(ServiceState, len(ss))
+	for id, account := range ss {
+		clone[id] = account.Clone()
+	}
+	return clone
+}
+
+// Clone returns a copy of the service account with its own storage, preimage
+// and preimage metadata maps. Stored values are shared, since they are
+// replaced rather than modified in place.
+func (sa ServiceAccount) Clone() ServiceAccount {
+	clone := sa
+	clone.Storage = maps.Clone(sa.Storage)
+	clone.PreimageLookup = maps.Clone(sa.PreimageLookup)
+	if sa.PreimageMeta != nil {
+		clone.PreimageMeta = make(map[PreImageMetaKey]PreimageHistoricalTimeslots, len(sa.PreimageMeta))
+		for key, timeslots := range sa.PreimageMeta {
+			clone.PreimageMeta[key] = slices.Clone(timeslots)
+		}
+	}
+	return clone
+}
+
 // Code returns the actual code of the service account as per Equation (9.4 v0.5.0)
 func (sa ServiceAccount) Code() []byte {
 	if code, exists := sa.PreimageLookup[sa.CodeHash]; exists {
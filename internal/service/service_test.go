@@ -37,3 +37,32 @@ func TestServiceAccount_AddAndLookupPreimage(t *testing.T) {
 	require.NotNil(t, p)
 	require.Equal(t, preimage, p)
 }
+
+func TestServiceState_Encode(t *testing.T) {
+	hash := testutils.RandomHash(t)
+	state := service.ServiceState{
+		1: {
+			Storage:        map[crypto.Hash][]byte{testutils.RandomHash(t): {1, 2}, testutils.RandomHash(t): {}},
+			PreimageLookup: map[crypto.Hash][]byte{hash: {3}},
+			PreimageMeta: map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{
+				{Hash: hash, Length: 1}:                     {5},
+				{Hash: testutils.RandomHash(t), Length: 10}: {},
+				{Hash: testutils.RandomHash(t), Length: 20}: {6, 7},
+			},
+			CodeHash:               hash,
+			Balance:                100,
+			GasLimitForAccumulator: 2,
+			GasLimitOnTransfer:     3,
+		},
+		2: {
+			Storage:        map[crypto.Hash][]byte{},
+			PreimageLookup: map[crypto.Hash][]byte{},
+			PreimageMeta:   map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{},
+		},
+	}
+	encoded, err := state.Encode()
+	require.NoError(t, err)
+	decoded, err := service.DecodeServiceState(encoded)
+	require.NoError(t, err)
+	require.Equal(t, state, decoded)
+}
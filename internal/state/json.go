@@ -0,0 +1,489 @@
+package state
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/service"
+	"github.com/eigerco/strawberry/internal/validator"
+)
+
+// JSON encoding of the state, following the component names of the JAM test
+// vectors. Maps are encoded as lists sorted by key, so that the same state
+// always gives the same JSON.
+
+type stateJSON struct {
+	AuthPools  [common.TotalNumberOfCores][]crypto.Hash     `json:"auth_pools"`
+	AuthQueues PendingAuthorizersQueues                     `json:"auth_queues"`
+	Beta       []blockStateJSON                             `json:"beta"`
+	GammaK     validatorsDataJSON                           `json:"gamma_k"`
+	GammaA     []block.Ticket                               `json:"gamma_a"`
+	GammaS     sealingKeysJSON                              `json:"gamma_s"`
+	GammaZ     crypto.RingCommitment                        `json:"gamma_z"`
+	Psi        judgementsJSON                               `json:"psi"`
+	Eta        EntropyPool                                  `json:"eta"`
+	Iota       validatorsDataJSON                           `json:"iota"`
+	Kappa      validatorsDataJSON                           `json:"kappa"`
+	Lambda     validatorsDataJSON                           `json:"lambda"`
+	Rho        [common.TotalNumberOfCores]*assignmentJSON   `json:"rho"`
+	Tau        jamtime.Timeslot                             `json:"tau"`
+	Chi        privilegedServicesJSON                       `json:"chi"`
+	Pi         validatorStatisticsJSON                      `json:"pi"`
+	Theta      [jamtime.TimeslotsPerEpoch][]readyRecordJSON `json:"theta"`
+	Xi         [jamtime.TimeslotsPerEpoch][]crypto.Hash     `json:"xi"`
+	Accounts   []accountJSON                                `json:"accounts"`
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (s State) MarshalJSON() ([]byte, error) {
+	v := stateJSON{
+		AuthQueues: s.PendingAuthorizersQueues,
+		Beta:       make([]blockStateJSON, len(s.RecentBlocks)),
+		GammaK:     newValidatorsDataJSON(s.ValidatorState.SafroleState.NextValidators),
+		GammaA:     nonNil(s.ValidatorState.SafroleState.TicketAccumulator),
+		GammaZ:     s.ValidatorState.SafroleState.RingCommitment,
+		Psi: judgementsJSON{
+			Good:      nonNil(s.PastJudgements.GoodWorkReports),
+			Bad:       nonNil(s.PastJudgements.BadWorkReports),
+			Wonky:     nonNil(s.PastJudgements.WonkyWorkReports),
+			Offenders: make([]crypto.HexBytes, len(s.PastJudgements.OffendingValidators)),
+		},
+		Eta:    s.EntropyPool,
+		Iota:   newValidatorsDataJSON(s.ValidatorState.QueuedValidators),
+		Kappa:  newValidatorsDataJSON(s.ValidatorState.CurrentValidators),
+		Lambda: newValidatorsDataJSON(s.ValidatorState.ArchivedValidators),
+		Tau:    s.TimeslotIndex,
+		Chi: privilegedServicesJSON{
+			Manager:          s.PrivilegedServices.ManagerServiceId,
+			Assign:           s.PrivilegedServices.AssignServiceId,
+			Designate:        s.PrivilegedServices.DesignateServiceId,
+			AlwaysAccumulate: s.PrivilegedServices.AmountOfGasPerServiceId,
+		},
+		Pi: validatorStatisticsJSON{
+			Current: newValidatorStatisticsJSON(s.ValidatorStatistics[1]),
+			Last:    newValidatorStatisticsJSON(s.ValidatorStatistics[0]),
+		},
+		Accounts: make([]accountJSON, 0, len(s.Services)),
+	}
+	for core, pool := range s.CoreAuthorizersPool {
+		v.AuthPools[core] = nonNil(pool)
+	}
+	for i, recent := range s.RecentBlocks {
+		v.Beta[i] = newBlockStateJSON(recent)
+	}
+	switch keys := s.ValidatorState.SafroleState.SealingKeySeries.Get().(type) {
+	case safrole.TicketsBodies:
+		v.GammaS.Tickets = keys[:]
+	case crypto.EpochKeys:
+		v.GammaS.Keys = keys[:]
+	}
+	for i, key := range s.PastJudgements.OffendingValidators {
+		v.Psi.Offenders[i] = crypto.HexBytes(key)
+	}
+	if v.Chi.AlwaysAccumulate == nil {
+		v.Chi.AlwaysAccumulate = map[block.ServiceId]uint64{}
+	}
+	for core, assignment := range s.CoreAssignments {
+		if assignment != nil && assignment.WorkReport != nil {
+			v.Rho[core] = &assignmentJSON{Report: *assignment.WorkReport, Timeout: assignment.Time}
+		}
+	}
+	for slot, records := range s.AccumulationQueue {
+		v.Theta[slot] = make([]readyRecordJSON, len(records))
+		for i, record := range records {
+			v.Theta[slot][i] = readyRecordJSON{Report: record.WorkReport, Dependencies: sortedHashes(record.Dependencies)}
+		}
+	}
+	for slot, history := range s.AccumulationHistory {
+		v.Xi[slot] = sortedHashes(history)
+	}
+	for id, account := range s.Services {
+		v.Accounts = append(v.Accounts, newAccountJSON(id, account))
+	}
+	sort.Slice(v.Accounts, func(i, j int) bool { return v.Accounts[i].Id < v.Accounts[j].Id })
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (s *State) UnmarshalJSON(data []byte) error {
+	var v stateJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	result := State{
+		Services: make(service.ServiceState, len(v.Accounts)),
+		PrivilegedServices: service.PrivilegedServices{
+			ManagerServiceId:        v.Chi.Manager,
+			AssignServiceId:         v.Chi.Assign,
+			DesignateServiceId:      v.Chi.Designate,
+			AmountOfGasPerServiceId: v.Chi.AlwaysAccumulate,
+		},
+		ValidatorState: validator.ValidatorState{
+			CurrentValidators:  v.Kappa.validatorsData(),
+			ArchivedValidators: v.Lambda.validatorsData(),
+			QueuedValidators:   v.Iota.validatorsData(),
+			SafroleState: safrole.State{
+				NextValidators:    v.GammaK.validatorsData(),
+				TicketAccumulator: v.GammaA,
+				RingCommitment:    v.GammaZ,
+			},
+		},
+		EntropyPool:              v.Eta,
+		PendingAuthorizersQueues: v.AuthQueues,
+		RecentBlocks:             make([]BlockState, len(v.Beta)),
+		TimeslotIndex:            v.Tau,
+		PastJudgements: Judgements{
+			BadWorkReports:      v.Psi.Bad,
+			GoodWorkReports:     v.Psi.Good,
+			WonkyWorkReports:    v.Psi.Wonky,
+			OffendingValidators: make([]ed25519.PublicKey, len(v.Psi.Offenders)),
+		},
+		ValidatorStatistics: validator.ValidatorStatisticsState{
+			statistics(v.Pi.Last),
+			statistics(v.Pi.Current),
+		},
+	}
+	if result.PrivilegedServices.AmountOfGasPerServiceId == nil {
+		result.PrivilegedServices.AmountOfGasPerServiceId = map[block.ServiceId]uint64{}
+	}
+	for core, pool := range v.AuthPools {
+		result.CoreAuthorizersPool[core] = pool
+	}
+	for i, recent := range v.Beta {
+		result.RecentBlocks[i] = recent.blockState()
+	}
+	switch {
+	case v.GammaS.Tickets != nil && v.GammaS.Keys != nil:
+		return fmt.Errorf("gamma_s: both tickets and keys given")
+	case v.GammaS.Tickets != nil:
+		var tickets safrole.TicketsBodies
+		if len(v.GammaS.Tickets) != len(tickets) {
+			return fmt.Errorf("gamma_s: expected %d tickets, got %d", len(tickets), len(v.GammaS.Tickets))
+		}
+		copy(tickets[:], v.GammaS.Tickets)
+		result.ValidatorState.SafroleState.SealingKeySeries.Set(tickets)
+	case v.GammaS.Keys != nil:
+		var keys crypto.EpochKeys
+		if len(v.GammaS.Keys) != len(keys) {
+			return fmt.Errorf("gamma_s: expected %d keys, got %d", len(keys), len(v.GammaS.Keys))
+		}
+		copy(keys[:], v.GammaS.Keys)
+		result.ValidatorState.SafroleState.SealingKeySeries.Set(keys)
+	}
+	for i, key := range v.Psi.Offenders {
+		if len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("psi_o %d: expected %d bytes, got %d", i, ed25519.PublicKeySize, len(key))
+		}
+		result.PastJudgements.OffendingValidators[i] = ed25519.PublicKey(key)
+	}
+	for core, assignment := range v.Rho {
+		if assignment != nil {
+			report := assignment.Report
+			result.CoreAssignments[core] = &Assignment{WorkReport: &report, Time: assignment.Timeout}
+		}
+	}
+	for slot, records := range v.Theta {
+		for _, record := range records {
+			result.AccumulationQueue[slot] = append(result.AccumulationQueue[slot], WorkReportWithUnAccumulatedDependencies{
+				WorkReport:   record.Report,
+				Dependencies: hashSet(record.Dependencies),
+			})
+		}
+	}
+	for slot, history := range v.Xi {
+		result.AccumulationHistory[slot] = hashSet(history)
+	}
+	for _, account := range v.Accounts {
+		if _, ok := result.Services[account.Id]; ok {
+			return fmt.Errorf("accounts: duplicate service %d", account.Id)
+		}
+		result.Services[account.Id] = account.Data.serviceAccount()
+	}
+	*s = result
+	return nil
+}
+
+// validatorsDataJSON encodes absent validator keys as zeroed keys
+type validatorsDataJSON [common.NumberOfValidators]crypto.ValidatorKey
+
+func newValidatorsDataJSON(validators safrole.ValidatorsData) validatorsDataJSON {
+	var v validatorsDataJSON
+	for i, key := range validators {
+		if key != nil {
+			v[i] = *key
+		}
+		if len(v[i].Ed25519) == 0 {
+			v[i].Ed25519 = make(ed25519.PublicKey, ed25519.PublicKeySize)
+		}
+	}
+	return v
+}
+
+func (v validatorsDataJSON) validatorsData() safrole.ValidatorsData {
+	var validators safrole.ValidatorsData
+	for i := range v {
+		key := v[i]
+		validators[i] = &key
+	}
+	return validators
+}
+
+type sealingKeysJSON struct {
+	Tickets []block.Ticket                 `json:"tickets,omitempty"`
+	Keys    []crypto.BandersnatchPublicKey `json:"keys,omitempty"`
+}
+
+type judgementsJSON struct {
+	Good      []crypto.Hash     `json:"psi_g"`
+	Bad       []crypto.Hash     `json:"psi_b"`
+	Wonky     []crypto.Hash     `json:"psi_w"`
+	Offenders []crypto.HexBytes `json:"psi_o"`
+}
+
+type assignmentJSON struct {
+	Report  block.WorkReport `json:"report"`
+	Timeout jamtime.Timeslot `json:"timeout"`
+}
+
+type privilegedServicesJSON struct {
+	Manager          block.ServiceId            `json:"chi_m"`
+	Assign           block.ServiceId            `json:"chi_a"`
+	Designate        block.ServiceId            `json:"chi_v"`
+	AlwaysAccumulate map[block.ServiceId]uint64 `json:"chi_g"`
+}
+
+type validatorStatisticsJSON struct {
+	Current [common.NumberOfValidators]statisticsJSON `json:"current"`
+	Last    [common.NumberOfValidators]statisticsJSON `json:"last"`
+}
+
+type statisticsJSON struct {
+	Blocks        uint32 `json:"blocks"`
+	Tickets       uint64 `json:"tickets"`
+	Preimages     uint64 `json:"pre_images"`
+	PreimagesSize uint64 `json:"pre_images_size"`
+	Guarantees    uint64 `json:"guarantees"`
+	Assurances    uint64 `json:"assurances"`
+}
+
+func newValidatorStatisticsJSON(stats [common.NumberOfValidators]validator.ValidatorStatistics) [common.NumberOfValidators]statisticsJSON {
+	var v [common.NumberOfValidators]statisticsJSON
+	for i, s := range stats {
+		v[i] = statisticsJSON{
+			Blocks:        s.NumOfBlocks,
+			Tickets:       s.NumOfTickets,
+			Preimages:     s.NumOfPreimages,
+			PreimagesSize: s.NumOfBytesAllPreimages,
+			Guarantees:    s.NumOfGuaranteedReports,
+			Assurances:    s.NumOfAvailabilityAssurances,
+		}
+	}
+	return v
+}
+
+func statistics(v [common.NumberOfValidators]statisticsJSON) [common.NumberOfValidators]validator.ValidatorStatistics {
+	var stats [common.NumberOfValidators]validator.ValidatorStatistics
+	for i, s := range v {
+		stats[i] = validator.ValidatorStatistics{
+			NumOfBlocks:                 s.Blocks,
+			NumOfTickets:                s.Tickets,
+			NumOfPreimages:              s.Preimages,
+			NumOfBytesAllPreimages:      s.PreimagesSize,
+			NumOfGuaranteedReports:      s.Guarantees,
+			NumOfAvailabilityAssurances: s.Assurances,
+		}
+	}
+	return stats
+}
+
+type readyRecordJSON struct {
+	Report       block.WorkReport `json:"report"`
+	Dependencies []crypto.Hash    `json:"dependencies"`
+}
+
+type blockStateJSON struct {
+	HeaderHash crypto.Hash    `json:"header_hash"`
+	Mmr        mmrJSON        `json:"mmr"`
+	StateRoot  crypto.Hash    `json:"state_root"`
+	Reported   []reportedJSON `json:"reported"`
+}
+
+type mmrJSON struct {
+	Peaks []*crypto.Hash `json:"peaks"`
+}
+
+type reportedJSON struct {
+	Hash        crypto.Hash `json:"hash"`
+	ExportsRoot crypto.Hash `json:"exports_root"`
+}
+
+func newBlockStateJSON(b BlockState) blockStateJSON {
+	v := blockStateJSON{
+		HeaderHash: b.HeaderHash,
+		Mmr:        mmrJSON{Peaks: nonNil(b.AccumulationResultMMR)},
+		StateRoot:  b.StateRoot,
+		Reported:   make([]reportedJSON, 0, len(b.WorkReportHashes)),
+	}
+	for hash, exportsRoot := range b.WorkReportHashes {
+		v.Reported = append(v.Reported, reportedJSON{Hash: hash, ExportsRoot: exportsRoot})
+	}
+	sort.Slice(v.Reported, func(i, j int) bool {
+		return bytes.Compare(v.Reported[i].Hash[:], v.Reported[j].Hash[:]) < 0
+	})
+	return v
+}
+
+func (v blockStateJSON) blockState() BlockState {
+	b := BlockState{
+		HeaderHash:            v.HeaderHash,
+		StateRoot:             v.StateRoot,
+		AccumulationResultMMR: v.Mmr.Peaks,
+		WorkReportHashes:      make(map[crypto.Hash]crypto.Hash, len(v.Reported)),
+	}
+	for _, reported := range v.Reported {
+		b.WorkReportHashes[reported.Hash] = reported.ExportsRoot
+	}
+	return b
+}
+
+type accountJSON struct {
+	Id   block.ServiceId `json:"id"`
+	Data accountDataJSON `json:"data"`
+}
+
+type accountDataJSON struct {
+	Service    serviceInfoJSON   `json:"service"`
+	Preimages  []preimageJSON    `json:"preimages"`
+	LookupMeta []lookupMetaJSON  `json:"lookup_meta"`
+	Storage    []storageItemJSON `json:"storage"`
+}
+
+// serviceInfoJSON holds the account fields. Bytes and items are derived from
+// the account's storage and preimages, so they are ignored when decoding.
+type serviceInfoJSON struct {
+	CodeHash   crypto.Hash `json:"code_hash"`
+	Balance    uint64      `json:"balance"`
+	MinItemGas uint64      `json:"min_item_gas"`
+	MinMemoGas uint64      `json:"min_memo_gas"`
+	Bytes      uint64      `json:"bytes"`
+	Items      uint32      `json:"items"`
+}
+
+type preimageJSON struct {
+	Hash crypto.Hash     `json:"hash"`
+	Blob crypto.HexBytes `json:"blob"`
+}
+
+type lookupMetaJSON struct {
+	Key   lookupMetaKeyJSON  `json:"key"`
+	Value []jamtime.Timeslot `json:"value"`
+}
+
+type lookupMetaKeyJSON struct {
+	Hash   crypto.Hash            `json:"hash"`
+	Length service.PreimageLength `json:"length"`
+}
+
+type storageItemJSON struct {
+	Key   crypto.Hash     `json:"key"`
+	Value crypto.HexBytes `json:"value"`
+}
+
+func newAccountJSON(id block.ServiceId, account service.ServiceAccount) accountJSON {
+	data := accountDataJSON{
+		Service: serviceInfoJSON{
+			CodeHash:   account.CodeHash,
+			Balance:    account.Balance,
+			MinItemGas: account.GasLimitForAccumulator,
+			MinMemoGas: account.GasLimitOnTransfer,
+			Bytes:      account.TotalStorageSize(),
+			Items:      account.TotalItems(),
+		},
+		Preimages:  make([]preimageJSON, 0, len(account.PreimageLookup)),
+		LookupMeta: make([]lookupMetaJSON, 0, len(account.PreimageMeta)),
+		Storage:    make([]storageItemJSON, 0, len(account.Storage)),
+	}
+	for hash, blob := range account.PreimageLookup {
+		data.Preimages = append(data.Preimages, preimageJSON{Hash: hash, Blob: blob})
+	}
+	sort.Slice(data.Preimages, func(i, j int) bool {
+		return bytes.Compare(data.Preimages[i].Hash[:], data.Preimages[j].Hash[:]) < 0
+	})
+	for key, timeslots := range account.PreimageMeta {
+		data.LookupMeta = append(data.LookupMeta, lookupMetaJSON{
+			Key:   lookupMetaKeyJSON{Hash: key.Hash, Length: key.Length},
+			Value: nonNil(timeslots),
+		})
+	}
+	sort.Slice(data.LookupMeta, func(i, j int) bool {
+		a, b := data.LookupMeta[i].Key, data.LookupMeta[j].Key
+		if c := bytes.Compare(a.Hash[:], b.Hash[:]); c != 0 {
+			return c < 0
+		}
+		return a.Length < b.Length
+	})
+	for key, value := range account.Storage {
+		data.Storage = append(data.Storage, storageItemJSON{Key: key, Value: value})
+	}
+	sort.Slice(data.Storage, func(i, j int) bool {
+		return bytes.Compare(data.Storage[i].Key[:], data.Storage[j].Key[:]) < 0
+	})
+	return accountJSON{Id: id, Data: data}
+}
+
+func (v accountDataJSON) serviceAccount() service.ServiceAccount {
+	account := service.ServiceAccount{
+		Storage:                make(map[crypto.Hash][]byte, len(v.Storage)),
+		PreimageLookup:         make(map[crypto.Hash][]byte, len(v.Preimages)),
+		PreimageMeta:           make(map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots, len(v.LookupMeta)),
+		CodeHash:               v.Service.CodeHash,
+		Balance:                v.Service.Balance,
+		GasLimitForAccumulator: v.Service.MinItemGas,
+		GasLimitOnTransfer:     v.Service.MinMemoGas,
+	}
+	for _, preimage := range v.Preimages {
+		account.PreimageLookup[preimage.Hash] = preimage.Blob
+	}
+	for _, meta := range v.LookupMeta {
+		account.PreimageMeta[service.PreImageMetaKey{Hash: meta.Key.Hash, Length: meta.Key.Length}] = meta.Value
+	}
+	for _, item := range v.Storage {
+		account.Storage[item.Key] = item.Value
+	}
+	return account
+}
+
+func sortedHashes(set map[crypto.Hash]struct{}) []crypto.Hash {
+	hashes := make([]crypto.Hash, 0, len(set))
+	for hash := range set {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	return hashes
+}
+
+func hashSet(hashes []crypto.Hash) map[crypto.Hash]struct{} {
+	set := make(map[crypto.Hash]struct{}, len(hashes))
+	for _, hash := range hashes {
+		set[hash] = struct{}{}
+	}
+	return set
+}
+
+// nonNil returns an empty slice for nil, which is encoded as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/internal/state"
+)
+
+// StateDelta is the difference between the serializations of two states, as
+// produced by SerializeState
+type StateDelta struct {
+	Updated map[crypto.Hash][]byte // Added or changed state keys with their posterior values
+	Removed []crypto.Hash          // State keys which no longer exist, in ascending order
+}
+
+// NewStateDelta computes the delta which turns the prior serialized state into the posterior one
+func NewStateDelta(prior, posterior map[crypto.Hash][]byte) StateDelta {
+	delta := StateDelta{Updated: make(map[crypto.Hash][]byte)}
+	for key, value := range posterior {
+		if priorValue, ok := prior[key]; !ok || !bytes.Equal(priorValue, value) {
+			delta.Updated[key] = value
+		}
+	}
+	for key := range prior {
+		if _, ok := posterior[key]; !ok {
+			delta.Removed = append(delta.Removed, key)
+		}
+	}
+	sort.Slice(delta.Removed, func(i, j int) bool {
+		return bytes.Compare(delta.Removed[i][:], delta.Removed[j][:]) < 0
+	})
+	return delta
+}
+
+// StateChanges lists the parts of a state which a state transition may have modified
+type StateChanges struct {
+	Components []uint8           // Indices of the basic state components
+	Services   []block.ServiceId // Service accounts, including the created and removed ones
+}
+
+// NewStateDeltaFromChanges computes the delta which turns the prior state into
+// the posterior one, only serializing the components and services listed in
+// the changes. The rest of the two states must be the same.
+func NewStateDeltaFromChanges(prior, posterior state.State, changes StateChanges) (StateDelta, error) {
+	delta := StateDelta{Updated: make(map[crypto.Hash][]byte)}
+	for _, key := range changes.Components {
+		priorValue, err := serializeComponent(prior, key)
+		if err != nil {
+			return StateDelta{}, err
+		}
+		posteriorValue, err := serializeComponent(posterior, key)
+		if err != nil {
+			return StateDelta{}, err
+		}
+		if !bytes.Equal(priorValue, posteriorValue) {
+			delta.Updated[generateStateKeyBasic(key)] = posteriorValue
+		}
+	}
+
+	priorServices := make(map[crypto.Hash][]byte)
+	posteriorServices := make(map[crypto.Hash][]byte)
+	for _, serviceId := range changes.Services {
+		if account, ok := prior.Services[serviceId]; ok {
+			if err := serializeServiceAccount(serviceId, account, priorServices); err != nil {
+				return StateDelta{}, err
+			}
+		}
+		if account, ok := posterior.Services[serviceId]; ok {
+			if err := serializeServiceAccount(serviceId, account, posteriorServices); err != nil {
+				return StateDelta{}, err
+			}
+		}
+	}
+	servicesDelta := NewStateDelta(priorServices, posteriorServices)
+	maps.Copy(delta.Updated, servicesDelta.Updated)
+	delta.Removed = servicesDelta.Removed
+	return delta, nil
+}
+
+// Empty tells whether the two states are the same
+func (d StateDelta) Empty() bool {
+	return len(d.Updated) == 0 && len(d.Removed) == 0
+}
+
+// Components returns the indices of the changed basic state components, in ascending order
+func (d StateDelta) Components() ([]uint8, error) {
+	var components []uint8
+	err := d.classify(func(info StateKeyInfo) {
+		if info.Kind == StateKeyComponent {
+			components = append(components, info.Component)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(components)
+	return slices.Compact(components), nil
+}
+
+// Services returns the IDs of the services with changed state keys, in ascending order
+func (d StateDelta) Services() ([]block.ServiceId, error) {
+	var services []block.ServiceId
+	err := d.classify(func(info StateKeyInfo) {
+		if info.Kind != StateKeyComponent {
+			services = append(services, info.ServiceId)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(services)
+	return slices.Compact(services), nil
+}
+
+func (d StateDelta) classify(f func(StateKeyInfo)) error {
+	for key := range d.Updated {
+		info, err := ClassifyStateKey(key)
+		if err != nil {
+			return err
+		}
+		f(info)
+	}
+	for _, key := range d.Removed {
+		info, err := ClassifyStateKey(key)
+		if err != nil {
+			return err
+		}
+		f(info)
+	}
+	return nil
+}
+
+// Apply returns the posterior serialized state from the prior one. The prior
+// state is not modified.
+func (d StateDelta) Apply(prior map[crypto.Hash][]byte) map[crypto.Hash][]byte {
+	posterior := maps.Clone(prior)
+	if posterior == nil {
+		posterior = make(map[crypto.Hash][]byte, len(d.Updated))
+	}
+	for _, key := range d.Removed {
+		delete(posterior, key)
+	}
+	maps.Copy(posterior, d.Updated)
+	return posterior
+}
+
+// MerklizeStateDelta computes the Merkle root of the posterior state from the
+// root of the prior state, only updating the trie for the keys in the delta
+func MerklizeStateDelta(priorRoot crypto.Hash, delta StateDelta, store *trie.DB) (crypto.Hash, error) {
+	inserts := make([][2][]byte, 0, len(delta.Updated))
+	for key, value := range delta.Updated {
+		inserts = append(inserts, [2][]byte{key[:], value})
+	}
+	deletes := make([][]byte, len(delta.Removed))
+	for i, key := range delta.Removed {
+		deletes[i] = key[:]
+	}
+	return store.Update(priorRoot, inserts, deletes)
+}
@@ -0,0 +1,60 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+)
+
+func TestStateDelta(t *testing.T) {
+	prior := RandomState(t)
+	posterior := prior.Clone()
+	posterior.TimeslotIndex++
+	posterior.EntropyPool[0] = crypto.Hash{1}
+	delete(posterior.Services, 789)
+	account := posterior.Services[790]
+	account.Storage[crypto.Hash{1}] = []byte{1, 2, 3}
+	posterior.Services[790] = account
+
+	priorSerialized, err := SerializeState(prior)
+	require.NoError(t, err)
+	posteriorSerialized, err := SerializeState(posterior)
+	require.NoError(t, err)
+
+	delta := NewStateDelta(priorSerialized, posteriorSerialized)
+	assert.False(t, delta.Empty())
+	assert.True(t, NewStateDelta(priorSerialized, priorSerialized).Empty())
+	assert.Equal(t, posteriorSerialized, delta.Apply(priorSerialized))
+
+	components, err := delta.Components()
+	require.NoError(t, err)
+	assert.Equal(t, []uint8{6, 11}, components)
+	services, err := delta.Services()
+	require.NoError(t, err)
+	assert.Equal(t, []block.ServiceId{789, 790}, services)
+
+	// Only serializing the changed parts gives the same delta
+	changed, err := NewStateDeltaFromChanges(prior, posterior, StateChanges{
+		Components: []uint8{6, 10, 11},
+		Services:   []block.ServiceId{789, 790},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, delta, changed)
+
+	// Updating the trie with the delta gives the root of the posterior state
+	trieDB, err := trie.NewDB()
+	require.NoError(t, err)
+	defer trieDB.Close()
+	priorRoot, err := MerklizeState(prior, trieDB)
+	require.NoError(t, err)
+	root, err := MerklizeStateDelta(priorRoot, delta, trieDB)
+	require.NoError(t, err)
+	expectedRoot, err := MerklizeState(posterior, trieDB)
+	require.NoError(t, err)
+	assert.Equal(t, expectedRoot, root)
+}
@@ -0,0 +1,341 @@
+package state
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/service"
+	"github.com/eigerco/strawberry/internal/state"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+var (
+	ErrMissingStateKey     = errors.New("missing state key")
+	ErrUnknownStateKey     = errors.New("unknown state key")
+	ErrMalformedStateValue = errors.New("malformed state value")
+	// ErrAmbiguousPreimageMeta is returned when a service has several preimage
+	// metadata entries without a matching preimage, the lengths of which cannot
+	// be told apart from the footprint of the account
+	ErrAmbiguousPreimageMeta = errors.New("ambiguous preimage metadata lengths")
+)
+
+// Markers found in the odd bytes of the first 8 bytes of service storage and preimage lookup keys
+var (
+	storageKeyMarker        = [4]byte{0xff, 0xff, 0xff, 0xff} // E4(2^32-1)
+	preimageLookupKeyMarker = [4]byte{0xfe, 0xff, 0xff, 0xff} // E4(2^32-2)
+)
+
+// serviceAccountEntry is the value stored under the key of a service account
+type serviceAccountEntry struct {
+	CodeHash               crypto.Hash
+	Balance                uint64
+	GasLimitForAccumulator uint64
+	GasLimitOnTransfer     uint64
+	FootprintSize          uint64
+	FootprintItems         int
+}
+
+// DeserializeState rebuilds a state from its serialization, the inverse of SerializeState.
+// Graypaper 0.5.4.
+//
+// The serialization does not retain everything: storage keys only keep their
+// first 24 bytes, the rest is zeroed, and preimage metadata keys keep the first
+// 28 bytes of the hash, not the length. The full hash and the length of a
+// preimage metadata entry are recovered from the matching preimage when it is
+// present, otherwise the length is derived from the footprint of the account,
+// which is only possible for a single such entry per service. The resulting
+// state serializes back to the same key-values.
+func DeserializeState(serializedState map[crypto.Hash][]byte) (state.State, error) {
+	deserializedState := state.State{
+		Services: make(service.ServiceState),
+	}
+
+	basicFields := map[uint8]interface{}{
+		1:  &deserializedState.CoreAuthorizersPool,
+		2:  &deserializedState.PendingAuthorizersQueues,
+		3:  &deserializedState.RecentBlocks,
+		4:  &deserializedState.ValidatorState.SafroleState,
+		6:  &deserializedState.EntropyPool,
+		7:  &deserializedState.ValidatorState.QueuedValidators,
+		8:  &deserializedState.ValidatorState.CurrentValidators,
+		9:  &deserializedState.ValidatorState.ArchivedValidators,
+		10: &deserializedState.CoreAssignments,
+		11: &deserializedState.TimeslotIndex,
+		12: &deserializedState.PrivilegedServices,
+		13: &deserializedState.ValidatorStatistics,
+		14: &deserializedState.AccumulationQueue,
+		15: &deserializedState.AccumulationHistory,
+	}
+	for i := uint8(1); i <= 15; i++ {
+		stateKey := generateStateKeyBasic(i)
+		encodedValue, ok := serializedState[stateKey]
+		if !ok {
+			return state.State{}, fmt.Errorf("%w: %x", ErrMissingStateKey, stateKey)
+		}
+		if i == 5 {
+			if err := deserializeJudgements(&deserializedState, encodedValue); err != nil {
+				return state.State{}, err
+			}
+			continue
+		}
+		if err := jam.Unmarshal(encodedValue, basicFields[i]); err != nil {
+			return state.State{}, fmt.Errorf("%w: state component %d: %w", ErrMalformedStateValue, i, err)
+		}
+	}
+
+	for stateKey := range serializedState {
+		if isBasicKey(stateKey) && (stateKey[0] == 0 || stateKey[0] > 15) {
+			return state.State{}, fmt.Errorf("%w: %x", ErrUnknownStateKey, stateKey)
+		}
+	}
+
+	// Service accounts first, their storage and preimages are added after
+	entries := make(map[block.ServiceId]serviceAccountEntry)
+	for stateKey, encodedValue := range serializedState {
+		if isBasicKey(stateKey) || !isServiceAccountKey(stateKey) {
+			continue
+		}
+		serviceId, err := extractServiceIdFromKey(stateKey)
+		if err != nil {
+			return state.State{}, err
+		}
+		var entry serviceAccountEntry
+		if err := jam.Unmarshal(encodedValue, &entry); err != nil {
+			return state.State{}, fmt.Errorf("%w: service %d: %w", ErrMalformedStateValue, serviceId, err)
+		}
+		entries[serviceId] = entry
+		deserializedState.Services[serviceId] = service.ServiceAccount{
+			Storage:                make(map[crypto.Hash][]byte),
+			PreimageLookup:         make(map[crypto.Hash][]byte),
+			PreimageMeta:           make(map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots),
+			CodeHash:               entry.CodeHash,
+			Balance:                entry.Balance,
+			GasLimitForAccumulator: entry.GasLimitForAccumulator,
+			GasLimitOnTransfer:     entry.GasLimitOnTransfer,
+		}
+	}
+
+	// Preimage metadata is resolved once all preimages are known
+	pendingMeta := make(map[block.ServiceId]map[crypto.Hash]service.PreimageHistoricalTimeslots)
+	for stateKey, encodedValue := range serializedState {
+		if isBasicKey(stateKey) || isServiceAccountKey(stateKey) {
+			continue
+		}
+		serviceId, marker, rest, err := splitInterleavedKey(stateKey)
+		if err != nil {
+			return state.State{}, err
+		}
+		account, ok := deserializedState.Services[serviceId]
+		if !ok {
+			return state.State{}, fmt.Errorf("%w: %x belongs to unknown service %d", ErrUnknownStateKey, stateKey, serviceId)
+		}
+
+		switch marker {
+		case storageKeyMarker:
+			var value []byte
+			if err := jam.Unmarshal(encodedValue, &value); err != nil {
+				return state.State{}, fmt.Errorf("%w: storage of service %d: %w", ErrMalformedStateValue, serviceId, err)
+			}
+			var hash crypto.Hash
+			copy(hash[:], rest)
+			account.Storage[hash] = value
+		case preimageLookupKeyMarker:
+			var preimage []byte
+			if err := jam.Unmarshal(encodedValue, &preimage); err != nil {
+				return state.State{}, fmt.Errorf("%w: preimage of service %d: %w", ErrMalformedStateValue, serviceId, err)
+			}
+			hash := crypto.HashData(preimage)
+			if !bytes.Equal(hash[1:25], rest) {
+				return state.State{}, fmt.Errorf("%w: preimage of service %d does not match its key %x", ErrMalformedStateValue, serviceId, stateKey)
+			}
+			account.PreimageLookup[hash] = preimage
+		default:
+			var timeslots service.PreimageHistoricalTimeslots
+			if err := jam.Unmarshal(encodedValue, &timeslots); err != nil {
+				return state.State{}, fmt.Errorf("%w: preimage metadata of service %d: %w", ErrMalformedStateValue, serviceId, err)
+			}
+			var hash crypto.Hash
+			copy(hash[:4], marker[:])
+			copy(hash[4:], rest)
+			if pendingMeta[serviceId] == nil {
+				pendingMeta[serviceId] = make(map[crypto.Hash]service.PreimageHistoricalTimeslots)
+			}
+			pendingMeta[serviceId][hash] = timeslots
+		}
+	}
+
+	for serviceId, account := range deserializedState.Services {
+		if err := resolvePreimageMeta(serviceId, account, pendingMeta[serviceId], entries[serviceId]); err != nil {
+			return state.State{}, err
+		}
+	}
+
+	return deserializedState, nil
+}
+
+// resolvePreimageMeta adds the preimage metadata to the account, keyed by the
+// hash and length of the preimage, and checks the account footprint
+func resolvePreimageMeta(serviceId block.ServiceId, account service.ServiceAccount, meta map[crypto.Hash]service.PreimageHistoricalTimeslots, entry serviceAccountEntry) error {
+	// Metadata keys keep the first 28 bytes of the preimage hash
+	preimages := make(map[crypto.Hash]crypto.Hash, len(account.PreimageLookup))
+	for hash := range account.PreimageLookup {
+		var prefix crypto.Hash
+		copy(prefix[:28], hash[:28])
+		preimages[prefix] = hash
+	}
+
+	var unmatched []crypto.Hash
+	for prefix, timeslots := range meta {
+		hash, ok := preimages[prefix]
+		if !ok {
+			unmatched = append(unmatched, prefix)
+			continue
+		}
+		key := service.PreImageMetaKey{Hash: hash, Length: service.PreimageLength(len(account.PreimageLookup[hash]))}
+		account.PreimageMeta[key] = timeslots
+	}
+
+	footprint := calculateFootprintSize(account.Storage, account.PreimageMeta)
+	switch {
+	case len(unmatched) == 1:
+		size := uint64(81)
+		if entry.FootprintSize < footprint+size || entry.FootprintSize-footprint-size > math.MaxUint32 {
+			return fmt.Errorf("%w: footprint of service %d does not fit its items", ErrMalformedStateValue, serviceId)
+		}
+		key := service.PreImageMetaKey{Hash: unmatched[0], Length: service.PreimageLength(entry.FootprintSize - footprint - size)}
+		account.PreimageMeta[key] = meta[unmatched[0]]
+	case len(unmatched) > 1:
+		return fmt.Errorf("%w: service %d", ErrAmbiguousPreimageMeta, serviceId)
+	case footprint != entry.FootprintSize:
+		return fmt.Errorf("%w: footprint of service %d is %d, items add up to %d", ErrMalformedStateValue, serviceId, entry.FootprintSize, footprint)
+	}
+
+	if items := 2*len(account.PreimageMeta) + len(account.Storage); items != entry.FootprintItems {
+		return fmt.Errorf("%w: service %d has %d items, %d recorded", ErrMalformedStateValue, serviceId, items, entry.FootprintItems)
+	}
+	return nil
+}
+
+func deserializeJudgements(state *state.State, encodedValue []byte) error {
+	var combined struct {
+		GoodWorkReports     []crypto.Hash
+		BadWorkReports      []crypto.Hash
+		WonkyWorkReports    []crypto.Hash
+		OffendingValidators []ed25519.PublicKey
+	}
+	if err := jam.Unmarshal(encodedValue, &combined); err != nil {
+		return fmt.Errorf("%w: past judgements: %w", ErrMalformedStateValue, err)
+	}
+
+	state.PastJudgements.GoodWorkReports = combined.GoodWorkReports
+	state.PastJudgements.BadWorkReports = combined.BadWorkReports
+	state.PastJudgements.WonkyWorkReports = combined.WonkyWorkReports
+	state.PastJudgements.OffendingValidators = combined.OffendingValidators
+	return nil
+}
+
+// isBasicKey tells whether the key has the layout of the state component
+// keys, a single index byte followed by zeroes
+func isBasicKey(stateKey crypto.Hash) bool {
+	for _, b := range stateKey[1:] {
+		if b != 0 {
+			return false
+		}
+	}
+	return stateKey[0] != 255
+}
+
+// isServiceAccountKey tells whether the key is the key of a service account,
+// the service ID interleaved with zeroes after the 255 prefix
+func isServiceAccountKey(stateKey crypto.Hash) bool {
+	if stateKey[0] != 255 || stateKey[2] != 0 || stateKey[4] != 0 || stateKey[6] != 0 {
+		return false
+	}
+	for _, b := range stateKey[8:] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// extractServiceIdFromKey returns the service ID of a service account key
+func extractServiceIdFromKey(stateKey crypto.Hash) (block.ServiceId, error) {
+	// Collect service ID bytes from positions 1,3,5,7 into a slice
+	encodedServiceId := []byte{
+		stateKey[1],
+		stateKey[3],
+		stateKey[5],
+		stateKey[7],
+	}
+
+	var serviceId block.ServiceId
+	if err := jam.Unmarshal(encodedServiceId, &serviceId); err != nil {
+		return 0, err
+	}
+
+	return serviceId, nil
+}
+
+// splitInterleavedKey splits a key built by generateStateKeyInterleaved into
+// the service ID, the first 4 bytes of the interleaved hash and the rest of it
+func splitInterleavedKey(stateKey crypto.Hash) (block.ServiceId, [4]byte, []byte, error) {
+	var serviceId block.ServiceId
+	if err := jam.Unmarshal([]byte{stateKey[0], stateKey[2], stateKey[4], stateKey[6]}, &serviceId); err != nil {
+		return 0, [4]byte{}, nil, err
+	}
+	marker := [4]byte{stateKey[1], stateKey[3], stateKey[5], stateKey[7]}
+	return serviceId, marker, stateKey[8:], nil
+}
+
+// StateKeyKind tells which part of the state a state key belongs to
+type StateKeyKind int
+
+const (
+	StateKeyComponent      StateKeyKind = iota // One of the basic state components
+	StateKeyServiceAccount                     // The account of a service
+	StateKeyStorage                            // A storage item of a service
+	StateKeyPreimage                           // A preimage of a service
+	StateKeyPreimageMeta                       // The metadata of a preimage of a service
+)
+
+// StateKeyInfo describes a state key
+type StateKeyInfo struct {
+	Kind      StateKeyKind
+	Component uint8           // Index of the state component, for StateKeyComponent
+	ServiceId block.ServiceId // Service the key belongs to, for the service kinds
+}
+
+// ClassifyStateKey tells which part of the state a key belongs to, following
+// the key layout of SerializeState
+func ClassifyStateKey(stateKey crypto.Hash) (StateKeyInfo, error) {
+	if isBasicKey(stateKey) {
+		if stateKey[0] == 0 || stateKey[0] > 15 {
+			return StateKeyInfo{}, fmt.Errorf("%w: %x", ErrUnknownStateKey, stateKey)
+		}
+		return StateKeyInfo{Kind: StateKeyComponent, Component: stateKey[0]}, nil
+	}
+	if isServiceAccountKey(stateKey) {
+		serviceId, err := extractServiceIdFromKey(stateKey)
+		if err != nil {
+			return StateKeyInfo{}, err
+		}
+		return StateKeyInfo{Kind: StateKeyServiceAccount, ServiceId: serviceId}, nil
+	}
+	serviceId, marker, _, err := splitInterleavedKey(stateKey)
+	if err != nil {
+		return StateKeyInfo{}, err
+	}
+	switch marker {
+	case storageKeyMarker:
+		return StateKeyInfo{Kind: StateKeyStorage, ServiceId: serviceId}, nil
+	case preimageLookupKeyMarker:
+		return StateKeyInfo{Kind: StateKeyPreimage, ServiceId: serviceId}, nil
+	}
+	return StateKeyInfo{Kind: StateKeyPreimageMeta, ServiceId: serviceId}, nil
+}
@@ -0,0 +1,48 @@
+package state
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/state"
+)
+
+func TestStateJSON_RoundTrip(t *testing.T) {
+	original := RandomState(t)
+	data, err := json.Marshal(original)
+	require.NoError(t, err)
+
+	var decoded state.State
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	// The decoded state serializes to the same state keys and values
+	expected, err := SerializeState(original)
+	require.NoError(t, err)
+	actual, err := SerializeState(decoded)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// and encodes to the same JSON
+	reencoded, err := json.Marshal(decoded)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(reencoded))
+}
+
+func TestStateJSON_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"malformed":         `{`,
+		"short hash":        `{"tau": 1, "eta": ["0x01"]}`,
+		"short offender":    `{"psi": {"psi_o": ["0x01"]}}`,
+		"short gamma_s":     `{"gamma_s": {"keys": []}}`,
+		"duplicate account": `{"accounts": [{"id": 1, "data": {}}, {"id": 1, "data": {}}]}`,
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			var s state.State
+			assert.Error(t, json.Unmarshal([]byte(input), &s))
+		})
+	}
+}
@@ -1,9 +1,10 @@
 package state
 
 import (
-	"github.com/eigerco/strawberry/internal/merkle/trie"
 	"testing"
 
+	"github.com/eigerco/strawberry/internal/merkle/trie"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
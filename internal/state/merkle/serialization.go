@@ -1,64 +1,31 @@
 package state
 
 import (
+	"fmt"
+	"math"
+
 	"github.com/eigerco/strawberry/internal/block"
 	"github.com/eigerco/strawberry/internal/crypto"
 	"github.com/eigerco/strawberry/internal/service"
 	"github.com/eigerco/strawberry/internal/state"
 	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
-	"math"
 )
 
+// componentKeys are the indices of the basic state components, C(i) with i in 1...15
+var componentKeys = []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
 // SerializeState serializes the given state into a map of crypto.Hash to byte arrays, for merklization.
 // Graypaper 0.5.4.
 func SerializeState(state state.State) (map[crypto.Hash][]byte, error) {
 	serializedState := make(map[crypto.Hash][]byte)
 
-	// Helper function to serialize individual fields
-	serializeField := func(key uint8, value interface{}) error {
-		stateKey := generateStateKeyBasic(key)
-		encodedValue, err := jam.Marshal(value)
-		if err != nil {
-			return err
-		}
-		serializedState[stateKey] = encodedValue
-		return nil
-	}
-
 	// Serialize basic fields
-	basicFields := []struct {
-		key   uint8
-		value interface{}
-	}{
-		{1, state.CoreAuthorizersPool},
-		{2, state.PendingAuthorizersQueues},
-		{3, state.RecentBlocks},
-		{6, state.EntropyPool},
-		{7, state.ValidatorState.QueuedValidators},
-		{8, state.ValidatorState.CurrentValidators},
-		{9, state.ValidatorState.ArchivedValidators},
-		{10, state.CoreAssignments},
-		{11, state.TimeslotIndex},
-		{12, state.PrivilegedServices},
-		{13, state.ValidatorStatistics},
-		{14, state.AccumulationQueue},
-		{15, state.AccumulationHistory},
-	}
-
-	for _, field := range basicFields {
-		if err := serializeField(field.key, field.value); err != nil {
+	for _, key := range componentKeys {
+		encodedValue, err := serializeComponent(state, key)
+		if err != nil {
 			return nil, err
 		}
-	}
-
-	// Serialize SafroleState specific fields
-	if err := serializeSafroleState(state, serializedState); err != nil {
-		return nil, err
-	}
-
-	// Serialize Past Judgements
-	if err := serializeJudgements(state, serializedState); err != nil {
-		return nil, err
+		serializedState[generateStateKeyBasic(key)] = encodedValue
 	}
 
 	// Serialize Services
@@ -71,45 +38,68 @@ func SerializeState(state state.State) (map[crypto.Hash][]byte, error) {
 	return serializedState, nil
 }
 
-func serializeSafroleState(state state.State, serializedState map[crypto.Hash][]byte) error {
-	encodedSafroleState, err := jam.Marshal(state.ValidatorState.SafroleState)
-	if err != nil {
-		return err
-	}
-
-	stateKey := generateStateKeyBasic(4)
-	serializedState[stateKey] = encodedSafroleState
-	return nil
+// serializeComponent serializes the basic state component stored at C(key)
+func serializeComponent(state state.State, key uint8) ([]byte, error) {
+	switch key {
+	case 1:
+		return jam.Marshal(state.CoreAuthorizersPool)
+	case 2:
+		return jam.Marshal(state.PendingAuthorizersQueues)
+	case 3:
+		return jam.Marshal(state.RecentBlocks)
+	case 4:
+		return jam.Marshal(state.ValidatorState.SafroleState)
+	case 5:
+		return serializeJudgements(state)
+	case 6:
+		return jam.Marshal(state.EntropyPool)
+	case 7:
+		return jam.Marshal(state.ValidatorState.QueuedValidators)
+	case 8:
+		return jam.Marshal(state.ValidatorState.CurrentValidators)
+	case 9:
+		return jam.Marshal(state.ValidatorState.ArchivedValidators)
+	case 10:
+		return jam.Marshal(state.CoreAssignments)
+	case 11:
+		return jam.Marshal(state.TimeslotIndex)
+	case 12:
+		return jam.Marshal(state.PrivilegedServices)
+	case 13:
+		return jam.Marshal(state.ValidatorStatistics)
+	case 14:
+		return jam.Marshal(state.AccumulationQueue)
+	case 15:
+		return jam.Marshal(state.AccumulationHistory)
+	}
+	return nil, fmt.Errorf("unknown state component %d", key)
 }
 
-func serializeJudgements(state state.State, serializedState map[crypto.Hash][]byte) error {
+func serializeJudgements(state state.State) ([]byte, error) {
 	sortedGoodWorkReports := sortByteSlicesCopy(state.PastJudgements.GoodWorkReports)
 	encodedGoodWorkReports, err := jam.Marshal(sortedGoodWorkReports)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	encodedBadWorkReports, err := jam.Marshal(sortByteSlicesCopy(state.PastJudgements.BadWorkReports))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	encodedWonkyWorkReports, err := jam.Marshal(sortByteSlicesCopy(state.PastJudgements.WonkyWorkReports))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	encodedOffendingValidators, err := jam.Marshal(sortByteSlicesCopy(state.PastJudgements.OffendingValidators))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	combined := combineEncoded(
+	return combineEncoded(
 		encodedGoodWorkReports,
 		encodedBadWorkReports,
 		encodedWonkyWorkReports,
 		encodedOffendingValidators,
-	)
-	stateKey := generateStateKeyBasic(5)
-	serializedState[stateKey] = combined
-	return nil
+	), nil
 }
 
 func serializeServiceAccount(serviceId block.ServiceId, serviceAccount service.ServiceAccount, serializedState map[crypto.Hash][]byte) error {
@@ -165,7 +155,7 @@ func serializeServiceAccount(serviceId block.ServiceId, serviceAccount service.S
 }
 
 func serializeStorageAndPreimage(serviceId block.ServiceId, serviceAccount service.ServiceAccount, serializedState map[crypto.Hash][]byte) error {
-	encodedMaxUint32, err := jam.Marshal(math.MaxUint32)
+	encodedMaxUint32, err := jam.Marshal(uint32(math.MaxUint32)) // E4(2^32-1)
 	if err != nil {
 		return err
 	}
@@ -185,7 +175,7 @@ func serializeStorageAndPreimage(serviceId block.ServiceId, serviceAccount servi
 		serializedState[stateKey] = encodedValue
 	}
 
-	encodedMaxUint32MinusOne, err := jam.Marshal(math.MaxUint32 - 1)
+	encodedMaxUint32MinusOne, err := jam.Marshal(uint32(math.MaxUint32 - 1)) // E4(2^32-2)
 	if err != nil {
 		return err
 	}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/eigerco/strawberry/internal/crypto"
 	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/service"
+	"github.com/eigerco/strawberry/internal/testutils"
 	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
 )
 
@@ -287,3 +289,98 @@ func TestSerializeStateServices(t *testing.T) {
 		assert.NotEmpty(t, serializedState[hashKey])
 	}
 }
+
+func TestDeserializeState_RoundTrip(t *testing.T) {
+	state := RandomState(t)
+	// Preimage metadata matching a stored preimage, recovered from the preimage
+	account := state.Services[789]
+	for hash, preimage := range account.PreimageLookup {
+		account.PreimageMeta[service.PreImageMetaKey{Hash: hash, Length: service.PreimageLength(len(preimage))}] = service.PreimageHistoricalTimeslots{testutils.RandomTimeslot()}
+	}
+
+	encodedState, err := SerializeState(state)
+	require.NoError(t, err)
+	decodedState, err := DeserializeState(encodedState)
+	require.NoError(t, err)
+
+	reencodedState, err := SerializeState(decodedState)
+	require.NoError(t, err)
+	assert.Equal(t, encodedState, reencodedState)
+
+	// Storage keys only keep their first 24 bytes
+	for serviceId, original := range state.Services {
+		decoded := decodedState.Services[serviceId]
+		require.Len(t, decoded.Storage, len(original.Storage))
+		for hash, value := range original.Storage {
+			var truncated crypto.Hash
+			copy(truncated[:24], hash[:24])
+			assert.Equal(t, value, decoded.Storage[truncated])
+		}
+		assert.Equal(t, original.PreimageLookup, decoded.PreimageLookup)
+		// Metadata without a stored preimage only keeps the first 28 bytes of the hash
+		expectedMeta := make(map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots)
+		for key, timeslots := range original.PreimageMeta {
+			if _, ok := original.PreimageLookup[key.Hash]; !ok {
+				clear(key.Hash[28:])
+			}
+			expectedMeta[key] = timeslots
+		}
+		assert.Equal(t, expectedMeta, decoded.PreimageMeta)
+	}
+	assert.Equal(t, state.ValidatorState, decodedState.ValidatorState)
+	assert.Equal(t, state.TimeslotIndex, decodedState.TimeslotIndex)
+	assert.Equal(t, state.EntropyPool, decodedState.EntropyPool)
+}
+
+func TestDeserializeState_Errors(t *testing.T) {
+	encode := func(t *testing.T) map[crypto.Hash][]byte {
+		encodedState, err := SerializeState(RandomState(t))
+		require.NoError(t, err)
+		return encodedState
+	}
+
+	t.Run("missing component", func(t *testing.T) {
+		encodedState := encode(t)
+		delete(encodedState, generateStateKeyBasic(6))
+		_, err := DeserializeState(encodedState)
+		require.ErrorIs(t, err, ErrMissingStateKey)
+	})
+	t.Run("unknown component", func(t *testing.T) {
+		encodedState := encode(t)
+		encodedState[generateStateKeyBasic(16)] = []byte{0}
+		_, err := DeserializeState(encodedState)
+		require.ErrorIs(t, err, ErrUnknownStateKey)
+	})
+	t.Run("malformed component", func(t *testing.T) {
+		encodedState := encode(t)
+		encodedState[generateStateKeyBasic(6)] = []byte{1}
+		_, err := DeserializeState(encodedState)
+		require.ErrorIs(t, err, ErrMalformedStateValue)
+	})
+	t.Run("unknown service", func(t *testing.T) {
+		encodedState := encode(t)
+		stateKey, err := generateStateKeyInterleaved(1, testutils.RandomHash(t))
+		require.NoError(t, err)
+		encodedState[stateKey] = []byte{0}
+		_, err = DeserializeState(encodedState)
+		require.ErrorIs(t, err, ErrUnknownStateKey)
+	})
+	t.Run("preimage not matching its key", func(t *testing.T) {
+		encodedState := encode(t)
+		var combined [32]byte
+		copy(combined[:4], []byte{0xfe, 0xff, 0xff, 0xff})
+		stateKey, err := generateStateKeyInterleaved(789, combined)
+		require.NoError(t, err)
+		encodedState[stateKey] = []byte{1, 2}
+		_, err = DeserializeState(encodedState)
+		require.ErrorIs(t, err, ErrMalformedStateValue)
+	})
+	t.Run("ambiguous preimage metadata", func(t *testing.T) {
+		state := RandomState(t)
+		state.Services[789].PreimageMeta[service.PreImageMetaKey{Hash: testutils.RandomHash(t), Length: 10}] = service.PreimageHistoricalTimeslots{}
+		encodedState, err := SerializeState(state)
+		require.NoError(t, err)
+		_, err = DeserializeState(encodedState)
+		require.ErrorIs(t, err, ErrAmbiguousPreimageMeta)
+	})
+}
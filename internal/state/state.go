@@ -1,6 +1,9 @@
 package state
 
 import (
+	"maps"
+	"slices"
+
 	"github.com/eigerco/strawberry/internal/jamtime"
 	"github.com/eigerco/strawberry/internal/service"
 	"github.com/eigerco/strawberry/internal/validator"
@@ -22,3 +25,55 @@ type State struct {
 	AccumulationQueue        AccumulationQueue                  // Accumulation Queue (ϑ) - ready (i.e. available and/or audited) but not-yet-accumulated work-reports. Each of these were made available at most one epoch ago but have or had unfulfilled dependencies.
 	AccumulationHistory      AccumulationHistory                // Accumulation history (ξ) - history of what has been accumulated for an epoch worth of work-reports. Mapping of work-package hash to segment-root.
 }
+
+// Clone returns a deep copy of the state, which can be modified without
+// affecting the original. Keys, hashes and byte values are shared, since they
+// are replaced rather than modified in place.
+func (s State) Clone() State {
+	clone := s
+	clone.Services = s.Services.Clone()
+	clone.PrivilegedServices.AmountOfGasPerServiceId = maps.Clone(s.PrivilegedServices.AmountOfGasPerServiceId)
+	clone.ValidatorState.SafroleState.TicketAccumulator = slices.Clone(s.ValidatorState.SafroleState.TicketAccumulator)
+	for core, pool := range s.CoreAuthorizersPool {
+		clone.CoreAuthorizersPool[core] = slices.Clone(pool)
+	}
+	for core, assignment := range s.CoreAssignments {
+		if assignment == nil {
+			continue
+		}
+		assignmentClone := *assignment
+		if assignment.WorkReport != nil {
+			report := *assignment.WorkReport
+			assignmentClone.WorkReport = &report
+		}
+		clone.CoreAssignments[core] = &assignmentClone
+	}
+	if s.RecentBlocks != nil {
+		clone.RecentBlocks = make([]BlockState, len(s.RecentBlocks))
+		for i, recent := range s.RecentBlocks {
+			recent.AccumulationResultMMR = slices.Clone(recent.AccumulationResultMMR)
+			recent.WorkReportHashes = maps.Clone(recent.WorkReportHashes)
+			clone.RecentBlocks[i] = recent
+		}
+	}
+	clone.PastJudgements = Judgements{
+		BadWorkReports:      slices.Clone(s.PastJudgements.BadWorkReports),
+		GoodWorkReports:     slices.Clone(s.PastJudgements.GoodWorkReports),
+		WonkyWorkReports:    slices.Clone(s.PastJudgements.WonkyWorkReports),
+		OffendingValidators: slices.Clone(s.PastJudgements.OffendingValidators),
+	}
+	for slot, records := range s.AccumulationQueue {
+		if records == nil {
+			continue
+		}
+		clone.AccumulationQueue[slot] = make([]WorkReportWithUnAccumulatedDependencies, len(records))
+		for i, record := range records {
+			record.Dependencies = maps.Clone(record.Dependencies)
+			clone.AccumulationQueue[slot][i] = record
+		}
+	}
+	for slot, history := range s.AccumulationHistory {
+		clone.AccumulationHistory[slot] = maps.Clone(history)
+	}
+	return clone
+}
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/service"
+)
+
+func TestStateClone(t *testing.T) {
+	original := State{
+		Services: service.ServiceState{
+			1: {
+				Storage:      map[crypto.Hash][]byte{{1}: {1}},
+				PreimageMeta: map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{{Hash: crypto.Hash{2}, Length: 1}: {5}},
+			},
+		},
+		PrivilegedServices:  service.PrivilegedServices{AmountOfGasPerServiceId: map[block.ServiceId]uint64{1: 10}},
+		CoreAuthorizersPool: CoreAuthorizersPool{{{1}}},
+		CoreAssignments:     CoreAssignments{{WorkReport: &block.WorkReport{CoreIndex: 0}, Time: 1}},
+		RecentBlocks:        []BlockState{{WorkReportHashes: map[crypto.Hash]crypto.Hash{{1}: {2}}}},
+		PastJudgements:      Judgements{GoodWorkReports: []crypto.Hash{{1}}},
+		AccumulationQueue: AccumulationQueue{{{
+			Dependencies: map[crypto.Hash]struct{}{{1}: {}},
+		}}},
+		AccumulationHistory: AccumulationHistory{{{1}: {}}},
+	}
+
+	clone := original.Clone()
+	assert.Equal(t, original, clone)
+
+	clone.Services[1].Storage[crypto.Hash{1}] = []byte{2}
+	clone.Services[1].PreimageMeta[service.PreImageMetaKey{Hash: crypto.Hash{2}, Length: 1}][0] = 6
+	clone.Services[2] = service.ServiceAccount{}
+	clone.PrivilegedServices.AmountOfGasPerServiceId[1] = 20
+	clone.CoreAuthorizersPool[0][0] = crypto.Hash{2}
+	clone.CoreAssignments[0].WorkReport.CoreIndex = 1
+	clone.CoreAssignments[0].Time = 2
+	clone.RecentBlocks[0].WorkReportHashes[crypto.Hash{1}] = crypto.Hash{3}
+	clone.PastJudgements.GoodWorkReports[0] = crypto.Hash{2}
+	delete(clone.AccumulationQueue[0][0].Dependencies, crypto.Hash{1})
+	delete(clone.AccumulationHistory[0], crypto.Hash{1})
+
+	assert.Equal(t, []byte{1}, original.Services[1].Storage[crypto.Hash{1}])
+	assert.Equal(t, service.PreimageHistoricalTimeslots{5}, original.Services[1].PreimageMeta[service.PreImageMetaKey{Hash: crypto.Hash{2}, Length: 1}])
+	assert.Len(t, original.Services, 1)
+	assert.Equal(t, uint64(10), original.PrivilegedServices.AmountOfGasPerServiceId[1])
+	assert.Equal(t, crypto.Hash{1}, original.CoreAuthorizersPool[0][0])
+	assert.Equal(t, uint16(0), original.CoreAssignments[0].WorkReport.CoreIndex)
+	assert.Equal(t, uint32(1), uint32(original.CoreAssignments[0].Time))
+	assert.Equal(t, crypto.Hash{2}, original.RecentBlocks[0].WorkReportHashes[crypto.Hash{1}])
+	assert.Equal(t, crypto.Hash{1}, original.PastJudgements.GoodWorkReports[0])
+	assert.Len(t, original.AccumulationQueue[0][0].Dependencies, 1)
+	assert.Len(t, original.AccumulationHistory[0], 1)
+}
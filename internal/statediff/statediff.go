@@ -0,0 +1,255 @@
+// Package statediff compares two states component by component, to find out
+// why two state roots disagree.
+package statediff
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/state"
+	merkle "github.com/eigerco/strawberry/internal/state/merkle"
+)
+
+// component identifies a state component by its symbol and field name
+type component struct {
+	Symbol string
+	Name   string
+}
+
+// components lists the basic state components by state key index. Graypaper 0.5.4.
+var components = map[uint8]component{
+	1:  {"α", "CoreAuthorizersPool"},
+	2:  {"φ", "PendingAuthorizersQueues"},
+	3:  {"β", "RecentBlocks"},
+	4:  {"γ", "SafroleState"},
+	5:  {"ψ", "PastJudgements"},
+	6:  {"η", "EntropyPool"},
+	7:  {"ι", "QueuedValidators"},
+	8:  {"κ", "CurrentValidators"},
+	9:  {"λ", "ArchivedValidators"},
+	10: {"ρ", "CoreAssignments"},
+	11: {"τ", "TimeslotIndex"},
+	12: {"χ", "PrivilegedServices"},
+	13: {"π", "ValidatorStatistics"},
+	14: {"ϑ", "AccumulationQueue"},
+	15: {"ξ", "AccumulationHistory"},
+}
+
+// services is the component of the service accounts
+var services = component{"δ", "Services"}
+
+// Kinds of change
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Items of a service account
+const (
+	ItemAccount      = "account"
+	ItemStorage      = "storage"
+	ItemPreimage     = "preimage"
+	ItemPreimageMeta = "preimage_meta"
+)
+
+// Difference is a single state key whose value differs between the two states
+type Difference struct {
+	Symbol    string           `json:"symbol"`
+	Component string           `json:"component"`
+	Service   *block.ServiceId `json:"service,omitempty"`
+	Item      string           `json:"item,omitempty"` // Item of the service account, for δ
+	Key       string           `json:"key"`            // Hex encoded state key
+	Change    string           `json:"change"`
+	Prior     string           `json:"prior,omitempty"`     // Hex encoded prior value
+	Posterior string           `json:"posterior,omitempty"` // Hex encoded posterior value
+	Offset    *int             `json:"offset,omitempty"`    // First differing byte of a changed value
+}
+
+// Report lists the differences between two states, ordered by component,
+// service and key
+type Report struct {
+	Differences []Difference `json:"differences"`
+}
+
+// Empty tells whether the two states are the same
+func (r Report) Empty() bool {
+	return len(r.Differences) == 0
+}
+
+// States compares two states
+func States(prior, posterior state.State) (Report, error) {
+	priorSerialized, err := merkle.SerializeState(prior)
+	if err != nil {
+		return Report{}, fmt.Errorf("serialize prior state: %w", err)
+	}
+	posteriorSerialized, err := merkle.SerializeState(posterior)
+	if err != nil {
+		return Report{}, fmt.Errorf("serialize posterior state: %w", err)
+	}
+	return Serialized(priorSerialized, posteriorSerialized)
+}
+
+// Serialized compares two serialized states, as produced by merkle.SerializeState
+func Serialized(prior, posterior map[crypto.Hash][]byte) (Report, error) {
+	type keyed struct {
+		info merkle.StateKeyInfo
+		key  crypto.Hash
+		diff Difference
+	}
+	var diffs []keyed
+	add := func(key crypto.Hash, priorValue, posteriorValue []byte, change string) error {
+		info, err := merkle.ClassifyStateKey(key)
+		if err != nil {
+			return err
+		}
+		diff := Difference{
+			Key:       hex.EncodeToString(key[:]),
+			Change:    change,
+			Prior:     hex.EncodeToString(priorValue),
+			Posterior: hex.EncodeToString(posteriorValue),
+		}
+		if info.Kind == merkle.StateKeyComponent {
+			diff.Symbol, diff.Component = components[info.Component].Symbol, components[info.Component].Name
+		} else {
+			serviceId := info.ServiceId
+			diff.Symbol, diff.Component = services.Symbol, services.Name
+			diff.Service = &serviceId
+			diff.Item = itemName(info.Kind)
+		}
+		if change == Changed {
+			offset := firstDifference(priorValue, posteriorValue)
+			diff.Offset = &offset
+		}
+		diffs = append(diffs, keyed{info: info, key: key, diff: diff})
+		return nil
+	}
+
+	for key, priorValue := range prior {
+		posteriorValue, ok := posterior[key]
+		if !ok {
+			if err := add(key, priorValue, nil, Removed); err != nil {
+				return Report{}, err
+			}
+			continue
+		}
+		if !bytes.Equal(priorValue, posteriorValue) {
+			if err := add(key, priorValue, posteriorValue, Changed); err != nil {
+				return Report{}, err
+			}
+		}
+	}
+	for key, posteriorValue := range posterior {
+		if _, ok := prior[key]; !ok {
+			if err := add(key, nil, posteriorValue, Added); err != nil {
+				return Report{}, err
+			}
+		}
+	}
+
+	// Components in key order, then services by ID, item and key
+	sort.Slice(diffs, func(i, j int) bool {
+		a, b := diffs[i].info, diffs[j].info
+		aComponent, bComponent := a.Kind == merkle.StateKeyComponent, b.Kind == merkle.StateKeyComponent
+		switch {
+		case aComponent && bComponent:
+			return a.Component < b.Component
+		case aComponent != bComponent:
+			return aComponent
+		case a.ServiceId != b.ServiceId:
+			return a.ServiceId < b.ServiceId
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		}
+		return bytes.Compare(diffs[i].key[:], diffs[j].key[:]) < 0
+	})
+
+	report := Report{Differences: make([]Difference, len(diffs))}
+	for i, d := range diffs {
+		report.Differences[i] = d.diff
+	}
+	return report, nil
+}
+
+// maxTextValueSize is the number of bytes of a value shown in the text report
+const maxTextValueSize = 64
+
+// WriteText writes the report in a human readable form, one line per
+// difference followed by the values, truncated around the first difference
+func (r Report) WriteText(w io.Writer) error {
+	if r.Empty() {
+		_, err := fmt.Fprintln(w, "states are identical")
+		return err
+	}
+	for _, d := range r.Differences {
+		location := fmt.Sprintf("%s %s", d.Symbol, d.Component)
+		if d.Service != nil {
+			location = fmt.Sprintf("%s[%d] %s", d.Symbol, *d.Service, d.Item)
+		}
+		if _, err := fmt.Fprintf(w, "%s %s key %s\n", location, d.Change, d.Key); err != nil {
+			return err
+		}
+		offset := 0
+		if d.Offset != nil {
+			offset = *d.Offset
+			if _, err := fmt.Fprintf(w, "  first difference at byte %d\n", offset); err != nil {
+				return err
+			}
+		}
+		if d.Prior != "" {
+			if _, err := fmt.Fprintf(w, "  - %s\n", excerpt(d.Prior, offset)); err != nil {
+				return err
+			}
+		}
+		if d.Posterior != "" {
+			if _, err := fmt.Fprintf(w, "  + %s\n", excerpt(d.Posterior, offset)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d differences\n", len(r.Differences))
+	return err
+}
+
+// excerpt returns up to maxTextValueSize bytes of a hex encoded value, starting a little before the offset
+func excerpt(value string, offset int) string {
+	start := max(0, offset-8) * 2
+	if start >= len(value) {
+		start = 0
+	}
+	end := min(len(value), start+2*maxTextValueSize)
+	result := value[start:end]
+	if start > 0 {
+		result = "…" + result
+	}
+	if end < len(value) {
+		result += "…"
+	}
+	return fmt.Sprintf("%s (%d bytes)", result, len(value)/2)
+}
+
+func firstDifference(a, b []byte) int {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return min(len(a), len(b))
+}
+
+func itemName(kind merkle.StateKeyKind) string {
+	switch kind {
+	case merkle.StateKeyServiceAccount:
+		return ItemAccount
+	case merkle.StateKeyStorage:
+		return ItemStorage
+	case merkle.StateKeyPreimage:
+		return ItemPreimage
+	}
+	return ItemPreimageMeta
+}
@@ -0,0 +1,113 @@
+package statediff
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/safrole"
+	"github.com/eigerco/strawberry/internal/service"
+	"github.com/eigerco/strawberry/internal/state"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/eigerco/strawberry/internal/validator"
+)
+
+func testState(t *testing.T) state.State {
+	sealingKeySeries := safrole.TicketAccumulator{}
+	sealingKeySeries.Set(crypto.EpochKeys{})
+
+	return state.State{
+		ValidatorState: validator.ValidatorState{
+			SafroleState: safrole.State{SealingKeySeries: sealingKeySeries},
+		},
+		Services: service.ServiceState{
+			1: {
+				Storage:        map[crypto.Hash][]byte{testutils.RandomHash(t): []byte("value")},
+				PreimageLookup: map[crypto.Hash][]byte{},
+				PreimageMeta:   map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{},
+				CodeHash:       testutils.RandomHash(t),
+				Balance:        100,
+			},
+		},
+		TimeslotIndex: 10,
+		EntropyPool:   state.EntropyPool{testutils.RandomHash(t)},
+	}
+}
+
+func TestStates_Identical(t *testing.T) {
+	s := testState(t)
+	report, err := States(s, s)
+	require.NoError(t, err)
+	assert.True(t, report.Empty())
+
+	var text bytes.Buffer
+	require.NoError(t, report.WriteText(&text))
+	assert.Equal(t, "states are identical\n", text.String())
+}
+
+func TestStates(t *testing.T) {
+	prior := testState(t)
+	posterior := testState(t)
+	posterior.TimeslotIndex = prior.TimeslotIndex
+	posterior.Services[1] = prior.Services[1]
+	posterior.EntropyPool = prior.EntropyPool
+	posterior.EntropyPool[1] = testutils.RandomHash(t)
+
+	// Change the balance and a storage value, add a service
+	account := posterior.Services[1]
+	account.Balance = 200
+	account.Storage = map[crypto.Hash][]byte{}
+	for key := range prior.Services[1].Storage {
+		account.Storage[key] = []byte("other")
+	}
+	posterior.Services[1] = account
+	posterior.Services[2] = service.ServiceAccount{}
+
+	report, err := States(prior, posterior)
+	require.NoError(t, err)
+
+	type summary struct {
+		symbol  string
+		service *block.ServiceId
+		item    string
+		change  string
+	}
+	var got []summary
+	for _, d := range report.Differences {
+		got = append(got, summary{d.Symbol, d.Service, d.Item, d.Change})
+	}
+	one, two := block.ServiceId(1), block.ServiceId(2)
+	assert.Equal(t, []summary{
+		{"η", nil, "", Changed},
+		{"δ", &one, ItemAccount, Changed},
+		{"δ", &one, ItemStorage, Changed},
+		{"δ", &two, ItemAccount, Added},
+	}, got)
+
+	// The entropy pool differs from its second hash on
+	require.NotNil(t, report.Differences[0].Offset)
+	assert.GreaterOrEqual(t, *report.Differences[0].Offset, crypto.HashSize)
+
+	var text bytes.Buffer
+	require.NoError(t, report.WriteText(&text))
+	assert.Contains(t, text.String(), "η EntropyPool changed key 06")
+	assert.Contains(t, text.String(), "δ[1] storage changed")
+	assert.Contains(t, text.String(), "δ[2] account added")
+	assert.Contains(t, text.String(), "4 differences")
+
+	encoded, err := json.Marshal(report)
+	require.NoError(t, err)
+	var decoded Report
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, report, decoded)
+}
+
+func TestSerialized_UnknownKey(t *testing.T) {
+	_, err := Serialized(nil, map[crypto.Hash][]byte{{16}: {1}})
+	require.Error(t, err)
+}
@@ -11,4 +11,6 @@ var (
 	ErrBadOrder             = errors.New("bad order")
 	ErrCoreNotEngaged       = errors.New("core not engaged")
 	ErrBadValidatorIndex    = errors.New("bad validator index")
+	ErrBadTicketAttempt     = errors.New("bad ticket attempt")
+	ErrBadTicketProof       = errors.New("bad ticket proof")
 )
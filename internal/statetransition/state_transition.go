@@ -23,24 +23,43 @@ import (
 	"github.com/eigerco/strawberry/internal/safrole"
 	"github.com/eigerco/strawberry/internal/service"
 	"github.com/eigerco/strawberry/internal/state"
+	merkle "github.com/eigerco/strawberry/internal/state/merkle"
 	"github.com/eigerco/strawberry/internal/store"
 	"github.com/eigerco/strawberry/internal/validator"
 	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
 )
 
-// UpdateState updates the state
+// UpdateState applies a block to the prior state and returns the posterior
+// state, together with the delta between the serializations of the two. The
+// transition runs on a copy of the prior state, so the prior state is left
+// untouched, also when the block turns out to be invalid.
 // TODO: all the calculations which are not dependent on intermediate / new state can be done in parallel
-//
-//	it might be worth making State immutable and make it so that UpdateState returns a new State with all the updated fields
-func UpdateState(s *state.State, newBlock block.Block, chain *store.Chain) error {
+func UpdateState(prior state.State, newBlock block.Block, chain *store.Chain) (state.State, merkle.StateDelta, error) {
+	posterior := prior.Clone()
+	changes, err := applyBlock(&posterior, newBlock, chain)
+	if err != nil {
+		return state.State{}, merkle.StateDelta{}, err
+	}
+
+	delta, err := merkle.NewStateDeltaFromChanges(prior, posterior, changes)
+	if err != nil {
+		return state.State{}, merkle.StateDelta{}, fmt.Errorf("compute state delta: %w", err)
+	}
+	return posterior, delta, nil
+}
+
+// applyBlock updates the state in place and returns the parts of it which may
+// have changed. On error the state may be partially updated.
+func applyBlock(s *state.State, newBlock block.Block, chain *store.Chain) (merkle.StateChanges, error) {
 	if newBlock.Header.TimeSlotIndex.IsInFuture() {
-		return errors.New("invalid block, it is in the future")
+		return merkle.StateChanges{}, errors.New("invalid block, it is in the future")
 	}
 
+	priorEpoch := s.TimeslotIndex.ToEpoch()
 	newTimeState := CalculateNewTimeState(newBlock.Header)
 
 	if err := ValidateExtrinsicGuarantees(newBlock.Header, s, newBlock.Extrinsic.EG, s.CoreAssignments, newTimeState, chain); err != nil {
-		return fmt.Errorf("extrinsic guarantees validation failed, err: %w", err)
+		return merkle.StateChanges{}, fmt.Errorf("extrinsic guarantees validation failed, err: %w", err)
 	}
 
 	intermediateCoreAssignments := CalculateIntermediateCoreAssignmentsFromExtrinsics(newBlock.Extrinsic.ED, s.CoreAssignments)
@@ -48,21 +67,21 @@ func UpdateState(s *state.State, newBlock block.Block, chain *store.Chain) error
 	// Update SAFROLE state.
 	safroleInput, err := NewSafroleInputFromBlock(newBlock)
 	if err != nil {
-		return err
+		return merkle.StateChanges{}, err
 	}
 	newEntropyPool, newValidatorState, _, err := UpdateSafroleState(safroleInput, s.TimeslotIndex, s.EntropyPool, s.ValidatorState)
 	if err != nil {
-		return err
+		return merkle.StateChanges{}, err
 	}
 
 	intermediateCoreAssignments, _, err = CalculateIntermediateCoreFromAssurances(newValidatorState.CurrentValidators, intermediateCoreAssignments, newBlock.Header, newBlock.Extrinsic.EA)
 	if err != nil {
-		return err
+		return merkle.StateChanges{}, err
 	}
 
 	newCoreAssignments, reporters, err := CalculateNewCoreAssignments(newBlock.Extrinsic.EG, intermediateCoreAssignments, s.ValidatorState, newTimeState, newEntropyPool)
 	if err != nil {
-		return err
+		return merkle.StateChanges{}, err
 	}
 	newValidatorStatistics := CalculateNewValidatorStatistics(newBlock, newTimeState, s.ValidatorStatistics, reporters, s.ValidatorState.CurrentValidators)
 
@@ -74,7 +93,8 @@ func UpdateState(s *state.State, newBlock block.Block, chain *store.Chain) error
 		newPrivilegedServices,
 		newQueuedValidators,
 		newPendingCoreAuthorizations,
-		serviceHashPairs := CalculateWorkReportsAndAccumulate(
+		serviceHashPairs,
+		changedServices := CalculateWorkReportsAndAccumulate(
 		&newBlock.Header,
 		s,
 		newTimeState,
@@ -84,12 +104,12 @@ func UpdateState(s *state.State, newBlock block.Block, chain *store.Chain) error
 	intermediateRecentBlocks := calculateIntermediateBlockState(newBlock.Header, s.RecentBlocks)
 	newRecentBlocks, err := calculateNewRecentBlocks(newBlock.Header, newBlock.Extrinsic.EG, intermediateRecentBlocks, serviceHashPairs)
 	if err != nil {
-		return err
+		return merkle.StateChanges{}, err
 	}
 
 	newJudgements, err := CalculateNewJudgements(newTimeState, newBlock.Extrinsic.ED, s.PastJudgements, s.ValidatorState)
 	if err != nil {
-		return err
+		return merkle.StateChanges{}, err
 	}
 
 	newCoreAuthorizations := CalculateNewCoreAuthorizations(newBlock.Header, newBlock.Extrinsic.EG, newPendingCoreAuthorizations, s.CoreAuthorizersPool)
@@ -109,7 +129,25 @@ func UpdateState(s *state.State, newBlock block.Block, chain *store.Chain) error
 	s.AccumulationQueue = newAccumulationQueue
 	s.AccumulationHistory = newAccumulationHistory
 
-	return nil
+	// α, β, η, ι, ρ, τ, χ, π, ϑ and ξ are recomputed for every block
+	changes := merkle.StateChanges{
+		Components: []uint8{1, 3, 6, 7, 10, 11, 12, 13, 14, 15},
+		Services:   changedServices,
+	}
+	// γ only changes with tickets and on a new epoch, κ and λ on a new epoch
+	newEpoch := newTimeState.ToEpoch() > priorEpoch
+	if newEpoch || len(newBlock.Extrinsic.ET.TicketProofs) > 0 {
+		changes.Components = append(changes.Components, 4)
+	}
+	if newEpoch {
+		changes.Components = append(changes.Components, 8, 9)
+	}
+	// ψ only changes with disputes
+	disputes := newBlock.Extrinsic.ED
+	if len(disputes.Verdicts) > 0 || len(disputes.Culprits) > 0 || len(disputes.Faults) > 0 {
+		changes.Components = append(changes.Components, 5)
+	}
+	return changes, nil
 }
 
 // Intermediate State Calculation Functions
@@ -155,6 +193,7 @@ func preimageHasBeenSolicited(serviceState service.ServiceState, serviceIndex bl
 // The function returns a new ServiceState without modifying the input state.
 func CalculateIntermediateServiceState(preimages block.PreimageExtrinsic, serviceState service.ServiceState, newTimeslot jamtime.Timeslot) service.ServiceState {
 	newServiceState := maps.Clone(serviceState)
+	cloned := make(map[block.ServiceId]struct{})
 
 	for _, preimage := range preimages {
 		serviceId := block.ServiceId(preimage.ServiceIndex)
@@ -175,10 +214,15 @@ func CalculateIntermediateServiceState(preimages block.PreimageExtrinsic, servic
 		//							⎧ δ′[s]p[H(p)] = p
 		// δ′ = δ‡ ex. ∀(s, p) ∈ P∶ ⎨
 		//							⎩ δ′[s]l[H(p), |p|] = [τ′]
-		account, ok := serviceState[serviceId]
+		account, ok := newServiceState[serviceId]
 		if !ok {
 			continue
 		}
+		// Copy the account on its first change, its maps are shared with the input state
+		if _, ok := cloned[serviceId]; !ok {
+			account = account.Clone()
+			cloned[serviceId] = struct{}{}
+		}
 		// If checks pass, add the new preimage
 		if account.PreimageLookup == nil {
 			account.PreimageLookup = make(map[crypto.Hash][]byte)
@@ -438,6 +482,33 @@ type SafroleOutput struct {
 	WinningTicketMark *block.WinningTicketMarker
 }
 
+// VerifyTicketProof validates the ring signature of a ticket proof against the
+// ring commitment γz and the entropy η2, and returns the resulting ticket.
+// Equations 74 and 76 (v.0.4.5)
+// ET ∈ D{r ∈ NN, p ∈ F̄[]γz⟨XT ⌢ η′2 r⟩}I
+// n ≡ [{y ▸ Y(ip), r ▸ ir} S i <− ET]
+func VerifyTicketProof(ringVerifier *bandersnatch.RingVrfVerifier, ringCommitment crypto.RingCommitment, entropy crypto.Hash, tp block.TicketProof) (block.Ticket, error) {
+	// Equation 6.29: r ∈ N_N (v.0.5.4)
+	if tp.EntryIndex >= common.MaxTicketAttempts {
+		return block.Ticket{}, ErrBadTicketAttempt
+	}
+
+	// Validate the ring signature. VrfInputData is X_t ⌢ η_2′ ++ r. Equation 74. (v.0.4.5)
+	vrfInputData := append([]byte(state.TicketSealContext), entropy[:]...)
+	vrfInputData = append(vrfInputData, tp.EntryIndex)
+	// This produces the output hash we need to construct the ticket.
+	ok, outputHash := ringVerifier.Verify(vrfInputData, []byte{}, ringCommitment, tp.Proof)
+	if !ok {
+		return block.Ticket{}, ErrBadTicketProof
+	}
+
+	// Equation 76: n ≡ [{y ▸ Y(ip), r ▸ ir} S i <− ET] (v.0.4.5)
+	return block.Ticket{
+		Identifier: outputHash,
+		EntryIndex: tp.EntryIndex,
+	}, nil
+}
+
 // Validates then produces tickets from submitted ticket proofs.
 // Implements equations 74-80 in the graypaper (v.0.4.5)
 // ET ∈ D{r ∈ NN, p ∈ F̄[]γz⟨XT ⌢ η′2 r⟩}I  (74)
@@ -469,30 +540,17 @@ func calculateTickets(safstate safrole.State, entropyPool state.EntropyPool, tic
 	// n ≡ [{y ▸ Y(ip), r ▸ ir} S i <− ET]
 	tickets := make([]block.Ticket, len(ticketProofs))
 	for i, tp := range ticketProofs {
-		// Equation 6.29: r ∈ N_N (v.0.5.4)
-		if tp.EntryIndex >= common.MaxTicketAttempts {
-			return []block.Ticket{}, errors.New("bad ticket attempt")
-		}
-
-		// Validate the ring signature. VrfInputData is X_t ⌢ η_2′ ++ r. Equation 74. (v.0.4.5)
-		vrfInputData := append([]byte(state.TicketSealContext), entropyPool[2][:]...)
-		vrfInputData = append(vrfInputData, tp.EntryIndex)
-		// This produces the output hash we need to construct the ticket further down.
-		ok, outputHash := ringVerifier.Verify(vrfInputData, []byte{}, safstate.RingCommitment, tp.Proof)
-		if !ok {
-			return []block.Ticket{}, errors.New("bad ticket proof")
+		ticket, err := VerifyTicketProof(ringVerifier, safstate.RingCommitment, entropyPool[2], tp)
+		if err != nil {
+			return []block.Ticket{}, err
 		}
 
 		// Equation 78: {xy S x ∈ n} ⫰ {xy S x ∈ γa} (v.0.4.5)
-		if _, exists := existingIds[outputHash]; exists {
+		if _, exists := existingIds[ticket.Identifier]; exists {
 			return []block.Ticket{}, errors.New("duplicate ticket")
 		}
 
-		// Equation 76: n ≡ [{y ▸ Y(ip), r ▸ ir} S i <− ET] (v.0.4.5)
-		tickets[i] = block.Ticket{
-			Identifier: outputHash,
-			EntryIndex: tp.EntryIndex,
-		}
+		tickets[i] = ticket
 	}
 
 	// Equation 77: n = [xy __ x ∈ n] (v.0.4.5)
@@ -1767,6 +1825,7 @@ func PermuteAssignments(entropy crypto.Hash, timeslot jamtime.Timeslot) ([]uint3
 // with the only difference that we take in available work reports and calculate the accumulatable WR
 // eq. 4.16 W* ≺ (EA, ρ′) and
 // eq. 4.17: (ϑ′, ξ′, δ‡, χ′, ι′, φ′, C) ≺ (W*, ϑ, ξ, δ, χ, ι, φ)
+// It also returns the IDs of the services which may differ between δ and δ‡.
 func CalculateWorkReportsAndAccumulate(header *block.Header, currentState *state.State, newTimeslot jamtime.Timeslot, workReports []block.WorkReport) (
 	newAccumulationQueue state.AccumulationQueue,
 	newAccumulationHistory state.AccumulationHistory,
@@ -1775,6 +1834,7 @@ func CalculateWorkReportsAndAccumulate(header *block.Header, currentState *state
 	newValidatorKeys safrole.ValidatorsData,
 	newPendingAuthorizersQueues state.PendingAuthorizersQueues,
 	hashPairs ServiceHashPairs,
+	changedServices []block.ServiceId,
 ) {
 	// W! ≡ [w S w <− W, |(w_x)p| = 0 ∧ wl = {}] (eq. 12.4)
 	var immediatelyAccWorkReports []block.WorkReport
@@ -1840,6 +1900,36 @@ func CalculateWorkReportsAndAccumulate(header *block.Header, currentState *state
 		postAccumulationServiceState[serviceId] = newService
 	}
 
+	// Only the accumulated services, the ones they created or removed and the
+	// receivers of transfers are modified
+	changed := make(map[block.ServiceId]struct{})
+	if maxReports > 0 {
+		for _, report := range accumulatableWorkReports[:maxReports] {
+			for _, result := range report.WorkResults {
+				changed[result.ServiceId] = struct{}{}
+			}
+		}
+		for serviceId := range currentState.PrivilegedServices.AmountOfGasPerServiceId {
+			changed[serviceId] = struct{}{}
+		}
+		for serviceId := range postAccumulationServiceState {
+			if _, ok := currentState.Services[serviceId]; !ok {
+				changed[serviceId] = struct{}{}
+			}
+		}
+		for serviceId := range currentState.Services {
+			if _, ok := postAccumulationServiceState[serviceId]; !ok {
+				changed[serviceId] = struct{}{}
+			}
+		}
+	}
+	for _, transfer := range transfers {
+		changed[transfer.ReceiverServiceIndex] = struct{}{}
+	}
+	for serviceId := range changed {
+		changedServices = append(changedServices, serviceId)
+	}
+
 	// ξ′E−1 = P(W*...n) (eq. 12.25)
 	// ∀i ∈ NE−1 ∶ ξ′i ≡ ξi+1 (eq. 12.26)
 	newAccumulationHistory = state.AccumulationHistory(append(
@@ -1872,7 +1962,8 @@ func CalculateWorkReportsAndAccumulate(header *block.Header, currentState *state
 		newPrivilegedServices,
 		newValidatorKeys,
 		newPendingAuthorizersQueues,
-		hashPairs
+		hashPairs,
+		changedServices
 }
 
 // accumulationPriority Q(r ⟦(W, {H})⟧) → ⟦W⟧ (eq. 12.8)
@@ -7,10 +7,12 @@ import (
 	"github.com/eigerco/strawberry/internal/block"
 	"github.com/eigerco/strawberry/internal/common"
 	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/crypto/bandersnatch"
 	"github.com/eigerco/strawberry/internal/jamtime"
 	"github.com/eigerco/strawberry/internal/safrole"
 	"github.com/eigerco/strawberry/internal/service"
 	"github.com/eigerco/strawberry/internal/state"
+	merkle "github.com/eigerco/strawberry/internal/state/merkle"
 	"github.com/eigerco/strawberry/internal/testutils"
 	"github.com/eigerco/strawberry/internal/validator"
 	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
@@ -1114,3 +1116,95 @@ func createAssuranceExtrinsic(availableCores uint16, validators uint16) block.As
 	}
 	return assurances
 }
+
+func TestCalculateIntermediateServiceStateLeavesInputUnchanged(t *testing.T) {
+	preimageData := []byte{1, 2, 3}
+	serviceState := service.ServiceState{
+		block.ServiceId(0): {
+			PreimageLookup: map[crypto.Hash][]byte{},
+			PreimageMeta:   map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{},
+		},
+	}
+
+	newServiceState := CalculateIntermediateServiceState(block.PreimageExtrinsic{{ServiceIndex: 0, Data: preimageData}}, serviceState, 100)
+	assert.Len(t, newServiceState[0].PreimageLookup, 1)
+	assert.Empty(t, serviceState[0].PreimageLookup)
+	assert.Empty(t, serviceState[0].PreimageMeta)
+}
+
+func newUpdateStateTestBlock(t *testing.T, timeslot jamtime.Timeslot) block.Block {
+	vrfSignature, err := bandersnatch.Sign(testutils.RandomBandersnatchPrivateKey(t), []byte(state.EntropyContext), nil)
+	require.NoError(t, err)
+	return block.Block{Header: block.Header{
+		ParentHash:    testutils.RandomHash(t),
+		TimeSlotIndex: timeslot,
+		VRFSignature:  vrfSignature,
+	}}
+}
+
+func TestUpdateState(t *testing.T) {
+	prior := state.State{
+		Services: service.ServiceState{
+			7: {
+				Storage:        map[crypto.Hash][]byte{{1}: {2}},
+				PreimageLookup: map[crypto.Hash][]byte{},
+				PreimageMeta:   map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{},
+			},
+		},
+		TimeslotIndex: 1,
+	}
+	prior.ValidatorState.SafroleState.SealingKeySeries.Set(crypto.EpochKeys{})
+	priorSerialized, err := merkle.SerializeState(prior)
+	require.NoError(t, err)
+
+	posterior, delta, err := UpdateState(prior, newUpdateStateTestBlock(t, 2), nil)
+	require.NoError(t, err)
+	assert.Equal(t, jamtime.Timeslot(2), posterior.TimeslotIndex)
+	assert.Len(t, posterior.RecentBlocks, 1)
+
+	// The prior state is left untouched
+	serialized, err := merkle.SerializeState(prior)
+	require.NoError(t, err)
+	assert.Equal(t, priorSerialized, serialized)
+
+	// and the delta turns it into the posterior state
+	posteriorSerialized, err := merkle.SerializeState(posterior)
+	require.NoError(t, err)
+	assert.Equal(t, posteriorSerialized, delta.Apply(priorSerialized))
+	components, err := delta.Components()
+	require.NoError(t, err)
+	assert.Contains(t, components, uint8(3))  // β
+	assert.Contains(t, components, uint8(6))  // η
+	assert.Contains(t, components, uint8(11)) // τ
+	services, err := delta.Services()
+	require.NoError(t, err)
+	assert.Empty(t, services)
+}
+
+func TestUpdateStateInvalidBlock(t *testing.T) {
+	prior := state.State{
+		Services: service.ServiceState{
+			7: {
+				Storage:        map[crypto.Hash][]byte{{1}: {2}},
+				PreimageLookup: map[crypto.Hash][]byte{},
+				PreimageMeta:   map[service.PreImageMetaKey]service.PreimageHistoricalTimeslots{},
+			},
+		},
+		TimeslotIndex: 5,
+	}
+	prior.ValidatorState.SafroleState.SealingKeySeries.Set(crypto.EpochKeys{})
+	priorSerialized, err := merkle.SerializeState(prior)
+	require.NoError(t, err)
+
+	// The disputes are the last stage to be checked, after the safrole and
+	// accumulation stages ran on the posterior state. The judgements of the
+	// verdict are not sorted.
+	b := newUpdateStateTestBlock(t, 6)
+	b.Extrinsic.ED.Verdicts = []block.Verdict{{ReportHash: testutils.RandomHash(t)}}
+	_, _, err = UpdateState(prior, b, nil)
+	require.ErrorContains(t, err, "judgements not sorted unique")
+
+	serialized, err := merkle.SerializeState(prior)
+	require.NoError(t, err)
+	assert.Equal(t, priorSerialized, serialized)
+}
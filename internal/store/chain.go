@@ -3,65 +3,126 @@ package store
 import (
 	"errors"
 	"fmt"
-	"log"
 	"sync/atomic"
 
 	"github.com/eigerco/strawberry/internal/block"
 	"github.com/eigerco/strawberry/internal/crypto"
 	"github.com/eigerco/strawberry/pkg/db"
-	"github.com/eigerco/strawberry/pkg/db/pebble"
 )
 
 var (
 	ErrBlockNotFound  = errors.New("block not found")
 	ErrHeaderNotFound = errors.New("header not found")
 	ErrChainClosed    = errors.New("chain store is closed")
+	ErrNoFinalized    = errors.New("no finalized block stored")
 )
 
 const (
 	prefixHeader byte = iota + 1
 	prefixBlock
+	prefixMeta
+	prefixChildren
+	prefixTimeslot
+	prefixHeight
+	prefixCanonical
+	prefixState
+	prefixJustification
+	prefixEquivocation
+	prefixVoteEquivocation
 )
 
+var keyLatestFinalized = makeKey(prefixMeta, []byte("latest_finalized"))
+
 // Chain manages blockchain storage using a key-value store
 type Chain struct {
-	db     db.KVStore
+	*Reader
+	db db.KVStore
+}
+
+// Reader provides the read operations of the chain store. The reader embedded
+// in Chain reads the live database, while Snapshot readers see the database as
+// it was when the snapshot was taken.
+type Reader struct {
+	db     db.Reader
 	closed atomic.Bool
 }
 
+// Snapshot is a consistent read-only view of the chain store. Blocks written
+// after the snapshot was taken are not visible through it, which allows
+// serving requests while blocks are being imported.
+// Snapshots must be closed before the chain store.
+type Snapshot struct {
+	*Reader
+	snapshot db.Snapshot
+}
+
 // NewChain creates a new chain store using KVStore
 func NewChain(db db.KVStore) *Chain {
-	return &Chain{db: db}
+	return &Chain{Reader: &Reader{db: db}, db: db}
 }
 
-// PutHeader stores a header in the chain store
+// NewSnapshot captures the current state of the chain store
+func (c *Chain) NewSnapshot() (*Snapshot, error) {
+	if c.closed.Load() {
+		return nil, ErrChainClosed
+	}
+	snapshot, err := c.db.NewSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot: %w", err)
+	}
+	return &Snapshot{Reader: &Reader{db: snapshot}, snapshot: snapshot}, nil
+}
+
+// Close releases the snapshot
+func (s *Snapshot) Close() error {
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	return s.snapshot.Close()
+}
+
+// PutHeader stores a header in the chain store together with its indexes
 func (c *Chain) PutHeader(h block.Header) error {
 	if c.closed.Load() {
 		return ErrChainClosed
 	}
+	batch := c.db.NewBatch()
+	defer batch.Close()
+
+	if _, err := c.putHeader(batch, h); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	return nil
+}
+
+// putHeader adds a header and its index entries to the batch and returns the header hash
+func (c *Chain) putHeader(batch db.Batch, h block.Header) (crypto.Hash, error) {
 	bytes, err := h.Bytes()
 	if err != nil {
-		return fmt.Errorf("marshal header: %w", err)
+		return crypto.Hash{}, fmt.Errorf("marshal header: %w", err)
 	}
-	hash, err := h.Hash()
-	if err != nil {
-		return fmt.Errorf("hash header: %w", err)
+	hash := crypto.HashData(bytes)
+	if err := batch.Put(makeKey(prefixHeader, hash[:]), bytes); err != nil {
+		return crypto.Hash{}, fmt.Errorf("store header: %w", err)
 	}
-	if err := c.db.Put(makeKey(prefixHeader, hash[:]), bytes); err != nil {
-		return fmt.Errorf("store header: %w", err)
+	if err := c.putIndexes(batch, h, hash); err != nil {
+		return crypto.Hash{}, fmt.Errorf("store indexes: %w", err)
 	}
-	return nil
+	return hash, nil
 }
 
 // GetHeader retrieves a header by its hash
-func (c *Chain) GetHeader(hash crypto.Hash) (block.Header, error) {
+func (c *Reader) GetHeader(hash crypto.Hash) (block.Header, error) {
 	if c.closed.Load() {
 		return block.Header{}, ErrChainClosed
 	}
 
 	headerBytes, err := c.db.Get(makeKey(prefixHeader, hash[:]))
 	if err != nil {
-		if errors.Is(err, pebble.ErrNotFound) {
+		if errors.Is(err, db.ErrNotFound) {
 			return block.Header{}, ErrHeaderNotFound
 		}
 		return block.Header{}, fmt.Errorf("get header: %w", err)
@@ -71,10 +132,12 @@ func (c *Chain) GetHeader(hash crypto.Hash) (block.Header, error) {
 }
 
 // FindHeader searches for a header that matches the given predicate function.
+// This scans every stored header, prefer the indexed lookups such as
+// GetHeadersAtTimeslot or GetChildren where possible.
 // Returns the first matching header and nil error if found.
 // Returns zero header and nil error if no match is found.
 // Returns zero header and error if the chain is closed or if database operations fail.
-func (c *Chain) FindHeader(fn func(header block.Header) bool) (block.Header, error) {
+func (c *Reader) FindHeader(fn func(header block.Header) bool) (block.Header, error) {
 	if c.closed.Load() {
 		return block.Header{}, ErrChainClosed
 	}
@@ -114,18 +177,11 @@ func (c *Chain) PutBlock(b block.Block) error {
 	// Create new batch for atomic operations
 	batch := c.db.NewBatch()
 	defer batch.Close()
-	headerHash, err := b.Header.Hash()
-	if err != nil {
-		return fmt.Errorf("hash header: %w", err)
-	}
 
 	// Store the header
-	headerBytes, err := b.Header.Bytes()
+	headerHash, err := c.putHeader(batch, b.Header)
 	if err != nil {
-		return fmt.Errorf("marshal header: %w", err)
-	}
-	if err := batch.Put(makeKey(prefixHeader, headerHash[:]), headerBytes); err != nil {
-		return fmt.Errorf("store header: %w", err)
+		return err
 	}
 
 	// Store full block
@@ -143,15 +199,40 @@ func (c *Chain) PutBlock(b block.Block) error {
 	return nil
 }
 
+// DeleteBlock deletes a block which has no descendants, along with its
+// header, state and index entries. It undoes the storage of a block whose
+// import failed.
+func (c *Chain) DeleteBlock(hash crypto.Hash) error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+	children, err := c.GetChildren(hash)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return fmt.Errorf("block %x has descendants", hash)
+	}
+	batch := c.db.NewBatch()
+	defer batch.Close()
+	if _, _, err := c.deleteBranch(batch, hash); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	return nil
+}
+
 // GetBlock retrieves a block by its header hash
-func (c *Chain) GetBlock(hash crypto.Hash) (block.Block, error) {
+func (c *Reader) GetBlock(hash crypto.Hash) (block.Block, error) {
 	if c.closed.Load() {
 		return block.Block{}, ErrChainClosed
 	}
 
 	blockBytes, err := c.db.Get(makeKey(prefixBlock, hash[:]))
 	if err != nil {
-		if errors.Is(err, pebble.ErrNotFound) {
+		if errors.Is(err, db.ErrNotFound) {
 			return block.Block{}, ErrBlockNotFound
 		}
 		return block.Block{}, fmt.Errorf("get block: %w", err)
@@ -160,37 +241,24 @@ func (c *Chain) GetBlock(hash crypto.Hash) (block.Block, error) {
 	return block.BlockFromBytes(blockBytes)
 }
 
-// FindChildren finds all immediate child blocks for a given block hash
-func (c *Chain) FindChildren(parentHash crypto.Hash) ([]block.Block, error) {
-	if c.closed.Load() {
-		return nil, ErrChainClosed
-	}
-
-	var children []block.Block
-
-	// Create iterator for block prefix
-	iter, err := c.db.NewIterator([]byte{prefixBlock}, []byte{prefixBlock + 1})
+// FindChildren finds all immediate child blocks for a given block hash.
+// Children whose header is stored without a block body are skipped.
+func (c *Reader) FindChildren(parentHash crypto.Hash) ([]block.Block, error) {
+	childHashes, err := c.GetChildren(parentHash)
 	if err != nil {
-		return nil, fmt.Errorf("create iterator: %w", err)
+		return nil, err
 	}
-	defer iter.Close()
 
-	// Iterate through blocks
-	for iter.Next() {
-		blockBytes, err := iter.Value()
-		if err != nil {
-			log.Println("read block value from iterator", err)
-			continue
-		}
-		b, err := block.BlockFromBytes(blockBytes)
+	var children []block.Block
+	for _, childHash := range childHashes {
+		b, err := c.GetBlock(childHash)
 		if err != nil {
-			log.Println("parse block from bytes", err)
-			continue
-		}
-
-		if b.Header.ParentHash == parentHash {
-			children = append(children, b)
+			if errors.Is(err, ErrBlockNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("get child block: %w", err)
 		}
+		children = append(children, b)
 	}
 
 	return children, nil
@@ -199,7 +267,7 @@ func (c *Chain) FindChildren(parentHash crypto.Hash) ([]block.Block, error) {
 // GetBlockSequence retrieves a sequence of blocks.
 // If ascending is true, returns children of the start block (exclusive).
 // If ascending is false, returns the start block and its ancestors (inclusive).
-func (c *Chain) GetBlockSequence(startHash crypto.Hash, ascending bool, maxBlocks uint32) ([]block.Block, error) {
+func (c *Reader) GetBlockSequence(startHash crypto.Hash, ascending bool, maxBlocks uint32) ([]block.Block, error) {
 	if c.closed.Load() {
 		return nil, ErrChainClosed
 	}
@@ -221,17 +289,15 @@ func (c *Chain) GetBlockSequence(startHash crypto.Hash, ascending bool, maxBlock
 			if currentHash != startHash {
 				blocks = append(blocks, currentBlock)
 			}
-			// Find children and take the first one
-			children, err := c.FindChildren(currentHash)
-			if err != nil || len(children) == 0 {
-				break
-			}
-
-			// Get hash for next iteration
-			currentHash, err = children[0].Header.Hash()
+			// Follow the canonical child, or the first known child if there is none
+			nextHash, ok, err := c.nextChild(currentHash)
 			if err != nil {
-				return nil, fmt.Errorf("marshal child header: %w", err)
+				return nil, fmt.Errorf("get child: %w", err)
+			}
+			if !ok {
+				break
 			}
+			currentHash = nextHash
 		} else {
 			// For descending (inclusive), include current and follow parent
 			blocks = append(blocks, currentBlock)
@@ -251,6 +317,37 @@ func (c *Chain) GetBlockSequence(startHash crypto.Hash, ascending bool, maxBlock
 	return blocks, nil
 }
 
+// PutLatestFinalized persists the hash of the latest finalized block so that
+// it can be restored after a restart
+func (c *Chain) PutLatestFinalized(hash crypto.Hash) error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+	if err := c.db.Put(keyLatestFinalized, hash[:]); err != nil {
+		return fmt.Errorf("store latest finalized: %w", err)
+	}
+	return nil
+}
+
+// GetLatestFinalized retrieves the hash of the latest finalized block.
+// Returns ErrNoFinalized if nothing has been finalized yet.
+func (c *Reader) GetLatestFinalized() (crypto.Hash, error) {
+	if c.closed.Load() {
+		return crypto.Hash{}, ErrChainClosed
+	}
+	value, err := c.db.Get(keyLatestFinalized)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return crypto.Hash{}, ErrNoFinalized
+		}
+		return crypto.Hash{}, fmt.Errorf("get latest finalized: %w", err)
+	}
+	if len(value) != crypto.HashSize {
+		return crypto.Hash{}, fmt.Errorf("invalid latest finalized length: %d", len(value))
+	}
+	return crypto.Hash(value), nil
+}
+
 // Close closes the chain store
 func (c *Chain) Close() error {
 	if !c.closed.CompareAndSwap(false, true) {
@@ -266,6 +363,24 @@ func PrefixToString(p byte) string {
 		return "header"
 	case prefixBlock:
 		return "block"
+	case prefixMeta:
+		return "meta"
+	case prefixChildren:
+		return "children"
+	case prefixTimeslot:
+		return "timeslot"
+	case prefixHeight:
+		return "height"
+	case prefixCanonical:
+		return "canonical"
+	case prefixState:
+		return "state"
+	case prefixJustification:
+		return "justification"
+	case prefixEquivocation:
+		return "equivocation"
+	case prefixVoteEquivocation:
+		return "vote_equivocation"
 	default:
 		return "unknown"
 	}
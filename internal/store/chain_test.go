@@ -7,15 +7,14 @@ import (
 	"github.com/eigerco/strawberry/internal/crypto"
 	"github.com/eigerco/strawberry/internal/jamtime"
 	"github.com/eigerco/strawberry/internal/testutils"
-	"github.com/eigerco/strawberry/pkg/db/pebble"
+	"github.com/eigerco/strawberry/pkg/db/memory"
 	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestPutAndGetHeader(t *testing.T) {
-	db, err := pebble.NewKVStore()
-	require.NoError(t, err)
+	db := memory.NewKVStore()
 
 	chain := NewChain(db)
 	defer chain.Close()
@@ -27,7 +26,7 @@ func TestPutAndGetHeader(t *testing.T) {
 	}
 
 	// Store the parent header
-	err = chain.PutHeader(parentHeader)
+	err := chain.PutHeader(parentHeader)
 	require.NoError(t, err)
 
 	// Calculate the hash of the parent header
@@ -56,8 +55,7 @@ func TestPutAndGetHeader(t *testing.T) {
 }
 
 func TestGetNonExistentAncestor(t *testing.T) {
-	db, err := pebble.NewKVStore()
-	require.NoError(t, err)
+	db := memory.NewKVStore()
 
 	chain := NewChain(db)
 	defer chain.Close()
@@ -175,6 +173,36 @@ func Test_PutGetBlock(t *testing.T) {
 	require.Equal(t, header.ParentHash, resultBlock.Header.ParentHash)
 }
 
+func Test_DeleteBlock(t *testing.T) {
+	chain := newStore(t)
+	blocks := createNumOfRandomBlocks(2, t)
+	for _, b := range blocks {
+		require.NoError(t, chain.PutBlock(b))
+	}
+	parentHash, err := blocks[0].Header.Hash()
+	require.NoError(t, err)
+	hash, err := blocks[1].Header.Hash()
+	require.NoError(t, err)
+	require.NoError(t, chain.PutState(hash, testutils.RandomHash(t), nil, nil))
+
+	// A block with descendants is kept
+	require.Error(t, chain.DeleteBlock(parentHash))
+
+	require.NoError(t, chain.DeleteBlock(hash))
+	_, err = chain.GetBlock(hash)
+	require.ErrorIs(t, err, ErrBlockNotFound)
+	_, err = chain.GetHeader(hash)
+	require.ErrorIs(t, err, ErrHeaderNotFound)
+	ok, err := chain.HasState(hash)
+	require.NoError(t, err)
+	require.False(t, ok)
+	children, err := chain.GetChildren(parentHash)
+	require.NoError(t, err)
+	require.Empty(t, children)
+	_, err = chain.GetCanonicalHashAt(1)
+	require.ErrorIs(t, err, ErrHeaderNotFound)
+}
+
 func Test_GetBlockNotFound(t *testing.T) {
 	chain := newStore(t)
 	_, err := chain.GetBlock(testutils.RandomHash(t))
@@ -402,8 +430,6 @@ func createNumOfRandomBlocks(num int, t *testing.T) []block.Block {
 }
 
 func newStore(t *testing.T) *Chain {
-	kvStore, err := pebble.NewKVStore()
-	require.NoError(t, err)
-	chain := NewChain(kvStore)
+	chain := NewChain(memory.NewKVStore())
 	return chain
 }
@@ -0,0 +1,286 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+)
+
+// Kinds of issues reported by Check
+const (
+	IssueCorruptHeader       = "corrupt_header"       // Header does not decode or does not hash to its key
+	IssueMissingParent       = "missing_parent"       // Header whose parent is not stored
+	IssueCorruptBlock        = "corrupt_block"        // Block does not decode or its header does not hash to its key
+	IssueExtrinsicMismatch   = "extrinsic_mismatch"   // Block extrinsic does not match the header's extrinsic hash
+	IssueOrphanBlock         = "orphan_block"         // Block stored without its header
+	IssueDanglingIndex       = "dangling_index"       // Index entry referring to a header that is not stored
+	IssueInvalidIndex        = "invalid_index"        // Index entry that cannot be parsed
+	IssueDanglingFinalized   = "dangling_finalized"   // Latest finalized pointer referring to a header that is not stored
+	IssueUnknownKey          = "unknown_key"          // Key outside of the known key layout
+	IssueMismatchedTimeslots = "mismatched_timeslots" // Timeslot index entry whose slot differs from the header's
+	IssueOrphanState         = "orphan_state"         // State stored without the header of its block
+	IssueOrphanJustification = "orphan_justification" // Justification stored without the header of its block
+)
+
+// Issue is a single problem found by Check
+type Issue struct {
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`            // Hex encoded database key of the offending entry
+	Hash     string `json:"hash,omitempty"` // Hex encoded hash of the header the entry refers to
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"` // The entry was deleted by the repair
+}
+
+// CheckReport summarises the result of Check
+type CheckReport struct {
+	Headers      int     `json:"headers"`
+	Blocks       int     `json:"blocks"`
+	IndexEntries int     `json:"index_entries"`
+	Issues       []Issue `json:"issues"`
+
+	// StateRoots are the distinct non-zero prior state roots referenced by
+	// the intact headers, to be checked against the trie db by the caller
+	StateRoots []crypto.Hash `json:"-"`
+}
+
+// Check verifies the integrity of the chain store:
+//   - every header hashes to its key and its parent is stored, except for the genesis block
+//   - every block hashes to its key, has a stored header and its extrinsic matches the header's extrinsic hash
+//   - every index entry, stored state and justification refers to a stored header
+//
+// When repair is set, entries that cannot be trusted or refer to missing
+// headers are deleted: corrupt headers and blocks, blocks that do not match
+// their header, orphan blocks, states and justifications, and dangling or
+// invalid index entries. Missing parents and a dangling finalized pointer are only reported,
+// as deleting headers would throw away data that a resync can complete.
+func (c *Chain) Check(repair bool) (CheckReport, error) {
+	if c.closed.Load() {
+		return CheckReport{}, ErrChainClosed
+	}
+	var (
+		report CheckReport
+		drop   [][]byte
+	)
+	issue := func(kind string, key []byte, hash *crypto.Hash, detail string, repairable bool) {
+		i := Issue{Kind: kind, Key: hex.EncodeToString(key), Detail: detail, Repaired: repair && repairable}
+		if hash != nil {
+			i.Hash = hex.EncodeToString(hash[:])
+		}
+		report.Issues = append(report.Issues, i)
+		if i.Repaired {
+			drop = append(drop, key)
+		}
+	}
+
+	// Headers
+	headers := make(map[crypto.Hash]block.Header)
+	headerKeys, err := c.keysWithPrefix([]byte{prefixHeader})
+	if err != nil {
+		return CheckReport{}, err
+	}
+	for _, key := range headerKeys {
+		report.Headers++
+		if len(key) != 1+crypto.HashSize {
+			issue(IssueCorruptHeader, key, nil, "invalid key length", true)
+			continue
+		}
+		keyHash := crypto.Hash(key[1:])
+		value, err := c.db.Get(key)
+		if err != nil {
+			return CheckReport{}, fmt.Errorf("get header: %w", err)
+		}
+		header, err := block.HeaderFromBytes(value)
+		if err != nil {
+			issue(IssueCorruptHeader, key, &keyHash, err.Error(), true)
+			continue
+		}
+		if crypto.HashData(value) != keyHash {
+			issue(IssueCorruptHeader, key, &keyHash, "header hash does not match key", true)
+			continue
+		}
+		headers[keyHash] = header
+	}
+
+	genesis, _ := c.GetCanonicalHashAt(0)
+	roots := make(map[crypto.Hash]struct{})
+	for hash, header := range headers {
+		if _, ok := headers[header.ParentHash]; !ok && hash != genesis {
+			hash := hash
+			issue(IssueMissingParent, makeKey(prefixHeader, hash[:]), &hash,
+				fmt.Sprintf("parent %x not stored", header.ParentHash), false)
+		}
+		if header.PriorStateRoot != (crypto.Hash{}) {
+			if _, ok := roots[header.PriorStateRoot]; !ok {
+				roots[header.PriorStateRoot] = struct{}{}
+				report.StateRoots = append(report.StateRoots, header.PriorStateRoot)
+			}
+		}
+	}
+
+	// Blocks
+	blockKeys, err := c.keysWithPrefix([]byte{prefixBlock})
+	if err != nil {
+		return CheckReport{}, err
+	}
+	for _, key := range blockKeys {
+		report.Blocks++
+		if len(key) != 1+crypto.HashSize {
+			issue(IssueCorruptBlock, key, nil, "invalid key length", true)
+			continue
+		}
+		keyHash := crypto.Hash(key[1:])
+		if err := c.checkBlock(key, keyHash, headers); err != nil {
+			issue(err.kind, key, &keyHash, err.detail, true)
+		}
+	}
+
+	// Indexes
+	for _, prefix := range []byte{prefixChildren, prefixTimeslot, prefixHeight, prefixCanonical} {
+		keys, err := c.keysWithPrefix([]byte{prefix})
+		if err != nil {
+			return CheckReport{}, err
+		}
+		for _, key := range keys {
+			report.IndexEntries++
+			hash, slot, ok, err := c.indexedHash(prefix, key)
+			if err != nil {
+				return CheckReport{}, err
+			}
+			if !ok {
+				issue(IssueInvalidIndex, key, nil, PrefixToString(prefix), true)
+				continue
+			}
+			header, exists := headers[hash]
+			if !exists {
+				issue(IssueDanglingIndex, key, &hash, PrefixToString(prefix), true)
+				continue
+			}
+			if prefix == prefixTimeslot && uint32(header.TimeSlotIndex) != slot {
+				issue(IssueMismatchedTimeslots, key, &hash,
+					fmt.Sprintf("indexed at %d, header at %d", slot, header.TimeSlotIndex), true)
+			}
+		}
+	}
+
+	// States and justifications
+	for _, orphan := range []struct {
+		prefix byte
+		kind   string
+	}{{prefixState, IssueOrphanState}, {prefixJustification, IssueOrphanJustification}} {
+		kind := orphan.kind
+		keys, err := c.keysWithPrefix([]byte{orphan.prefix})
+		if err != nil {
+			return CheckReport{}, err
+		}
+		for _, key := range keys {
+			if len(key) != 1+crypto.HashSize {
+				issue(kind, key, nil, "invalid key length", true)
+				continue
+			}
+			keyHash := crypto.Hash(key[1:])
+			if _, ok := headers[keyHash]; !ok {
+				issue(kind, key, &keyHash, "", true)
+			}
+		}
+	}
+
+	// Metadata
+	metaKeys, err := c.keysWithPrefix([]byte{prefixMeta})
+	if err != nil {
+		return CheckReport{}, err
+	}
+	for _, key := range metaKeys {
+		if string(key) != string(keyLatestFinalized) {
+			issue(IssueUnknownKey, key, nil, PrefixToString(prefixMeta), false)
+		}
+	}
+	if finalized, err := c.GetLatestFinalized(); err == nil {
+		if _, ok := headers[finalized]; !ok {
+			issue(IssueDanglingFinalized, keyLatestFinalized, &finalized, "", false)
+		}
+	}
+
+	if len(drop) > 0 {
+		batch := c.db.NewBatch()
+		defer batch.Close()
+		for _, key := range drop {
+			if err := batch.Delete(key); err != nil {
+				return CheckReport{}, fmt.Errorf("delete key: %w", err)
+			}
+		}
+		if err := batch.Commit(); err != nil {
+			return CheckReport{}, fmt.Errorf("commit batch: %w", err)
+		}
+	}
+	return report, nil
+}
+
+type blockIssue struct {
+	kind   string
+	detail string
+}
+
+// checkBlock verifies a stored block against its key and its stored header
+func (c *Chain) checkBlock(key []byte, keyHash crypto.Hash, headers map[crypto.Hash]block.Header) *blockIssue {
+	value, err := c.db.Get(key)
+	if err != nil {
+		return &blockIssue{IssueCorruptBlock, err.Error()}
+	}
+	b, err := block.BlockFromBytes(value)
+	if err != nil {
+		return &blockIssue{IssueCorruptBlock, err.Error()}
+	}
+	hash, err := b.Header.Hash()
+	if err != nil || hash != keyHash {
+		return &blockIssue{IssueCorruptBlock, "block header hash does not match key"}
+	}
+	if _, ok := headers[keyHash]; !ok {
+		return &blockIssue{IssueOrphanBlock, "header not stored"}
+	}
+	extrinsicHash, err := b.Extrinsic.Hash()
+	if err != nil {
+		return &blockIssue{IssueCorruptBlock, err.Error()}
+	}
+	if extrinsicHash != b.Header.ExtrinsicHash {
+		return &blockIssue{IssueExtrinsicMismatch, fmt.Sprintf("extrinsic hashes to %x", extrinsicHash)}
+	}
+	return nil
+}
+
+// indexedHash returns the header hash an index entry refers to, and for
+// timeslot entries the indexed slot
+func (c *Chain) indexedHash(prefix byte, key []byte) (crypto.Hash, uint32, bool, error) {
+	switch prefix {
+	case prefixChildren:
+		if len(key) != 1+2*crypto.HashSize {
+			return crypto.Hash{}, 0, false, nil
+		}
+		return crypto.Hash(key[1+crypto.HashSize:]), 0, true, nil
+	case prefixTimeslot:
+		if len(key) != 5+crypto.HashSize {
+			return crypto.Hash{}, 0, false, nil
+		}
+		return crypto.Hash(key[5:]), binary.BigEndian.Uint32(key[1:5]), true, nil
+	case prefixHeight:
+		if len(key) != 1+crypto.HashSize {
+			return crypto.Hash{}, 0, false, nil
+		}
+		return crypto.Hash(key[1:]), 0, true, nil
+	case prefixCanonical:
+		if len(key) != 5 {
+			return crypto.Hash{}, 0, false, nil
+		}
+		value, err := c.db.Get(key)
+		if err != nil {
+			return crypto.Hash{}, 0, false, fmt.Errorf("get canonical entry: %w", err)
+		}
+		if len(value) != crypto.HashSize {
+			return crypto.Hash{}, 0, false, nil
+		}
+		return crypto.Hash(value), 0, true, nil
+	}
+	return crypto.Hash{}, 0, false, nil
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createCheckedBlocks creates a chain of blocks with a valid extrinsic hash
+func createCheckedBlocks(num int, t *testing.T) ([]block.Block, []crypto.Hash) {
+	extrinsicHash, err := block.Extrinsic{}.Hash()
+	require.NoError(t, err)
+
+	blocks := createNumOfRandomBlocks(1, t)
+	blocks[0].Header.ExtrinsicHash = extrinsicHash
+	hashes := make([]crypto.Hash, 0, num)
+	for i := 0; ; i++ {
+		hash, err := blocks[i].Header.Hash()
+		require.NoError(t, err)
+		hashes = append(hashes, hash)
+		if len(blocks) == num {
+			return blocks, hashes
+		}
+		b := createRandomBlock(hash, blocks[i].Header.TimeSlotIndex+1, t)
+		b.Header.ExtrinsicHash = extrinsicHash
+		blocks = append(blocks, b)
+	}
+}
+
+func issueKinds(issues []Issue) []string {
+	kinds := make([]string, len(issues))
+	for i, issue := range issues {
+		kinds[i] = issue.Kind
+	}
+	return kinds
+}
+
+func Test_Check_Clean(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blocks, hashes := createCheckedBlocks(3, t)
+	for _, b := range blocks {
+		require.NoError(t, chain.PutBlock(b))
+	}
+	require.NoError(t, chain.PutLatestFinalized(hashes[1]))
+
+	report, err := chain.Check(false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Issues)
+	assert.Equal(t, 3, report.Headers)
+	assert.Equal(t, 3, report.Blocks)
+	assert.Equal(t, 12, report.IndexEntries)
+	assert.Len(t, report.StateRoots, 3)
+}
+
+func Test_Check_Issues(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blocks, hashes := createCheckedBlocks(3, t)
+	for _, b := range blocks {
+		require.NoError(t, chain.PutBlock(b))
+	}
+
+	// Header whose parent is not stored
+	detached := createRandomBlock(testutils.RandomHash(t), 100, t)
+	require.NoError(t, chain.PutHeader(detached.Header))
+
+	// Block extrinsic not matching the header
+	mismatched := blocks[1]
+	mismatched.Extrinsic.EP = block.PreimageExtrinsic{{ServiceIndex: 1, Data: []byte("data")}}
+	mismatchedBytes, err := mismatched.Bytes()
+	require.NoError(t, err)
+	require.NoError(t, chain.db.Put(makeKey(prefixBlock, hashes[1][:]), mismatchedBytes))
+
+	// Corrupt header, its block and index entries are left behind
+	corruptKey := makeKey(prefixHeader, hashes[2][:])
+	require.NoError(t, chain.db.Put(corruptKey, []byte{1, 2, 3}))
+
+	report, err := chain.Check(false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		IssueCorruptHeader,
+		IssueMissingParent,
+		IssueExtrinsicMismatch,
+		IssueOrphanBlock,
+		IssueDanglingIndex, // children
+		IssueDanglingIndex, // timeslot
+		IssueDanglingIndex, // height
+		IssueDanglingIndex, // canonical
+	}, issueKinds(report.Issues))
+	for _, issue := range report.Issues {
+		assert.False(t, issue.Repaired)
+	}
+	assert.Equal(t, hex.EncodeToString(corruptKey), report.Issues[0].Key)
+
+	// Repair removes every entry that cannot be trusted, but keeps the detached header
+	report, err = chain.Check(true)
+	require.NoError(t, err)
+	for _, issue := range report.Issues {
+		assert.Equal(t, issue.Kind != IssueMissingParent, issue.Repaired, issue.Kind)
+	}
+	_, err = chain.GetBlock(hashes[1])
+	require.ErrorIs(t, err, ErrBlockNotFound)
+	_, err = chain.GetCanonicalHashAt(2)
+	require.ErrorIs(t, err, ErrHeaderNotFound)
+
+	report, err = chain.Check(false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{IssueMissingParent}, issueKinds(report.Issues))
+	assert.Equal(t, 3, report.Headers)
+	assert.Equal(t, 1, report.Blocks)
+}
+
+func Test_Check_Closed(t *testing.T) {
+	chain := newStore(t)
+	require.NoError(t, chain.Close())
+
+	_, err := chain.Check(false)
+	require.ErrorIs(t, err, ErrChainClosed)
+}
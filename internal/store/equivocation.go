@@ -0,0 +1,107 @@
+package store
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+// Equivocation is the evidence of a block author sealing two different
+// headers for the same timeslot. The headers are kept in full, so the
+// evidence outlives the pruning of the forks they belong to.
+type Equivocation struct {
+	Offender ed25519.PublicKey // Ed25519 key of the author
+	First    block.Header
+	Second   block.Header
+}
+
+// PutEquivocation stores the evidence of an equivocation, keyed by the
+// timeslot and the author index of the headers. Evidence already stored for
+// the same timeslot and author is kept.
+func (c *Chain) PutEquivocation(equivocation Equivocation) error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+	key := makeKey(prefixEquivocation, binary.BigEndian.AppendUint16(
+		encodeUint32(uint32(equivocation.First.TimeSlotIndex)), equivocation.First.BlockAuthorIndex))
+	if _, err := c.db.Get(key); err == nil {
+		return nil
+	}
+	value, err := jam.Marshal(equivocation)
+	if err != nil {
+		return fmt.Errorf("marshal equivocation: %w", err)
+	}
+	if err := c.db.Put(key, value); err != nil {
+		return fmt.Errorf("store equivocation: %w", err)
+	}
+	return nil
+}
+
+// GetEquivocations returns the evidence of all stored equivocations, ordered
+// by timeslot and author index
+func (c *Reader) GetEquivocations() ([]Equivocation, error) {
+	if c.closed.Load() {
+		return nil, ErrChainClosed
+	}
+	keys, err := c.keysWithPrefix([]byte{prefixEquivocation})
+	if err != nil {
+		return nil, err
+	}
+	equivocations := make([]Equivocation, 0, len(keys))
+	for _, key := range keys {
+		value, err := c.db.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("get equivocation: %w", err)
+		}
+		var equivocation Equivocation
+		if err := jam.Unmarshal(value, &equivocation); err != nil {
+			return nil, fmt.Errorf("unmarshal equivocation: %w", err)
+		}
+		equivocations = append(equivocations, equivocation)
+	}
+	return equivocations, nil
+}
+
+// PutVoteEquivocation stores the encoded evidence of a finality voter signing
+// two different votes of the same kind in a round, keyed by the round, the
+// vote kind and the voter index. Evidence already stored for the same key is
+// kept.
+func (c *Chain) PutVoteEquivocation(round uint64, kind uint8, validatorIndex uint16, evidence []byte) error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+	key := binary.BigEndian.AppendUint64(nil, round)
+	key = append(key, kind)
+	key = makeKey(prefixVoteEquivocation, binary.BigEndian.AppendUint16(key, validatorIndex))
+	if _, err := c.db.Get(key); err == nil {
+		return nil
+	}
+	if err := c.db.Put(key, evidence); err != nil {
+		return fmt.Errorf("store vote equivocation: %w", err)
+	}
+	return nil
+}
+
+// GetVoteEquivocations returns the encoded evidence of all stored finality
+// vote equivocations, ordered by round, vote kind and voter index
+func (c *Reader) GetVoteEquivocations() ([][]byte, error) {
+	if c.closed.Load() {
+		return nil, ErrChainClosed
+	}
+	keys, err := c.keysWithPrefix([]byte{prefixVoteEquivocation})
+	if err != nil {
+		return nil, err
+	}
+	evidence := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		value, err := c.db.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("get vote equivocation: %w", err)
+		}
+		evidence = append(evidence, value)
+	}
+	return evidence, nil
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/testutils"
+)
+
+func Test_PutGetEquivocations(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	equivocations, err := chain.GetEquivocations()
+	require.NoError(t, err)
+	assert.Empty(t, equivocations)
+
+	later := Equivocation{
+		Offender: testutils.RandomED25519PublicKey(t),
+		First:    block.Header{TimeSlotIndex: 5, BlockAuthorIndex: 1, ParentHash: testutils.RandomHash(t)},
+		Second:   block.Header{TimeSlotIndex: 5, BlockAuthorIndex: 1, ParentHash: testutils.RandomHash(t)},
+	}
+	earlier := Equivocation{
+		Offender: testutils.RandomED25519PublicKey(t),
+		First:    block.Header{TimeSlotIndex: 2, BlockAuthorIndex: 3, ParentHash: testutils.RandomHash(t)},
+		Second:   block.Header{TimeSlotIndex: 2, BlockAuthorIndex: 3, ParentHash: testutils.RandomHash(t)},
+	}
+	require.NoError(t, chain.PutEquivocation(later))
+	require.NoError(t, chain.PutEquivocation(earlier))
+
+	// Further evidence for the same timeslot and author is ignored
+	third := later
+	third.Second.ParentHash = testutils.RandomHash(t)
+	require.NoError(t, chain.PutEquivocation(third))
+
+	equivocations, err = chain.GetEquivocations()
+	require.NoError(t, err)
+	assert.Equal(t, []Equivocation{earlier, later}, equivocations)
+
+	// The evidence is not reported by the integrity check
+	report, err := chain.Check(false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Issues)
+}
+
+func Test_PutGetVoteEquivocations(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	evidence, err := chain.GetVoteEquivocations()
+	require.NoError(t, err)
+	assert.Empty(t, evidence)
+
+	require.NoError(t, chain.PutVoteEquivocation(2, 0, 1, []byte("later round")))
+	require.NoError(t, chain.PutVoteEquivocation(1, 1, 0, []byte("precommit")))
+	require.NoError(t, chain.PutVoteEquivocation(1, 0, 5, []byte("prevote")))
+
+	// Further evidence for the same round, kind and voter is ignored
+	require.NoError(t, chain.PutVoteEquivocation(1, 0, 5, []byte("again")))
+
+	evidence, err = chain.GetVoteEquivocations()
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("prevote"), []byte("precommit"), []byte("later round")}, evidence)
+
+	report, err := chain.Check(false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Issues)
+}
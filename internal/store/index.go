@@ -0,0 +1,330 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+// The chain store keeps the following secondary indexes, all written in the
+// same batch as the header they refer to:
+//   - children:  parent hash ++ child hash -> nil
+//   - timeslot:  timeslot (big endian) ++ header hash -> nil
+//   - height:    header hash -> block number (big endian)
+//   - canonical: block number (big endian) -> header hash
+//
+// Integers are big endian so that iteration order follows numeric order.
+
+// GetChildren returns the hashes of all known headers whose parent is the given hash
+func (c *Reader) GetChildren(parentHash crypto.Hash) ([]crypto.Hash, error) {
+	if c.closed.Load() {
+		return nil, ErrChainClosed
+	}
+	keys, err := c.keysWithPrefix(makeKey(prefixChildren, parentHash[:]))
+	if err != nil {
+		return nil, err
+	}
+	children := make([]crypto.Hash, 0, len(keys))
+	for _, key := range keys {
+		children = append(children, crypto.Hash(key[1+crypto.HashSize:]))
+	}
+	return children, nil
+}
+
+// GetHeadersAtTimeslot returns all known headers produced in the given timeslot
+func (c *Reader) GetHeadersAtTimeslot(slot jamtime.Timeslot) ([]block.Header, error) {
+	if c.closed.Load() {
+		return nil, ErrChainClosed
+	}
+	keys, err := c.keysWithPrefix(makeKey(prefixTimeslot, encodeUint32(uint32(slot))))
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]block.Header, 0, len(keys))
+	for _, key := range keys {
+		header, err := c.GetHeader(crypto.Hash(key[5:]))
+		if err != nil {
+			return nil, fmt.Errorf("get indexed header: %w", err)
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// GetBlockNumber returns the block number (height) of the header with the given hash.
+// Headers whose parent is unknown at insertion time, such as genesis, have number 0.
+func (c *Reader) GetBlockNumber(hash crypto.Hash) (uint32, error) {
+	if c.closed.Load() {
+		return 0, ErrChainClosed
+	}
+	value, err := c.db.Get(makeKey(prefixHeight, hash[:]))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return 0, ErrHeaderNotFound
+		}
+		return 0, fmt.Errorf("get block number: %w", err)
+	}
+	return binary.BigEndian.Uint32(value), nil
+}
+
+// GetCanonicalHashAt returns the hash of the canonical block at the given block number.
+// Until SetCanonicalHead is called, the first header stored at a given number
+// is canonical if its parent is.
+func (c *Reader) GetCanonicalHashAt(number uint32) (crypto.Hash, error) {
+	if c.closed.Load() {
+		return crypto.Hash{}, ErrChainClosed
+	}
+	value, err := c.db.Get(makeKey(prefixCanonical, encodeUint32(number)))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return crypto.Hash{}, ErrHeaderNotFound
+		}
+		return crypto.Hash{}, fmt.Errorf("get canonical hash: %w", err)
+	}
+	return crypto.Hash(value), nil
+}
+
+// SetCanonicalHead marks the chain ending at the given header as canonical.
+// It rewrites the canonical index from the head back to the first ancestor
+// that is already canonical, and drops canonical entries above the head.
+func (c *Chain) SetCanonicalHead(head crypto.Hash) error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+	headNumber, err := c.GetBlockNumber(head)
+	if err != nil {
+		return fmt.Errorf("get head block number: %w", err)
+	}
+
+	batch := c.db.NewBatch()
+	defer batch.Close()
+
+	// Drop canonical entries above the new head
+	staleKeys, err := c.keysInRange(
+		makeKey(prefixCanonical, encodeUint32(headNumber+1)),
+		[]byte{prefixCanonical + 1},
+	)
+	if err != nil {
+		return err
+	}
+	for _, key := range staleKeys {
+		if err := batch.Delete(key); err != nil {
+			return fmt.Errorf("delete canonical entry: %w", err)
+		}
+	}
+
+	// Walk back from the head until we meet the existing canonical chain
+	currentHash, number := head, headNumber
+	for {
+		canonical, err := c.GetCanonicalHashAt(number)
+		if err != nil && !errors.Is(err, ErrHeaderNotFound) {
+			return err
+		}
+		if err == nil && canonical == currentHash {
+			break
+		}
+		if err := batch.Put(makeKey(prefixCanonical, encodeUint32(number)), currentHash[:]); err != nil {
+			return fmt.Errorf("store canonical entry: %w", err)
+		}
+		if number == 0 {
+			break
+		}
+		header, err := c.GetHeader(currentHash)
+		if err != nil {
+			return fmt.Errorf("get header: %w", err)
+		}
+		currentHash, number = header.ParentHash, number-1
+	}
+
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	return nil
+}
+
+// RebuildIndexes drops all secondary indexes and rebuilds them from the stored
+// headers. This is used to upgrade databases written before the indexes existed.
+func (c *Chain) RebuildIndexes() error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+
+	batch := c.db.NewBatch()
+	defer batch.Close()
+
+	for _, prefix := range []byte{prefixChildren, prefixTimeslot, prefixHeight, prefixCanonical} {
+		keys, err := c.keysWithPrefix([]byte{prefix})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := batch.Delete(key); err != nil {
+				return fmt.Errorf("delete index entry: %w", err)
+			}
+		}
+	}
+
+	var headers []block.Header
+	if _, err := c.FindHeader(func(header block.Header) bool {
+		headers = append(headers, header)
+		return false
+	}); err != nil {
+		return fmt.Errorf("scan headers: %w", err)
+	}
+
+	// Parents always precede their children in timeslot order
+	sort.SliceStable(headers, func(i, j int) bool {
+		return headers[i].TimeSlotIndex < headers[j].TimeSlotIndex
+	})
+
+	numbers := make(map[crypto.Hash]uint32, len(headers))
+	canonical := make(map[uint32]crypto.Hash)
+	for _, header := range headers {
+		hash, err := header.Hash()
+		if err != nil {
+			return fmt.Errorf("hash header: %w", err)
+		}
+
+		number := uint32(0)
+		if parentNumber, ok := numbers[header.ParentHash]; ok {
+			number = parentNumber + 1
+		}
+		numbers[hash] = number
+
+		if err := putStaticIndexes(batch, header, hash, number); err != nil {
+			return err
+		}
+		_, taken := canonical[number]
+		if !taken && (number == 0 || canonical[number-1] == header.ParentHash) {
+			canonical[number] = hash
+			if err := batch.Put(makeKey(prefixCanonical, encodeUint32(number)), hash[:]); err != nil {
+				return fmt.Errorf("store canonical entry: %w", err)
+			}
+		}
+	}
+
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	return nil
+}
+
+// putIndexes adds the index entries for a newly stored header to the batch
+func (c *Chain) putIndexes(batch db.Batch, header block.Header, hash crypto.Hash) error {
+	number := uint32(0)
+	parentNumber, err := c.GetBlockNumber(header.ParentHash)
+	if err == nil {
+		number = parentNumber + 1
+	} else if !errors.Is(err, ErrHeaderNotFound) {
+		return err
+	}
+
+	if err := putStaticIndexes(batch, header, hash, number); err != nil {
+		return err
+	}
+
+	// The first header seen at a given number becomes canonical if it extends
+	// the canonical chain, so that the canonical entries always form a chain
+	_, err = c.GetCanonicalHashAt(number)
+	if !errors.Is(err, ErrHeaderNotFound) {
+		return err
+	}
+	if number > 0 {
+		parentCanonical, err := c.GetCanonicalHashAt(number - 1)
+		if err != nil && !errors.Is(err, ErrHeaderNotFound) {
+			return err
+		}
+		if err != nil || parentCanonical != header.ParentHash {
+			return nil
+		}
+	}
+	return batch.Put(makeKey(prefixCanonical, encodeUint32(number)), hash[:])
+}
+
+// putStaticIndexes adds the index entries that only depend on the header itself
+func putStaticIndexes(batch db.Batch, header block.Header, hash crypto.Hash, number uint32) error {
+	if err := batch.Put(childKey(header.ParentHash, hash), nil); err != nil {
+		return fmt.Errorf("store child entry: %w", err)
+	}
+	if err := batch.Put(timeslotKey(header.TimeSlotIndex, hash), nil); err != nil {
+		return fmt.Errorf("store timeslot entry: %w", err)
+	}
+	if err := batch.Put(makeKey(prefixHeight, hash[:]), encodeUint32(number)); err != nil {
+		return fmt.Errorf("store height entry: %w", err)
+	}
+	return nil
+}
+
+// nextChild returns the canonical child of the given block, or the first
+// known child if none of the children is canonical
+func (c *Reader) nextChild(parentHash crypto.Hash) (crypto.Hash, bool, error) {
+	children, err := c.GetChildren(parentHash)
+	if err != nil || len(children) == 0 {
+		return crypto.Hash{}, false, err
+	}
+	number, err := c.GetBlockNumber(children[0])
+	if err != nil {
+		return crypto.Hash{}, false, err
+	}
+	canonical, err := c.GetCanonicalHashAt(number)
+	if err != nil && !errors.Is(err, ErrHeaderNotFound) {
+		return crypto.Hash{}, false, err
+	}
+	for _, child := range children {
+		if child == canonical {
+			return child, true, nil
+		}
+	}
+	return children[0], true, nil
+}
+
+// keysWithPrefix returns all keys starting with the given prefix
+func (c *Reader) keysWithPrefix(prefix []byte) ([][]byte, error) {
+	return c.keysInRange(prefix, prefixUpperBound(prefix))
+}
+
+// keysInRange returns all keys in the range [start, end)
+func (c *Reader) keysInRange(start, end []byte) ([][]byte, error) {
+	iter, err := c.db.NewIterator(start, end)
+	if err != nil {
+		return nil, fmt.Errorf("create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	var keys [][]byte
+	for iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	return keys, nil
+}
+
+// prefixUpperBound returns the smallest key greater than every key with the given prefix
+func prefixUpperBound(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil // prefix is all 0xff, no upper bound
+}
+
+func childKey(parentHash, childHash crypto.Hash) []byte {
+	return makeKey(prefixChildren, append(parentHash[:], childHash[:]...))
+}
+
+func timeslotKey(slot jamtime.Timeslot, hash crypto.Hash) []byte {
+	return makeKey(prefixTimeslot, append(encodeUint32(uint32(slot)), hash[:]...))
+}
+
+func encodeUint32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetChildren(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	parent := createRandomBlock(crypto.Hash{}, 1, t)
+	require.NoError(t, chain.PutBlock(parent))
+	parentHash, err := parent.Header.Hash()
+	require.NoError(t, err)
+
+	child1 := createRandomBlock(parentHash, 2, t)
+	child2 := createRandomBlock(parentHash, 3, t)
+	require.NoError(t, chain.PutBlock(child1))
+	require.NoError(t, chain.PutHeader(child2.Header))
+	child1Hash, err := child1.Header.Hash()
+	require.NoError(t, err)
+	child2Hash, err := child2.Header.Hash()
+	require.NoError(t, err)
+
+	children, err := chain.GetChildren(parentHash)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []crypto.Hash{child1Hash, child2Hash}, children)
+
+	// Headers stored without a body are not returned as blocks
+	blocks, err := chain.FindChildren(parentHash)
+	require.NoError(t, err)
+	assert.Equal(t, []block.Block{child1}, blocks)
+
+	children, err = chain.GetChildren(child1Hash)
+	require.NoError(t, err)
+	assert.Empty(t, children)
+}
+
+func Test_GetHeadersAtTimeslot(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	header1 := block.Header{ParentHash: testutils.RandomHash(t), TimeSlotIndex: 5}
+	header2 := block.Header{ParentHash: testutils.RandomHash(t), TimeSlotIndex: 5}
+	header3 := block.Header{ParentHash: testutils.RandomHash(t), TimeSlotIndex: 6}
+	for _, h := range []block.Header{header1, header2, header3} {
+		require.NoError(t, chain.PutHeader(h))
+	}
+
+	headers, err := chain.GetHeadersAtTimeslot(5)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []block.Header{header1, header2}, headers)
+
+	headers, err = chain.GetHeadersAtTimeslot(7)
+	require.NoError(t, err)
+	assert.Empty(t, headers)
+}
+
+func Test_BlockNumberAndCanonicalHash(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blocks := createNumOfRandomBlocks(4, t)
+	for _, b := range blocks {
+		require.NoError(t, chain.PutBlock(b))
+	}
+
+	for i, b := range blocks {
+		hash, err := b.Header.Hash()
+		require.NoError(t, err)
+
+		number, err := chain.GetBlockNumber(hash)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(i), number)
+
+		canonical, err := chain.GetCanonicalHashAt(uint32(i))
+		require.NoError(t, err)
+		assert.Equal(t, hash, canonical)
+	}
+
+	_, err := chain.GetCanonicalHashAt(4)
+	require.ErrorIs(t, err, ErrHeaderNotFound)
+	_, err = chain.GetBlockNumber(testutils.RandomHash(t))
+	require.ErrorIs(t, err, ErrHeaderNotFound)
+}
+
+func Test_SetCanonicalHead(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	// Main chain 0 -> 1 -> 2 -> 3
+	blocks := createNumOfRandomBlocks(4, t)
+	for _, b := range blocks {
+		require.NoError(t, chain.PutBlock(b))
+	}
+	hash1, err := blocks[1].Header.Hash()
+	require.NoError(t, err)
+
+	// Fork 1 -> 2' stored after the main chain, so not canonical
+	fork := createRandomBlock(hash1, blocks[2].Header.TimeSlotIndex+10, t)
+	require.NoError(t, chain.PutBlock(fork))
+	forkHash, err := fork.Header.Hash()
+	require.NoError(t, err)
+
+	canonical, err := chain.GetCanonicalHashAt(2)
+	require.NoError(t, err)
+	assert.NotEqual(t, forkHash, canonical)
+
+	// Switch to the fork
+	require.NoError(t, chain.SetCanonicalHead(forkHash))
+
+	canonical, err = chain.GetCanonicalHashAt(2)
+	require.NoError(t, err)
+	assert.Equal(t, forkHash, canonical)
+	canonical, err = chain.GetCanonicalHashAt(1)
+	require.NoError(t, err)
+	assert.Equal(t, hash1, canonical)
+	_, err = chain.GetCanonicalHashAt(3)
+	require.ErrorIs(t, err, ErrHeaderNotFound)
+
+	// A child of a block which is not canonical does not become canonical,
+	// even if it is the first header stored at its number
+	hash2, err := blocks[2].Header.Hash()
+	require.NoError(t, err)
+	orphan := createRandomBlock(hash2, blocks[3].Header.TimeSlotIndex+10, t)
+	require.NoError(t, chain.PutBlock(orphan))
+	_, err = chain.GetCanonicalHashAt(3)
+	require.ErrorIs(t, err, ErrHeaderNotFound)
+
+	// Ascending sequences follow the canonical chain
+	hash0, err := blocks[0].Header.Hash()
+	require.NoError(t, err)
+	sequence, err := chain.GetBlockSequence(hash0, true, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []block.Block{blocks[1], fork}, sequence)
+}
+
+func Test_RebuildIndexes(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blocks := createNumOfRandomBlocks(3, t)
+	// Write headers bypassing the indexes, as an older database would have them
+	for _, b := range blocks {
+		bytes, err := b.Header.Bytes()
+		require.NoError(t, err)
+		hash, err := b.Header.Hash()
+		require.NoError(t, err)
+		require.NoError(t, chain.db.Put(makeKey(prefixHeader, hash[:]), bytes))
+	}
+	hash0, err := blocks[0].Header.Hash()
+	require.NoError(t, err)
+	hash1, err := blocks[1].Header.Hash()
+	require.NoError(t, err)
+
+	children, err := chain.GetChildren(hash0)
+	require.NoError(t, err)
+	require.Empty(t, children)
+
+	require.NoError(t, chain.RebuildIndexes())
+
+	children, err = chain.GetChildren(hash0)
+	require.NoError(t, err)
+	assert.Equal(t, []crypto.Hash{hash1}, children)
+
+	headers, err := chain.GetHeadersAtTimeslot(blocks[2].Header.TimeSlotIndex)
+	require.NoError(t, err)
+	assert.Equal(t, []block.Header{blocks[2].Header}, headers)
+
+	canonical, err := chain.GetCanonicalHashAt(1)
+	require.NoError(t, err)
+	assert.Equal(t, hash1, canonical)
+}
+
+func Test_Indexes_ChainClosed(t *testing.T) {
+	chain := newStore(t)
+	chain.Close()
+
+	_, err := chain.GetChildren(testutils.RandomHash(t))
+	require.ErrorIs(t, err, ErrChainClosed)
+	_, err = chain.GetHeadersAtTimeslot(jamtime.Timeslot(1))
+	require.ErrorIs(t, err, ErrChainClosed)
+	_, err = chain.GetCanonicalHashAt(0)
+	require.ErrorIs(t, err, ErrChainClosed)
+	require.ErrorIs(t, chain.RebuildIndexes(), ErrChainClosed)
+}
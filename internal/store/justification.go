@@ -0,0 +1,38 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+var ErrJustificationNotFound = errors.New("justification not found")
+
+// PutJustification stores the encoded finality justification of a block,
+// next to its header
+func (c *Chain) PutJustification(blockHash crypto.Hash, justification []byte) error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+	if err := c.db.Put(makeKey(prefixJustification, blockHash[:]), justification); err != nil {
+		return fmt.Errorf("store justification: %w", err)
+	}
+	return nil
+}
+
+// GetJustification retrieves the encoded finality justification of a block
+func (c *Reader) GetJustification(blockHash crypto.Hash) ([]byte, error) {
+	if c.closed.Load() {
+		return nil, ErrChainClosed
+	}
+	value, err := c.db.Get(makeKey(prefixJustification, blockHash[:]))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, ErrJustificationNotFound
+		}
+		return nil, fmt.Errorf("get justification: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/testutils"
+)
+
+func Test_PutGetJustification(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	header := block.Header{TimeSlotIndex: 1}
+	require.NoError(t, chain.PutHeader(header))
+	hash, err := header.Hash()
+	require.NoError(t, err)
+
+	_, err = chain.GetJustification(hash)
+	assert.ErrorIs(t, err, ErrJustificationNotFound)
+
+	require.NoError(t, chain.PutJustification(hash, []byte{1, 2, 3}))
+	justification, err := chain.GetJustification(hash)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, justification)
+}
+
+func Test_CheckOrphanJustification(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blockHash := testutils.RandomHash(t)
+	require.NoError(t, chain.PutJustification(blockHash, []byte{1}))
+
+	report, err := chain.Check(true)
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 1)
+	assert.Equal(t, IssueOrphanJustification, report.Issues[0].Kind)
+	assert.True(t, report.Issues[0].Repaired)
+
+	_, err = chain.GetJustification(blockHash)
+	assert.ErrorIs(t, err, ErrJustificationNotFound)
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+// chainMigrations upgrade the key layout of existing chain stores.
+// Append new migrations at the end, never change or reorder existing ones.
+var chainMigrations = []db.Migration{
+	{
+		Version:     1,
+		Description: "add children, timeslot, height and canonical indexes",
+		Apply: func(kvStore db.KVStore) error {
+			return NewChain(kvStore).RebuildIndexes()
+		},
+	},
+}
+
+// Migrate upgrades the chain store to the latest key layout. It must be
+// called before the store is used, databases written by older versions are
+// upgraded in place and new databases are stamped with the latest version.
+func (c *Chain) Migrate() error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+	if _, err := db.Migrate(c.db, chainMigrations); err != nil {
+		return fmt.Errorf("migrate chain store: %w", err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the key layout version of the chain store
+func (c *Chain) SchemaVersion() (uint32, error) {
+	if c.closed.Load() {
+		return 0, ErrChainClosed
+	}
+	return db.SchemaVersion(c.db)
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Migrate_NewStore(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	require.NoError(t, chain.Migrate())
+	version, err := chain.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(len(chainMigrations)), version)
+}
+
+func Test_Migrate_LegacyStore(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	// Headers written without indexes or a schema version, as the first key layout did
+	blocks := createNumOfRandomBlocks(3, t)
+	for _, b := range blocks {
+		bytes, err := b.Header.Bytes()
+		require.NoError(t, err)
+		hash, err := b.Header.Hash()
+		require.NoError(t, err)
+		require.NoError(t, chain.db.Put(makeKey(prefixHeader, hash[:]), bytes))
+	}
+	hash0, err := blocks[0].Header.Hash()
+	require.NoError(t, err)
+	hash1, err := blocks[1].Header.Hash()
+	require.NoError(t, err)
+
+	require.NoError(t, chain.Migrate())
+
+	children, err := chain.GetChildren(hash0)
+	require.NoError(t, err)
+	assert.Equal(t, []crypto.Hash{hash1}, children)
+
+	version, err := chain.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), version)
+}
+
+func Test_Migrate_NewerSchema(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	require.NoError(t, db.SetSchemaVersion(chain.db, uint32(len(chainMigrations)+1)))
+	require.ErrorIs(t, chain.Migrate(), db.ErrSchemaTooNew)
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+// PruneForks deletes every branch competing with the chain ending at the
+// finalized block. It walks back from the finalized block until it reaches
+// stop (exclusive) or a block whose parent is unknown, deleting all siblings
+// of each ancestor together with their descendants. The canonical index is
+// pointed at the finalized chain along the way, canonical entries above the
+// finalized block that belonged to a deleted fork are dropped.
+// Returns the hashes of the deleted headers and the number of deleted keys.
+func (c *Chain) PruneForks(finalized, stop crypto.Hash) ([]crypto.Hash, int, error) {
+	if c.closed.Load() {
+		return nil, 0, ErrChainClosed
+	}
+
+	batch := c.db.NewBatch()
+	defer batch.Close()
+
+	var (
+		deleted []crypto.Hash
+		keys    int
+	)
+	current := finalized
+	for current != stop {
+		header, err := c.GetHeader(current)
+		if err != nil {
+			if errors.Is(err, ErrHeaderNotFound) {
+				break
+			}
+			return nil, 0, fmt.Errorf("get header: %w", err)
+		}
+		number, err := c.GetBlockNumber(current)
+		if err != nil {
+			return nil, 0, fmt.Errorf("get block number: %w", err)
+		}
+		siblings, err := c.GetChildren(header.ParentHash)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, sibling := range siblings {
+			if sibling == current {
+				continue
+			}
+			hashes, n, err := c.deleteBranch(batch, sibling)
+			if err != nil {
+				return nil, 0, err
+			}
+			deleted = append(deleted, hashes...)
+			keys += n
+		}
+		// Written after the sibling deletions, which may drop a canonical entry at this number
+		if err := batch.Put(makeKey(prefixCanonical, encodeUint32(number)), current[:]); err != nil {
+			return nil, 0, fmt.Errorf("store canonical entry: %w", err)
+		}
+		current = header.ParentHash
+	}
+
+	if err := batch.Commit(); err != nil {
+		return nil, 0, fmt.Errorf("commit batch: %w", err)
+	}
+	return deleted, keys, nil
+}
+
+// DeleteBlockBodies deletes the bodies of all blocks produced in the timeslot
+// range [from, to). Headers and indexes are kept.
+// Returns the number of deleted keys.
+func (c *Chain) DeleteBlockBodies(from, to jamtime.Timeslot) (int, error) {
+	if c.closed.Load() {
+		return 0, ErrChainClosed
+	}
+	if from >= to {
+		return 0, nil
+	}
+
+	indexKeys, err := c.keysInRange(
+		makeKey(prefixTimeslot, encodeUint32(uint32(from))),
+		makeKey(prefixTimeslot, encodeUint32(uint32(to))),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := c.db.NewBatch()
+	defer batch.Close()
+
+	keys := 0
+	for _, indexKey := range indexKeys {
+		hash := crypto.Hash(indexKey[5:])
+		ok, err := c.deleteIfExists(batch, makeKey(prefixBlock, hash[:]))
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			keys++
+		}
+	}
+
+	if err := batch.Commit(); err != nil {
+		return 0, fmt.Errorf("commit batch: %w", err)
+	}
+	return keys, nil
+}
+
+// deleteBranch adds the deletion of the given header, all of its descendants,
+// their bodies, states and index entries to the batch.
+func (c *Chain) deleteBranch(batch db.Batch, root crypto.Hash) ([]crypto.Hash, int, error) {
+	var (
+		deleted []crypto.Hash
+		keys    int
+	)
+	queue := []crypto.Hash{root}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		header, err := c.GetHeader(hash)
+		if err != nil {
+			return nil, 0, fmt.Errorf("get header: %w", err)
+		}
+		children, err := c.GetChildren(hash)
+		if err != nil {
+			return nil, 0, err
+		}
+		queue = append(queue, children...)
+
+		number, err := c.GetBlockNumber(hash)
+		if err != nil {
+			return nil, 0, fmt.Errorf("get block number: %w", err)
+		}
+		canonical, err := c.GetCanonicalHashAt(number)
+		if err != nil && !errors.Is(err, ErrHeaderNotFound) {
+			return nil, 0, err
+		}
+		if err == nil && canonical == hash {
+			if err := batch.Delete(makeKey(prefixCanonical, encodeUint32(number))); err != nil {
+				return nil, 0, fmt.Errorf("delete canonical entry: %w", err)
+			}
+			keys++
+		}
+
+		for _, key := range [][]byte{
+			makeKey(prefixHeader, hash[:]),
+			childKey(header.ParentHash, hash),
+			timeslotKey(header.TimeSlotIndex, hash),
+			makeKey(prefixHeight, hash[:]),
+		} {
+			if err := batch.Delete(key); err != nil {
+				return nil, 0, fmt.Errorf("delete key: %w", err)
+			}
+			keys++
+		}
+		for _, key := range [][]byte{makeKey(prefixBlock, hash[:]), makeKey(prefixState, hash[:]), makeKey(prefixJustification, hash[:])} {
+			ok, err := c.deleteIfExists(batch, key)
+			if err != nil {
+				return nil, 0, err
+			}
+			if ok {
+				keys++
+			}
+		}
+		deleted = append(deleted, hash)
+	}
+	return deleted, keys, nil
+}
+
+// deleteIfExists adds the deletion of the key to the batch if the key is present
+func (c *Chain) deleteIfExists(batch db.Batch, key []byte) (bool, error) {
+	if _, err := c.db.Get(key); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get key: %w", err)
+	}
+	if err := batch.Delete(key); err != nil {
+		return false, fmt.Errorf("delete key: %w", err)
+	}
+	return true, nil
+}
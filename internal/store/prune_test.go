@@ -0,0 +1,148 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PruneForks(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	// Main chain 0 -> 1 -> 2 -> 3
+	blocks := createNumOfRandomBlocks(4, t)
+	for _, b := range blocks {
+		require.NoError(t, chain.PutBlock(b))
+	}
+	hashes := make([]crypto.Hash, len(blocks))
+	for i, b := range blocks {
+		hash, err := b.Header.Hash()
+		require.NoError(t, err)
+		hashes[i] = hash
+	}
+
+	// Fork 1 -> 2' -> 3', stored before the main chain was finalized
+	fork := createRandomBlock(hashes[1], blocks[2].Header.TimeSlotIndex+10, t)
+	require.NoError(t, chain.PutBlock(fork))
+	forkHash, err := fork.Header.Hash()
+	require.NoError(t, err)
+	forkChild := createRandomBlock(forkHash, fork.Header.TimeSlotIndex+1, t)
+	require.NoError(t, chain.PutHeader(forkChild.Header))
+	forkChildHash, err := forkChild.Header.Hash()
+	require.NoError(t, err)
+	require.NoError(t, chain.PutState(forkHash, crypto.Hash{}, nil, nil))
+
+	// Make the fork canonical, finalization must switch it back
+	require.NoError(t, chain.SetCanonicalHead(forkChildHash))
+
+	pruned, keys, err := chain.PruneForks(hashes[2], crypto.Hash{})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []crypto.Hash{forkHash, forkChildHash}, pruned)
+	assert.Positive(t, keys)
+
+	for _, hash := range pruned {
+		_, err := chain.GetHeader(hash)
+		require.ErrorIs(t, err, ErrHeaderNotFound)
+		_, err = chain.GetBlockNumber(hash)
+		require.ErrorIs(t, err, ErrHeaderNotFound)
+	}
+	_, err = chain.GetBlock(forkHash)
+	require.ErrorIs(t, err, ErrBlockNotFound)
+	_, _, _, err = chain.GetState(forkHash)
+	require.ErrorIs(t, err, ErrStateNotFound)
+
+	children, err := chain.GetChildren(hashes[1])
+	require.NoError(t, err)
+	assert.Equal(t, []crypto.Hash{hashes[2]}, children)
+
+	// The finalized chain and its descendants are kept
+	for i, b := range blocks {
+		stored, err := chain.GetBlock(hashes[i])
+		require.NoError(t, err)
+		assert.Equal(t, b, stored)
+	}
+	for i := range 3 {
+		canonical, err := chain.GetCanonicalHashAt(uint32(i))
+		require.NoError(t, err)
+		assert.Equal(t, hashes[i], canonical)
+	}
+}
+
+func Test_PruneForks_StopsAtPreviousFinalized(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blocks := createNumOfRandomBlocks(3, t)
+	for _, b := range blocks {
+		require.NoError(t, chain.PutBlock(b))
+	}
+	hash0, err := blocks[0].Header.Hash()
+	require.NoError(t, err)
+	hash2, err := blocks[2].Header.Hash()
+	require.NoError(t, err)
+
+	// A sibling of block 1 is below the previous finalized block and is not visited
+	sibling := createRandomBlock(hash0, blocks[1].Header.TimeSlotIndex+5, t)
+	require.NoError(t, chain.PutBlock(sibling))
+	hash1, err := blocks[1].Header.Hash()
+	require.NoError(t, err)
+
+	pruned, _, err := chain.PruneForks(hash2, hash1)
+	require.NoError(t, err)
+	assert.Empty(t, pruned)
+
+	siblingHash, err := sibling.Header.Hash()
+	require.NoError(t, err)
+	_, err = chain.GetHeader(siblingHash)
+	require.NoError(t, err)
+}
+
+func Test_DeleteBlockBodies(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blocks := createNumOfRandomBlocks(4, t)
+	for _, b := range blocks {
+		require.NoError(t, chain.PutBlock(b))
+	}
+
+	deleted, err := chain.DeleteBlockBodies(blocks[0].Header.TimeSlotIndex, blocks[2].Header.TimeSlotIndex)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	for i, b := range blocks {
+		hash, err := b.Header.Hash()
+		require.NoError(t, err)
+
+		// Headers are always kept
+		header, err := chain.GetHeader(hash)
+		require.NoError(t, err)
+		assert.Equal(t, b.Header, header)
+
+		_, err = chain.GetBlock(hash)
+		if i < 2 {
+			require.ErrorIs(t, err, ErrBlockNotFound)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	// Deleting again is a no-op
+	deleted, err = chain.DeleteBlockBodies(blocks[0].Header.TimeSlotIndex, blocks[2].Header.TimeSlotIndex)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
+
+func Test_Prune_ChainClosed(t *testing.T) {
+	chain := newStore(t)
+	chain.Close()
+
+	_, _, err := chain.PruneForks(testutils.RandomHash(t), crypto.Hash{})
+	require.ErrorIs(t, err, ErrChainClosed)
+	_, err = chain.DeleteBlockBodies(0, 10)
+	require.ErrorIs(t, err, ErrChainClosed)
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Snapshot(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blocks := createNumOfRandomBlocks(4, t)
+	for _, b := range blocks[:2] {
+		require.NoError(t, chain.PutBlock(b))
+	}
+	hash0, err := blocks[0].Header.Hash()
+	require.NoError(t, err)
+	hash2, err := blocks[2].Header.Hash()
+	require.NoError(t, err)
+
+	snapshot, err := chain.NewSnapshot()
+	require.NoError(t, err)
+
+	// Blocks imported after the snapshot was taken are not visible through it
+	for _, b := range blocks[2:] {
+		require.NoError(t, chain.PutBlock(b))
+	}
+
+	sequence, err := snapshot.GetBlockSequence(hash0, true, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []block.Block{blocks[1]}, sequence)
+	_, err = snapshot.GetHeader(hash2)
+	require.ErrorIs(t, err, ErrHeaderNotFound)
+	_, err = snapshot.GetBlockNumber(hash2)
+	require.ErrorIs(t, err, ErrHeaderNotFound)
+
+	sequence, err = chain.GetBlockSequence(hash0, true, 10)
+	require.NoError(t, err)
+	assert.Equal(t, blocks[1:], sequence)
+
+	require.NoError(t, snapshot.Close())
+	_, err = snapshot.GetHeader(hash0)
+	require.ErrorIs(t, err, ErrChainClosed)
+}
+
+func Test_Snapshot_ChainClosed(t *testing.T) {
+	chain := newStore(t)
+	chain.Close()
+
+	_, err := chain.NewSnapshot()
+	require.ErrorIs(t, err, ErrChainClosed)
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/pkg/db"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+var ErrStateNotFound = errors.New("state not found")
+
+// storedState is the encoding of the posterior state of a block
+type storedState struct {
+	Root     crypto.Hash
+	Entries  []stateEntry // Ordered by key
+	Services []byte       // Lossless encoding of the service accounts
+}
+
+type stateEntry struct {
+	Key   crypto.Hash
+	Value []byte
+}
+
+// PutState stores the posterior state of a block, given as its state root,
+// its serialization into state keys and values and the encoding of its
+// service accounts. The serialization truncates the storage keys of the
+// services, so the state cannot be rebuilt from it alone.
+func (c *Chain) PutState(blockHash, root crypto.Hash, serialized map[crypto.Hash][]byte, services []byte) error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+	stored := storedState{Root: root, Entries: make([]stateEntry, 0, len(serialized)), Services: services}
+	for key, value := range serialized {
+		stored.Entries = append(stored.Entries, stateEntry{Key: key, Value: value})
+	}
+	sort.Slice(stored.Entries, func(i, j int) bool {
+		return bytes.Compare(stored.Entries[i].Key[:], stored.Entries[j].Key[:]) < 0
+	})
+	value, err := jam.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	if err := c.db.Put(makeKey(prefixState, blockHash[:]), value); err != nil {
+		return fmt.Errorf("store state: %w", err)
+	}
+	return nil
+}
+
+// GetState retrieves the state root, the serialized posterior state and the
+// encoded service accounts of a block
+func (c *Reader) GetState(blockHash crypto.Hash) (crypto.Hash, map[crypto.Hash][]byte, []byte, error) {
+	if c.closed.Load() {
+		return crypto.Hash{}, nil, nil, ErrChainClosed
+	}
+	value, err := c.db.Get(makeKey(prefixState, blockHash[:]))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return crypto.Hash{}, nil, nil, ErrStateNotFound
+		}
+		return crypto.Hash{}, nil, nil, fmt.Errorf("get state: %w", err)
+	}
+	var stored storedState
+	if err := jam.Unmarshal(value, &stored); err != nil {
+		return crypto.Hash{}, nil, nil, fmt.Errorf("unmarshal state: %w", err)
+	}
+	serialized := make(map[crypto.Hash][]byte, len(stored.Entries))
+	for _, entry := range stored.Entries {
+		serialized[entry.Key] = entry.Value
+	}
+	return stored.Root, serialized, stored.Services, nil
+}
+
+// HasState tells whether the posterior state of a block is stored
+func (c *Reader) HasState(blockHash crypto.Hash) (bool, error) {
+	if c.closed.Load() {
+		return false, ErrChainClosed
+	}
+	if _, err := c.db.Get(makeKey(prefixState, blockHash[:])); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get state: %w", err)
+	}
+	return true, nil
+}
+
+// DeleteState deletes the stored posterior state of a block, if any
+func (c *Chain) DeleteState(blockHash crypto.Hash) error {
+	if c.closed.Load() {
+		return ErrChainClosed
+	}
+	if err := c.db.Delete(makeKey(prefixState, blockHash[:])); err != nil {
+		return fmt.Errorf("delete state: %w", err)
+	}
+	return nil
+}
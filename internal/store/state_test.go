@@ -0,0 +1,64 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/testutils"
+)
+
+func Test_PutGetState(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blockHash := testutils.RandomHash(t)
+	root := testutils.RandomHash(t)
+	serialized := map[crypto.Hash][]byte{
+		testutils.RandomHash(t): {1, 2, 3},
+		testutils.RandomHash(t): {},
+	}
+
+	_, _, _, err := chain.GetState(blockHash)
+	assert.ErrorIs(t, err, ErrStateNotFound)
+	ok, err := chain.HasState(blockHash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	services := []byte{4, 5}
+	require.NoError(t, chain.PutState(blockHash, root, serialized, services))
+	storedRoot, storedSerialized, storedServices, err := chain.GetState(blockHash)
+	require.NoError(t, err)
+	assert.Equal(t, root, storedRoot)
+	assert.Equal(t, services, storedServices)
+	assert.Len(t, storedSerialized, 2)
+	for key, value := range serialized {
+		assert.Equal(t, len(value), len(storedSerialized[key]))
+	}
+	ok, err = chain.HasState(blockHash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, chain.DeleteState(blockHash))
+	_, _, _, err = chain.GetState(blockHash)
+	assert.ErrorIs(t, err, ErrStateNotFound)
+}
+
+func Test_CheckOrphanState(t *testing.T) {
+	chain := newStore(t)
+	defer chain.Close()
+
+	blockHash := testutils.RandomHash(t)
+	require.NoError(t, chain.PutState(blockHash, crypto.Hash{}, nil, nil))
+
+	report, err := chain.Check(true)
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 1)
+	assert.Equal(t, IssueOrphanState, report.Issues[0].Kind)
+	assert.True(t, report.Issues[0].Repaired)
+
+	_, _, _, err = chain.GetState(blockHash)
+	assert.ErrorIs(t, err, ErrStateNotFound)
+}
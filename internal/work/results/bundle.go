@@ -0,0 +1,102 @@
+package results
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/common"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/work"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+)
+
+var ErrInvalidAuditBundle = errors.New("invalid auditable work bundle")
+
+// AuditBundle holds the inputs of the evaluation of a work package, as
+// found in its auditable work bundle
+type AuditBundle struct {
+	Package            work.Package
+	ExtrinsicPreimages map[crypto.Hash][]byte // Extrinsic data by hash, X(w) of every item
+	SegmentData        map[crypto.Hash][]byte // Imported segments by segment root, S(w) of every item
+}
+
+// DecodeAuditBundle splits an auditable work bundle b = p || X(w) || S(w) || J(w)
+// (14.14 v0.5.4) into the work package and the data it imports. The segment
+// roots are the segment-root lookup of the audited report. The bundle is
+// rejected unless building it again from its parts results in the same bytes.
+func DecodeAuditBundle(b []byte, segmentRoots map[crypto.Hash]crypto.Hash) (AuditBundle, error) {
+	reader := bytes.NewReader(b)
+	var wp work.Package
+	if err := jam.NewDecoder(reader).Decode(&wp); err != nil {
+		return AuditBundle{}, fmt.Errorf("%w: decode work package: %w", ErrInvalidAuditBundle, err)
+	}
+	offset := len(b) - reader.Len()
+	next := func(size int) ([]byte, error) {
+		if size > len(b)-offset {
+			return nil, fmt.Errorf("%w: too short", ErrInvalidAuditBundle)
+		}
+		data := b[offset : offset+size]
+		offset += size
+		return data, nil
+	}
+
+	c := &Computation{
+		SegmentRoots:       segmentRoots,
+		SegmentData:        make(map[crypto.Hash][]byte),
+		ExtrinsicPreimages: make(map[crypto.Hash][]byte),
+	}
+	for _, item := range wp.WorkItems {
+		// X(w)
+		for _, extr := range item.Extrinsics {
+			data, err := next(int(extr.Length))
+			if err != nil {
+				return AuditBundle{}, err
+			}
+			c.ExtrinsicPreimages[extr.Hash] = data
+		}
+		// S(w) and its root
+		for _, imp := range item.ImportedSegments {
+			data, err := next(common.SizeOfSegment)
+			if err != nil {
+				return AuditBundle{}, err
+			}
+			c.SegmentData[c.lookup(imp.Hash)] = data
+		}
+		if _, err := next(crypto.HashSize); err != nil {
+			return AuditBundle{}, err
+		}
+		// J(w) and its root, which only depend on S(w)
+		jBlobs, _, err := c.buildJustificationData(item)
+		if err != nil {
+			return AuditBundle{}, fmt.Errorf("%w: %w", ErrInvalidAuditBundle, err)
+		}
+		if _, err := next(len(flattenBlobs(jBlobs)) + crypto.HashSize); err != nil {
+			return AuditBundle{}, err
+		}
+	}
+
+	rebuilt, err := c.buildAuditableWorkPackage(wp)
+	if err != nil {
+		return AuditBundle{}, fmt.Errorf("%w: %w", ErrInvalidAuditBundle, err)
+	}
+	if !bytes.Equal(rebuilt, b) {
+		return AuditBundle{}, fmt.Errorf("%w: does not match its contents", ErrInvalidAuditBundle)
+	}
+	return AuditBundle{
+		Package:            wp,
+		ExtrinsicPreimages: c.ExtrinsicPreimages,
+		SegmentData:        c.SegmentData,
+	}, nil
+}
+
+// EncodeAuditBundle builds the auditable work bundle of a work package from
+// the data it imports, b = p || X(w) || S(w) || J(w) (14.14 v0.5.4)
+func EncodeAuditBundle(bundle AuditBundle, segmentRoots map[crypto.Hash]crypto.Hash) ([]byte, error) {
+	c := &Computation{
+		SegmentRoots:       segmentRoots,
+		SegmentData:        bundle.SegmentData,
+		ExtrinsicPreimages: bundle.ExtrinsicPreimages,
+	}
+	return c.buildAuditableWorkPackage(bundle.Package)
+}
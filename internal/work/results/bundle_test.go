@@ -0,0 +1,52 @@
+package results
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/work"
+)
+
+func TestAuditBundleRoundTrip(t *testing.T) {
+	extrinsic := []byte("my extrinsic #1")
+	extrinsicHash := crypto.HashData(extrinsic)
+	segmentRoot := crypto.HashData([]byte("root"))
+	segment := createTestSegment(0x42)
+
+	bundle := AuditBundle{
+		Package: work.Package{
+			AuthorizationToken: []byte("token"),
+			WorkItems: []work.Item{
+				{
+					Payload:          []byte("payload"),
+					Extrinsics:       []work.Extrinsic{{Hash: extrinsicHash, Length: uint32(len(extrinsic))}},
+					ImportedSegments: []work.ImportedSegment{{Hash: segmentRoot}},
+				},
+				{Payload: []byte("no imports")},
+			},
+		},
+		ExtrinsicPreimages: map[crypto.Hash][]byte{extrinsicHash: extrinsic},
+		SegmentData:        map[crypto.Hash][]byte{segmentRoot: segment[:]},
+	}
+	encoded, err := EncodeAuditBundle(bundle, nil)
+	require.NoError(t, err)
+
+	decoded, err := DecodeAuditBundle(encoded, nil)
+	require.NoError(t, err)
+	assert.Equal(t, bundle.ExtrinsicPreimages, decoded.ExtrinsicPreimages)
+	assert.Equal(t, bundle.SegmentData, decoded.SegmentData)
+	reencoded, err := EncodeAuditBundle(decoded, nil)
+	require.NoError(t, err)
+	assert.Equal(t, encoded, reencoded)
+
+	// Truncated or altered bundles are rejected
+	_, err = DecodeAuditBundle(encoded[:len(encoded)-1], nil)
+	assert.ErrorIs(t, err, ErrInvalidAuditBundle)
+	altered := append([]byte{}, encoded...)
+	altered[len(altered)-1] ^= 1
+	_, err = DecodeAuditBundle(altered, nil)
+	assert.ErrorIs(t, err, ErrInvalidAuditBundle)
+}
@@ -17,6 +17,7 @@ import (
 // Section 15 of the graypaper 0.6.2.
 type GuaranteeManager struct {
 	computation *Computation
+	onBundle    func(erasureRoot crypto.Hash, bundle []byte)
 }
 
 func NewGuaranteeManager(computation *Computation) (*GuaranteeManager, error) {
@@ -28,6 +29,13 @@ func NewGuaranteeManager(computation *Computation) (*GuaranteeManager, error) {
 	}, nil
 }
 
+// OnBundle registers the function receiving the auditable work bundles of the
+// work packages we guarantee, by the erasure root of their work report. The
+// guarantor holds them to serve their shards to the auditors.
+func (gp *GuaranteeManager) OnBundle(fn func(erasureRoot crypto.Hash, bundle []byte)) {
+	gp.onBundle = fn
+}
+
 // ProcessWorkPackageGuarantee generates and validates a guarantee for a work package.
 // This handles the operations within the guarantor which generates guarantee based on received package and collaborating with other guarantors.
 // Section 15 of v0.6.2.
@@ -59,6 +67,14 @@ func (gp *GuaranteeManager) ProcessWorkPackageGuarantee(
 		return nil, errors.New("work report output size exceeds limit")
 	}
 
+	if gp.onBundle != nil {
+		bundle, err := gp.computation.buildAuditableWorkPackage(wp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build auditable work-package: %w", err)
+		}
+		gp.onBundle(workReport.WorkPackageSpecification.ErasureRoot, bundle)
+	}
+
 	// Encode the work report and generate the payload hash (15.2 v0.6.2)
 	payloadHash, err := gp.hashCoreIndexWithWorkReport(workReport, coreIndex)
 	if err != nil {
@@ -179,6 +179,39 @@ func TestProcessWorkPackageGuarantee(t *testing.T) {
 	}
 }
 
+func TestGuaranteeManager_OnBundle(t *testing.T) {
+	_, privateKey, err := testutils.RandomED25519Keys(t)
+	require.NoError(t, err)
+	exData := []byte("extrinsic #1")
+	exHash := crypto.HashData(exData)
+	wp := work.Package{
+		WorkItems: []work.Item{{
+			Extrinsics: []work.Extrinsic{{Hash: exHash, Length: uint32(len(exData))}},
+		}},
+	}
+
+	computation := NewComputation(mockAuthorizationInvoker{}, mockRefineInvoker{}, nil, nil, map[crypto.Hash][]byte{exHash: exData})
+	gm, err := NewGuaranteeManager(computation)
+	require.NoError(t, err)
+	bundles := make(map[crypto.Hash][]byte)
+	gm.OnBundle(func(erasureRoot crypto.Hash, bundle []byte) {
+		bundles[erasureRoot] = bundle
+	})
+
+	// The bundle is held as soon as the work package is evaluated, whether
+	// enough guarantors signed the report or not
+	_, _ = gm.ProcessWorkPackageGuarantee(wp, 1, 1, privateKey, EmptyCoreAuthorizersPool())
+	workReport, err := computation.EvaluateWorkPackage(wp, 1)
+	require.NoError(t, err)
+	require.Len(t, bundles, 1)
+	bundle, ok := bundles[workReport.WorkPackageSpecification.ErasureRoot]
+	require.True(t, ok)
+	assert.Len(t, bundle, int(workReport.WorkPackageSpecification.AuditableWorkBundleLength))
+	decoded, err := DecodeAuditBundle(bundle, nil)
+	require.NoError(t, err)
+	assert.Equal(t, exData, decoded.ExtrinsicPreimages[exHash])
+}
+
 func TestGenerateGuarantee(t *testing.T) {
 	_, privateKey1, err := testutils.RandomED25519Keys(t)
 	require.NoError(t, err)
@@ -3,11 +3,25 @@ package db
 // KVStore represents a key-value storage interface providing basic operations
 // for data manipulation and iteration.
 type KVStore interface {
-	Get(key []byte) ([]byte, error)
+	Reader
 	Put(key []byte, value []byte) error
 	Delete(key []byte) error
 	NewBatch() Batch
+	NewSnapshot() (Snapshot, error)
+	Close() error
+}
+
+// Reader provides read access to a key-value store.
+type Reader interface {
+	Get(key []byte) ([]byte, error)
 	NewIterator(start, end []byte) (Iterator, error)
+}
+
+// Snapshot is a read-only, point-in-time view of a KVStore.
+// Writes made after the snapshot was taken, including batches committed
+// concurrently, are never visible through it. Snapshots must be closed after use.
+type Snapshot interface {
+	Reader
 	Close() error
 }
 
@@ -0,0 +1,506 @@
+// Package dbtest provides a conformance test suite for db.KVStore backends.
+//
+// Every backend is expected to pass it from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		dbtest.TestKVStore(t, func(t *testing.T) db.KVStore {
+//			return mybackend.NewKVStore()
+//		})
+//	}
+package dbtest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+// NewStoreFunc creates a new empty store for a single test case.
+// The suite closes the store when the test case finishes.
+type NewStoreFunc func(t *testing.T) db.KVStore
+
+// TestKVStore runs the whole conformance suite against the backend
+func TestKVStore(t *testing.T, newStore NewStoreFunc) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store db.KVStore)
+	}{
+		{name: "basic_put_get", fn: testBasicPutGet},
+		{name: "overwrite", fn: testOverwrite},
+		{name: "delete_operations", fn: testDelete},
+		{name: "value_isolation", fn: testValueIsolation},
+		{name: "store_closure", fn: testStoreClosure},
+		{name: "basic_batch_operations", fn: testBasicBatchOperations},
+		{name: "batch_commit_closure", fn: testBatchCommitAndClose},
+		{name: "multiple_batches", fn: testMultipleBatches},
+		{name: "batch_atomicity", fn: testBatchAtomicity},
+		{name: "batch_operation_order", fn: testBatchOperationOrder},
+		{name: "batch_store_closed", fn: testBatchStoreClosed},
+		{name: "full_range_iteration", fn: testFullRangeIteration},
+		{name: "bounded_range_iteration", fn: testBoundedRangeIteration},
+		{name: "iterator_order", fn: testIteratorOrder},
+		{name: "iterator_validity", fn: testIteratorValidity},
+		{name: "iterator_store_closed", fn: testIteratorStoreClosed},
+		{name: "snapshot_isolation", fn: testSnapshotIsolation},
+		{name: "snapshot_iteration", fn: testSnapshotIteration},
+		{name: "snapshot_closure", fn: testSnapshotClosure},
+		{name: "snapshot_store_closed", fn: testSnapshotStoreClosed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newStore(t)
+			defer store.Close()
+
+			tc.fn(t, store)
+		})
+	}
+}
+
+func testBasicPutGet(t *testing.T, store db.KVStore) {
+	key := []byte("test-key")
+	value := []byte("test-value")
+
+	err := store.Put(key, value)
+	require.NoError(t, err)
+
+	retrieved, err := store.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, value, retrieved)
+
+	// Test non-existent key
+	_, err = store.Get([]byte("non-existent"))
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func testOverwrite(t *testing.T, store db.KVStore) {
+	key := []byte("key")
+	require.NoError(t, store.Put(key, []byte("first")))
+	require.NoError(t, store.Put(key, []byte("second")))
+
+	value, err := store.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), value)
+}
+
+func testDelete(t *testing.T, store db.KVStore) {
+	key := []byte("delete-test")
+	value := []byte("to-be-deleted")
+
+	err := store.Put(key, value)
+	require.NoError(t, err)
+
+	err = store.Delete(key)
+	require.NoError(t, err)
+
+	_, err = store.Get(key)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	// Delete non-existent key should not error
+	err = store.Delete([]byte("non-existent"))
+	assert.NoError(t, err)
+}
+
+func testValueIsolation(t *testing.T, store db.KVStore) {
+	key := []byte("key")
+	value := []byte("value")
+	require.NoError(t, store.Put(key, value))
+
+	// Modifying the slices passed to or returned from the store must not affect the stored value
+	value[0] = 'X'
+	retrieved, err := store.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), retrieved)
+
+	retrieved[0] = 'Y'
+	retrieved, err = store.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), retrieved)
+}
+
+func testStoreClosure(t *testing.T, store db.KVStore) {
+	err := store.Close()
+	require.NoError(t, err)
+
+	// Test operations after close
+	_, err = store.Get([]byte("key"))
+	assert.ErrorIs(t, err, db.ErrClosed)
+
+	err = store.Put([]byte("key"), []byte("value"))
+	assert.ErrorIs(t, err, db.ErrClosed)
+
+	err = store.Delete([]byte("key"))
+	assert.ErrorIs(t, err, db.ErrClosed)
+
+	// Double close should not error
+	err = store.Close()
+	assert.NoError(t, err)
+}
+
+func testBasicBatchOperations(t *testing.T, store db.KVStore) {
+	batch := store.NewBatch()
+	defer batch.Close()
+
+	// Test batch Put operations
+	keys := [][]byte{[]byte("key1"), []byte("key2"), []byte("key3")}
+	values := [][]byte{[]byte("value1"), []byte("value2"), []byte("value3")}
+
+	for i := range keys {
+		err := batch.Put(keys[i], values[i])
+		require.NoError(t, err)
+	}
+
+	// Delete one key in the same batch
+	err := batch.Delete(keys[1])
+	require.NoError(t, err)
+
+	// Commit batch
+	err = batch.Commit()
+	require.NoError(t, err)
+
+	// Verify values
+	val1, err := store.Get(keys[0])
+	require.NoError(t, err)
+	assert.Equal(t, values[0], val1)
+
+	// Verify deleted key
+	_, err = store.Get(keys[1])
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	val3, err := store.Get(keys[2])
+	require.NoError(t, err)
+	assert.Equal(t, values[2], val3)
+}
+
+func testBatchCommitAndClose(t *testing.T, store db.KVStore) {
+	batch := store.NewBatch()
+
+	// Add some operations
+	err := batch.Put([]byte("key"), []byte("value"))
+	require.NoError(t, err)
+
+	// Commit batch
+	err = batch.Commit()
+	require.NoError(t, err)
+
+	// Operations after commit should fail
+	err = batch.Put([]byte("key2"), []byte("value2"))
+	assert.ErrorIs(t, err, db.ErrBatchDone)
+
+	err = batch.Delete([]byte("key2"))
+	assert.ErrorIs(t, err, db.ErrBatchDone)
+
+	// Second commit should fail
+	err = batch.Commit()
+	assert.ErrorIs(t, err, db.ErrBatchDone)
+
+	// Close should not error
+	err = batch.Close()
+	assert.NoError(t, err)
+
+	// Double close should not error
+	err = batch.Close()
+	assert.NoError(t, err)
+}
+
+func testMultipleBatches(t *testing.T, store db.KVStore) {
+	batch1 := store.NewBatch()
+	batch2 := store.NewBatch()
+	defer batch1.Close()
+	defer batch2.Close()
+
+	// Write to both batches
+	err := batch1.Put([]byte("key1"), []byte("batch1"))
+	require.NoError(t, err)
+	err = batch2.Put([]byte("key2"), []byte("batch2"))
+	require.NoError(t, err)
+
+	// Commit both batches
+	err = batch1.Commit()
+	require.NoError(t, err)
+	err = batch2.Commit()
+	require.NoError(t, err)
+
+	// Verify both writes succeeded
+	val1, err := store.Get([]byte("key1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("batch1"), val1)
+
+	val2, err := store.Get([]byte("key2"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("batch2"), val2)
+}
+
+func testBatchAtomicity(t *testing.T, store db.KVStore) {
+	require.NoError(t, store.Put([]byte("existing"), []byte("value")))
+
+	batch := store.NewBatch()
+	require.NoError(t, batch.Put([]byte("key1"), []byte("value1")))
+	require.NoError(t, batch.Delete([]byte("existing")))
+
+	// Nothing is visible before commit
+	_, err := store.Get([]byte("key1"))
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	_, err = store.Get([]byte("existing"))
+	assert.NoError(t, err)
+
+	// A batch closed without commit is discarded
+	require.NoError(t, batch.Close())
+	_, err = store.Get([]byte("key1"))
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	_, err = store.Get([]byte("existing"))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, batch.Commit(), db.ErrBatchDone)
+}
+
+func testBatchOperationOrder(t *testing.T, store db.KVStore) {
+	batch := store.NewBatch()
+	defer batch.Close()
+
+	// Later operations on the same key win
+	require.NoError(t, batch.Put([]byte("put-delete"), []byte("value")))
+	require.NoError(t, batch.Delete([]byte("put-delete")))
+	require.NoError(t, batch.Delete([]byte("delete-put")))
+	require.NoError(t, batch.Put([]byte("delete-put"), []byte("value")))
+	require.NoError(t, batch.Put([]byte("put-put"), []byte("first")))
+	require.NoError(t, batch.Put([]byte("put-put"), []byte("second")))
+	require.NoError(t, batch.Commit())
+
+	_, err := store.Get([]byte("put-delete"))
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	value, err := store.Get([]byte("delete-put"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	value, err = store.Get([]byte("put-put"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), value)
+}
+
+func testBatchStoreClosed(t *testing.T, store db.KVStore) {
+	batch := store.NewBatch()
+	defer batch.Close()
+	require.NoError(t, batch.Put([]byte("key"), []byte("value")))
+
+	require.NoError(t, store.Close())
+	assert.ErrorIs(t, batch.Commit(), db.ErrClosed)
+}
+
+func testFullRangeIteration(t *testing.T, store db.KVStore) {
+	data := map[string]string{
+		"a": "value-a",
+		"b": "value-b",
+		"c": "value-c",
+		"d": "value-d",
+	}
+	putAll(t, store, data)
+
+	iter, err := store.NewIterator(nil, nil)
+	require.NoError(t, err)
+	defer iter.Close()
+
+	assert.Equal(t, data, collect(t, iter))
+}
+
+func testBoundedRangeIteration(t *testing.T, store db.KVStore) {
+	putAll(t, store, map[string]string{
+		"a": "value-a",
+		"b": "value-b",
+		"c": "value-c",
+		"d": "value-d",
+		"e": "value-e",
+	})
+
+	// The lower bound is inclusive, the upper bound exclusive
+	iter, err := store.NewIterator([]byte("b"), []byte("e"))
+	require.NoError(t, err)
+	defer iter.Close()
+	assert.Equal(t, map[string]string{
+		"b": "value-b",
+		"c": "value-c",
+		"d": "value-d",
+	}, collect(t, iter))
+
+	// Only a lower bound
+	iter, err = store.NewIterator([]byte("d"), nil)
+	require.NoError(t, err)
+	defer iter.Close()
+	assert.Equal(t, map[string]string{"d": "value-d", "e": "value-e"}, collect(t, iter))
+
+	// Only an upper bound
+	iter, err = store.NewIterator(nil, []byte("b"))
+	require.NoError(t, err)
+	defer iter.Close()
+	assert.Equal(t, map[string]string{"a": "value-a"}, collect(t, iter))
+
+	// Empty range
+	iter, err = store.NewIterator([]byte("x"), []byte("z"))
+	require.NoError(t, err)
+	defer iter.Close()
+	assert.False(t, iter.Next())
+}
+
+func testIteratorOrder(t *testing.T, store db.KVStore) {
+	keys := [][]byte{{0x02}, {0x01, 0xff}, {0x01}, {0x10}, {0x01, 0x00}}
+	for _, key := range keys {
+		require.NoError(t, store.Put(key, nil))
+	}
+
+	iter, err := store.NewIterator(nil, nil)
+	require.NoError(t, err)
+	defer iter.Close()
+
+	// Keys are returned in lexicographic byte order
+	var got [][]byte
+	for iter.Next() {
+		got = append(got, iter.Key())
+	}
+	assert.Equal(t, [][]byte{{0x01}, {0x01, 0x00}, {0x01, 0xff}, {0x02}, {0x10}}, got)
+}
+
+func testIteratorValidity(t *testing.T, store db.KVStore) {
+	testData := map[string]string{
+		"key1": "value1",
+		"key2": "value2",
+	}
+	putAll(t, store, testData)
+
+	iter, err := store.NewIterator(nil, nil)
+	require.NoError(t, err)
+	defer iter.Close()
+
+	// Initial state - iterator is not positioned
+	assert.False(t, iter.Valid())
+
+	// First Next() should position at first element
+	assert.True(t, iter.Next())
+	assert.True(t, iter.Valid())
+
+	val, err := iter.Value()
+	require.NoError(t, err)
+	assert.Contains(t, testData, string(iter.Key()))
+	assert.Equal(t, testData[string(iter.Key())], string(val))
+
+	// Should be able to move to second element
+	assert.True(t, iter.Next())
+	assert.True(t, iter.Valid())
+
+	val, err = iter.Value()
+	require.NoError(t, err)
+	assert.Contains(t, testData, string(iter.Key()))
+	assert.Equal(t, testData[string(iter.Key())], string(val))
+
+	// No more elements, and the iterator does not start over
+	assert.False(t, iter.Next())
+	assert.False(t, iter.Valid())
+	assert.False(t, iter.Next())
+
+	// Value() should error when invalid
+	_, err = iter.Value()
+	assert.ErrorIs(t, err, db.ErrIteratorInvalid)
+}
+
+func testIteratorStoreClosed(t *testing.T, store db.KVStore) {
+	require.NoError(t, store.Close())
+	_, err := store.NewIterator(nil, nil)
+	assert.ErrorIs(t, err, db.ErrClosed)
+}
+
+func testSnapshotIsolation(t *testing.T, store db.KVStore) {
+	require.NoError(t, store.Put([]byte("key1"), []byte("value1")))
+	require.NoError(t, store.Put([]byte("key2"), []byte("value2")))
+
+	snapshot, err := store.NewSnapshot()
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	// Changes after the snapshot was taken are not visible through it
+	batch := store.NewBatch()
+	require.NoError(t, batch.Put([]byte("key1"), []byte("updated")))
+	require.NoError(t, batch.Delete([]byte("key2")))
+	require.NoError(t, batch.Put([]byte("key3"), []byte("value3")))
+	require.NoError(t, batch.Commit())
+	require.NoError(t, batch.Close())
+	require.NoError(t, store.Put([]byte("key4"), []byte("value4")))
+
+	value, err := snapshot.Get([]byte("key1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value1"), value)
+	value, err = snapshot.Get([]byte("key2"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value2"), value)
+	for _, key := range []string{"key3", "key4"} {
+		_, err = snapshot.Get([]byte(key))
+		assert.ErrorIs(t, err, db.ErrNotFound)
+	}
+
+	// The store itself sees the new values
+	value, err = store.Get([]byte("key1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("updated"), value)
+}
+
+func testSnapshotIteration(t *testing.T, store db.KVStore) {
+	putAll(t, store, map[string]string{"a": "1", "b": "2", "c": "3"})
+
+	snapshot, err := store.NewSnapshot()
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	require.NoError(t, store.Delete([]byte("b")))
+	require.NoError(t, store.Put([]byte("bb"), []byte("4")))
+
+	iter, err := snapshot.NewIterator([]byte("a"), []byte("c"))
+	require.NoError(t, err)
+	defer iter.Close()
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, collect(t, iter))
+}
+
+func testSnapshotClosure(t *testing.T, store db.KVStore) {
+	require.NoError(t, store.Put([]byte("key"), []byte("value")))
+
+	snapshot, err := store.NewSnapshot()
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Close())
+
+	_, err = snapshot.Get([]byte("key"))
+	assert.ErrorIs(t, err, db.ErrClosed)
+	_, err = snapshot.NewIterator(nil, nil)
+	assert.ErrorIs(t, err, db.ErrClosed)
+
+	// Double close should not error
+	assert.NoError(t, snapshot.Close())
+
+	// The store is unaffected
+	_, err = store.Get([]byte("key"))
+	assert.NoError(t, err)
+}
+
+func testSnapshotStoreClosed(t *testing.T, store db.KVStore) {
+	require.NoError(t, store.Close())
+	_, err := store.NewSnapshot()
+	assert.ErrorIs(t, err, db.ErrClosed)
+}
+
+func putAll(t *testing.T, store db.KVStore, data map[string]string) {
+	for k, v := range data {
+		require.NoError(t, store.Put([]byte(k), []byte(v)))
+	}
+}
+
+// collect drains the iterator into a map, failing on duplicate keys
+func collect(t *testing.T, iter db.Iterator) map[string]string {
+	result := make(map[string]string)
+	for iter.Next() {
+		value, err := iter.Value()
+		require.NoError(t, err)
+		key := string(iter.Key())
+		_, duplicate := result[key]
+		require.False(t, duplicate, fmt.Sprintf("duplicate key %q", key))
+		result[key] = string(value)
+	}
+	return result
+}
@@ -0,0 +1,12 @@
+package db
+
+import "errors"
+
+// Errors shared by all KVStore backends, so that callers do not depend on a
+// specific backend to recognise them.
+var (
+	ErrClosed          = errors.New("db is closed")
+	ErrNotFound        = errors.New("key not found")
+	ErrBatchDone       = errors.New("batch is already committed or closed")
+	ErrIteratorInvalid = errors.New("iterator is not valid")
+)
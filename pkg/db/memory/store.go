@@ -0,0 +1,237 @@
+// Package memory implements db.KVStore on top of a plain Go map. It has no
+// caches or background work, which makes it a cheap backend for unit tests
+// and short-lived tools that do not need persistence.
+package memory
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+// KVStore is an in-memory, map backed key-value store
+type KVStore struct {
+	mu     sync.RWMutex
+	data   map[string][]byte
+	closed atomic.Bool
+}
+
+// NewKVStore creates a new empty in-memory store
+func NewKVStore() *KVStore {
+	return &KVStore{data: make(map[string][]byte)}
+}
+
+func (m *KVStore) Get(key []byte) ([]byte, error) {
+	if m.closed.Load() {
+		return nil, db.ErrClosed
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return get(m.data, key)
+}
+
+func (m *KVStore) Put(key, value []byte) error {
+	if m.closed.Load() {
+		return db.ErrClosed
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[string(key)] = bytes.Clone(value)
+	return nil
+}
+
+func (m *KVStore) Delete(key []byte) error {
+	if m.closed.Load() {
+		return db.ErrClosed
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *KVStore) NewBatch() db.Batch {
+	return &Batch{store: m}
+}
+
+// NewIterator returns an iterator over the keys in [start, end). A nil bound
+// is unbounded. The iterator works on a copy of the matching entries, so
+// writes made after its creation are not visible.
+func (m *KVStore) NewIterator(start, end []byte) (db.Iterator, error) {
+	if m.closed.Load() {
+		return nil, db.ErrClosed
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return newIterator(m.data, start, end), nil
+}
+
+// NewSnapshot copies the current contents of the store
+func (m *KVStore) NewSnapshot() (db.Snapshot, error) {
+	if m.closed.Load() {
+		return nil, db.ErrClosed
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data := make(map[string][]byte, len(m.data))
+	for k, v := range m.data {
+		data[k] = v // Values are never mutated in place, sharing them is safe
+	}
+	return &Snapshot{data: data}, nil
+}
+
+func (m *KVStore) Close() error {
+	m.closed.Store(true)
+	return nil
+}
+
+func get(data map[string][]byte, key []byte) ([]byte, error) {
+	value, ok := data[string(key)]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return bytes.Clone(value), nil
+}
+
+// Batch collects writes and applies them to the store at once on Commit
+type Batch struct {
+	store *KVStore
+	ops   []operation
+	done  bool
+}
+
+type operation struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+func (b *Batch) Put(key, value []byte) error {
+	if b.done {
+		return db.ErrBatchDone
+	}
+	b.ops = append(b.ops, operation{key: string(key), value: bytes.Clone(value)})
+	return nil
+}
+
+func (b *Batch) Delete(key []byte) error {
+	if b.done {
+		return db.ErrBatchDone
+	}
+	b.ops = append(b.ops, operation{key: string(key), delete: true})
+	return nil
+}
+
+func (b *Batch) Commit() error {
+	if b.done {
+		return db.ErrBatchDone
+	}
+	if b.store.closed.Load() {
+		return db.ErrClosed
+	}
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	for _, op := range b.ops {
+		if op.delete {
+			delete(b.store.data, op.key)
+		} else {
+			b.store.data[op.key] = op.value
+		}
+	}
+	b.done = true
+	b.ops = nil
+	return nil
+}
+
+func (b *Batch) Close() error {
+	b.done = true
+	b.ops = nil
+	return nil
+}
+
+// Snapshot is a read-only copy of the store taken by NewSnapshot
+type Snapshot struct {
+	data   map[string][]byte
+	closed atomic.Bool
+}
+
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if s.closed.Load() {
+		return nil, db.ErrClosed
+	}
+	return get(s.data, key)
+}
+
+func (s *Snapshot) NewIterator(start, end []byte) (db.Iterator, error) {
+	if s.closed.Load() {
+		return nil, db.ErrClosed
+	}
+	return newIterator(s.data, start, end), nil
+}
+
+func (s *Snapshot) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+// Iterator walks over a sorted copy of the entries in its range
+type Iterator struct {
+	keys   []string
+	values [][]byte
+	pos    int // -1 before the first call to Next
+}
+
+func newIterator(data map[string][]byte, start, end []byte) *Iterator {
+	keys := make([]string, 0)
+	for k := range data {
+		if start != nil && k < string(start) {
+			continue
+		}
+		if end != nil && k >= string(end) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		values[i] = data[k]
+	}
+	return &Iterator{keys: keys, values: values, pos: -1}
+}
+
+func (it *Iterator) Next() bool {
+	if it.pos < len(it.keys) {
+		it.pos++
+	}
+	return it.Valid()
+}
+
+func (it *Iterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return []byte(it.keys[it.pos])
+}
+
+func (it *Iterator) Value() ([]byte, error) {
+	if !it.Valid() {
+		return nil, db.ErrIteratorInvalid
+	}
+	return bytes.Clone(it.values[it.pos]), nil
+}
+
+func (it *Iterator) Valid() bool {
+	return it.pos >= 0 && it.pos < len(it.keys)
+}
+
+func (it *Iterator) Close() error {
+	it.keys = nil
+	it.values = nil
+	return nil
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/eigerco/strawberry/pkg/db"
+	"github.com/eigerco/strawberry/pkg/db/dbtest"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.TestKVStore(t, func(t *testing.T) db.KVStore {
+		return NewKVStore()
+	})
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersionKey is the key under which the schema version of a store is
+// recorded. It starts with a zero byte so that it stays clear of the prefixed
+// key layouts used on top of the store.
+var SchemaVersionKey = []byte("\x00schema_version")
+
+var (
+	ErrSchemaTooNew         = errors.New("database schema is newer than supported")
+	ErrMigrationsOutOfOrder = errors.New("migrations must have consecutive versions starting at 1")
+)
+
+// Migration upgrades the key layout of a store from Version-1 to Version.
+// A crash may interrupt a migration before the new version is recorded, in
+// which case it runs again on the next start, so Apply must be idempotent.
+type Migration struct {
+	Version     uint32
+	Description string
+	Apply       func(store KVStore) error
+}
+
+// SchemaVersion returns the schema version recorded in the store, or 0 if the
+// store has no version record yet.
+func SchemaVersion(store Reader) (uint32, error) {
+	value, err := store.Get(SchemaVersionKey)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("get schema version: %w", err)
+	}
+	if len(value) != 4 {
+		return 0, fmt.Errorf("invalid schema version length: %d", len(value))
+	}
+	return binary.BigEndian.Uint32(value), nil
+}
+
+// SetSchemaVersion records the schema version of the store
+func SetSchemaVersion(store KVStore, version uint32) error {
+	if err := store.Put(SchemaVersionKey, binary.BigEndian.AppendUint32(nil, version)); err != nil {
+		return fmt.Errorf("store schema version: %w", err)
+	}
+	return nil
+}
+
+// Migrate brings the store up to the latest version by applying, in order,
+// every migration newer than the recorded schema version. The version is
+// recorded after each successful migration. It returns the resulting version.
+func Migrate(store KVStore, migrations []Migration) (uint32, error) {
+	for i, m := range migrations {
+		if m.Version != uint32(i+1) {
+			return 0, ErrMigrationsOutOfOrder
+		}
+	}
+	latest := uint32(len(migrations))
+
+	current, err := SchemaVersion(store)
+	if err != nil {
+		return 0, err
+	}
+	if current > latest {
+		return 0, fmt.Errorf("%w: database at version %d, latest known is %d", ErrSchemaTooNew, current, latest)
+	}
+
+	for _, m := range migrations[current:] {
+		if err := m.Apply(store); err != nil {
+			return current, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		if err := SetSchemaVersion(store, m.Version); err != nil {
+			return current, err
+		}
+		current = m.Version
+	}
+	return current, nil
+}
+
+// moveBatchSize is the number of keys moved per batch by MoveToNamespace
+const moveBatchSize = 1024
+
+// MoveToNamespace moves every key of the store into the namespace, except
+// the ones already in it or in one of the other namespaces, and the records
+// starting with a zero byte such as the schema version. It is meant for
+// migrating a store owned by a single component to a layout shared by several
+// namespaced ones, and can be run again after an interruption.
+func MoveToNamespace(store KVStore, namespace []byte, others ...[]byte) error {
+	snapshot, err := store.NewSnapshot()
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer snapshot.Close()
+	iter, err := snapshot.NewIterator(nil, nil)
+	if err != nil {
+		return fmt.Errorf("create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	batch := store.NewBatch()
+	defer func() { batch.Close() }()
+	pending := 0
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) == 0 || key[0] == 0 || hasAnyPrefix(key, namespace, others) {
+			continue
+		}
+		value, err := iter.Value()
+		if err != nil {
+			return fmt.Errorf("get value: %w", err)
+		}
+		if err := batch.Put(prefixKey(namespace, key), value); err != nil {
+			return fmt.Errorf("put moved key: %w", err)
+		}
+		if err := batch.Delete(bytes.Clone(key)); err != nil {
+			return fmt.Errorf("delete moved key: %w", err)
+		}
+		pending++
+		if pending == moveBatchSize {
+			if err := batch.Commit(); err != nil {
+				return fmt.Errorf("commit moved keys: %w", err)
+			}
+			batch.Close()
+			batch = store.NewBatch()
+			pending = 0
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("commit moved keys: %w", err)
+	}
+	return nil
+}
+
+func hasAnyPrefix(key, namespace []byte, others [][]byte) bool {
+	if bytes.HasPrefix(key, namespace) {
+		return true
+	}
+	for _, other := range others {
+		if bytes.HasPrefix(key, other) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package db_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/pkg/db"
+	"github.com/eigerco/strawberry/pkg/db/memory"
+)
+
+func TestMigrate(t *testing.T) {
+	store := memory.NewKVStore()
+	defer store.Close()
+
+	var applied []uint32
+	migration := func(version uint32) db.Migration {
+		return db.Migration{
+			Version:     version,
+			Description: "test",
+			Apply: func(store db.KVStore) error {
+				applied = append(applied, version)
+				return nil
+			},
+		}
+	}
+
+	version, err := db.SchemaVersion(store)
+	require.NoError(t, err)
+	assert.Zero(t, version)
+
+	version, err = db.Migrate(store, []db.Migration{migration(1), migration(2)})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), version)
+	assert.Equal(t, []uint32{1, 2}, applied)
+
+	// Only newer migrations are applied on the next run
+	version, err = db.Migrate(store, []db.Migration{migration(1), migration(2), migration(3)})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), version)
+	assert.Equal(t, []uint32{1, 2, 3}, applied)
+
+	version, err = db.SchemaVersion(store)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), version)
+
+	// A database written by a newer version is rejected
+	_, err = db.Migrate(store, []db.Migration{migration(1)})
+	require.ErrorIs(t, err, db.ErrSchemaTooNew)
+}
+
+func TestMigrateFailure(t *testing.T) {
+	store := memory.NewKVStore()
+	defer store.Close()
+
+	failure := errors.New("failure")
+	migrations := []db.Migration{
+		{Version: 1, Apply: func(db.KVStore) error { return nil }},
+		{Version: 2, Apply: func(db.KVStore) error { return failure }},
+	}
+	version, err := db.Migrate(store, migrations)
+	require.ErrorIs(t, err, failure)
+	assert.Equal(t, uint32(1), version)
+
+	// The failed migration is not recorded
+	version, err = db.SchemaVersion(store)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), version)
+}
+
+func TestMigrateOutOfOrder(t *testing.T) {
+	store := memory.NewKVStore()
+	defer store.Close()
+
+	_, err := db.Migrate(store, []db.Migration{{Version: 2, Apply: func(db.KVStore) error { return nil }}})
+	require.ErrorIs(t, err, db.ErrMigrationsOutOfOrder)
+}
+
+func TestMoveToNamespace(t *testing.T) {
+	store := memory.NewKVStore()
+	defer store.Close()
+
+	records := map[string]string{
+		"\x01header":     "header",
+		"\x02block":      "block",
+		"chain/\x01head": "head",
+		"trie/node":      "node",
+	}
+	for key, value := range records {
+		require.NoError(t, store.Put([]byte(key), []byte(value)))
+	}
+	require.NoError(t, db.SetSchemaVersion(store, 1))
+
+	for range 2 {
+		require.NoError(t, db.MoveToNamespace(store, []byte("chain/"), []byte("trie/")))
+
+		expected := map[string]string{
+			"chain/\x01header": "header",
+			"chain/\x02block":  "block",
+			"chain/\x01head":   "head",
+			"trie/node":        "node",
+		}
+		for key, value := range expected {
+			got, err := store.Get([]byte(key))
+			require.NoError(t, err, key)
+			assert.Equal(t, value, string(got))
+		}
+		for _, key := range []string{"\x01header", "\x02block"} {
+			_, err := store.Get([]byte(key))
+			require.ErrorIs(t, err, db.ErrNotFound)
+		}
+		version, err := db.SchemaVersion(store)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1), version)
+	}
+}
@@ -7,13 +7,17 @@ import (
 )
 
 type Batch struct {
-	batch *pebble.Batch
-	done  atomic.Bool
+	batch        *pebble.Batch
+	writeOptions *pebble.WriteOptions
+	storeClosed  *atomic.Bool
+	done         atomic.Bool
 }
 
 func (p *KVStore) NewBatch() db.Batch {
 	return &Batch{
-		batch: p.db.NewBatch(),
+		batch:        p.db.NewBatch(),
+		writeOptions: p.writeOptions,
+		storeClosed:  &p.closed,
 	}
 }
 
@@ -35,7 +39,10 @@ func (b *Batch) Commit() error {
 	if b.done.Load() {
 		return ErrBatchDone
 	}
-	if err := b.batch.Commit(pebble.Sync); err != nil {
+	if b.storeClosed.Load() {
+		return ErrClosed
+	}
+	if err := b.batch.Commit(b.writeOptions); err != nil {
 		return err
 	}
 	b.done.Store(true)
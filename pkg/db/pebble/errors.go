@@ -1,12 +1,17 @@
 package pebble
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/eigerco/strawberry/pkg/db"
+)
 
 var (
-	ErrClosed          = errors.New("db is closed")
-	ErrNotFound        = errors.New("key not found")
-	ErrBatchDone       = errors.New("batch is already committed or closed")
-	ErrIteratorInvalid = errors.New("iterator is not valid")
+	ErrClosed          = db.ErrClosed
+	ErrNotFound        = db.ErrNotFound
+	ErrBatchDone       = db.ErrBatchDone
+	ErrIteratorInvalid = db.ErrIteratorInvalid
+	ErrEmptyPath       = errors.New("data directory path is empty")
 
 	ErrInIteratorCreation = "failed to create iterator with error %w"
 	ErrIteratorValue      = "iterator value errored with %w"
@@ -7,11 +7,20 @@ import (
 )
 
 type Iterator struct {
-	iter *pebble.Iterator
+	iter    *pebble.Iterator
+	started bool
 }
 
 func (p *KVStore) NewIterator(start, end []byte) (db.Iterator, error) {
-	iter, err := p.db.NewIter(&pebble.IterOptions{
+	if p.closed.Load() {
+		return nil, ErrClosed
+	}
+	return newIterator(p.db, start, end)
+}
+
+// newIterator creates an iterator over either the database or a snapshot
+func newIterator(reader pebble.Reader, start, end []byte) (db.Iterator, error) {
+	iter, err := reader.NewIter(&pebble.IterOptions{
 		LowerBound: start,
 		UpperBound: end,
 	})
@@ -23,9 +32,14 @@ func (p *KVStore) NewIterator(start, end []byte) (db.Iterator, error) {
 
 func (it *Iterator) Next() bool {
 	// If the iterator is un-positioned, position it at the first key
-	if !it.iter.Valid() {
+	if !it.started {
+		it.started = true
 		return it.iter.First()
 	}
+	// Once exhausted, the iterator stays exhausted
+	if !it.iter.Valid() {
+		return false
+	}
 	// Otherwise, move to the next key
 	return it.iter.Next()
 }
@@ -0,0 +1,44 @@
+package pebble
+
+import (
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/eigerco/strawberry/pkg/db"
+)
+
+// Snapshot is a read-only, point-in-time view of a KVStore backed by a Pebble snapshot.
+// Snapshots must be closed before the store they were taken from.
+type Snapshot struct {
+	snap   *pebble.Snapshot
+	closed atomic.Bool
+}
+
+// NewSnapshot captures the current state of the store
+func (p *KVStore) NewSnapshot() (db.Snapshot, error) {
+	if p.closed.Load() {
+		return nil, ErrClosed
+	}
+	return &Snapshot{snap: p.db.NewSnapshot()}, nil
+}
+
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if s.closed.Load() {
+		return nil, ErrClosed
+	}
+	return get(s.snap, key)
+}
+
+func (s *Snapshot) NewIterator(start, end []byte) (db.Iterator, error) {
+	if s.closed.Load() {
+		return nil, ErrClosed
+	}
+	return newIterator(s.snap, start, end)
+}
+
+func (s *Snapshot) Close() error {
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	return s.snap.Close()
+}
@@ -3,39 +3,102 @@ package pebble
 import (
 	"errors"
 	"fmt"
+	"sync/atomic"
+
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/vfs"
-	"sync/atomic"
 )
 
+// SyncMode controls how writes are persisted to the write-ahead log.
+type SyncMode uint8
+
+const (
+	// SyncModeSync fsyncs the WAL on every write. This is the safest option.
+	SyncModeSync SyncMode = iota
+	// SyncModeNoSync writes to the WAL but leaves flushing to the OS.
+	// A crash may lose the most recent writes, but the database stays consistent.
+	SyncModeNoSync
+	// SyncModeDisabled disables the WAL entirely. Unflushed memtables are lost on crash.
+	SyncModeDisabled
+)
+
+// Config holds the tuning options for a Pebble backed KVStore.
+type Config struct {
+	// CacheSize is the size of the block cache in bytes.
+	CacheSize int64
+	// MemTableSize is the size of a single memtable in bytes.
+	MemTableSize uint64
+	// MemTableStopWritesThreshold is the number of queued memtables after which writes are stalled.
+	MemTableStopWritesThreshold int
+	// SyncMode controls the durability of writes.
+	SyncMode SyncMode
+}
+
+// DefaultConfig returns the configuration used by NewKVStore.
+func DefaultConfig() Config {
+	return Config{
+		CacheSize:                   64 * 1024 * 1024,
+		MemTableSize:                32 * 1024 * 1024,
+		MemTableStopWritesThreshold: 4,
+		SyncMode:                    SyncModeSync,
+	}
+}
+
 type KVStore struct {
-	db     *pebble.DB
-	closed atomic.Bool
+	db           *pebble.DB
+	writeOptions *pebble.WriteOptions
+	closed       atomic.Bool
 }
 
 // NewKVStore initializes a new in-memory key-value store using Pebble.
 func NewKVStore() (*KVStore, error) {
+	return open("", vfs.NewMem(), DefaultConfig()) // Empty string for path when using in-memory FS
+}
+
+// NewPersistentKVStore opens, or creates if missing, an on-disk key-value store
+// rooted at the given directory.
+func NewPersistentKVStore(dir string, config Config) (*KVStore, error) {
+	if dir == "" {
+		return nil, ErrEmptyPath
+	}
+	return open(dir, vfs.Default, config)
+}
+
+func open(dir string, fs vfs.FS, config Config) (*KVStore, error) {
+	cache := pebble.NewCache(config.CacheSize)
+	defer cache.Unref()
+
 	opts := &pebble.Options{
-		FS:                          vfs.NewMem(), // Use in-memory filesystem
-		Cache:                       pebble.NewCache(64 * 1024 * 1024),
-		MemTableSize:                32 * 1024 * 1024,
-		MemTableStopWritesThreshold: 4,
+		FS:                          fs,
+		Cache:                       cache,
+		MemTableSize:                config.MemTableSize,
+		MemTableStopWritesThreshold: config.MemTableStopWritesThreshold,
+		DisableWAL:                  config.SyncMode == SyncModeDisabled,
 	}
 
-	db, err := pebble.Open("", opts) // Empty string for path when using in-memory FS
+	db, err := pebble.Open(dir, opts)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("pebble open failed: %w", err)
 	}
 
-	return &KVStore{db: db}, nil
+	writeOptions := pebble.Sync
+	if config.SyncMode != SyncModeSync {
+		writeOptions = pebble.NoSync
+	}
+
+	return &KVStore{db: db, writeOptions: writeOptions}, nil
 }
 
 func (p *KVStore) Get(key []byte) ([]byte, error) {
 	if p.closed.Load() {
 		return nil, ErrClosed
 	}
+	return get(p.db, key)
+}
 
-	value, closer, err := p.db.Get(key)
+// get reads a key from either the database or a snapshot
+func get(reader pebble.Reader, key []byte) ([]byte, error) {
+	value, closer, err := reader.Get(key)
 	if err != nil {
 		if errors.Is(err, pebble.ErrNotFound) {
 			return nil, ErrNotFound
@@ -58,14 +121,14 @@ func (p *KVStore) Put(key, value []byte) error {
 	if p.closed.Load() {
 		return ErrClosed
 	}
-	return p.db.Set(key, value, pebble.Sync)
+	return p.db.Set(key, value, p.writeOptions)
 }
 
 func (p *KVStore) Delete(key []byte) error {
 	if p.closed.Load() {
 		return ErrClosed
 	}
-	return p.db.Delete(key, pebble.Sync)
+	return p.db.Delete(key, p.writeOptions)
 }
 
 func (p *KVStore) Close() error {
@@ -2,90 +2,75 @@ package pebble
 
 import (
 	"github.com/eigerco/strawberry/pkg/db"
+	"github.com/eigerco/strawberry/pkg/db/dbtest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
 
-func TestKVStore(t *testing.T) {
-	tests := []struct {
-		name string
-		fn   func(t *testing.T, store db.KVStore)
-	}{
-		{
-			name: "basic_put_get",
-			fn:   testBasicPutGet,
-		},
-		{
-			name: "delete_operations",
-			fn:   testDelete,
-		},
-		{
-			name: "store_closure",
-			fn:   testStoreClosure,
-		},
-	}
-
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			store, err := NewKVStore()
-			require.NoError(t, err)
-			defer store.Close()
+func TestConformance(t *testing.T) {
+	dbtest.TestKVStore(t, func(t *testing.T) db.KVStore {
+		store, err := NewKVStore()
+		require.NoError(t, err)
+		return store
+	})
+}
 
-			tc.fn(t, store)
-		})
-	}
+func TestPersistentConformance(t *testing.T) {
+	dbtest.TestKVStore(t, func(t *testing.T) db.KVStore {
+		store, err := NewPersistentKVStore(t.TempDir(), DefaultConfig())
+		require.NoError(t, err)
+		return store
+	})
 }
 
-func testBasicPutGet(t *testing.T, store db.KVStore) {
-	key := []byte("test-key")
-	value := []byte("test-value")
+func TestPersistentKVStore(t *testing.T) {
+	dir := t.TempDir()
 
-	err := store.Put(key, value)
+	store, err := NewPersistentKVStore(dir, DefaultConfig())
 	require.NoError(t, err)
 
-	retrieved, err := store.Get(key)
+	err = store.Put([]byte("key"), []byte("value"))
 	require.NoError(t, err)
-	assert.Equal(t, value, retrieved)
 
-	// Test non-existent key
-	_, err = store.Get([]byte("non-existent"))
-	assert.ErrorIs(t, err, ErrNotFound)
-}
+	batch := store.NewBatch()
+	require.NoError(t, batch.Put([]byte("batch-key"), []byte("batch-value")))
+	require.NoError(t, batch.Commit())
+	require.NoError(t, batch.Close())
 
-func testDelete(t *testing.T, store db.KVStore) {
-	key := []byte("delete-test")
-	value := []byte("to-be-deleted")
+	require.NoError(t, store.Close())
 
-	err := store.Put(key, value)
+	// Reopen the store and verify the data survived
+	store, err = NewPersistentKVStore(dir, DefaultConfig())
 	require.NoError(t, err)
+	defer store.Close()
 
-	err = store.Delete(key)
+	value, err := store.Get([]byte("key"))
 	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
 
-	_, err = store.Get(key)
-	assert.ErrorIs(t, err, ErrNotFound)
-
-	// Delete non-existent key should not error
-	err = store.Delete([]byte("non-existent"))
-	assert.NoError(t, err)
-}
-
-func testStoreClosure(t *testing.T, store db.KVStore) {
-	err := store.Close()
+	value, err = store.Get([]byte("batch-key"))
 	require.NoError(t, err)
+	assert.Equal(t, []byte("batch-value"), value)
+}
 
-	// Test operations after close
-	_, err = store.Get([]byte("key"))
-	assert.ErrorIs(t, err, ErrClosed)
+func TestPersistentKVStoreSyncModes(t *testing.T) {
+	for _, mode := range []SyncMode{SyncModeSync, SyncModeNoSync, SyncModeDisabled} {
+		config := DefaultConfig()
+		config.SyncMode = mode
 
-	err = store.Put([]byte("key"), []byte("value"))
-	assert.ErrorIs(t, err, ErrClosed)
+		store, err := NewPersistentKVStore(t.TempDir(), config)
+		require.NoError(t, err)
 
-	err = store.Delete([]byte("key"))
-	assert.ErrorIs(t, err, ErrClosed)
+		require.NoError(t, store.Put([]byte("key"), []byte("value")))
+		value, err := store.Get([]byte("key"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+		require.NoError(t, store.Close())
+	}
+}
 
-	// Double close should not error
-	err = store.Close()
-	assert.NoError(t, err)
+func TestPersistentKVStoreEmptyPath(t *testing.T) {
+	_, err := NewPersistentKVStore("", DefaultConfig())
+	assert.ErrorIs(t, err, ErrEmptyPath)
 }
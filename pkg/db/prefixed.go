@@ -0,0 +1,164 @@
+package db
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// Prefixed returns a view of the store scoped to the given namespace. Every key
+// written through the view is stored with the namespace prepended, and reads
+// and iterators only see keys of the namespace, with the namespace stripped.
+// This allows several components to share one database without their keys
+// colliding. Namespaces sharing a database must not be prefixes of each other.
+//
+// Closing the view does not close the underlying store, which stays owned by
+// the caller, since other views may still be using it.
+func Prefixed(store KVStore, namespace []byte) KVStore {
+	return &prefixedStore{
+		store:  store,
+		prefix: bytes.Clone(namespace),
+	}
+}
+
+type prefixedStore struct {
+	store  KVStore
+	prefix []byte
+	closed atomic.Bool
+}
+
+func (p *prefixedStore) Get(key []byte) ([]byte, error) {
+	if p.closed.Load() {
+		return nil, ErrClosed
+	}
+	return p.store.Get(prefixKey(p.prefix, key))
+}
+
+func (p *prefixedStore) Put(key, value []byte) error {
+	if p.closed.Load() {
+		return ErrClosed
+	}
+	return p.store.Put(prefixKey(p.prefix, key), value)
+}
+
+func (p *prefixedStore) Delete(key []byte) error {
+	if p.closed.Load() {
+		return ErrClosed
+	}
+	return p.store.Delete(prefixKey(p.prefix, key))
+}
+
+func (p *prefixedStore) NewBatch() Batch {
+	return &prefixedBatch{batch: p.store.NewBatch(), prefix: p.prefix, closed: &p.closed}
+}
+
+func (p *prefixedStore) NewIterator(start, end []byte) (Iterator, error) {
+	if p.closed.Load() {
+		return nil, ErrClosed
+	}
+	return newPrefixedIterator(p.store, p.prefix, start, end)
+}
+
+func (p *prefixedStore) NewSnapshot() (Snapshot, error) {
+	if p.closed.Load() {
+		return nil, ErrClosed
+	}
+	snapshot, err := p.store.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &prefixedSnapshot{snapshot: snapshot, prefix: p.prefix}, nil
+}
+
+func (p *prefixedStore) Close() error {
+	p.closed.Store(true)
+	return nil
+}
+
+type prefixedBatch struct {
+	batch  Batch
+	prefix []byte
+	closed *atomic.Bool // Closed state of the view the batch belongs to
+}
+
+func (b *prefixedBatch) Put(key, value []byte) error {
+	return b.batch.Put(prefixKey(b.prefix, key), value)
+}
+
+func (b *prefixedBatch) Delete(key []byte) error {
+	return b.batch.Delete(prefixKey(b.prefix, key))
+}
+
+func (b *prefixedBatch) Commit() error {
+	if b.closed.Load() {
+		return ErrClosed
+	}
+	return b.batch.Commit()
+}
+
+func (b *prefixedBatch) Close() error {
+	return b.batch.Close()
+}
+
+type prefixedSnapshot struct {
+	snapshot Snapshot
+	prefix   []byte
+}
+
+func (s *prefixedSnapshot) Get(key []byte) ([]byte, error) {
+	return s.snapshot.Get(prefixKey(s.prefix, key))
+}
+
+func (s *prefixedSnapshot) NewIterator(start, end []byte) (Iterator, error) {
+	return newPrefixedIterator(s.snapshot, s.prefix, start, end)
+}
+
+func (s *prefixedSnapshot) Close() error {
+	return s.snapshot.Close()
+}
+
+// prefixedIterator strips the namespace from the keys of the wrapped iterator
+type prefixedIterator struct {
+	Iterator
+	prefixLen int
+}
+
+func newPrefixedIterator(reader Reader, prefix, start, end []byte) (Iterator, error) {
+	// A nil bound is unbounded, which in a namespace means the namespace bounds
+	lower := prefixKey(prefix, start)
+	upper := prefixUpperBound(prefix)
+	if end != nil {
+		upper = prefixKey(prefix, end)
+	}
+	iter, err := reader.NewIterator(lower, upper)
+	if err != nil {
+		return nil, err
+	}
+	return &prefixedIterator{Iterator: iter, prefixLen: len(prefix)}, nil
+}
+
+func (it *prefixedIterator) Key() []byte {
+	key := it.Iterator.Key()
+	if len(key) < it.prefixLen {
+		return key
+	}
+	return key[it.prefixLen:]
+}
+
+func prefixKey(prefix, key []byte) []byte {
+	result := make([]byte, 0, len(prefix)+len(key))
+	result = append(result, prefix...)
+	return append(result, key...)
+}
+
+// prefixUpperBound returns the smallest key greater than every key with the given prefix,
+// or nil if there is none
+func prefixUpperBound(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/pkg/db"
+	"github.com/eigerco/strawberry/pkg/db/dbtest"
+	"github.com/eigerco/strawberry/pkg/db/memory"
+)
+
+func TestPrefixedConformance(t *testing.T) {
+	dbtest.TestKVStore(t, func(t *testing.T) db.KVStore {
+		return db.Prefixed(memory.NewKVStore(), []byte("ns/"))
+	})
+}
+
+func TestPrefixedIsolation(t *testing.T) {
+	store := memory.NewKVStore()
+	defer store.Close()
+	first := db.Prefixed(store, []byte("a/"))
+	second := db.Prefixed(store, []byte("b/"))
+
+	require.NoError(t, first.Put([]byte("key"), []byte("first")))
+	require.NoError(t, second.Put([]byte("key"), []byte("second")))
+	require.NoError(t, store.Put([]byte("key"), []byte("root")))
+
+	value, err := first.Get([]byte("key"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), value)
+	value, err = second.Get([]byte("key"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), value)
+
+	// Keys are stored with the namespace prepended
+	value, err = store.Get([]byte("a/key"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), value)
+
+	// Iterators only see their namespace and return keys without it
+	iter, err := first.NewIterator(nil, nil)
+	require.NoError(t, err)
+	defer iter.Close()
+	var keys []string
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"key"}, keys)
+
+	// Batches are scoped as well
+	batch := second.NewBatch()
+	require.NoError(t, batch.Delete([]byte("key")))
+	require.NoError(t, batch.Commit())
+	require.NoError(t, batch.Close())
+	_, err = second.Get([]byte("key"))
+	require.ErrorIs(t, err, db.ErrNotFound)
+	_, err = first.Get([]byte("key"))
+	require.NoError(t, err)
+}
+
+func TestPrefixedCloseKeepsStoreOpen(t *testing.T) {
+	store := memory.NewKVStore()
+	defer store.Close()
+	view := db.Prefixed(store, []byte("ns/"))
+
+	require.NoError(t, view.Put([]byte("key"), []byte("value")))
+	require.NoError(t, view.Close())
+
+	_, err := view.Get([]byte("key"))
+	require.ErrorIs(t, err, db.ErrClosed)
+	value, err := store.Get([]byte("ns/key"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestPrefixedUpperBoundOverflow(t *testing.T) {
+	store := memory.NewKVStore()
+	defer store.Close()
+	view := db.Prefixed(store, []byte{0x01, 0xff})
+
+	require.NoError(t, view.Put([]byte{0xff}, []byte("in")))
+	require.NoError(t, store.Put([]byte{0x02}, []byte("out")))
+
+	iter, err := view.NewIterator(nil, nil)
+	require.NoError(t, err)
+	defer iter.Close()
+	var keys [][]byte
+	for iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	assert.Equal(t, [][]byte{{0xff}}, keys)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/auditing"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+	"github.com/quic-go/quic-go"
+)
+
+// AuditAnnouncementHandler processes incoming CE 144 audit announcement
+// streams. It implements protocol specification section "CE 144: Audit
+// announcement". Announcements are sent to all validators, so they are not
+// forwarded.
+type AuditAnnouncementHandler struct {
+	onAnnouncement func(announcement auditing.Announcement)
+}
+
+// NewAuditAnnouncementHandler creates a new handler for audit announcements,
+// every received announcement is passed to onAnnouncement.
+func NewAuditAnnouncementHandler(onAnnouncement func(announcement auditing.Announcement)) *AuditAnnouncementHandler {
+	return &AuditAnnouncementHandler{onAnnouncement: onAnnouncement}
+}
+
+// HandleStream processes an incoming audit announcement stream.
+//
+//	--> Header Hash ++ Tranche ++ len++[Core Index ++ Work-Report Hash] ++ Validator Index ++ Ed25519 Signature ++ Evidence
+//	--> FIN
+//	<-- FIN
+//
+// The evidence is the Bandersnatch signature s0 followed by
+// len++[Bandersnatch Signature ++ len++[No-show Validator Index]], the first
+// is used in tranche 0 and the second in later tranches.
+func (h *AuditAnnouncementHandler) HandleStream(ctx context.Context, stream quic.Stream) error {
+	msg, err := ReadMessageWithContext(ctx, stream)
+	if err != nil {
+		return fmt.Errorf("read announcement message: %w", err)
+	}
+	var announcement auditing.Announcement
+	if err := jam.Unmarshal(msg.Content, &announcement); err != nil {
+		return fmt.Errorf("unmarshal announcement: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("close stream: %w", err)
+	}
+	h.onAnnouncement(announcement)
+	return nil
+}
+
+// AuditAnnouncementSender handles outgoing audit announcement streams.
+type AuditAnnouncementSender struct{}
+
+// SendAnnouncement sends an announcement on a newly opened audit announcement
+// stream and closes it.
+func (s *AuditAnnouncementSender) SendAnnouncement(ctx context.Context, stream quic.Stream, announcement auditing.Announcement) error {
+	content, err := jam.Marshal(announcement)
+	if err != nil {
+		return fmt.Errorf("marshal announcement: %w", err)
+	}
+	if err := WriteMessageWithContext(ctx, stream, content); err != nil {
+		return fmt.Errorf("write announcement: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("close write: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/chain"
+	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/pkg/network/protocol"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+	"github.com/quic-go/quic-go"
+)
+
+// blockRef identifies a block by its header hash and timeslot, it is used for
+// both the finalized block and the leaves in UP 0 messages
+type blockRef struct {
+	Hash crypto.Hash
+	Slot jamtime.Timeslot
+}
+
+// handshakeMessage is the first message sent by both sides of a UP 0 stream:
+// the latest finalized block followed by all known leaves
+type handshakeMessage struct {
+	Final  blockRef
+	Leaves []blockRef
+}
+
+// announcementMessage announces a new block header together with the latest
+// finalized block of the sender
+type announcementMessage struct {
+	Header block.Header
+	Final  blockRef
+}
+
+// newHandshake builds the handshake message from the block service's view of the chain
+func newHandshake(bs *chain.BlockService) handshakeMessage {
+	bs.Mu.RLock()
+	defer bs.Mu.RUnlock()
+	msg := handshakeMessage{
+		Final: blockRef{Hash: bs.LatestFinalized.Hash, Slot: bs.LatestFinalized.TimeSlotIndex},
+	}
+	for hash, slot := range bs.KnownLeaves {
+		msg.Leaves = append(msg.Leaves, blockRef{Hash: hash, Slot: slot})
+	}
+	return msg
+}
+
+// exchangeHandshakes writes our handshake and reads the peer's one
+func exchangeHandshakes(ctx context.Context, stream quic.Stream, bs *chain.BlockService) (handshakeMessage, error) {
+	content, err := jam.Marshal(newHandshake(bs))
+	if err != nil {
+		return handshakeMessage{}, fmt.Errorf("marshal handshake: %w", err)
+	}
+	if err := WriteMessageWithContext(ctx, stream, content); err != nil {
+		return handshakeMessage{}, fmt.Errorf("write handshake: %w", err)
+	}
+	msg, err := ReadMessageWithContext(ctx, stream)
+	if err != nil {
+		return handshakeMessage{}, fmt.Errorf("read handshake: %w", err)
+	}
+	var handshake handshakeMessage
+	if err := jam.Unmarshal(msg.Content, &handshake); err != nil {
+		return handshakeMessage{}, fmt.Errorf("unmarshal handshake: %w", err)
+	}
+	return handshake, nil
+}
+
+// BlockAnnouncementHandler processes UP 0 block announcement streams from peers.
+// It implements protocol specification section "UP 0: Block announcement".
+// After both sides exchanged handshakes, the peer announces every new block
+// header it learns about. Announced headers of blocks which are not imported
+// yet are handed to the onAnnouncement callback, along with the key of the
+// announcing peer to request the block from.
+type BlockAnnouncementHandler struct {
+	blockService   *chain.BlockService
+	onAnnouncement func(ctx context.Context, peerKey ed25519.PublicKey, header block.Header)
+}
+
+// NewBlockAnnouncementHandler creates a new handler for block announcements.
+// The onAnnouncement callback is invoked for every header of an unknown
+// block, it is meant to request and import the full block. As the next
+// announcements are only read once it returns, it should not block.
+func NewBlockAnnouncementHandler(blockService *chain.BlockService, onAnnouncement func(ctx context.Context, peerKey ed25519.PublicKey, header block.Header)) *BlockAnnouncementHandler {
+	return &BlockAnnouncementHandler{
+		blockService:   blockService,
+		onAnnouncement: onAnnouncement,
+	}
+}
+
+// HandleStream processes an incoming UP 0 stream. The stream stays open until
+// the peer closes it or the context is cancelled.
+//
+//	<-- Handshake
+//	--> Handshake
+//	<-- Announcement (unlimited)
+//
+// where
+//
+//	Handshake = Final ++ len++[Leaf]
+//	Announcement = Header ++ Final
+//	Final = Leaf = Header Hash (32 bytes) ++ Slot (4 bytes LE)
+func (h *BlockAnnouncementHandler) HandleStream(ctx context.Context, stream quic.Stream) error {
+	defer stream.Close()
+	if _, err := exchangeHandshakes(ctx, stream, h.blockService); err != nil {
+		return err
+	}
+	for {
+		msg, err := ReadMessageWithContext(ctx, stream)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return fmt.Errorf("read announcement: %w", err)
+		}
+		var announcement announcementMessage
+		if err := jam.Unmarshal(msg.Content, &announcement); err != nil {
+			return fmt.Errorf("unmarshal announcement: %w", err)
+		}
+		hash, err := announcement.Header.Hash()
+		if err != nil {
+			return fmt.Errorf("hash announced header: %w", err)
+		}
+		// Blocks we imported or authored are not requested again
+		if _, err := h.blockService.Store.GetBlock(hash); err == nil {
+			continue
+		}
+		h.onAnnouncement(ctx, protocol.PeerKey(ctx), announcement.Header)
+	}
+}
+
+// BlockAnnouncer handles the outgoing side of UP 0 block announcement streams.
+type BlockAnnouncer struct {
+	blockService *chain.BlockService
+}
+
+// NewBlockAnnouncer creates a new BlockAnnouncer, the block service provides
+// the finalized block and leaves sent to peers.
+func NewBlockAnnouncer(blockService *chain.BlockService) *BlockAnnouncer {
+	return &BlockAnnouncer{blockService: blockService}
+}
+
+// Handshake exchanges handshakes on a newly opened UP 0 stream. It must be
+// called once before the first announcement.
+func (a *BlockAnnouncer) Handshake(ctx context.Context, stream quic.Stream) error {
+	_, err := exchangeHandshakes(ctx, stream, a.blockService)
+	return err
+}
+
+// Announce sends a block header announcement on an open UP 0 stream
+func (a *BlockAnnouncer) Announce(ctx context.Context, stream quic.Stream, header block.Header) error {
+	a.blockService.Mu.RLock()
+	final := blockRef{Hash: a.blockService.LatestFinalized.Hash, Slot: a.blockService.LatestFinalized.TimeSlotIndex}
+	a.blockService.Mu.RUnlock()
+
+	content, err := jam.Marshal(announcementMessage{Header: header, Final: final})
+	if err != nil {
+		return fmt.Errorf("marshal announcement: %w", err)
+	}
+	if err := WriteMessageWithContext(ctx, stream, content); err != nil {
+		return fmt.Errorf("write announcement: %w", err)
+	}
+	return nil
+}
@@ -77,9 +77,16 @@ func (h *BlockRequestHandler) HandleStream(ctx context.Context, stream quic.Stre
 	}
 	copy(request.Hash[:], msg.Content[:32])
 
-	// Fetch block sequence based on direction
+	// Fetch block sequence based on direction, from a snapshot so that
+	// blocks imported concurrently do not result in an inconsistent sequence
+	snapshot, err := h.blockService.Store.NewSnapshot()
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer snapshot.Close()
+
 	ascending := request.Direction == 0
-	blocks, err := h.blockService.Store.GetBlockSequence(request.Hash, ascending, request.MaxBlocks)
+	blocks, err := snapshot.GetBlockSequence(request.Hash, ascending, request.MaxBlocks)
 	if err != nil {
 		return fmt.Errorf("get blocks: %w", err)
 	}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/finality"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+	"github.com/quic-go/quic-go"
+)
+
+// FinalityVoteHandler processes incoming finality vote streams. Validators
+// are connected to each other, so votes are not forwarded.
+type FinalityVoteHandler struct {
+	onVote func(vote finality.SignedVote)
+}
+
+// NewFinalityVoteHandler creates a new handler for finality votes, every
+// received vote is passed to onVote.
+func NewFinalityVoteHandler(onVote func(vote finality.SignedVote)) *FinalityVoteHandler {
+	return &FinalityVoteHandler{onVote: onVote}
+}
+
+// HandleStream processes an incoming finality vote stream.
+//
+//	--> Round (8 bytes LE) ++ Kind (1 byte) ++ Header Hash ++ Slot (4 bytes LE) ++ Validator Index (2 bytes LE) ++ Ed25519 Signature
+//	--> FIN
+//	<-- FIN
+func (h *FinalityVoteHandler) HandleStream(ctx context.Context, stream quic.Stream) error {
+	msg, err := ReadMessageWithContext(ctx, stream)
+	if err != nil {
+		return fmt.Errorf("read vote message: %w", err)
+	}
+	var vote finality.SignedVote
+	if err := jam.Unmarshal(msg.Content, &vote); err != nil {
+		return fmt.Errorf("unmarshal vote: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("close stream: %w", err)
+	}
+	h.onVote(vote)
+	return nil
+}
+
+// FinalityVoteSender handles outgoing finality vote streams.
+type FinalityVoteSender struct{}
+
+// SendVote sends a vote on a newly opened finality vote stream and closes it.
+func (s *FinalityVoteSender) SendVote(ctx context.Context, stream quic.Stream, vote finality.SignedVote) error {
+	content, err := jam.Marshal(vote)
+	if err != nil {
+		return fmt.Errorf("marshal vote: %w", err)
+	}
+	if err := WriteMessageWithContext(ctx, stream, content); err != nil {
+		return fmt.Errorf("write vote: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("close write: %w", err)
+	}
+	return nil
+}
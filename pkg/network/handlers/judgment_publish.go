@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/auditing"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+	"github.com/quic-go/quic-go"
+)
+
+// JudgmentPublishHandler processes incoming CE 145 judgment publication
+// streams. It implements protocol specification section "CE 145: Judgment
+// publication". Judgments are sent to all validators, so they are not
+// forwarded.
+type JudgmentPublishHandler struct {
+	onJudgment func(judgment auditing.Judgment)
+}
+
+// NewJudgmentPublishHandler creates a new handler for judgment publications,
+// every received judgment is passed to onJudgment.
+func NewJudgmentPublishHandler(onJudgment func(judgment auditing.Judgment)) *JudgmentPublishHandler {
+	return &JudgmentPublishHandler{onJudgment: onJudgment}
+}
+
+// HandleStream processes an incoming judgment publication stream.
+//
+//	--> Epoch Index ++ Validator Index ++ Validity ++ Work-Report Hash ++ Ed25519 Signature
+//	--> FIN
+//	<-- FIN
+//
+// The validity is 0 if the report was judged invalid and 1 otherwise.
+func (h *JudgmentPublishHandler) HandleStream(ctx context.Context, stream quic.Stream) error {
+	msg, err := ReadMessageWithContext(ctx, stream)
+	if err != nil {
+		return fmt.Errorf("read judgment message: %w", err)
+	}
+	var judgment auditing.Judgment
+	if err := jam.Unmarshal(msg.Content, &judgment); err != nil {
+		return fmt.Errorf("unmarshal judgment: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("close stream: %w", err)
+	}
+	h.onJudgment(judgment)
+	return nil
+}
+
+// JudgmentPublishSender handles outgoing judgment publication streams.
+type JudgmentPublishSender struct{}
+
+// SendJudgment sends a judgment on a newly opened judgment publication stream
+// and closes it.
+func (s *JudgmentPublishSender) SendJudgment(ctx context.Context, stream quic.Stream, judgment auditing.Judgment) error {
+	content, err := jam.Marshal(judgment)
+	if err != nil {
+		return fmt.Errorf("marshal judgment: %w", err)
+	}
+	if err := WriteMessageWithContext(ctx, stream, content); err != nil {
+		return fmt.Errorf("write judgment: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("close write: %w", err)
+	}
+	return nil
+}
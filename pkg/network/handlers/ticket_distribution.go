@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/pkg/serialization/codec/jam"
+	"github.com/quic-go/quic-go"
+)
+
+// ticketDistributionMessage is the wire format of CE 131 and CE 132:
+// - Epoch Index: The epoch the ticket is used in
+// - Ticket: Attempt (1 byte) ++ Ring VRF proof (784 bytes)
+type ticketDistributionMessage struct {
+	EpochIndex jamtime.Epoch
+	Ticket     block.TicketProof
+}
+
+// TicketDistributionHandler processes CE 131 and CE 132 Safrole ticket
+// distribution streams from peers. It implements protocol specification
+// sections "CE 131/132: Safrole ticket distribution".
+// With CE 131 a ticket generator sends a ticket to its proxy validator, with
+// CE 132 the proxy validator forwards it to all current validators.
+type TicketDistributionHandler struct {
+	onTicket func(epoch jamtime.Epoch, ticket block.TicketProof) error
+	forward  func(epoch jamtime.Epoch, ticket block.TicketProof)
+}
+
+// NewTicketDistributionHandler creates a new handler for ticket distribution,
+// every received ticket is passed to onTicket.
+func NewTicketDistributionHandler(onTicket func(epoch jamtime.Epoch, ticket block.TicketProof) error) *TicketDistributionHandler {
+	return &TicketDistributionHandler{onTicket: onTicket}
+}
+
+// NewTicketProxyHandler creates a new CE 131 handler for a proxy validator.
+// Every received ticket is passed to onTicket, and only the tickets it newly
+// accepts, i.e. returns no error for, are passed on to forward. Invalid and
+// duplicate tickets are never forwarded.
+func NewTicketProxyHandler(onTicket func(epoch jamtime.Epoch, ticket block.TicketProof) error, forward func(epoch jamtime.Epoch, ticket block.TicketProof)) *TicketDistributionHandler {
+	return &TicketDistributionHandler{onTicket: onTicket, forward: forward}
+}
+
+// HandleStream processes an incoming ticket distribution stream.
+//
+//	--> Epoch Index (4 bytes LE) ++ Ticket
+//	--> FIN
+//	<-- FIN
+func (h *TicketDistributionHandler) HandleStream(ctx context.Context, stream quic.Stream) error {
+	msg, err := ReadMessageWithContext(ctx, stream)
+	if err != nil {
+		return fmt.Errorf("read ticket message: %w", err)
+	}
+	var ticket ticketDistributionMessage
+	if err := jam.Unmarshal(msg.Content, &ticket); err != nil {
+		return fmt.Errorf("unmarshal ticket: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("close stream: %w", err)
+	}
+	// A rejected ticket is reported by onTicket itself, it is not an error of
+	// the stream
+	if err := h.onTicket(ticket.EpochIndex, ticket.Ticket); err != nil {
+		return nil
+	}
+	if h.forward != nil {
+		h.forward(ticket.EpochIndex, ticket.Ticket)
+	}
+	return nil
+}
+
+// TicketSender handles outgoing CE 131 and CE 132 ticket distribution streams.
+type TicketSender struct{}
+
+// SendTicket sends a ticket on a newly opened CE 131 or CE 132 stream and
+// closes it.
+func (s *TicketSender) SendTicket(ctx context.Context, stream quic.Stream, epoch jamtime.Epoch, ticket block.TicketProof) error {
+	content, err := jam.Marshal(ticketDistributionMessage{EpochIndex: epoch, Ticket: ticket})
+	if err != nil {
+		return fmt.Errorf("marshal ticket: %w", err)
+	}
+	if err := WriteMessageWithContext(ctx, stream, content); err != nil {
+		return fmt.Errorf("write ticket: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("close write: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eigerco/strawberry/internal/block"
+	"github.com/eigerco/strawberry/internal/jamtime"
+	"github.com/eigerco/strawberry/pkg/network/handlers"
+)
+
+// bufferStream is a quic.Stream reading and writing an in-memory buffer
+type bufferStream struct {
+	quic.Stream
+	bytes.Buffer
+}
+
+func (s *bufferStream) Read(p []byte) (int, error)  { return s.Buffer.Read(p) }
+func (s *bufferStream) Write(p []byte) (int, error) { return s.Buffer.Write(p) }
+func (s *bufferStream) Close() error                { return nil }
+
+func TestTicketProxyHandler(t *testing.T) {
+	ctx := context.Background()
+	errInvalid := errors.New("invalid ticket")
+	errDuplicate := errors.New("duplicate ticket")
+
+	// Mirrors the verdict of the ticket pool: tickets with a corrupted proof
+	// are invalid, and a ticket is only accepted once
+	accepted := map[block.TicketProof]bool{}
+	onTicket := func(epoch jamtime.Epoch, ticket block.TicketProof) error {
+		if ticket.Proof[0] == 0xff {
+			return errInvalid
+		}
+		if accepted[ticket] {
+			return errDuplicate
+		}
+		accepted[ticket] = true
+		return nil
+	}
+	var forwarded []block.TicketProof
+	handler := handlers.NewTicketProxyHandler(onTicket, func(epoch jamtime.Epoch, ticket block.TicketProof) {
+		assert.Equal(t, jamtime.Epoch(2), epoch)
+		forwarded = append(forwarded, ticket)
+	})
+
+	receive := func(ticket block.TicketProof) {
+		stream := &bufferStream{}
+		require.NoError(t, (&handlers.TicketSender{}).SendTicket(ctx, stream, 2, ticket))
+		require.NoError(t, handler.HandleStream(ctx, stream))
+	}
+
+	valid := block.TicketProof{EntryIndex: 1}
+	valid.Proof[0] = 0x01
+	invalid := block.TicketProof{EntryIndex: 0}
+	invalid.Proof[0] = 0xff
+
+	receive(invalid)
+	assert.Empty(t, forwarded)
+
+	receive(valid)
+	assert.Equal(t, []block.TicketProof{valid}, forwarded)
+
+	// The same ticket sent again is not forwarded a second time
+	receive(valid)
+	assert.Equal(t, []block.TicketProof{valid}, forwarded)
+}
@@ -22,6 +22,7 @@ const (
 	Arrow     = "→"
 	Plus      = "+"
 	Download  = "⇩"
+	Fork      = "⑂"
 )
 
 func LogBlockEvent(timestamp time.Time, eventType string, hash crypto.Hash, epoch jamtime.Epoch, slot jamtime.Timeslot) {
@@ -43,6 +44,9 @@ func LogBlockEvent(timestamp time.Time, eventType string, hash crypto.Hash, epoc
 	case "imported":
 		color = Cyan
 		symbol = Download
+	case "reorg":
+		color = Yellow
+		symbol = Fork
 	}
 
 	// Pad the event type to 10 characters to align output
@@ -4,32 +4,61 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/eigerco/strawberry/internal/auditing"
 	"github.com/eigerco/strawberry/internal/block"
 	"github.com/eigerco/strawberry/internal/chain"
 	"github.com/eigerco/strawberry/internal/crypto"
+	"github.com/eigerco/strawberry/internal/finality"
+	"github.com/eigerco/strawberry/internal/genesis"
+	"github.com/eigerco/strawberry/internal/jamtime"
 	"github.com/eigerco/strawberry/pkg/network/cert"
 	"github.com/eigerco/strawberry/pkg/network/handlers"
 	"github.com/eigerco/strawberry/pkg/network/protocol"
 	"github.com/eigerco/strawberry/pkg/network/transport"
+	"github.com/quic-go/quic-go"
 )
 
+// maxAnnouncedAncestors is the number of missing ancestors requested along
+// with an announced block
+const maxAnnouncedAncestors = jamtime.TimeslotsPerEpoch
+
 // Node manages peer connections, handles protocol messages, and coordinates network operations.
 // Each Node can act as both a client and server, maintaining connections with multiple peers simultaneously.
 type Node struct {
 	Context         context.Context
 	Cancel          context.CancelFunc
 	blockService    *chain.BlockService
+	importer        *chain.Importer
+	announcedLock   sync.Mutex
+	announced       map[crypto.Hash]struct{} // Announced blocks being fetched and imported
 	transport       *transport.Transport
 	protocolManager *protocol.Manager
 	peersLock       sync.RWMutex
 	peersSet        *PeerSet
 	blockRequester  *handlers.BlockRequester
+	blockAnnouncer  *handlers.BlockAnnouncer
+	ticketSender    *handlers.TicketSender
+	ticketsLock     sync.RWMutex
+	onTicket        func(epoch jamtime.Epoch, ticket block.TicketProof) error
+	voteSender      *handlers.FinalityVoteSender
+	votesLock       sync.RWMutex
+	onVote          func(vote finality.SignedVote)
+	auditSender     *handlers.AuditAnnouncementSender
+	auditsLock      sync.RWMutex
+	onAnnouncement  func(announcement auditing.Announcement)
+	judgmentSender  *handlers.JudgmentPublishSender
+	judgmentsLock   sync.RWMutex
+	onJudgment      func(judgment auditing.Judgment)
+	shardRequester  *handlers.AuditShardRequester
+	shardsLock      sync.RWMutex
+	onShardRequest  func(erasureRoot crypto.Hash, shardIndex uint16) ([]byte, error)
 }
 
 // ValidatorKeys holds the cryptographic keys required for a validator node.
@@ -84,6 +113,15 @@ func (ps *PeerSet) RemovePeer(peer *Peer) {
 	}
 }
 
+// Peers returns all peers in the set.
+func (ps *PeerSet) Peers() []*Peer {
+	peers := make([]*Peer, 0, len(ps.byEd25519Key))
+	for _, peer := range ps.byEd25519Key {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
 // GetByEd25519Key looks up a peer by their Ed25519 public key.
 // Returns nil if no peer is found with the given key.
 func (ps *PeerSet) GetByEd25519Key(key ed25519.PublicKey) *Peer {
@@ -104,12 +142,17 @@ func (ps *PeerSet) GetByValidatorIndex(index uint16) *Peer {
 
 // NewNode creates a new Node instance with the specified configuration.
 // It initializes the TLS certificate, protocol manager, and network transport.
-func NewNode(nodeCtx context.Context, listenAddr *net.UDPAddr, keys ValidatorKeys) (*Node, error) {
+// The block service provides the node's view of the chain and its storage,
+// blocks received from peers are applied to it by the importer.
+func NewNode(nodeCtx context.Context, listenAddr *net.UDPAddr, keys ValidatorKeys, bs *chain.BlockService, importer *chain.Importer) (*Node, error) {
 	nodeCtx, cancel := context.WithCancel(nodeCtx)
 	node := &Node{
-		peersSet: NewPeerSet(),
-		Context:  nodeCtx,
-		Cancel:   cancel,
+		peersSet:     NewPeerSet(),
+		Context:      nodeCtx,
+		Cancel:       cancel,
+		blockService: bs,
+		importer:     importer,
+		announced:    make(map[crypto.Hash]struct{}),
 	}
 
 	// Create TLS certificate using the node's Ed25519 key pair
@@ -124,19 +167,13 @@ func NewNode(nodeCtx context.Context, listenAddr *net.UDPAddr, keys ValidatorKey
 	}
 
 	// Initialize protocol manager with chain-specific configuration.
-	// These are just testing values.
+	// The builder settings are just testing values.
 	protoConfig := protocol.Config{
-		ChainHash:       "12345678",
+		ChainHash:       genesis.ChainHash(bs.Genesis),
 		IsBuilder:       true,
 		MaxBuilderSlots: 20,
 	}
 
-	// Create block service
-	bs, err := chain.NewBlockService()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create block service: %w", err)
-	}
-	node.blockService = bs
 	protoManager, err := protocol.NewManager(protoConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create protocol manager: %w", err)
@@ -144,7 +181,25 @@ func NewNode(nodeCtx context.Context, listenAddr *net.UDPAddr, keys ValidatorKey
 
 	// Register what type of streams the Node will support.
 	protoManager.Registry.RegisterHandler(protocol.StreamKindBlockRequest, handlers.NewBlockRequestHandler(bs))
+	protoManager.Registry.RegisterHandler(protocol.StreamKindBlockAnnouncement, handlers.NewBlockAnnouncementHandler(bs, node.receiveAnnounced))
 	node.blockRequester = &handlers.BlockRequester{}
+	node.blockAnnouncer = handlers.NewBlockAnnouncer(bs)
+	// As the proxy validator of a CE 131 ticket, forward it to all validators
+	protoManager.Registry.RegisterHandler(protocol.StreamKindTicketDistP2P, handlers.NewTicketProxyHandler(node.receiveTicket, func(epoch jamtime.Epoch, ticket block.TicketProof) {
+		if err := node.BroadcastTicket(nodeCtx, epoch, ticket); err != nil {
+			log.Printf("Failed to forward ticket: %v", err)
+		}
+	}))
+	protoManager.Registry.RegisterHandler(protocol.StreamKindTicketDistBroadcast, handlers.NewTicketDistributionHandler(node.receiveTicket))
+	node.ticketSender = &handlers.TicketSender{}
+	protoManager.Registry.RegisterHandler(protocol.StreamKindFinalityVote, handlers.NewFinalityVoteHandler(node.receiveVote))
+	node.voteSender = &handlers.FinalityVoteSender{}
+	protoManager.Registry.RegisterHandler(protocol.StreamKindAuditAnnouncement, handlers.NewAuditAnnouncementHandler(node.receiveAnnouncement))
+	node.auditSender = &handlers.AuditAnnouncementSender{}
+	protoManager.Registry.RegisterHandler(protocol.StreamKindJudgmentPublish, handlers.NewJudgmentPublishHandler(node.receiveJudgment))
+	node.judgmentSender = &handlers.JudgmentPublishSender{}
+	protoManager.Registry.RegisterHandler(protocol.StreamKindAuditShardRequest, handlers.NewAuditShardRequestHandler(node.auditShard))
+	node.shardRequester = &handlers.AuditShardRequester{}
 
 	// Create transport
 	transportConfig := transport.Config{
@@ -246,6 +301,307 @@ func (n *Node) RequestBlocks(ctx context.Context, hash crypto.Hash, ascending bo
 	return nil, fmt.Errorf("no peers available to request block from")
 }
 
+// ImportBlocks requests up to maxBlocks descendants of the given block from a
+// peer and imports them in order. It returns the number of imported blocks,
+// blocks which were already imported are skipped. Importing stops at the
+// first invalid block.
+func (n *Node) ImportBlocks(ctx context.Context, from crypto.Hash, maxBlocks uint32, peerKey ed25519.PublicKey) (int, error) {
+	blocks, err := n.RequestBlocks(ctx, from, true, maxBlocks, peerKey)
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for _, b := range blocks {
+		if _, err := n.importer.Import(b); err != nil {
+			if errors.Is(err, chain.ErrBlockKnown) {
+				continue
+			}
+			return imported, fmt.Errorf("failed to import block: %w", err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// receiveAnnounced fetches and imports a block announced by a peer over UP 0
+// in the background, so that reading the announcements of the peer goes on
+// meanwhile. A block announced again, e.g. by another peer, while it is still
+// being fetched is skipped.
+func (n *Node) receiveAnnounced(ctx context.Context, peerKey ed25519.PublicKey, header block.Header) {
+	hash, err := header.Hash()
+	if err != nil {
+		log.Printf("Failed to hash announced header: %v", err)
+		return
+	}
+	n.announcedLock.Lock()
+	defer n.announcedLock.Unlock()
+	if _, ok := n.announced[hash]; ok {
+		return
+	}
+	n.announced[hash] = struct{}{}
+	go func() {
+		n.importAnnounced(ctx, peerKey, header, hash)
+		n.announcedLock.Lock()
+		defer n.announcedLock.Unlock()
+		delete(n.announced, hash)
+	}()
+}
+
+// importAnnounced requests a block announced by a peer over CE 128 and
+// imports it. If its parent is not imported either, up to
+// maxAnnouncedAncestors of its ancestors are requested and imported first.
+func (n *Node) importAnnounced(ctx context.Context, peerKey ed25519.PublicKey, header block.Header, hash crypto.Hash) {
+	blocks, err := n.RequestBlocks(ctx, hash, false, 1, peerKey)
+	if err != nil {
+		log.Printf("Failed to request announced block %x: %v", hash, err)
+		return
+	}
+	if len(blocks) != 1 {
+		log.Printf("Peer sent %d blocks for announced block %x", len(blocks), hash)
+		return
+	}
+	if received, err := blocks[0].Header.Hash(); err != nil || received != hash {
+		log.Printf("Peer sent another block than the announced %x", hash)
+		return
+	}
+	if _, err := n.importer.Import(blocks[0]); err == nil || errors.Is(err, chain.ErrBlockKnown) {
+		return
+	} else if !errors.Is(err, chain.ErrUnknownParent) {
+		log.Printf("Failed to import announced block %x: %v", hash, err)
+		return
+	}
+
+	ancestors, err := n.RequestBlocks(ctx, header.ParentHash, false, maxAnnouncedAncestors, peerKey)
+	if err != nil {
+		log.Printf("Failed to request the ancestors of announced block %x: %v", hash, err)
+		return
+	}
+	// The ancestors are ordered from the parent backwards, the ones we already
+	// have or can not connect yet are skipped
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if _, err := n.importer.Import(ancestors[i]); err != nil && !errors.Is(err, chain.ErrBlockKnown) && !errors.Is(err, chain.ErrUnknownParent) {
+			log.Printf("Failed to import ancestor of announced block %x: %v", hash, err)
+			return
+		}
+	}
+	if _, err := n.importer.Import(blocks[0]); err != nil && !errors.Is(err, chain.ErrBlockKnown) {
+		log.Printf("Failed to import announced block %x: %v", hash, err)
+	}
+}
+
+// AnnounceBlock announces a new block header to all connected peers over
+// their UP 0 streams, opening the streams where needed. Failing peers are
+// skipped, their stream is reopened on the next announcement.
+func (n *Node) AnnounceBlock(ctx context.Context, header block.Header) {
+	n.peersLock.RLock()
+	peers := n.peersSet.Peers()
+	n.peersLock.RUnlock()
+
+	for _, p := range peers {
+		stream, err := p.ProtoConn.UniqueStream(ctx, protocol.StreamKindBlockAnnouncement, func(stream quic.Stream) error {
+			return n.blockAnnouncer.Handshake(ctx, stream)
+		})
+		if err != nil {
+			log.Printf("Failed to open block announcement stream to %v: %v", p.Address, err)
+			continue
+		}
+		if err := n.blockAnnouncer.Announce(ctx, stream, header); err != nil {
+			log.Printf("Failed to announce block to %v: %v", p.Address, err)
+			p.ProtoConn.ResetUniqueStream(protocol.StreamKindBlockAnnouncement)
+		}
+	}
+}
+
+// OnTicket registers the function receiving the Safrole tickets sent by peers.
+// It returns an error if the ticket is rejected, as the proxy validator of a
+// ticket only forwards it once it has been newly accepted.
+func (n *Node) OnTicket(fn func(epoch jamtime.Epoch, ticket block.TicketProof) error) {
+	n.ticketsLock.Lock()
+	defer n.ticketsLock.Unlock()
+	n.onTicket = fn
+}
+
+func (n *Node) receiveTicket(epoch jamtime.Epoch, ticket block.TicketProof) error {
+	n.ticketsLock.RLock()
+	defer n.ticketsLock.RUnlock()
+	if n.onTicket == nil {
+		return fmt.Errorf("no ticket receiver")
+	}
+	return n.onTicket(epoch, ticket)
+}
+
+// SendTicket sends one of our tickets to its proxy validator over CE 131
+func (n *Node) SendTicket(ctx context.Context, epoch jamtime.Epoch, ticket block.TicketProof, proxy ed25519.PublicKey) error {
+	n.peersLock.RLock()
+	p := n.peersSet.GetByEd25519Key(proxy)
+	n.peersLock.RUnlock()
+	if p == nil {
+		return fmt.Errorf("proxy validator is not connected")
+	}
+	return n.sendTicket(ctx, p, protocol.StreamKindTicketDistP2P, epoch, ticket)
+}
+
+// BroadcastTicket sends a ticket to all connected peers over CE 132
+func (n *Node) BroadcastTicket(ctx context.Context, epoch jamtime.Epoch, ticket block.TicketProof) error {
+	n.peersLock.RLock()
+	peers := n.peersSet.Peers()
+	n.peersLock.RUnlock()
+
+	var errs []error
+	for _, p := range peers {
+		if err := n.sendTicket(ctx, p, protocol.StreamKindTicketDistBroadcast, epoch, ticket); err != nil {
+			errs = append(errs, fmt.Errorf("peer %v: %w", p.Address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Node) sendTicket(ctx context.Context, p *Peer, kind protocol.StreamKind, epoch jamtime.Epoch, ticket block.TicketProof) error {
+	stream, err := p.ProtoConn.OpenStream(ctx, kind)
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+	return n.ticketSender.SendTicket(ctx, stream, epoch, ticket)
+}
+
+// OnVote registers the function receiving the finality votes sent by peers
+func (n *Node) OnVote(fn func(vote finality.SignedVote)) {
+	n.votesLock.Lock()
+	defer n.votesLock.Unlock()
+	n.onVote = fn
+}
+
+func (n *Node) receiveVote(vote finality.SignedVote) {
+	n.votesLock.RLock()
+	defer n.votesLock.RUnlock()
+	if n.onVote != nil {
+		n.onVote(vote)
+	}
+}
+
+// BroadcastVote sends a finality vote to all connected peers
+func (n *Node) BroadcastVote(ctx context.Context, vote finality.SignedVote) error {
+	n.peersLock.RLock()
+	peers := n.peersSet.Peers()
+	n.peersLock.RUnlock()
+
+	var errs []error
+	for _, p := range peers {
+		stream, err := p.ProtoConn.OpenStream(ctx, protocol.StreamKindFinalityVote)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("peer %v: failed to open stream: %w", p.Address, err))
+			continue
+		}
+		if err := n.voteSender.SendVote(ctx, stream, vote); err != nil {
+			errs = append(errs, fmt.Errorf("peer %v: %w", p.Address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OnAnnouncement registers the function receiving the audit announcements sent by peers
+func (n *Node) OnAnnouncement(fn func(announcement auditing.Announcement)) {
+	n.auditsLock.Lock()
+	defer n.auditsLock.Unlock()
+	n.onAnnouncement = fn
+}
+
+func (n *Node) receiveAnnouncement(announcement auditing.Announcement) {
+	n.auditsLock.RLock()
+	defer n.auditsLock.RUnlock()
+	if n.onAnnouncement != nil {
+		n.onAnnouncement(announcement)
+	}
+}
+
+// BroadcastAnnouncement sends an audit announcement to all connected peers
+func (n *Node) BroadcastAnnouncement(ctx context.Context, announcement auditing.Announcement) error {
+	n.peersLock.RLock()
+	peers := n.peersSet.Peers()
+	n.peersLock.RUnlock()
+
+	var errs []error
+	for _, p := range peers {
+		stream, err := p.ProtoConn.OpenStream(ctx, protocol.StreamKindAuditAnnouncement)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("peer %v: failed to open stream: %w", p.Address, err))
+			continue
+		}
+		if err := n.auditSender.SendAnnouncement(ctx, stream, announcement); err != nil {
+			errs = append(errs, fmt.Errorf("peer %v: %w", p.Address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OnJudgment registers the function receiving the judgments published by peers
+func (n *Node) OnJudgment(fn func(judgment auditing.Judgment)) {
+	n.judgmentsLock.Lock()
+	defer n.judgmentsLock.Unlock()
+	n.onJudgment = fn
+}
+
+func (n *Node) receiveJudgment(judgment auditing.Judgment) {
+	n.judgmentsLock.RLock()
+	defer n.judgmentsLock.RUnlock()
+	if n.onJudgment != nil {
+		n.onJudgment(judgment)
+	}
+}
+
+// BroadcastJudgment publishes a judgment to all connected peers
+func (n *Node) BroadcastJudgment(ctx context.Context, judgment auditing.Judgment) error {
+	n.peersLock.RLock()
+	peers := n.peersSet.Peers()
+	n.peersLock.RUnlock()
+
+	var errs []error
+	for _, p := range peers {
+		stream, err := p.ProtoConn.OpenStream(ctx, protocol.StreamKindJudgmentPublish)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("peer %v: failed to open stream: %w", p.Address, err))
+			continue
+		}
+		if err := n.judgmentSender.SendJudgment(ctx, stream, judgment); err != nil {
+			errs = append(errs, fmt.Errorf("peer %v: %w", p.Address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OnAuditShardRequest registers the function serving the audit shards
+// requested by peers
+func (n *Node) OnAuditShardRequest(fn func(erasureRoot crypto.Hash, shardIndex uint16) ([]byte, error)) {
+	n.shardsLock.Lock()
+	defer n.shardsLock.Unlock()
+	n.onShardRequest = fn
+}
+
+func (n *Node) auditShard(erasureRoot crypto.Hash, shardIndex uint16) ([]byte, error) {
+	n.shardsLock.RLock()
+	defer n.shardsLock.RUnlock()
+	if n.onShardRequest == nil {
+		return nil, fmt.Errorf("audit shards are not served")
+	}
+	return n.onShardRequest(erasureRoot, shardIndex)
+}
+
+// RequestAuditShard requests a shard of an audit bundle from a peer
+func (n *Node) RequestAuditShard(ctx context.Context, peerKey ed25519.PublicKey, erasureRoot crypto.Hash, shardIndex uint16) ([]byte, error) {
+	n.peersLock.RLock()
+	p := n.peersSet.GetByEd25519Key(peerKey)
+	n.peersLock.RUnlock()
+	if p == nil {
+		return nil, fmt.Errorf("peer %x not connected", peerKey)
+	}
+	stream, err := p.ProtoConn.OpenStream(ctx, protocol.StreamKindAuditShardRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+	defer stream.Close()
+	return n.shardRequester.RequestAuditShard(ctx, stream, erasureRoot, shardIndex)
+}
+
 // Start begins the node's network operations, including listening for incoming connections.
 func (n *Node) Start() error {
 	if err := n.transport.Start(); err != nil {
@@ -47,6 +47,41 @@ func (pc *ProtocolConn) OpenStream(ctx context.Context, kind StreamKind) (quic.S
 	return stream, nil
 }
 
+// UniqueStream returns the open UP stream of the given kind. If there is none
+// yet, a new stream is opened and passed to setup, e.g. for a handshake,
+// before it is kept for later use.
+func (pc *ProtocolConn) UniqueStream(ctx context.Context, kind StreamKind, setup func(quic.Stream) error) (quic.Stream, error) {
+	if !kind.IsUniquePersistent() {
+		return nil, fmt.Errorf("stream kind %d is not unique persistent", kind)
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if stream, ok := pc.streams[kind]; ok {
+		return stream, nil
+	}
+	stream, err := pc.OpenStream(ctx, kind)
+	if err != nil {
+		return nil, err
+	}
+	if err := setup(stream); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	pc.streams[kind] = stream
+	return stream, nil
+}
+
+// ResetUniqueStream closes the UP stream of the given kind, if any. The next
+// call to UniqueStream opens a new one.
+func (pc *ProtocolConn) ResetUniqueStream(kind StreamKind) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if stream, ok := pc.streams[kind]; ok {
+		stream.Close()
+		delete(pc.streams, kind)
+	}
+}
+
 // AcceptStream accepts and handles an incoming stream.
 // It reads the stream kind byte, looks up the appropriate handler,
 // and starts a goroutine to handle the stream.
@@ -72,8 +107,9 @@ func (pc *ProtocolConn) AcceptStream() error {
 	}
 
 	// Handle the stream
+	ctx := WithPeerKey(pc.TConn.Context(), pc.TConn.PeerKey())
 	go func() {
-		if err := handler.HandleStream(pc.TConn.Context(), stream); err != nil {
+		if err := handler.HandleStream(ctx, stream); err != nil {
 			fmt.Printf("stream handler error: %v\n", err)
 		}
 	}()
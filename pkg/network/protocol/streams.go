@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"sync"
 
@@ -30,13 +31,32 @@ const (
 	StreamKindPreimageRequest     StreamKind = 143
 	StreamKindAuditAnnouncement   StreamKind = 144
 	StreamKindJudgmentPublish     StreamKind = 145
+
+	// Not part of JAMNP yet: finality votes of the validators
+	StreamKindFinalityVote StreamKind = 146
 )
 
-// StreamHandler processes individual QUIC streams within a connection
+// StreamHandler processes individual QUIC streams within a connection. The
+// context carries the Ed25519 key of the peer, see PeerKey.
 type StreamHandler interface {
 	HandleStream(ctx context.Context, stream quic.Stream) error
 }
 
+type peerKeyContextKey struct{}
+
+// WithPeerKey returns a context carrying the Ed25519 key of the peer a stream
+// belongs to
+func WithPeerKey(ctx context.Context, key ed25519.PublicKey) context.Context {
+	return context.WithValue(ctx, peerKeyContextKey{}, key)
+}
+
+// PeerKey returns the Ed25519 key of the peer a stream handler was invoked
+// for, nil if the context does not carry one
+func PeerKey(ctx context.Context) ed25519.PublicKey {
+	key, _ := ctx.Value(peerKeyContextKey{}).(ed25519.PublicKey)
+	return key
+}
+
 // StreamKind represents the type of stream (Unique Persistent or Common Ephemeral)
 type StreamKind byte
 
@@ -57,7 +77,7 @@ func NewJAMNPRegistry() *JAMNPRegistry {
 // Returns an error if the kind is outside the valid range
 func (r *JAMNPRegistry) ValidateKind(kindByte byte) error {
 	kind := StreamKind(kindByte)
-	if kind < StreamKindBlockAnnouncement || kind > StreamKindJudgmentPublish {
+	if kind < StreamKindBlockAnnouncement || kind > StreamKindFinalityVote {
 		return fmt.Errorf("invalid stream kind: %d", kind)
 	}
 	return nil
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -247,6 +248,50 @@ func TestCodec(t *testing.T) {
 	}
 }
 
+// TestCodecJSON decodes the JSON vectors with the JSON codec of the block types
+// and checks that their JAM encoding matches the binary vectors, and that
+// encoding them again gives back the JSON vectors.
+func TestCodecJSON(t *testing.T) {
+	tests := []struct {
+		file  string
+		value func() any
+	}{
+		{"block", func() any { return &block.Block{} }},
+		{"header_0", func() any { return &block.Header{} }},
+		{"header_1", func() any { return &block.Header{} }},
+		{"extrinsic", func() any { return &block.Extrinsic{} }},
+		{"tickets_extrinsic", func() any { return &block.TicketExtrinsic{} }},
+		{"preimages_extrinsic", func() any { return &block.PreimageExtrinsic{} }},
+		{"guarantees_extrinsic", func() any { return &block.GuaranteesExtrinsic{} }},
+		{"assurances_extrinsic", func() any { return &block.AssurancesExtrinsic{} }},
+		{"disputes_extrinsic", func() any { return &block.DisputeExtrinsic{} }},
+		{"refine_context", func() any { return &block.RefinementContext{} }},
+		{"work_report", func() any { return &block.WorkReport{} }},
+		{"work_result_0", func() any { return &block.WorkResult{} }},
+		{"work_result_1", func() any { return &block.WorkResult{} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			jsonData, err := os.ReadFile(fmt.Sprintf("%s/%s.json", testVectorsPath, tt.file))
+			require.NoError(t, err)
+			binData, err := os.ReadFile(fmt.Sprintf("%s/%s.bin", testVectorsPath, tt.file))
+			require.NoError(t, err)
+
+			value := tt.value()
+			require.NoError(t, json.Unmarshal(jsonData, value))
+
+			// Pointers are encoded as optional values, encode the value itself
+			encoded, err := jam.Marshal(reflect.ValueOf(value).Elem().Interface())
+			require.NoError(t, err)
+			require.Equal(t, binData, encoded)
+
+			reencoded, err := json.Marshal(value)
+			require.NoError(t, err)
+			require.JSONEq(t, string(jsonData), string(reencoded))
+		})
+	}
+}
+
 func compareHeader(t *testing.T, expected ExpectedHeader, actual block.Header) {
 	require.Equal(t, expected.Parent, toHex(actual.ParentHash))
 	require.Equal(t, expected.ParentStateRoot, toHex(actual.PriorStateRoot))
@@ -13,7 +13,7 @@ import (
 
 	"github.com/eigerco/strawberry/internal/store"
 	"github.com/eigerco/strawberry/internal/validator"
-	"github.com/eigerco/strawberry/pkg/db/pebble"
+	"github.com/eigerco/strawberry/pkg/db/memory"
 
 	"github.com/eigerco/strawberry/internal/statetransition"
 
@@ -275,10 +275,7 @@ func TestReports(t *testing.T) {
 	files, err := os.ReadDir("vectors/reports/tiny")
 	require.NoError(t, err, "failed to read tiny directory")
 
-	db, err := pebble.NewKVStore()
-	require.NoError(t, err)
-
-	chain := store.NewChain(db)
+	chain := store.NewChain(memory.NewKVStore())
 	defer chain.Close()
 
 	for _, file := range files {
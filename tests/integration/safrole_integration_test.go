@@ -186,6 +186,41 @@ func TestSafrole(t *testing.T) {
 	}
 }
 
+// TestSafroleStateJSON decodes the states of the SAFROLE test vectors with the
+// JSON encoding of the state and compares them to the ones built field by field.
+func TestSafroleStateJSON(t *testing.T) {
+	files, err := filepath.Glob("vectors/safrole/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, tf := range files {
+		t.Run(filepath.Base(tf), func(t *testing.T) {
+			file, err := os.ReadFile(tf)
+			require.NoError(t, err)
+
+			var tv SafroleTestVector
+			require.NoError(t, json.Unmarshal(file, &tv))
+			var states struct {
+				PreState  state.State `json:"pre_state"`
+				PostState state.State `json:"post_state"`
+			}
+			require.NoError(t, json.Unmarshal(file, &states))
+
+			for _, c := range []struct {
+				expected SafroleTestVectorState
+				actual   state.State
+			}{
+				{tv.PreState, states.PreState},
+				{tv.PostState, states.PostState},
+			} {
+				require.Equal(t, jamtime.Timeslot(c.expected.Tau), c.actual.TimeslotIndex)
+				require.Equal(t, toEntropyPool(t, c.expected), c.actual.EntropyPool)
+				require.Equal(t, toValidatorState(t, c.expected), c.actual.ValidatorState)
+			}
+		})
+	}
+}
+
 // Helper to construct the validator state from the test vector's state.
 func toValidatorState(t *testing.T, s SafroleTestVectorState) validator.ValidatorState {
 	currentValidators := safrole.ValidatorsData{}
@@ -5,7 +5,9 @@ package integration_test
 import (
 	"encoding/hex"
 	"encoding/json"
+	"github.com/eigerco/strawberry/internal/crypto"
 	"github.com/eigerco/strawberry/internal/merkle/trie"
+	"github.com/eigerco/strawberry/pkg/db/memory"
 	"github.com/stretchr/testify/require"
 	"os"
 	"testing"
@@ -51,6 +53,47 @@ func TestMerkleTreeFromJSON(t *testing.T) {
 	}
 }
 
+// Test function to verify that incremental updates reach the same roots as Merklize
+func TestMerkleTreeUpdateFromJSON(t *testing.T) {
+	testVectors := loadMerkleTestVectors(t, "vectors/trie/trie.json")
+
+	for _, vector := range testVectors {
+		db := trie.NewDBWithStore(memory.NewKVStore())
+
+		// Insert the keys one at a time
+		var kvs [][2][]byte
+		root := crypto.Hash{}
+		for k, v := range vector.Input {
+			kv := [2][]byte{hexToBytes(t, k), hexToBytes(t, v)}
+			kvs = append(kvs, kv)
+
+			var err error
+			root, err = db.Update(root, [][2][]byte{kv}, nil)
+			require.NoError(t, err)
+		}
+		expected := hexToBytes(t, vector.Output)
+		require.Equal(t, expected, root[:], "Update root mismatch for input %v", vector.Input)
+
+		// Delete every other key and compare with the reference
+		var deletes [][]byte
+		var remaining [][2][]byte
+		for i, kv := range kvs {
+			if i%2 == 0 {
+				deletes = append(deletes, kv[0])
+			} else {
+				remaining = append(remaining, kv)
+			}
+		}
+		root, err := db.Update(root, nil, deletes)
+		require.NoError(t, err)
+		reference, err := trie.Merklize(remaining, 0, nil)
+		require.NoError(t, err)
+		require.Equal(t, reference, root, "Update root mismatch after deletes for input %v", vector.Input)
+
+		require.NoError(t, db.Close())
+	}
+}
+
 // Helper function to convert a hex string to byte slice
 func hexToBytes(t *testing.T, hexStr string) []byte {
 	bytes, err := hex.DecodeString(hexStr)